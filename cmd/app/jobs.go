@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/loans"
+	"github.com/gidyon/pesapalm/internal/mpesa"
+	"github.com/gidyon/pesapalm/internal/savings"
+	"github.com/spf13/viper"
+)
+
+// job is a background job that runs for a business date
+type job struct {
+	name        string
+	description string
+	run         func(ctx context.Context, businessDate time.Time) (interface{}, error)
+}
+
+// jobs returns the background jobs of the service
+func jobs() []*job {
+	all := []*job{
+		{
+			name:        "accrue-interest",
+			description: "Accrue interest on active loan accounts",
+			run: func(ctx context.Context, businessDate time.Time) (interface{}, error) {
+				return loans.AccrueInterest(ctx, sqlDB, appLogger, businessDate)
+			},
+		},
+		{
+			name:        "apply-penalties",
+			description: "Charge penalties on overdue loan installments and default delinquent loans",
+			run: func(ctx context.Context, businessDate time.Time) (interface{}, error) {
+				return loans.ApplyPenalties(ctx, sqlDB, appLogger, businessDate)
+			},
+		},
+		{
+			name:        "savings-interest",
+			description: "Accrue daily interest on savings accounts and post it at period end",
+			run: func(ctx context.Context, businessDate time.Time) (interface{}, error) {
+				return savings.AccrueInterest(ctx, sqlDB, appLogger, businessDate)
+			},
+		},
+		{
+			name:        "mature-fixed-deposits",
+			description: "Pay out, roll over or transfer fixed deposits that have reached maturity",
+			run: func(ctx context.Context, businessDate time.Time) (interface{}, error) {
+				return savings.MatureFixedDeposits(ctx, sqlDB, appLogger, businessDate)
+			},
+		},
+	}
+
+	if mpesaClient != nil {
+		all = append(all, &job{
+			name:        "query-stk-payments",
+			description: "Query M-Pesa for STK pushes whose callback never arrived",
+			run: func(ctx context.Context, _ time.Time) (interface{}, error) {
+				return mpesa.QueryPendingPayments(ctx, sqlDB, appLogger, mpesaClient, time.Now())
+			},
+		})
+	}
+
+	if mpesaClient != nil && mpesaClient.B2CEnabled() {
+		all = append(all, &job{
+			name:        "query-b2c-payouts",
+			description: "Query, expire or fail M-Pesa payouts whose result never arrived",
+			run: func(ctx context.Context, _ time.Time) (interface{}, error) {
+				return mpesa.QueryPendingPayouts(ctx, sqlDB, appLogger, mpesaClient, time.Now())
+			},
+		})
+	}
+
+	return all
+}
+
+func findJob(name string) *job {
+	for _, j := range jobs() {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// runJobCommand runs a job once from the command line, e.g. `service accrue-interest -date 2024-01-31`
+func runJobCommand(ctx context.Context, args []string) error {
+	j := findJob(args[0])
+	if j == nil {
+		names := make([]string, 0, len(jobs()))
+		for _, j := range jobs() {
+			names = append(names, fmt.Sprintf("  %-20s %s", j.name, j.description))
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown command %q, available jobs:\n%s", args[0], strings.Join(names, "\n"))
+	}
+
+	fs := flag.NewFlagSet(j.name, flag.ExitOnError)
+	date := fs.String("date", time.Now().UTC().Format(time.DateOnly), "Business date to run the job for (YYYY-MM-DD)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	businessDate, err := time.Parse(time.DateOnly, *date)
+	if err != nil {
+		return fmt.Errorf("invalid business date %q: %v", *date, err)
+	}
+
+	result, err := j.run(ctx, businessDate)
+	if err != nil {
+		return fmt.Errorf("job %s failed: %v", j.name, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// startJobs runs all jobs for the current business date every JOBS_INTERVAL until ctx is done.
+// Jobs are idempotent per business date so repeated runs only pick up outstanding work.
+func startJobs(ctx context.Context) {
+	if viper.GetBool("JOBS_DISABLED") {
+		appLogger.Infoln("Background jobs are disabled")
+		return
+	}
+
+	interval := viper.GetDuration("JOBS_INTERVAL")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	run := func() {
+		businessDate := time.Now().UTC()
+		for _, j := range jobs() {
+			result, err := j.run(ctx, businessDate)
+			if err != nil {
+				appLogger.Errorf("Job %s failed: %v", j.name, err)
+				continue
+			}
+			appLogger.Infof("Job %s completed: %+v", j.name, result)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		run()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
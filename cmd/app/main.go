@@ -2,18 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/casbin/casbin/v2"
 	gormadapter "github.com/casbin/gorm-adapter/v3"
 	"github.com/gidyon/gomicro/pkg/conn"
 	"github.com/gidyon/gomicro/pkg/grpc/zaplogger"
 	"github.com/gidyon/gomicro/utils/errs"
+	"github.com/gidyon/pesapalm/internal/apikey"
 	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gidyon/pesapalm/internal/customer"
+	"github.com/gidyon/pesapalm/internal/ledger"
 	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
 	"github.com/gidyon/pesapalm/internal/loans"
+	"github.com/gidyon/pesapalm/internal/mpesa"
+	"github.com/gidyon/pesapalm/internal/policy"
 	"github.com/gidyon/pesapalm/internal/savings"
 	"github.com/gidyon/pesapalm/internal/savings_product"
 	"github.com/gidyon/pesapalm/internal/template"
@@ -37,9 +49,12 @@ var (
 )
 
 var (
-	appLogger grpclog.LoggerV2
-	sqlDB     *gorm.DB
-	redisDB   *redis.Client
+	appLogger   grpclog.LoggerV2
+	sqlDB       *gorm.DB
+	redisDB     *redis.Client
+	mpesaClient *mpesa.Client
+
+	mpesaCallbackNetworks []netip.Prefix
 )
 
 func main() {
@@ -56,6 +71,16 @@ func main() {
 	err := viper.ReadInConfig()
 	errs.Panic(err)
 
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+
+	// Initialize logger
+	errs.Panic(zaplogger.Init(viper.GetInt("logLevel"), ""))
+
+	zaplogger.Log = zaplogger.Log.WithOptions(zap.WithCaller(true))
+
+	// gRPC logger compatible
+	appLogger = zaplogger.ZapGrpcLoggerV2(zaplogger.Log)
+
 	sqlDB, err = conn.OpenGorm(&conn.DbOptions{
 		Name:     viper.GetString("MYSQL_NAME"),
 		Dialect:  viper.GetString("MYSQL_DIALECT"),
@@ -87,6 +112,59 @@ func main() {
 		ConnMaxLifetime: viper.GetDuration("REDIS_MAX_CONN_AGE"),
 	})
 
+	// General ledger tables and chart of accounts
+	errs.Panic(ledger.Migrate(ctx, sqlDB))
+
+	// Loan tables
+	errs.Panic(loans_product.Migrate(ctx, sqlDB))
+	errs.Panic(loans.Migrate(ctx, sqlDB))
+	errs.Panic(savings_product.Migrate(ctx, sqlDB))
+	errs.Panic(savings.Migrate(ctx, sqlDB))
+	errs.Panic(mpesa.Migrate(ctx, sqlDB))
+	errs.Panic(policy.Migrate(ctx, sqlDB))
+	errs.Panic(apikey.Migrate(ctx, sqlDB))
+
+	// M-Pesa is optional in development
+	if viper.GetString("MPESA_CONSUMER_KEY") != "" {
+		// Callbacks credit accounts, so they must be authenticated and come from Daraja
+		if viper.GetString("MPESA_CALLBACK_TOKEN") == "" {
+			errs.Panic(errors.New("MPESA_CALLBACK_TOKEN is required when M-Pesa is configured"))
+		}
+		mpesaCallbackNetworks, err = mpesa.ParseNetworks(viper.GetString("MPESA_CALLBACK_IPS"))
+		errs.Panic(err)
+		if len(mpesaCallbackNetworks) == 0 {
+			errs.Panic(errors.New("MPESA_CALLBACK_IPS is required when M-Pesa is configured"))
+		}
+		mpesaClient, err = mpesa.NewClient(&mpesa.ClientOptions{
+			BaseURL:         viper.GetString("MPESA_API_URL"),
+			ConsumerKey:     viper.GetString("MPESA_CONSUMER_KEY"),
+			ConsumerSecret:  viper.GetString("MPESA_CONSUMER_SECRET"),
+			ShortCode:       viper.GetString("MPESA_SHORT_CODE"),
+			PassKey:         viper.GetString("MPESA_PASS_KEY"),
+			TransactionType: viper.GetString("MPESA_TRANSACTION_TYPE"),
+			CallbackURL:     viper.GetString("MPESA_STK_CALLBACK_URL"),
+
+			InitiatorName:      viper.GetString("MPESA_INITIATOR_NAME"),
+			SecurityCredential: viper.GetString("MPESA_SECURITY_CREDENTIAL"),
+			B2CShortCode:       viper.GetString("MPESA_B2C_SHORT_CODE"),
+			B2CCommandID:       viper.GetString("MPESA_B2C_COMMAND_ID"),
+			B2CResultURL:       viper.GetString("MPESA_B2C_RESULT_URL"),
+			B2CTimeoutURL:      viper.GetString("MPESA_B2C_TIMEOUT_URL"),
+			StatusResultURL:    viper.GetString("MPESA_B2C_STATUS_RESULT_URL"),
+			StatusTimeoutURL:   viper.GetString("MPESA_B2C_STATUS_TIMEOUT_URL"),
+		})
+		errs.Panic(err)
+	}
+
+	// Run a job once when invoked as a subcommand
+	if flag.NArg() > 0 {
+		if err := runJobCommand(ctx, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize  casbin adapter
 	gormAdapter, err := gormadapter.NewAdapterByDB(sqlDB)
 	errs.Panic(err)
@@ -97,16 +175,52 @@ func main() {
 	errs.Panic(enforcer.LoadPolicy())
 
 	// Auth service
-	appAuth := auth.NewAuthService(redisDB)
+	authCacheTTL := 5 * time.Second
+	if viper.IsSet("AUTH_CACHE_TTL") {
+		authCacheTTL = viper.GetDuration("AUTH_CACHE_TTL")
+	}
+	appAuth := auth.NewAuthService(redisDB, authCacheTTL)
+
+	// Access tokens are signed with RS256 or ES256 keys when configured, so that partners can verify them
+	var keySet *auth.KeySet
+	if keysSpec := viper.GetString("JWT_SIGNING_KEYS"); keysSpec != "" {
+		keySet, err = auth.NewKeySet(viper.GetString("JWT_SIGNING_METHOD"), keysSpec)
+		errs.Panic(err)
+		go reloadKeysOnHangup(keySet)
+	}
+
+	// Access tokens signed with ACCESS_SECRET before switching to signing keys are accepted until
+	// JWT_HMAC_ACCEPTED_UNTIL, an RFC 3339 time, and rejected when it is unset
+	var hmacAcceptedUntil time.Time
+	if until := viper.GetString("JWT_HMAC_ACCEPTED_UNTIL"); until != "" && keySet != nil {
+		hmacAcceptedUntil, err = time.Parse(time.RFC3339, until)
+		errs.Panic(err)
+	}
+
 	tkMng := auth.NewTokenService(&auth.TokenOptions{
 		AccessSecret:      viper.GetString("ACCESS_SECRET"),
 		RefreshSecret:     viper.GetString("REFRESH_SECRET"),
 		AccessExpiration:  viper.GetDuration("ACCESS_SECRET_DURATION"),
 		RefreshExpiration: viper.GetDuration("REFRESH_SECRET_DURATION"),
+		Auth:              appAuth,
+		KeySet:            keySet,
+		HMACAcceptedUntil: hmacAcceptedUntil,
 	})
 
 	router := gin.Default()
 
+	// Client IPs are taken from X-Forwarded-For only when set by a trusted proxy, so that they cannot
+	// be spoofed past the M-Pesa callback networks and login attempt limits
+	var trustedProxies []string
+	for _, proxy := range strings.Split(viper.GetString("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	errs.Panic(router.SetTrustedProxies(trustedProxies))
+
+	router.GET("/.well-known/jwks.json", auth.JWKSHandler(keySet))
+
 	// Cors handler
 	router.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"*"},
@@ -122,6 +236,7 @@ func main() {
 			"Keep-Alive",
 			"Origin",
 			"User-Agent",
+			"X-API-Key",
 			"X-Requested-With",
 		},
 		ExposeHeaders:             []string{"Authorization"},
@@ -135,15 +250,11 @@ func main() {
 		c.Redirect(http.StatusPermanentRedirect, "/")
 	})
 
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
-
-	// Initialize logger
-	errs.Panic(zaplogger.Init(viper.GetInt("logLevel"), ""))
-
-	zaplogger.Log = zaplogger.Log.WithOptions(zap.WithCaller(true))
-
-	// gRPC logger compatible
-	appLogger = zaplogger.ZapGrpcLoggerV2(zaplogger.Log)
+	// OTPs are stored as HMACs, keyed with the refresh secret when no OTP secret is configured
+	otpSecret := viper.GetString("OTP_SECRET")
+	if otpSecret == "" {
+		otpSecret = "otp:" + viper.GetString("REFRESH_SECRET")
+	}
 
 	// User management API
 	_, err = user.StartService(ctx, &user.Options{
@@ -159,8 +270,54 @@ func main() {
 		TokenManager: tkMng,
 		Auth:         appAuth,
 		GinEngine:    router,
+		Enforcer:     enforcer,
+		OTP: &user.OTPOptions{
+			Length: viper.GetInt("OTP_LENGTH"),
+			TTL:    viper.GetDuration("OTP_TTL"),
+			Templates: map[string]string{
+				user.OTPPurposeLogin:         viper.GetString("OTP_LOGIN_TEMPLATE"),
+				user.OTPPurposeResetPassword: viper.GetString("OTP_RESET_PASSWORD_TEMPLATE"),
+			},
+			DevMode:    viper.GetBool("OTP_DEV_MODE"),
+			Secret:     otpSecret,
+			Production: isProduction(viper.GetString("ENV")),
+		},
+		Lockout: &user.LockoutOptions{
+			FreeAttempts: viper.GetInt("LOCKOUT_FREE_ATTEMPTS"),
+			MaxDelay:     viper.GetDuration("LOCKOUT_MAX_DELAY"),
+			Threshold:    viper.GetInt("LOCKOUT_THRESHOLD"),
+			Duration:     viper.GetDuration("LOCKOUT_DURATION"),
+			IPThreshold:  viper.GetInt("LOCKOUT_IP_THRESHOLD"),
+			Window:       viper.GetDuration("LOCKOUT_WINDOW"),
+		},
+		TOTP: &user.TOTPOptions{
+			Issuer:         viper.GetString("TOTP_ISSUER"),
+			RequiredGroups: strings.Split(viper.GetString("TOTP_REQUIRED_GROUPS"), ","),
+			ChallengeTTL:   viper.GetDuration("TOTP_CHALLENGE_TTL"),
+		},
+	})
+	errs.Panic(err)
+
+	// API keys of partner integrations, accepted on /api/v1 routes in place of a token
+	apiKeys, err := apikey.NewAPIKeyAPI(ctx, &apikey.Options{
+		DB:           sqlDB,
+		RedisDB:      redisDB,
+		Enforcer:     enforcer,
+		Logger:       appLogger,
+		TokenManager: tkMng,
+		GinEngine:    router,
 	})
 	errs.Panic(err)
+	tkMng.APIKeys = apiKeys
+
+	// Mobile money disbursements need B2C credentials
+	var payoutSender loans.PayoutSender
+	if mpesaClient != nil && mpesaClient.B2CEnabled() {
+		payoutSender, err = mpesa.NewPayoutService(sqlDB, appLogger, mpesaClient)
+		errs.Panic(err)
+	} else {
+		appLogger.Warningln("M-Pesa B2C is not configured, mobile money disbursements are disabled")
+	}
 
 	// Loan service
 	loans.RegisterRoutes(&loans.Options{
@@ -168,6 +325,9 @@ func main() {
 		Logger:       appLogger,
 		TokenManager: tkMng,
 		GinEngine:    router,
+		Enforcer:     enforcer,
+		RedisDB:      redisDB,
+		PayoutSender: payoutSender,
 	})
 
 	// Loan products
@@ -176,6 +336,7 @@ func main() {
 		Logger:       appLogger,
 		TokenManager: tkMng,
 		GinEngine:    router,
+		Enforcer:     enforcer,
 	})
 
 	// Savings
@@ -184,6 +345,8 @@ func main() {
 		Logger:       appLogger,
 		TokenManager: tkMng,
 		GinEngine:    router,
+		Enforcer:     enforcer,
+		RedisDB:      redisDB,
 	})
 
 	// Savings products
@@ -192,14 +355,44 @@ func main() {
 		Logger:       appLogger,
 		TokenManager: tkMng,
 		GinEngine:    router,
+		Enforcer:     enforcer,
 	})
 
+	// General ledger
+	ledger.RegisterRoutes(&ledger.Options{
+		DB:           sqlDB,
+		Logger:       appLogger,
+		TokenManager: tkMng,
+		GinEngine:    router,
+		Enforcer:     enforcer,
+		RedisDB:      redisDB,
+	})
+
+	// M-Pesa payments
+	if mpesaClient != nil {
+		mpesa.RegisterRoutes(&mpesa.Options{
+			DB:            sqlDB,
+			Logger:        appLogger,
+			TokenManager:  tkMng,
+			GinEngine:     router,
+			Enforcer:      enforcer,
+			RedisDB:       redisDB,
+			Client:        mpesaClient,
+			CallbackToken: viper.GetString("MPESA_CALLBACK_TOKEN"),
+
+			CallbackNetworks: mpesaCallbackNetworks,
+		})
+	} else {
+		appLogger.Warningln("M-Pesa is not configured, STK push is disabled")
+	}
+
 	// Customers
 	customer.RegisterRoutes(&customer.Options{
 		DB:           sqlDB,
 		Logger:       appLogger,
 		TokenManager: tkMng,
 		GinEngine:    router,
+		Enforcer:     enforcer,
 	})
 
 	// Templates
@@ -208,11 +401,67 @@ func main() {
 		Logger:       appLogger,
 		TokenManager: tkMng,
 		GinEngine:    router,
+		Enforcer:     enforcer,
 	})
 
+	// Policy management
+	var adminUsers []string
+	if users := viper.GetString("CASBIN_ADMIN_USERS"); users != "" {
+		adminUsers = strings.Split(users, ",")
+	}
+	_, err = policy.NewPolicyAPI(ctx, &policy.Options{
+		Enforcer:     enforcer,
+		DB:           sqlDB,
+		Logger:       appLogger,
+		TokenManager: tkMng,
+		GinEngine:    router,
+		AdminRole:    viper.GetString("CASBIN_ADMIN_ROLE"),
+		AdminUsers:   adminUsers,
+	})
+	errs.Panic(err)
+
+	// Routes without a policy are forbidden to everyone
+	missing, err := auth.MissingPolicies(enforcer)
+	errs.Panic(err)
+	for _, permission := range missing {
+		appLogger.Warningf("No casbin policy grants %q on %q; its routes are forbidden to all users", permission.Action, permission.Object)
+	}
+
+	// Background jobs
+	startJobs(ctx)
+
 	if *dir != "" {
 		router.Use(static.Serve("/", static.LocalFile(*dir, true)))
 	}
 
 	errs.Panic(router.Run(":" + viper.GetString("HTTP_PORT")))
 }
+
+// reloadKeysOnHangup reloads the JWT signing keys on SIGHUP so that keys can be rotated without a restart
+func reloadKeysOnHangup(keySet *auth.KeySet) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		if err := viper.ReadInConfig(); err != nil {
+			appLogger.Errorf("Failed to read config file, keeping the current JWT signing keys: %v", err)
+			continue
+		}
+		if err := keySet.Load(viper.GetString("JWT_SIGNING_KEYS")); err != nil {
+			appLogger.Errorf("Failed to reload JWT signing keys, keeping the current keys: %v", err)
+			continue
+		}
+		appLogger.Infof("Reloaded JWT signing keys, signing with %s", keySet.ActiveKey().ID)
+	}
+}
+
+// isProduction reports whether env, the ENV setting, names a production deployment. ENV prefixes
+// every SMS, so production deployments leave it empty.
+func isProduction(env string) bool {
+	switch strings.ToLower(strings.TrimSpace(env)) {
+	case "", "prod", "production":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,490 @@
+// Command fakedaraja is a local stand-in for the Daraja APIs used for Lipa na M-Pesa Online.
+//
+// It issues access tokens, accepts STK pushes, answers STK queries and posts a callback to the
+// CallBackURL of each push once the simulated customer has responded. Point MPESA_API_URL at it to
+// exercise STK payments end to end without Safaricom credentials:
+//
+//	go run ./cmd/fakedaraja -addr :8091 -callback-delay 5s
+//
+// Use -result-code to simulate failures (1032 is a cancelled request) and -drop-callbacks to
+// exercise the STK query job.
+//
+// Paybill payments are simulated by registering the C2B URLs and posting to the simulate endpoint,
+// which calls the validation URL and, if the payment is accepted, the confirmation URL:
+//
+//	curl -H 'Authorization: Bearer x' -d '{"Amount":100,"Msisdn":"254712345678","BillRefNumber":"LN123"}' \
+//		http://localhost:8091/mpesa/c2b/v1/simulate
+//
+// B2C payments are accepted and answered with a result posted to the ResultURL after the callback
+// delay, using the same -result-code and -drop-callbacks flags. Transaction status queries of B2C
+// payments are answered with the status of the payment posted to their ResultURL, so that dropped
+// results exercise the payout query job.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/mpesa"
+	"github.com/gidyon/pesapalm/pkg/payload"
+)
+
+var (
+	addr          = flag.String("addr", ":8091", "Address to listen on")
+	callbackDelay = flag.Duration("callback-delay", 5*time.Second, "Time the simulated customer takes to respond")
+	resultCode    = flag.Int("result-code", 0, "Result code of every STK push; 0 is success, 1032 is cancelled by the customer")
+	dropCallbacks = flag.Bool("drop-callbacks", false, "Never send callbacks so that payments are settled by querying")
+)
+
+// push is an STK push received by the fake
+type push struct {
+	request     mpesa.STKPushRequest
+	checkoutID  string
+	merchantID  string
+	receipt     string
+	respondedAt time.Time
+}
+
+type server struct {
+	mu     sync.Mutex
+	pushes map[string]*push
+	urls   *mpesa.RegisterC2BURLsRequest
+	// B2C results by originator conversation id
+	payments map[string]*payload.B2CResultBody
+	client   *http.Client
+}
+
+func main() {
+	flag.Parse()
+
+	s := &server{
+		pushes:   make(map[string]*push),
+		payments: make(map[string]*payload.B2CResultBody),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/v1/generate", s.generateToken)
+	mux.HandleFunc("/mpesa/stkpush/v1/processrequest", s.authenticated(s.processRequest))
+	mux.HandleFunc("/mpesa/stkpushquery/v1/query", s.authenticated(s.query))
+	mux.HandleFunc("/mpesa/c2b/v1/registerurl", s.authenticated(s.registerURLs))
+	mux.HandleFunc("/mpesa/c2b/v1/simulate", s.authenticated(s.simulate))
+	mux.HandleFunc("/mpesa/b2c/v1/paymentrequest", s.authenticated(s.paymentRequest))
+	mux.HandleFunc("/mpesa/b2c/v3/paymentrequest", s.authenticated(s.paymentRequest))
+	mux.HandleFunc("/mpesa/transactionstatus/v1/query", s.authenticated(s.transactionStatus))
+
+	log.Printf("Fake Daraja listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func randomID(prefix string, n int) string {
+	bs := make([]byte, n)
+	_, _ = rand.Read(bs)
+	return prefix + strings.ToUpper(hex.EncodeToString(bs))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, &mpesa.APIError{RequestID: randomID("", 8), ErrorCode: code, ErrorMessage: message})
+}
+
+func (s *server) generateToken(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); !ok {
+		writeError(w, http.StatusBadRequest, "400.008.01", "Invalid Authentication passed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": randomID("", 16),
+		"expires_in":   "3599",
+	})
+}
+
+func (s *server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			writeError(w, http.StatusUnauthorized, "404.001.03", "Invalid Access Token")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "405.001", "Method not allowed")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) processRequest(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.STKPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	switch {
+	case req.Amount < 1:
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Amount")
+		return
+	case req.PhoneNumber == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid PhoneNumber")
+		return
+	case req.CallBackURL == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid CallBackURL")
+		return
+	}
+
+	p := &push{
+		request:     req,
+		checkoutID:  randomID("ws_CO_", 10),
+		merchantID:  randomID("", 6),
+		receipt:     randomID("", 5),
+		respondedAt: time.Now().Add(*callbackDelay),
+	}
+
+	s.mu.Lock()
+	s.pushes[p.checkoutID] = p
+	s.mu.Unlock()
+
+	log.Printf("STK push %s: %d from %s to %s", p.checkoutID, req.Amount, req.PhoneNumber, req.AccountReference)
+
+	if !*dropCallbacks {
+		time.AfterFunc(*callbackDelay, func() { s.sendCallback(p) })
+	}
+
+	writeJSON(w, http.StatusOK, &mpesa.STKPushResponse{
+		MerchantRequestID:   p.merchantID,
+		CheckoutRequestID:   p.checkoutID,
+		ResponseCode:        "0",
+		ResponseDescription: "Success. Request accepted for processing",
+		CustomerMessage:     "Success. Request accepted for processing",
+	})
+}
+
+func resultDesc(code int) string {
+	switch code {
+	case 0:
+		return "The service request is processed successfully."
+	case 1:
+		return "The balance is insufficient for the transaction."
+	case 1032:
+		return "Request cancelled by user"
+	case 1037:
+		return "DS timeout user cannot be reached"
+	default:
+		return "The transaction failed"
+	}
+}
+
+func (s *server) sendCallback(p *push) {
+	callback := payload.STKCallback{
+		MerchantRequestID: p.merchantID,
+		CheckoutRequestID: p.checkoutID,
+		ResultCode:        *resultCode,
+		ResultDesc:        resultDesc(*resultCode),
+	}
+	if *resultCode == 0 {
+		transactionDate := time.Now().UTC().Format("20060102150405")
+		callback.CallbackMetadata = payload.CallbackMeta{Item: []payload.Item{
+			{Name: "Amount", Value: float64(p.request.Amount)},
+			{Name: "MpesaReceiptNumber", Value: p.receipt},
+			{Name: "TransactionDate", Value: json.Number(transactionDate)},
+			{Name: "PhoneNumber", Value: json.Number(p.request.PhoneNumber)},
+		}}
+	}
+
+	bs, err := json.Marshal(&payload.STKPayload{Body: payload.Body{STKCallback: callback}})
+	if err != nil {
+		log.Printf("Failed to encode callback of %s: %v", p.checkoutID, err)
+		return
+	}
+
+	res, err := s.client.Post(p.request.CallBackURL, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		log.Printf("Failed to send callback of %s: %v", p.checkoutID, err)
+		return
+	}
+	defer res.Body.Close()
+
+	log.Printf("Callback of %s answered with %s", p.checkoutID, res.Status)
+}
+
+func (s *server) query(w http.ResponseWriter, r *http.Request) {
+	var req payload.QueryStkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	s.mu.Lock()
+	p, ok := s.pushes[req.CheckoutRequestID]
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid CheckoutRequestID")
+		return
+	case time.Now().Before(p.respondedAt):
+		writeError(w, http.StatusInternalServerError, "500.001.1001", "The transaction is being processed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &payload.QueryStkResponse{
+		ResponseCode:        "0",
+		ResponseDescription: "The service request has been accepted successsfully",
+		MerchantRequestID:   p.merchantID,
+		CheckoutRequestID:   p.checkoutID,
+		ResultCode:          fmt.Sprint(*resultCode),
+		ResultDesc:          resultDesc(*resultCode),
+	})
+}
+
+func (s *server) registerURLs(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.RegisterC2BURLsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ConfirmationURL == "" || req.ValidationURL == "" {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid URLs")
+		return
+	}
+
+	s.mu.Lock()
+	s.urls = &req
+	s.mu.Unlock()
+
+	log.Printf("Registered C2B URLs for %s: validation %s, confirmation %s", req.ShortCode, req.ValidationURL, req.ConfirmationURL)
+
+	writeJSON(w, http.StatusOK, &mpesa.RegisterC2BURLsResponse{
+		OriginatorConversationID: randomID("", 8),
+		ResponseCode:             "0",
+		ResponseDescription:      "Success",
+	})
+}
+
+// simulateRequest is the request body of a simulated paybill payment
+type simulateRequest struct {
+	ShortCode     string  `json:"ShortCode"`
+	Amount        float64 `json:"Amount"`
+	Msisdn        string  `json:"Msisdn"`
+	BillRefNumber string  `json:"BillRefNumber"`
+}
+
+func (s *server) simulate(w http.ResponseWriter, r *http.Request) {
+	var req simulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	s.mu.Lock()
+	urls := s.urls
+	s.mu.Unlock()
+
+	if urls == nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - C2B URLs are not registered")
+		return
+	}
+
+	body := &payload.C2BPayload{
+		TransactionType:   "Pay Bill",
+		TransID:           randomID("", 5),
+		TransTime:         time.Now().UTC().Format("20060102150405"),
+		TransAmount:       fmt.Sprintf("%.2f", req.Amount),
+		BusinessShortCode: urls.ShortCode,
+		BillRefNumber:     req.BillRefNumber,
+		MSISDN:            req.Msisdn,
+		FirstName:         "John",
+		LastName:          "Doe",
+	}
+
+	validation, err := s.postC2B(urls.ValidationURL, body)
+	switch {
+	case err != nil && urls.ResponseType == mpesa.ResponseTypeCancelled:
+		log.Printf("Validation of %s failed, cancelling: %v", body.TransID, err)
+	case err != nil:
+		log.Printf("Validation of %s failed, completing: %v", body.TransID, err)
+		validation = &payload.C2BResponse{ResultCode: "0"}
+	}
+
+	if validation != nil && validation.ResultCode == "0" {
+		if _, err := s.postC2B(urls.ConfirmationURL, body); err != nil {
+			log.Printf("Confirmation of %s failed: %v", body.TransID, err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"OriginatorCoversationID": randomID("", 8),
+		"ResponseCode":            "0",
+		"ResponseDescription":     "Accept the service request successfully.",
+		"TransID":                 body.TransID,
+	})
+}
+
+func (s *server) postC2B(url string, body *payload.C2BPayload) (*payload.C2BResponse, error) {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Post(url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var out payload.C2BResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: %v", res.Status, err)
+	}
+
+	log.Printf("%s answered %s with %s %s", url, body.TransID, out.ResultCode, out.ResultDesc)
+
+	return &out, nil
+}
+
+func (s *server) paymentRequest(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.B2CRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	switch {
+	case req.InitiatorName == "" || req.SecurityCredential == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Initiator")
+		return
+	case req.Amount < 10:
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Amount")
+		return
+	case req.PartyB == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid PartyB")
+		return
+	case req.ResultURL == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid ResultURL")
+		return
+	}
+
+	originatorID := req.OriginatorConversationID
+	if originatorID == "" {
+		originatorID = randomID("", 8)
+	}
+
+	result := payload.B2CResultBody{
+		ResultCode:               *resultCode,
+		ResultDesc:               resultDesc(*resultCode),
+		OriginatorConversationID: originatorID,
+		ConversationID:           randomID("AG_", 10),
+		TransactionID:            randomID("", 5),
+	}
+	if *resultCode == 0 {
+		result.ResultParameters.ResultParameter = []payload.ResultParameter{
+			{Key: "TransactionAmount", Value: float64(req.Amount)},
+			{Key: "TransactionReceipt", Value: result.TransactionID},
+			{Key: "ReceiverPartyPublicName", Value: req.PartyB + " - John Doe"},
+			{Key: "TransactionCompletedDateTime", Value: time.Now().Format("02.01.2006 15:04:05")},
+		}
+	}
+
+	log.Printf("B2C payment %s: %d from %s to %s", result.ConversationID, req.Amount, req.PartyA, req.PartyB)
+
+	s.mu.Lock()
+	s.payments[originatorID] = &result
+	s.mu.Unlock()
+
+	if !*dropCallbacks {
+		time.AfterFunc(*callbackDelay, func() { s.sendB2CResult(req.ResultURL, &result) })
+	}
+
+	writeJSON(w, http.StatusOK, &mpesa.B2CResponse{
+		ConversationID:           result.ConversationID,
+		OriginatorConversationID: result.OriginatorConversationID,
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	})
+}
+
+func (s *server) sendB2CResult(url string, result *payload.B2CResultBody) {
+	bs, err := json.Marshal(&payload.B2CResult{Result: *result})
+	if err != nil {
+		log.Printf("Failed to encode result of %s: %v", result.ConversationID, err)
+		return
+	}
+
+	res, err := s.client.Post(url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		log.Printf("Failed to send result of %s: %v", result.ConversationID, err)
+		return
+	}
+	defer res.Body.Close()
+
+	log.Printf("Result of %s answered with %s", result.ConversationID, res.Status)
+}
+
+func (s *server) transactionStatus(w http.ResponseWriter, r *http.Request) {
+	var req mpesa.TransactionStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Body")
+		return
+	}
+
+	switch {
+	case req.Initiator == "" || req.SecurityCredential == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid Initiator")
+		return
+	case req.ResultURL == "":
+		writeError(w, http.StatusBadRequest, "400.002.02", "Bad Request - Invalid ResultURL")
+		return
+	}
+
+	s.mu.Lock()
+	payment, ok := s.payments[req.OriginalConversationID]
+	s.mu.Unlock()
+
+	occasion, _ := json.Marshal(&payload.ResultParameter{Key: "Occasion", Value: req.Occasion})
+	result := payload.B2CResultBody{
+		ResultCode:               0,
+		ResultDesc:               "The service request is processed successfully.",
+		OriginatorConversationID: randomID("", 8),
+		ConversationID:           randomID("AG_", 10),
+		TransactionID:            randomID("", 5),
+		ReferenceData:            payload.ReferenceData{ReferenceItem: occasion},
+	}
+
+	switch {
+	case !ok:
+		result.ResultCode = 2001
+		result.ResultDesc = "The transaction could not be found."
+	case payment.ResultCode == 0:
+		result.ResultParameters.ResultParameter = []payload.ResultParameter{
+			{Key: "ReceiptNo", Value: payment.TransactionID},
+			{Key: "TransactionStatus", Value: "Completed"},
+			{Key: "Amount", Value: payment.ResultParameters.GetAmount()},
+			{Key: "CreditPartyName", Value: payment.ResultParameters.ReceiverName()},
+		}
+	default:
+		result.ResultParameters.ResultParameter = []payload.ResultParameter{
+			{Key: "TransactionStatus", Value: "Failed"},
+		}
+	}
+
+	log.Printf("Transaction status query %s of %s", result.ConversationID, req.OriginalConversationID)
+
+	time.AfterFunc(*callbackDelay, func() { s.sendB2CResult(req.ResultURL, &result) })
+
+	writeJSON(w, http.StatusOK, &mpesa.TransactionStatusResponse{
+		ConversationID:           result.ConversationID,
+		OriginatorConversationID: result.OriginatorConversationID,
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+	})
+}
@@ -0,0 +1,548 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+)
+
+// Object is the casbin object guarding the API keys API
+const Object = "api_keys"
+
+// keyPrefix starts every API key so that leaked keys are easy to recognise
+const keyPrefix = "pk_"
+
+const (
+	defaultPageSize = 200
+	maxPageSize     = 1000
+
+	// How long validated keys are cached, which bounds how long a revoked key keeps working
+	cacheTTL = time.Minute
+	// How often the last use of a key is written to the database
+	lastUsedInterval = time.Minute
+)
+
+type Options struct {
+	DB           *gorm.DB
+	RedisDB      *redis.Client
+	Enforcer     *casbin.Enforcer
+	Logger       grpclog.LoggerV2
+	TokenManager auth.TokenInterface
+	GinEngine    *gin.Engine
+}
+
+type APIServer struct {
+	*Options
+}
+
+// APIServer validates API keys for the token manager
+var _ auth.APIKeyValidator = &APIServer{}
+
+// NewAPIKeyAPI creates an API keys singleton. Set it as the APIKeys of the token options for
+// requests to authenticate with API keys.
+func NewAPIKeyAPI(ctx context.Context, opt *Options) (_ *APIServer, err error) {
+
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("Failed to start api keys service: %v", err)
+		}
+	}()
+
+	// Validation
+	switch {
+	case ctx == nil:
+		err = errors.New("missing context")
+	case opt == nil:
+		err = errors.New("missing options")
+	case opt.DB == nil:
+		err = errors.New("missing db")
+	case opt.RedisDB == nil:
+		err = errors.New("missing redis db")
+	case opt.Enforcer == nil:
+		err = errors.New("missing enforcer")
+	case opt.Logger == nil:
+		err = errors.New("missing logger")
+	case opt.TokenManager == nil:
+		err = errors.New("missing token manager")
+	case opt.GinEngine == nil:
+		err = errors.New("missing gin engine")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	api := &APIServer{
+		Options: opt,
+	}
+
+	// Register routes
+	api.registerRoutes()
+
+	return api, nil
+}
+
+func getCacheKey(prefix string) string {
+	return fmt.Sprintf("apikey:%s", prefix)
+}
+
+func getLastUsedKey(keyID uint64) string {
+	return fmt.Sprintf("apikey_used:%d", keyID)
+}
+
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// generateKey returns a new key of the form pk_<prefix>_<secret> and its prefix
+func generateKey() (string, string, error) {
+	bs := make([]byte, 6+32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", "", err
+	}
+	prefix := hex.EncodeToString(bs[:6])
+	return keyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(bs[6:]), prefix, nil
+}
+
+// parsePrefix returns the prefix of a key, which identifies it
+func parsePrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// parseScopes validates object:action scopes against the permissions of the registered routes
+func parseScopes(scopes []string) ([]auth.Permission, []string, error) {
+	if len(scopes) == 0 {
+		return nil, nil, errors.New("missing scopes")
+	}
+
+	known := map[auth.Permission]bool{}
+	for _, permission := range auth.Permissions() {
+		known[permission] = true
+	}
+
+	var (
+		permissions = make([]auth.Permission, 0, len(scopes))
+		normalized  = make([]string, 0, len(scopes))
+		seen        = map[auth.Permission]bool{}
+	)
+	for _, scope := range scopes {
+		obj, act, ok := strings.Cut(strings.TrimSpace(scope), ":")
+		permission := auth.Permission{Object: obj, Action: act}
+		switch {
+		case !ok || obj == "" || act == "":
+			return nil, nil, fmt.Errorf("scope %q must be of the form object:action", scope)
+		case !known[permission]:
+			return nil, nil, fmt.Errorf("unknown scope %q", scope)
+		case seen[permission]:
+			continue
+		}
+		seen[permission] = true
+		permissions = append(permissions, permission)
+		normalized = append(normalized, obj+":"+act)
+	}
+
+	return permissions, normalized, nil
+}
+
+// grantable responds with 403 and returns false when the user may not grant one of the permissions,
+// so that users cannot issue keys more privileged than themselves
+func (api *APIServer) grantable(c *gin.Context, metadata *auth.AccessDetails, permissions []auth.Permission) bool {
+	for _, permission := range permissions {
+		ok, err := api.Enforcer.Enforce(metadata.Subject(), permission.Object, permission.Action)
+		if err != nil {
+			api.Logger.Errorln(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to check scopes"})
+			return false
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("you are not allowed to grant %s:%s", permission.Object, permission.Action)})
+			return false
+		}
+	}
+	return true
+}
+
+// setPolicies replaces the casbin policies of a key with its scopes
+func (api *APIServer) setPolicies(keyID uint64, permissions []auth.Permission) error {
+	subject := auth.APIKeySubject(keyID)
+
+	if _, err := api.Enforcer.RemoveFilteredPolicy(0, subject); err != nil {
+		return err
+	}
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	rules := make([][]string, 0, len(permissions))
+	for _, permission := range permissions {
+		rules = append(rules, []string{subject, permission.Object, permission.Action})
+	}
+	_, err := api.Enforcer.AddPolicies(rules)
+	return err
+}
+
+// fetchKey retrieves a key by its prefix, from the cache when possible
+func (api *APIServer) fetchKey(ctx context.Context, prefix string) (*APIKey, error) {
+	db := &APIKey{}
+
+	bs, err := api.RedisDB.Get(ctx, getCacheKey(prefix)).Bytes()
+	if err == nil && json.Unmarshal(bs, db) == nil {
+		return db, nil
+	}
+
+	err = api.DB.WithContext(ctx).First(db, "prefix = ?", prefix).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if bs, err := json.Marshal(db); err == nil {
+		api.RedisDB.Set(ctx, getCacheKey(prefix), bs, cacheTTL)
+	}
+
+	return db, nil
+}
+
+func (api *APIServer) evictKey(ctx context.Context, db *APIKey) {
+	if err := api.RedisDB.Del(ctx, getCacheKey(db.Prefix)).Err(); err != nil {
+		api.Logger.Errorf("failed to evict api key %d from cache: %v", db.ID, err)
+	}
+}
+
+// ValidateAPIKey authenticates an API key and records its use
+func (api *APIServer) ValidateAPIKey(ctx context.Context, key, clientIP string) (*auth.AccessDetails, error) {
+	prefix, ok := parsePrefix(key)
+	if !ok {
+		return nil, auth.ErrInvalidAPIKey
+	}
+
+	db, err := api.fetchKey(ctx, prefix)
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, auth.ErrInvalidAPIKey
+	default:
+		return nil, err
+	}
+
+	switch {
+	case subtle.ConstantTimeCompare([]byte(db.KeyHash), []byte(hashKey(key))) != 1:
+		return nil, auth.ErrInvalidAPIKey
+	case db.RevokedAt.Valid:
+		return nil, auth.ErrInvalidAPIKey
+	case db.ExpiresAt.Valid && time.Now().After(db.ExpiresAt.Time):
+		return nil, auth.ErrAPIKeyExpired
+	}
+
+	// Writing every use would load the database, so the last use is recorded at most once per interval
+	if set, err := api.RedisDB.SetNX(ctx, getLastUsedKey(db.ID), 1, lastUsedInterval).Result(); err == nil && set {
+		err = api.DB.WithContext(ctx).Model(&APIKey{}).Where("id = ?", db.ID).
+			UpdateColumns(map[string]interface{}{"last_used_at": time.Now(), "last_used_ip": clientIP}).Error
+		if err != nil {
+			api.Logger.Errorf("failed to record use of api key %d: %v", db.ID, err)
+		}
+	}
+
+	return &auth.AccessDetails{APIKeyId: db.ID, UserName: db.Name}, nil
+}
+
+// CreateAPIKey issues an API key. The key is only returned in this response.
+func (api *APIServer) CreateAPIKey(c *gin.Context) {
+	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to decode request"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing name"})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "expiry must be in the future"})
+		return
+	}
+
+	permissions, scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if !api.grantable(c, metadata, permissions) {
+		return
+	}
+
+	key, prefix, err := generateKey()
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to generate api key"})
+		return
+	}
+
+	scopesJSON, _ := json.Marshal(scopes)
+
+	db := &APIKey{
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashKey(key),
+		Scopes:    scopesJSON,
+		CreatedBy: metadata.UserId,
+	}
+	if req.ExpiresAt != nil {
+		db.ExpiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+	}
+
+	// The key is not created when its policies cannot be added
+	err = api.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(db).Error; err != nil {
+			return err
+		}
+		return api.setPolicies(db.ID, permissions)
+	})
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create api key"})
+		return
+	}
+
+	api.Logger.Infof("User %d issued api key %d (%s) with scopes %v", metadata.UserId, db.ID, db.Name, scopes)
+
+	c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": toAPIKey_(db)})
+}
+
+// ListAPIKeys lists API keys, newest first
+func (api *APIServer) ListAPIKeys(c *gin.Context) {
+	queryParams := c.Request.URL.Query()
+
+	pageSize, _ := strconv.Atoi(queryParams.Get("pageSize"))
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	var lastID int
+
+	pageToken := queryParams.Get("pageToken")
+	if pageToken != "" {
+		bs, err := base64.StdEncoding.DecodeString(pageToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return
+		}
+		lastID, err = strconv.Atoi(string(bs))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return
+		}
+	}
+
+	db := api.DB.WithContext(c.Request.Context()).Order("id DESC").Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+	if queryParams.Get("include_revoked") != "true" {
+		db = db.Where("revoked_at IS NULL")
+	}
+
+	keys := make([]*APIKey, 0, pageSize+1)
+	if err := db.Find(&keys).Error; err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list api keys"})
+		return
+	}
+
+	var nextPageToken string
+	if len(keys) > pageSize {
+		keys = keys[:pageSize]
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(keys[pageSize-1].ID)))
+	}
+
+	res := make([]*APIKey_, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, toAPIKey_(key))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"api_keys":        res,
+	})
+}
+
+// getKey retrieves the key in the path, responding with an error when it is not found
+func (api *APIServer) getKey(c *gin.Context) (*APIKey, bool) {
+	db := &APIKey{}
+
+	err := api.DB.WithContext(c.Request.Context()).First(db, "id = ?", c.Param("keyId")).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "api key not found"})
+		return nil, false
+	default:
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get api key"})
+		return nil, false
+	}
+
+	return db, true
+}
+
+// GetAPIKey retrieves an API key
+func (api *APIServer) GetAPIKey(c *gin.Context) {
+	db, ok := api.getKey(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, toAPIKey_(db))
+}
+
+// UpdateAPIKey changes the name, scopes or expiry of an API key
+func (api *APIServer) UpdateAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to decode request"})
+		return
+	}
+
+	db, ok := api.getKey(c)
+	if !ok {
+		return
+	}
+	if db.RevokedAt.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "api key has been revoked"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		db.Name = name
+		updates["name"] = db.Name
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "expiry must be in the future"})
+			return
+		}
+		db.ExpiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+		updates["expires_at"] = db.ExpiresAt
+	}
+
+	var permissions []auth.Permission
+	if req.Scopes != nil {
+		var scopes []string
+		permissions, scopes, err = parseScopes(req.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if !api.grantable(c, metadata, permissions) {
+			return
+		}
+		db.Scopes, _ = json.Marshal(scopes)
+		updates["scopes"] = db.Scopes
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "nothing to update"})
+		return
+	}
+
+	err = api.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&APIKey{}).Where("id = ?", db.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		if permissions == nil {
+			return nil
+		}
+		return api.setPolicies(db.ID, permissions)
+	})
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to update api key"})
+		return
+	}
+
+	api.evictKey(ctx, db)
+
+	if permissions != nil {
+		api.Logger.Infof("User %d changed the scopes of api key %d to %s", metadata.UserId, db.ID, db.Scopes)
+	}
+
+	c.JSON(http.StatusOK, toAPIKey_(db))
+}
+
+// RevokeAPIKey revokes an API key and removes its policies. Revoked keys are kept for auditing.
+func (api *APIServer) RevokeAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	db, ok := api.getKey(c)
+	if !ok {
+		return
+	}
+	if db.RevokedAt.Valid {
+		c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+		return
+	}
+
+	err := api.DB.WithContext(ctx).Model(db).Update("revoked_at", time.Now()).Error
+	if err == nil {
+		err = api.setPolicies(db.ID, nil)
+	}
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to revoke api key"})
+		return
+	}
+
+	api.evictKey(ctx, db)
+
+	if metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		api.Logger.Infof("User %d revoked api key %d (%s)", metadata.UserId, db.ID, db.Name)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+}
@@ -0,0 +1,95 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreateRequest issues an API key to an integration. Scopes are object:action pairs of the casbin
+// permissions the key is granted, e.g. loans:read.
+type CreateRequest struct {
+	Name      string     `json:"name,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// UpdateRequest changes the name, scopes or expiry of an API key. Omitted fields are left unchanged.
+type UpdateRequest struct {
+	Name      string     `json:"name,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKey is an API key of an integration. Only a hash of the key is stored.
+type APIKey struct {
+	ID         uint64         `gorm:"primaryKey;autoIncrement"`
+	Name       string         `gorm:"size:100;not null"`
+	Prefix     string         `gorm:"size:20;uniqueIndex;not null"` // Identifies the key for lookup
+	KeyHash    string         `gorm:"size:64;not null"`
+	Scopes     []byte         `gorm:"type:json"`
+	CreatedBy  uint64         `gorm:"index;not null"`
+	ExpiresAt  sql.NullTime   `gorm:"type:datetime(6)"`
+	LastUsedAt sql.NullTime   `gorm:"type:datetime(6)"`
+	LastUsedIp sql.NullString `gorm:"size:50"`
+	RevokedAt  sql.NullTime   `gorm:"type:datetime(6);index"`
+	UpdatedAt  time.Time      `gorm:"type:datetime(6);autoUpdateTime"`
+	CreatedAt  time.Time      `gorm:"type:datetime(6);autoCreateTime;index"`
+}
+
+func (*APIKey) TableName() string {
+	return "api_keys"
+}
+
+// APIKey_ is an API key as returned by the API
+type APIKey_ struct {
+	ID         uint64   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedBy  uint64   `json:"created_by"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	LastUsedIp string   `json:"last_used_ip,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	UpdatedAt  string   `json:"updated_at"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+func formatTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+func toAPIKey_(db *APIKey) *APIKey_ {
+	scopes := []string{}
+	_ = json.Unmarshal(db.Scopes, &scopes)
+
+	return &APIKey_{
+		ID:         db.ID,
+		Name:       db.Name,
+		Prefix:     db.Prefix,
+		Scopes:     scopes,
+		CreatedBy:  db.CreatedBy,
+		ExpiresAt:  formatTime(db.ExpiresAt),
+		LastUsedAt: formatTime(db.LastUsedAt),
+		LastUsedIp: db.LastUsedIp.String,
+		RevokedAt:  formatTime(db.RevokedAt),
+		UpdatedAt:  db.UpdatedAt.Format(time.RFC3339),
+		CreatedAt:  db.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// Migrate creates the API keys table if it is missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+	if !migrator.HasTable(&APIKey{}) {
+		return migrator.AutoMigrate(&APIKey{})
+	}
+	return nil
+}
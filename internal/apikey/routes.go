@@ -0,0 +1,19 @@
+package apikey
+
+import (
+	"github.com/gidyon/pesapalm/internal/auth"
+)
+
+func (api *APIServer) registerRoutes() {
+	authorize := auth.Authorizer(api.Enforcer, api.TokenManager)
+
+	// API keys cannot manage API keys, only users can
+	v1 := api.GinEngine.Group("/api/v1/api-keys", auth.TokenAuthMiddleware(api.TokenManager), auth.RequireUser())
+	{
+		v1.GET("", authorize(Object, auth.ActionRead), api.ListAPIKeys)
+		v1.POST("", authorize(Object, auth.ActionCreate), api.CreateAPIKey)
+		v1.GET("/:keyId", authorize(Object, auth.ActionRead), api.GetAPIKey)
+		v1.PATCH("/:keyId", authorize(Object, auth.ActionUpdate), api.UpdateAPIKey)
+		v1.DELETE("/:keyId", authorize(Object, auth.ActionDelete), api.RevokeAPIKey)
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the API key of requests from partner integrations
+const APIKeyHeader = "X-API-Key"
+
+var (
+	// ErrInvalidAPIKey is returned for API keys that are unknown or have been revoked
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyExpired is returned for API keys past their expiry
+	ErrAPIKeyExpired = errors.New("api key has expired")
+)
+
+// APIKeyValidator authenticates the API keys of partner integrations
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key, clientIP string) (*AccessDetails, error)
+}
+
+// APIKeySubject is the casbin subject that the scopes of an API key are granted to
+func APIKeySubject(keyId uint64) string {
+	return fmt.Sprintf("apikey:%d", keyId)
+}
+
+// ValidateAPIKey authenticates an API key with the configured validator
+func (t *TokenManager) ValidateAPIKey(ctx context.Context, key, clientIP string) (*AccessDetails, error) {
+	if t.APIKeys == nil || key == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	return t.APIKeys.ValidateAPIKey(ctx, key, clientIP)
+}
+
+// RequireUser rejects requests authenticated with an API key, for routes that act on the logged in
+// user such as sessions, or that must not be available to integrations
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if metadata, ok := GetAccessDetails(c); ok && metadata.APIKeyId != 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "not available to api keys"})
+			return
+		}
+		c.Next()
+	}
+}
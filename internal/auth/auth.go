@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,8 +12,18 @@ import (
 
 type AccessDetails struct {
 	TokenUuid string
+	SessionId string
 	UserId    uint64
 	UserName  string
+	APIKeyId  uint64 // Set instead of UserId for requests authenticated with an API key
+}
+
+// Subject is the casbin subject of the user or API key
+func (ad *AccessDetails) Subject() string {
+	if ad.APIKeyId != 0 {
+		return APIKeySubject(ad.APIKeyId)
+	}
+	return fmt.Sprint(ad.UserId)
 }
 
 type TokenDetails struct {
@@ -20,6 +31,7 @@ type TokenDetails struct {
 	RefreshToken string
 	TokenUuid    string
 	RefreshUuid  string
+	SessionId    string
 	AtExpires    int64
 	RtExpires    int64
 }
@@ -29,16 +41,85 @@ type AuthInterface interface {
 	FetchAuth(context.Context, string) (string, error)
 	DeleteRefresh(context.Context, string) error
 	DeleteTokens(context.Context, *AccessDetails) error
+	ConsumeRefresh(context.Context, *AccessDetails) error
+	SaveSession(context.Context, *Session, *TokenDetails) error
+	FetchSession(context.Context, string) (*Session, error)
+	ListSessions(context.Context, uint64) ([]*Session, error)
+	RevokeSession(context.Context, uint64, string) error
+	RevokeSessions(context.Context, uint64) (int, error)
 }
 
 type RedisAuthService struct {
-	client *redis.Client
+	client   *redis.Client
+	cacheTTL time.Duration
+	mu       sync.RWMutex
+	cache    map[string]cachedAuth
 }
 
+// cachedAuth is a token found in redis, trusted locally until expires
+type cachedAuth struct {
+	userId  string
+	expires time.Time
+}
+
+// Expired entries are swept once the cache grows past this many tokens
+const maxCachedAuths = 10000
+
 var _ AuthInterface = &RedisAuthService{}
 
-func NewAuthService(client *redis.Client) *RedisAuthService {
-	return &RedisAuthService{client: client}
+// NewAuthService creates an auth service that stores tokens in redis. Tokens found in redis are cached
+// locally for cacheTTL, so a token revoked through another instance is honoured within cacheTTL. A
+// cacheTTL of zero disables the cache.
+func NewAuthService(client *redis.Client, cacheTTL time.Duration) *RedisAuthService {
+	return &RedisAuthService{
+		client:   client,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]cachedAuth),
+	}
+}
+
+func (tk *RedisAuthService) cached(tokenUuid string) (string, bool) {
+	if tk.cacheTTL <= 0 {
+		return "", false
+	}
+	tk.mu.RLock()
+	entry, ok := tk.cache[tokenUuid]
+	tk.mu.RUnlock()
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.userId, true
+}
+
+func (tk *RedisAuthService) remember(tokenUuid, userId string) {
+	if tk.cacheTTL <= 0 {
+		return
+	}
+	now := time.Now()
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	if len(tk.cache) >= maxCachedAuths {
+		for key, entry := range tk.cache {
+			if now.After(entry.expires) {
+				delete(tk.cache, key)
+			}
+		}
+	}
+	if len(tk.cache) < maxCachedAuths {
+		tk.cache[tokenUuid] = cachedAuth{userId: userId, expires: now.Add(tk.cacheTTL)}
+	}
+}
+
+// evict drops revoked tokens from the local cache
+func (tk *RedisAuthService) evict(tokenUuids ...string) {
+	if tk.cacheTTL <= 0 {
+		return
+	}
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	for _, tokenUuid := range tokenUuids {
+		delete(tk.cache, tokenUuid)
+	}
 }
 
 // Save token metadata to Redis
@@ -63,10 +144,14 @@ func (tk *RedisAuthService) CreateAuth(ctx context.Context, userId uint64, td *T
 
 // Check the metadata saved
 func (tk *RedisAuthService) FetchAuth(ctx context.Context, tokenUuid string) (string, error) {
+	if userid, ok := tk.cached(tokenUuid); ok {
+		return userid, nil
+	}
 	userid, err := tk.client.Get(ctx, tokenUuid).Result()
 	if err != nil {
 		return "", err
 	}
+	tk.remember(tokenUuid, userid)
 	return userid, nil
 }
 
@@ -74,6 +159,7 @@ func (tk *RedisAuthService) FetchAuth(ctx context.Context, tokenUuid string) (st
 func (tk *RedisAuthService) DeleteTokens(ctx context.Context, authD *AccessDetails) error {
 	//get the refresh uuid
 	refreshUuid := fmt.Sprintf("%s++%d", authD.TokenUuid, authD.UserId)
+	tk.evict(authD.TokenUuid, refreshUuid)
 	//delete access token
 	deletedAt, err := tk.client.Del(ctx, authD.TokenUuid).Result()
 	if err != nil {
@@ -84,6 +170,15 @@ func (tk *RedisAuthService) DeleteTokens(ctx context.Context, authD *AccessDetai
 	if err != nil {
 		return err
 	}
+	//delete the session the tokens belong to
+	if authD.SessionId != "" {
+		pipe := tk.client.TxPipeline()
+		pipe.Del(ctx, getSessionKey(authD.SessionId))
+		pipe.SRem(ctx, getUserSessionsKey(authD.UserId), authD.SessionId)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
 	//When the record is deleted, the return value is 1
 	if deletedAt != 1 || deletedRt != 1 {
 		return errors.New("something went wrong")
@@ -92,6 +187,7 @@ func (tk *RedisAuthService) DeleteTokens(ctx context.Context, authD *AccessDetai
 }
 
 func (tk *RedisAuthService) DeleteRefresh(ctx context.Context, refreshUuid string) error {
+	tk.evict(refreshUuid)
 	//delete refresh token
 	deleted, err := tk.client.Del(ctx, refreshUuid).Result()
 	if err != nil || deleted == 0 {
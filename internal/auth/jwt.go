@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,8 +10,12 @@ import (
 
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
+// ErrTokenRevoked is returned for tokens deleted on logout or revocation of their session
+var ErrTokenRevoked = errors.New("token has been revoked")
+
 type TokenManager struct {
 	*TokenOptions
 }
@@ -20,6 +25,12 @@ type TokenOptions struct {
 	RefreshSecret     string
 	AccessExpiration  time.Duration
 	RefreshExpiration time.Duration
+	Auth              AuthInterface // When set, tokens are only valid while they are stored in it
+	KeySet            *KeySet       // When set, access tokens are signed with it instead of AccessSecret
+	// While a key set is in use, access tokens signed with AccessSecret are only accepted until this
+	// time, to let tokens issued before the switch expire. They are rejected when it is zero.
+	HMACAcceptedUntil time.Time
+	APIKeys           APIKeyValidator // When set, requests may authenticate with an API key instead of a token
 }
 
 func NewTokenService(opt *TokenOptions) *TokenManager {
@@ -27,31 +38,44 @@ func NewTokenService(opt *TokenOptions) *TokenManager {
 }
 
 type TokenInterface interface {
-	CreateToken(userId uint64, userName string) (*TokenDetails, error)
+	CreateToken(userId uint64, userName, sessionId string) (*TokenDetails, error)
 	ExtractTokenMetadata(*http.Request) (*AccessDetails, error)
+	ExtractRefreshMetadata(*http.Request) (*AccessDetails, error)
 	TokenValid(*http.Request) error
 	VerifyToken(*http.Request) (*jwt.Token, error)
+	VerifyRefreshToken(*http.Request) (*jwt.Token, error)
+	ValidateAPIKey(ctx context.Context, key, clientIP string) (*AccessDetails, error)
 }
 
 // Token implements the TokenInterface
 var _ TokenInterface = &TokenManager{}
 
-func (t *TokenManager) CreateToken(userId uint64, userName string) (*TokenDetails, error) {
+// CreateToken creates an access and refresh token pair for a session, starting a new session when sessionId is empty
+func (t *TokenManager) CreateToken(userId uint64, userName, sessionId string) (*TokenDetails, error) {
 	td := &TokenDetails{}
 	td.AtExpires = time.Now().Add(t.AccessExpiration).Unix() //expires after 30 min
 	td.TokenUuid = uuid.NewString()
+	td.SessionId = firstVal(sessionId, uuid.NewString())
 
 	var err error
 
 	//Creating Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims["access_uuid"] = td.TokenUuid
+	atClaims["session_id"] = td.SessionId
 	atClaims["user_id"] = userId
 	atClaims["user_name"] = userName
 	atClaims["exp"] = td.AtExpires
 
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-	td.AccessToken, err = at.SignedString([]byte(t.AccessSecret))
+	if t.KeySet != nil {
+		key := t.KeySet.ActiveKey()
+		at := jwt.NewWithClaims(t.KeySet.Method(), atClaims)
+		at.Header["kid"] = key.ID
+		td.AccessToken, err = at.SignedString(key.PrivateKey)
+	} else {
+		at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
+		td.AccessToken, err = at.SignedString([]byte(t.AccessSecret))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +86,7 @@ func (t *TokenManager) CreateToken(userId uint64, userName string) (*TokenDetail
 
 	rtClaims := jwt.MapClaims{}
 	rtClaims["refresh_uuid"] = td.RefreshUuid
+	rtClaims["session_id"] = td.SessionId
 	rtClaims["user_id"] = userId
 	rtClaims["user_name"] = userName
 	rtClaims["exp"] = td.RtExpires
@@ -76,7 +101,12 @@ func (t *TokenManager) CreateToken(userId uint64, userName string) (*TokenDetail
 	return td, nil
 }
 
+// ExtractTokenMetadata returns the details of the access token of a request, or of the token or API
+// key already authenticated by TokenAuthMiddleware
 func (t *TokenManager) ExtractTokenMetadata(r *http.Request) (*AccessDetails, error) {
+	if acc, ok := r.Context().Value(accessDetailsCtxKey{}).(*AccessDetails); ok {
+		return acc, nil
+	}
 	token, err := t.VerifyToken(r)
 	if err != nil {
 		return nil, err
@@ -88,6 +118,15 @@ func (t *TokenManager) ExtractTokenMetadata(r *http.Request) (*AccessDetails, er
 	return acc, nil
 }
 
+// ExtractRefreshMetadata verifies the refresh token of a request and returns its details
+func (t *TokenManager) ExtractRefreshMetadata(r *http.Request) (*AccessDetails, error) {
+	token, err := t.VerifyRefreshToken(r)
+	if err != nil {
+		return nil, err
+	}
+	return Extract(token)
+}
+
 func (t *TokenManager) TokenValid(r *http.Request) error {
 	token, err := t.VerifyToken(r)
 	if err != nil {
@@ -96,26 +135,79 @@ func (t *TokenManager) TokenValid(r *http.Request) error {
 	if !token.Valid {
 		return errors.New("invalid token")
 	}
+	if t.Auth != nil {
+		// Tokens are deleted on logout and when their session is revoked
+		acc, err := Extract(token)
+		if err != nil {
+			return err
+		}
+		userId, err := t.Auth.FetchAuth(r.Context(), acc.TokenUuid)
+		switch {
+		case errors.Is(err, redis.Nil):
+			return ErrTokenRevoked
+		case err != nil:
+			return err
+		case userId != fmt.Sprint(acc.UserId):
+			return ErrTokenRevoked
+		}
+	}
 	return nil
 }
 
+// VerifyToken verifies the access token of a request. Refresh tokens are rejected.
 func (t *TokenManager) VerifyToken(r *http.Request) (*jwt.Token, error) {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	token, err := jwt.Parse(ExtractToken(r), func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if t.AccessSecret == "" || (t.KeySet != nil && !time.Now().Before(t.HMACAcceptedUntil)) {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(t.AccessSecret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+			if t.KeySet == nil {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return t.KeySet.verificationKey(token)
+		default:
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		if r.URL.Path == "/api/refresh" {
-			return []byte(t.RefreshSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hasClaim(token, "refresh_uuid") {
+		return nil, errors.New("refresh token cannot be used as an access token")
+	}
+	return token, nil
+}
+
+// VerifyRefreshToken verifies the refresh token of a request, which is always signed with RefreshSecret
+// since only this service reads it
+func (t *TokenManager) VerifyRefreshToken(r *http.Request) (*jwt.Token, error) {
+	token, err := jwt.Parse(ExtractToken(r), func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(t.AccessSecret), nil
+		return []byte(t.RefreshSecret), nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	if !hasClaim(token, "refresh_uuid") {
+		return nil, errors.New("access token cannot be used as a refresh token")
+	}
 	return token, nil
 }
 
+func hasClaim(token *jwt.Token, claim string) bool {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	_, ok = claims[claim]
+	return ok
+}
+
 // get the token from the request body
 func ExtractToken(r *http.Request) string {
 	bearToken := r.Header.Get("Authorization")
@@ -133,11 +225,13 @@ func Extract(token *jwt.Token) (*AccessDetails, error) {
 		refreshUuid, ok2 := claims["refresh_uuid"].(string)
 		userId, userOk := claims["user_id"].(float64)
 		userName, userNameOk := claims["user_name"].(string)
+		sessionId, _ := claims["session_id"].(string)
 		if (!ok1 && !ok2) || !userOk || !userNameOk {
 			return nil, errors.New("unauthorized")
 		} else {
 			return &AccessDetails{
 				TokenUuid: firstVal(refreshUuid, accessUuid),
+				SessionId: sessionId,
 				UserId:    uint64(userId),
 				UserName:  userName,
 			}, nil
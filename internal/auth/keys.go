@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// SigningKey is a key for signing access tokens with RS256 or ES256. Keys kept only to verify
+// tokens signed before a rotation may have no private key.
+type SigningKey struct {
+	ID         string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// KeySet holds the keys of an asymmetric signing method. The first key signs new access tokens and
+// all keys verify them, so a key can be rotated out by adding a new key in front of it and removed
+// once the tokens it signed have expired. Load can be called at any time to apply a new spec.
+type KeySet struct {
+	method jwt.SigningMethod
+	mu     sync.RWMutex
+	keys   []*SigningKey
+}
+
+// NewKeySet creates a key set for the RS256 or ES256 signing method, loaded from spec
+func NewKeySet(method, spec string) (*KeySet, error) {
+	var signingMethod jwt.SigningMethod
+	switch method {
+	case jwt.SigningMethodRS256.Alg():
+		signingMethod = jwt.SigningMethodRS256
+	case jwt.SigningMethodES256.Alg():
+		signingMethod = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported signing method %q", method)
+	}
+
+	ks := &KeySet{method: signingMethod}
+	if err := ks.Load(spec); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Load replaces the keys with those in spec, a comma separated list of kid=path entries where path
+// is a PEM encoded key file. The first entry must be a private key; the others may be public keys.
+func (ks *KeySet) Load(spec string) error {
+	keys := make([]*SigningKey, 0)
+	seen := map[string]bool{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, path, ok := strings.Cut(entry, "=")
+		kid, path = strings.TrimSpace(kid), strings.TrimSpace(path)
+		if !ok || kid == "" || path == "" {
+			return fmt.Errorf("signing key %q must be of the form kid=path", entry)
+		}
+		if seen[kid] {
+			return fmt.Errorf("duplicate signing key id %q", kid)
+		}
+		seen[kid] = true
+
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read signing key %s: %v", kid, err)
+		}
+
+		key, err := ks.parseKey(kid, bs)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+	}
+
+	switch {
+	case len(keys) == 0:
+		return errors.New("missing signing keys")
+	case keys[0].PrivateKey == nil:
+		return fmt.Errorf("signing key %s must be a private key", keys[0].ID)
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+
+	return nil
+}
+
+func (ks *KeySet) parseKey(kid string, bs []byte) (*SigningKey, error) {
+	key := &SigningKey{ID: kid}
+
+	switch ks.method {
+	case jwt.SigningMethodRS256:
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(bs); err == nil {
+			key.PrivateKey, key.PublicKey = private, &private.PublicKey
+			return key, nil
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(bs)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s is not an RSA key: %v", kid, err)
+		}
+		key.PublicKey = public
+	default:
+		if private, err := jwt.ParseECPrivateKeyFromPEM(bs); err == nil {
+			key.PrivateKey, key.PublicKey = private, &private.PublicKey
+		} else {
+			public, err := jwt.ParseECPublicKeyFromPEM(bs)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %s is not an EC key: %v", kid, err)
+			}
+			key.PublicKey = public
+		}
+		if key.PublicKey.(*ecdsa.PublicKey).Curve != elliptic.P256() {
+			return nil, fmt.Errorf("signing key %s must use the P-256 curve for ES256", kid)
+		}
+	}
+
+	return key, nil
+}
+
+// Method is the signing method of the keys
+func (ks *KeySet) Method() jwt.SigningMethod {
+	return ks.method
+}
+
+// ActiveKey is the key that signs new tokens
+func (ks *KeySet) ActiveKey() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[0]
+}
+
+// verificationKey returns the public key for the kid in the header of a token
+func (ks *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != ks.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key.PublicKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public keys of the set, including keys kept only for verification
+func (ks *KeySet) JWKS() []*JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	jwks := make([]*JWK, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		jwk := &JWK{Kid: key.ID, Use: "sig", Alg: ks.method.Alg()}
+
+		switch public := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(public.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = b64(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = b64(public.Y.FillBytes(make([]byte, size)))
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+// JWKSHandler serves the public keys that verify access tokens at /.well-known/jwks.json. It serves
+// an empty set when tokens are signed with a shared secret.
+func JWKSHandler(ks *KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := []*JWK{}
+		if ks != nil {
+			keys = ks.JWKS()
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// writePEM writes a PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// writeKeyPair writes the private key and the public key of key to dir, returning their paths
+func writeKeyPair(t *testing.T, dir, name string, key crypto.Signer) (string, string) {
+	t.Helper()
+
+	var (
+		privateType string
+		privateDER  []byte
+		err         error
+	)
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		privateType, privateDER = "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)
+	case *ecdsa.PrivateKey:
+		privateType = "EC PRIVATE KEY"
+		privateDER, err = x509.MarshalECPrivateKey(key)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode private key %s: %v", name, err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("failed to encode public key %s: %v", name, err)
+	}
+
+	return writePEM(t, dir, name+".pem", privateType, privateDER), writePEM(t, dir, name+".pub.pem", "PUBLIC KEY", publicDER)
+}
+
+// shortECKey generates a P-256 key with a coordinate shorter than 32 bytes, whose JWK encoding
+// needs padding
+func shortECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	for {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate EC key: %v", err)
+		}
+		if len(key.X.Bytes()) < 32 || len(key.Y.Bytes()) < 32 {
+			return key
+		}
+	}
+}
+
+// parseJWK converts a JWK back into a public key
+func parseJWK(t *testing.T, jwk *JWK) crypto.PublicKey {
+	t.Helper()
+
+	decode := func(field, value string) []byte {
+		bs, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatalf("JWK %s %s is not base64url: %v", jwk.Kid, field, err)
+		}
+		return bs
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decode("n", jwk.N)),
+			E: int(new(big.Int).SetBytes(decode("e", jwk.E)).Int64()),
+		}
+	case "EC":
+		if jwk.Crv != "P-256" {
+			t.Fatalf("JWK %s curve = %s, want P-256", jwk.Kid, jwk.Crv)
+		}
+		x, y := decode("x", jwk.X), decode("y", jwk.Y)
+		if len(x) != 32 || len(y) != 32 {
+			t.Fatalf("JWK %s coordinates are %d and %d bytes, want 32", jwk.Kid, len(x), len(y))
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	default:
+		t.Fatalf("JWK %s has unexpected key type %q", jwk.Kid, jwk.Kty)
+		return nil
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	rsaKey := func(t *testing.T) crypto.Signer {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate RSA key: %v", err)
+		}
+		return key
+	}
+
+	tests := []struct {
+		method string
+		newKey func(t *testing.T) crypto.Signer
+	}{
+		{jwt.SigningMethodRS256.Alg(), rsaKey},
+		{jwt.SigningMethodES256.Alg(), func(t *testing.T) crypto.Signer { return shortECKey(t) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			dir := t.TempDir()
+			oldKey, newKey := tt.newKey(t), tt.newKey(t)
+			oldPrivate, oldPublic := writeKeyPair(t, dir, "old", oldKey)
+			newPrivate, _ := writeKeyPair(t, dir, "new", newKey)
+
+			// tokenFrom issues an access token signed with the active key of spec
+			tokenFrom := func(t *testing.T, spec string) string {
+				ks, err := NewKeySet(tt.method, spec)
+				if err != nil {
+					t.Fatalf("NewKeySet(%q) error = %v", spec, err)
+				}
+				td, err := NewTokenService(&TokenOptions{KeySet: ks, RefreshSecret: "refresh", AccessExpiration: time.Minute}).CreateToken(7, "jdoe", "")
+				if err != nil {
+					t.Fatalf("CreateToken() error = %v", err)
+				}
+				return td.AccessToken
+			}
+
+			// verify checks an access token against the keys of spec
+			verify := func(t *testing.T, spec, token string) error {
+				ks, err := NewKeySet(tt.method, spec)
+				if err != nil {
+					t.Fatalf("NewKeySet(%q) error = %v", spec, err)
+				}
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				_, err = NewTokenService(&TokenOptions{KeySet: ks}).VerifyToken(req)
+				return err
+			}
+
+			oldToken := tokenFrom(t, "old="+oldPrivate)
+			newToken := tokenFrom(t, "new="+newPrivate)
+			rotated := "new=" + newPrivate + ",old=" + oldPublic
+
+			verifications := []struct {
+				name    string
+				spec    string
+				token   string
+				wantErr bool
+			}{
+				{"token of the active key", "old=" + oldPrivate, oldToken, false},
+				{"token of the new key after rotation", rotated, newToken, false},
+				{"token of a rotated out key", rotated, oldToken, false},
+				{"token of a removed key", "new=" + newPrivate, oldToken, true},
+				{"unknown kid", "other=" + oldPrivate, oldToken, true},
+			}
+			for _, v := range verifications {
+				t.Run(v.name, func(t *testing.T) {
+					if err := verify(t, v.spec, v.token); (err != nil) != v.wantErr {
+						t.Errorf("VerifyToken() error = %v, wantErr %v", err, v.wantErr)
+					}
+				})
+			}
+
+			t.Run("JWKS round trip", func(t *testing.T) {
+				ks, err := NewKeySet(tt.method, rotated)
+				if err != nil {
+					t.Fatalf("NewKeySet() error = %v", err)
+				}
+
+				bs, err := json.Marshal(ks.JWKS())
+				if err != nil {
+					t.Fatalf("json.Marshal() error = %v", err)
+				}
+				var jwks []*JWK
+				if err := json.Unmarshal(bs, &jwks); err != nil {
+					t.Fatalf("json.Unmarshal() error = %v", err)
+				}
+
+				want := map[string]crypto.PublicKey{"new": newKey.Public(), "old": oldKey.Public()}
+				if len(jwks) != len(want) {
+					t.Fatalf("JWKS() returned %d keys, want %d", len(jwks), len(want))
+				}
+				for _, jwk := range jwks {
+					if jwk.Alg != tt.method || jwk.Use != "sig" {
+						t.Errorf("JWK %s alg = %s and use = %s, want %s and sig", jwk.Kid, jwk.Alg, jwk.Use, tt.method)
+					}
+					public, ok := want[jwk.Kid].(interface{ Equal(crypto.PublicKey) bool })
+					if !ok {
+						t.Fatalf("JWKS() returned unexpected key %q", jwk.Kid)
+					}
+					if !public.Equal(parseJWK(t, jwk)) {
+						t.Errorf("JWK %s does not parse back into its public key", jwk.Kid)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestKeySetLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+
+	rsaPrivate, rsaPublic := writeKeyPair(t, dir, "rsa", rsaKey)
+	ecPrivate, _ := writeKeyPair(t, dir, "ec", ecKey)
+	p384Private, _ := writeKeyPair(t, dir, "p384", p384Key)
+
+	tests := []struct {
+		name    string
+		method  string
+		spec    string
+		wantErr string
+	}{
+		{"RSA key", "RS256", "a=" + rsaPrivate, ""},
+		{"EC key", "ES256", "a=" + ecPrivate, ""},
+		{"P-384 key for ES256", "ES256", "a=" + p384Private, "P-256"},
+		{"EC key for RS256", "RS256", "a=" + ecPrivate, "not an RSA key"},
+		{"public key first", "RS256", "a=" + rsaPublic + ",b=" + rsaPrivate, "must be a private key"},
+		{"duplicate kid", "RS256", "a=" + rsaPrivate + ",a=" + rsaPublic, "duplicate"},
+		{"entry without kid", "RS256", rsaPrivate, "kid=path"},
+		{"missing file", "RS256", "a=" + filepath.Join(dir, "missing.pem"), "failed to read"},
+		{"no keys", "RS256", " , ", "missing signing keys"},
+		{"unsupported method", "HS256", "a=" + rsaPrivate, "unsupported signing method"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeySet(tt.method, tt.spec)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("NewKeySet() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("NewKeySet() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,19 +1,72 @@
 package auth
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/gin-gonic/gin"
 )
 
+// accessDetailsKey is the gin context key of the AccessDetails of an authenticated request
+const accessDetailsKey = "auth.access_details"
+
+// accessDetailsCtxKey is the request context key of the AccessDetails, read by ExtractTokenMetadata
+type accessDetailsCtxKey struct{}
+
+// authenticate validates the token of a request, including that it has not been revoked, and
+// returns its details. Requests without a bearer token may authenticate with an API key instead.
+// Details set by an earlier middleware are reused.
+func authenticate(c *gin.Context, tkMng TokenInterface) (*AccessDetails, error) {
+	if metadata, ok := GetAccessDetails(c); ok {
+		return metadata, nil
+	}
+
+	var (
+		metadata *AccessDetails
+		err      error
+	)
+	if key := c.GetHeader(APIKeyHeader); key != "" && ExtractToken(c.Request) == "" {
+		metadata, err = tkMng.ValidateAPIKey(c.Request.Context(), key, c.ClientIP())
+	} else if err = tkMng.TokenValid(c.Request); err == nil {
+		metadata, err = tkMng.ExtractTokenMetadata(c.Request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set(accessDetailsKey, metadata)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), accessDetailsCtxKey{}, metadata))
+
+	return metadata, nil
+}
+
+// GetAccessDetails retrieves the details of the token authenticated by TokenAuthMiddleware
+func GetAccessDetails(c *gin.Context) (*AccessDetails, bool) {
+	v, ok := c.Get(accessDetailsKey)
+	if !ok {
+		return nil, false
+	}
+	metadata, ok := v.(*AccessDetails)
+	return metadata, ok
+}
+
+// TokenAuthMiddleware rejects requests without a valid token or API key, or whose token has been
+// revoked by logout, session revocation or the user being blocked. The token details are put in the context.
 func TokenAuthMiddleware(auth TokenInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := auth.TokenValid(c.Request)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
-			c.Abort()
+		_, err := authenticate(c, auth)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrTokenRevoked):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "session has ended, please login"})
+			return
+		case errors.Is(err, ErrInvalidAPIKey), errors.Is(err, ErrAPIKeyExpired):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		default:
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
 			return
 		}
 		c.Next()
@@ -21,23 +74,19 @@ func TokenAuthMiddleware(auth TokenInterface) gin.HandlerFunc {
 }
 
 // Authorize determines if current subject has been authorized to take an action on an object.
+// The object and action are recorded so that routes without a matching policy can be reported.
 func Authorize(obj, act string, enforcer *casbin.Enforcer, tkMng TokenInterface) gin.HandlerFunc {
+	registerPermission(obj, act)
+
 	return func(c *gin.Context) {
-		err := tkMng.TokenValid(c.Request)
+		metadata, err := authenticate(c, tkMng)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "user hasn't logged in yet"})
 			return
 		}
 
-		// Extract token
-		metadata, err := tkMng.ExtractTokenMetadata(c.Request)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
-			return
-		}
-
 		// casbin enforces polic
-		ok, err := enforcer.Enforce(fmt.Sprint(metadata.UserId), obj, act)
+		ok, err := enforcer.Enforce(metadata.Subject(), obj, act)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "error occurred while authorizing"})
 			return
@@ -51,3 +100,11 @@ func Authorize(obj, act string, enforcer *casbin.Enforcer, tkMng TokenInterface)
 		c.Next()
 	}
 }
+
+// Authorizer returns a shorthand for Authorize bound to an enforcer and token manager, for use when
+// registering the routes of a module.
+func Authorizer(enforcer *casbin.Enforcer, tkMng TokenInterface) func(obj, act string) gin.HandlerFunc {
+	return func(obj, act string) gin.HandlerFunc {
+		return Authorize(obj, act, enforcer, tkMng)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// Actions shared by most route permissions. Routes that move money use their own actions, such as
+// disburse or withdraw, so that they can be granted separately from plain updates.
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Permission is the casbin object and action that a route is authorized against
+type Permission struct {
+	Object string `json:"object"`
+	Action string `json:"action"`
+}
+
+// permissions holds the permissions of every route guarded by Authorize
+var permissions = struct {
+	sync.Mutex
+	set map[Permission]struct{}
+}{set: make(map[Permission]struct{})}
+
+func registerPermission(obj, act string) {
+	permissions.Lock()
+	permissions.set[Permission{Object: obj, Action: act}] = struct{}{}
+	permissions.Unlock()
+}
+
+// Permissions returns the permissions of all routes registered with Authorize, sorted by object and action
+func Permissions() []Permission {
+	permissions.Lock()
+	defer permissions.Unlock()
+
+	out := make([]Permission, 0, len(permissions.set))
+	for permission := range permissions.set {
+		out = append(out, permission)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Object != out[j].Object {
+			return out[i].Object < out[j].Object
+		}
+		return out[i].Action < out[j].Action
+	})
+
+	return out
+}
+
+// MissingPolicies returns the route permissions that no policy grants. Nobody can call such routes.
+func MissingPolicies(enforcer *casbin.Enforcer) ([]Permission, error) {
+	var missing []Permission
+	for _, permission := range Permissions() {
+		policies, err := enforcer.GetFilteredPolicy(1, permission.Object, permission.Action)
+		if err != nil {
+			return nil, err
+		}
+		if len(policies) == 0 {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
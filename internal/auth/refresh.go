@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already exchanged is presented
+// again. Its token family, the session it was issued to, has been revoked since the token may
+// have been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+func getUsedRefreshKey(refreshUuid string) string {
+	return fmt.Sprintf("refresh_used:%s", refreshUuid)
+}
+
+// ConsumeRefresh exchanges a refresh token exactly once. The token is deleted and remembered as
+// used until it would have expired, so that presenting it again revokes its session, or all
+// sessions of the user for tokens issued before sessions were tracked.
+func (tk *RedisAuthService) ConsumeRefresh(ctx context.Context, authD *AccessDetails) error {
+	tk.evict(authD.TokenUuid)
+
+	var (
+		ttlCmd    *redis.DurationCmd
+		getDelCmd *redis.StringCmd
+	)
+	_, err := tk.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		ttlCmd = pipe.PTTL(ctx, authD.TokenUuid)
+		getDelCmd = pipe.GetDel(ctx, authD.TokenUuid)
+		return nil
+	})
+
+	userId, getErr := getDelCmd.Result()
+	switch {
+	case getErr == nil:
+	case errors.Is(getErr, redis.Nil):
+		return tk.refreshReused(ctx, authD)
+	case err != nil:
+		return err
+	default:
+		return getErr
+	}
+
+	if userId != fmt.Sprint(authD.UserId) {
+		return ErrTokenRevoked
+	}
+
+	ttl := ttlCmd.Val()
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+
+	return tk.client.Set(ctx, getUsedRefreshKey(authD.TokenUuid), authD.SessionId, ttl).Err()
+}
+
+// refreshReused revokes the token family of a refresh token that is no longer stored, when it was
+// consumed before. Tokens deleted on logout or revocation were never consumed and are only rejected.
+func (tk *RedisAuthService) refreshReused(ctx context.Context, authD *AccessDetails) error {
+	sessionId, err := tk.client.Get(ctx, getUsedRefreshKey(authD.TokenUuid)).Result()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return ErrTokenRevoked
+	default:
+		return err
+	}
+
+	if strings.TrimSpace(sessionId) == "" {
+		_, err = tk.RevokeSessions(ctx, authD.UserId)
+	} else {
+		err = tk.RevokeSession(ctx, authD.UserId, sessionId)
+		if errors.Is(err, ErrSessionNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
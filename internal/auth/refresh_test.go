@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-memory redis implementing the commands used by the auth service. It is
+// installed as a hook, so commands never reach a server.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	ttls    map[string]time.Duration
+	sets    map[string]map[string]bool
+}
+
+func newFakeRedisClient() (*redis.Client, *fakeRedis) {
+	fake := &fakeRedis{
+		strings: make(map[string]string),
+		ttls:    make(map[string]time.Duration),
+		sets:    make(map[string]map[string]bool),
+	}
+	client := redis.NewClient(&redis.Options{Addr: "fake:6379"})
+	client.AddHook(fake)
+	return client, fake
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (f *fakeRedis) ProcessHook(redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			f.process(cmd)
+		}
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (f *fakeRedis) exists(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, isString := f.strings[key]
+	_, isSet := f.sets[key]
+	return isString || isSet
+}
+
+func (f *fakeRedis) get(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.strings[key]
+}
+
+func toString(arg interface{}) string {
+	if bs, ok := arg.([]byte); ok {
+		return string(bs)
+	}
+	return fmt.Sprint(arg)
+}
+
+func (f *fakeRedis) process(cmd redis.Cmder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	args := make([]string, 0, len(cmd.Args()))
+	for _, arg := range cmd.Args() {
+		args = append(args, toString(arg))
+	}
+
+	switch strings.ToLower(cmd.Name()) {
+	case "multi", "exec":
+	case "get", "getdel":
+		value, ok := f.strings[args[1]]
+		if !ok {
+			cmd.SetErr(redis.Nil)
+			return
+		}
+		if cmd.Name() == "getdel" {
+			delete(f.strings, args[1])
+			delete(f.ttls, args[1])
+		}
+		cmd.(*redis.StringCmd).SetVal(value)
+	case "set":
+		f.strings[args[1]] = args[2]
+		delete(f.ttls, args[1])
+		if len(args) == 5 {
+			n, _ := strconv.Atoi(args[4])
+			switch strings.ToLower(args[3]) {
+			case "px":
+				f.ttls[args[1]] = time.Duration(n) * time.Millisecond
+			case "ex":
+				f.ttls[args[1]] = time.Duration(n) * time.Second
+			}
+		}
+		cmd.(*redis.StatusCmd).SetVal("OK")
+	case "pttl":
+		ttl, ok := f.ttls[args[1]]
+		if !ok {
+			ttl = -2
+			if _, exists := f.strings[args[1]]; exists {
+				ttl = -1
+			}
+		}
+		cmd.(*redis.DurationCmd).SetVal(ttl)
+	case "del":
+		var deleted int64
+		for _, key := range args[1:] {
+			if _, ok := f.strings[key]; ok {
+				deleted++
+			} else if _, ok := f.sets[key]; ok {
+				deleted++
+			}
+			delete(f.strings, key)
+			delete(f.ttls, key)
+			delete(f.sets, key)
+		}
+		cmd.(*redis.IntCmd).SetVal(deleted)
+	case "sadd":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = make(map[string]bool)
+		}
+		for _, member := range args[2:] {
+			f.sets[args[1]][member] = true
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(len(args) - 2))
+	case "srem":
+		for _, member := range args[2:] {
+			delete(f.sets[args[1]], member)
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(len(args) - 2))
+	case "smembers":
+		members := make([]string, 0, len(f.sets[args[1]]))
+		for member := range f.sets[args[1]] {
+			members = append(members, member)
+		}
+		cmd.(*redis.StringSliceCmd).SetVal(members)
+	case "expire":
+		cmd.(*redis.BoolCmd).SetVal(true)
+	default:
+		cmd.SetErr(fmt.Errorf("fake redis does not support %s", cmd.Name()))
+	}
+}
+
+func TestConsumeRefresh(t *testing.T) {
+	const userId = 7
+
+	// login stores a session with its own access and refresh tokens
+	login := func(t *testing.T, tk *RedisAuthService, sessionId string) *AccessDetails {
+		td := &TokenDetails{
+			TokenUuid:   "access-" + sessionId,
+			RefreshUuid: "refresh-" + sessionId,
+			AtExpires:   time.Now().Add(15 * time.Minute).Unix(),
+			RtExpires:   time.Now().Add(24 * time.Hour).Unix(),
+		}
+		if err := tk.SaveSession(context.Background(), &Session{ID: sessionId, UserId: userId}, td); err != nil {
+			t.Fatalf("SaveSession() error = %v", err)
+		}
+		return &AccessDetails{TokenUuid: td.RefreshUuid, SessionId: sessionId, UserId: userId}
+	}
+
+	tests := []struct {
+		name         string
+		sessions     []string
+		consume      func(t *testing.T, tk *RedisAuthService, authD *AccessDetails) error
+		wantErr      error
+		wantSessions []string
+	}{
+		{
+			name:     "first use consumes the token",
+			sessions: []string{"s1", "s2"},
+			consume: func(t *testing.T, tk *RedisAuthService, authD *AccessDetails) error {
+				return tk.ConsumeRefresh(context.Background(), authD)
+			},
+			wantSessions: []string{"s1", "s2"},
+		},
+		{
+			name:     "reuse revokes the session of the token",
+			sessions: []string{"s1", "s2"},
+			consume: func(t *testing.T, tk *RedisAuthService, authD *AccessDetails) error {
+				if err := tk.ConsumeRefresh(context.Background(), authD); err != nil {
+					t.Fatalf("first ConsumeRefresh() error = %v", err)
+				}
+				return tk.ConsumeRefresh(context.Background(), authD)
+			},
+			wantErr:      ErrRefreshTokenReused,
+			wantSessions: []string{"s2"},
+		},
+		{
+			name:     "reuse of a token without a session revokes all sessions",
+			sessions: []string{"s1", "s2"},
+			consume: func(t *testing.T, tk *RedisAuthService, authD *AccessDetails) error {
+				authD.SessionId = ""
+				if err := tk.ConsumeRefresh(context.Background(), authD); err != nil {
+					t.Fatalf("first ConsumeRefresh() error = %v", err)
+				}
+				return tk.ConsumeRefresh(context.Background(), authD)
+			},
+			wantErr: ErrRefreshTokenReused,
+		},
+		{
+			name:     "token deleted on logout is only rejected",
+			sessions: []string{"s1", "s2"},
+			consume: func(t *testing.T, tk *RedisAuthService, authD *AccessDetails) error {
+				if err := tk.DeleteRefresh(context.Background(), authD.TokenUuid); err != nil {
+					t.Fatalf("DeleteRefresh() error = %v", err)
+				}
+				return tk.ConsumeRefresh(context.Background(), authD)
+			},
+			wantErr:      ErrTokenRevoked,
+			wantSessions: []string{"s1", "s2"},
+		},
+		{
+			name:     "token of another user is rejected",
+			sessions: []string{"s1"},
+			consume: func(t *testing.T, tk *RedisAuthService, authD *AccessDetails) error {
+				authD.UserId = userId + 1
+				return tk.ConsumeRefresh(context.Background(), authD)
+			},
+			wantErr:      ErrTokenRevoked,
+			wantSessions: []string{"s1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newFakeRedisClient()
+			tk := NewAuthService(client, time.Minute)
+
+			var authD *AccessDetails
+			for _, sessionId := range tt.sessions {
+				details := login(t, tk, sessionId)
+				if authD == nil {
+					authD = details
+				}
+			}
+			refreshUuid := authD.TokenUuid
+
+			err := tt.consume(t, tk, authD)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("ConsumeRefresh() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if fake.exists(refreshUuid) {
+				t.Error("refresh token can still be used")
+			}
+			if tt.wantErr == nil {
+				if used := fake.get(getUsedRefreshKey(refreshUuid)); used != authD.SessionId {
+					t.Errorf("refresh token is remembered as used by session %q, want %q", used, authD.SessionId)
+				}
+			}
+
+			sessions, err := tk.ListSessions(context.Background(), userId)
+			if err != nil {
+				t.Fatalf("ListSessions() error = %v", err)
+			}
+			var got []string
+			for _, session := range sessions {
+				got = append(got, session.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantSessions) {
+				t.Errorf("sessions = %v, want %v", got, tt.wantSessions)
+			}
+
+			// Tokens of revoked sessions are deleted with them
+			for _, sessionId := range tt.sessions {
+				revoked := !slices.Contains(tt.wantSessions, sessionId)
+				if revoked == fake.exists("access-"+sessionId) {
+					t.Errorf("access token of session %s exists = %v, want %v", sessionId, !revoked, !revoked)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrSessionNotFound is returned when a session does not exist, has expired or has been revoked
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login on a device. It outlives the tokens issued to it, which are replaced on every refresh.
+type Session struct {
+	ID          string    `json:"id"`
+	UserId      uint64    `json:"user_id"`
+	Device      string    `json:"device,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	AccessUuid  string    `json:"access_uuid,omitempty"`
+	RefreshUuid string    `json:"refresh_uuid,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func getSessionKey(sessionId string) string {
+	return fmt.Sprintf("session:%s", sessionId)
+}
+
+func getUserSessionsKey(userId uint64) string {
+	return fmt.Sprintf("user_sessions:%d", userId)
+}
+
+// SaveSession stores the tokens of a session and indexes the session under its user. The tokens
+// previously issued to the session are deleted so that only the latest pair can be used.
+func (tk *RedisAuthService) SaveSession(ctx context.Context, session *Session, td *TokenDetails) error {
+	previous, err := tk.FetchSession(ctx, session.ID)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrSessionNotFound):
+		previous = nil
+	default:
+		return err
+	}
+
+	now := time.Now()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = time.Unix(td.RtExpires, 0)
+	session.AccessUuid = td.TokenUuid
+	session.RefreshUuid = td.RefreshUuid
+
+	bs, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	if err := tk.CreateAuth(ctx, session.UserId, td); err != nil {
+		return err
+	}
+
+	ttl := session.ExpiresAt.Sub(now)
+
+	pipe := tk.client.TxPipeline()
+	pipe.Set(ctx, getSessionKey(session.ID), bs, ttl)
+	pipe.SAdd(ctx, getUserSessionsKey(session.UserId), session.ID)
+	pipe.Expire(ctx, getUserSessionsKey(session.UserId), ttl)
+	if previous != nil {
+		tk.evict(previous.AccessUuid, previous.RefreshUuid)
+		if previous.AccessUuid != td.TokenUuid {
+			pipe.Del(ctx, previous.AccessUuid)
+		}
+		if previous.RefreshUuid != td.RefreshUuid {
+			pipe.Del(ctx, previous.RefreshUuid)
+		}
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// FetchSession retrieves a session by its id
+func (tk *RedisAuthService) FetchSession(ctx context.Context, sessionId string) (*Session, error) {
+	bs, err := tk.client.Get(ctx, getSessionKey(sessionId)).Bytes()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return nil, ErrSessionNotFound
+	default:
+		return nil, err
+	}
+
+	session := &Session{}
+	if err := json.Unmarshal(bs, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// ListSessions retrieves the active sessions of a user, most recently used first
+func (tk *RedisAuthService) ListSessions(ctx context.Context, userId uint64) ([]*Session, error) {
+	ids, err := tk.client.SMembers(ctx, getUserSessionsKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(ids))
+	stale := make([]interface{}, 0)
+
+	for _, id := range ids {
+		session, err := tk.FetchSession(ctx, id)
+		switch {
+		case err == nil:
+			sessions = append(sessions, session)
+		case errors.Is(err, ErrSessionNotFound):
+			stale = append(stale, id)
+		default:
+			return nil, err
+		}
+	}
+
+	// Expired sessions are dropped from the index lazily
+	if len(stale) > 0 {
+		tk.client.SRem(ctx, getUserSessionsKey(userId), stale...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession deletes a session of a user together with its tokens
+func (tk *RedisAuthService) RevokeSession(ctx context.Context, userId uint64, sessionId string) error {
+	session, err := tk.FetchSession(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if session.UserId != userId {
+		return ErrSessionNotFound
+	}
+
+	tk.evict(session.AccessUuid, session.RefreshUuid)
+
+	pipe := tk.client.TxPipeline()
+	pipe.Del(ctx, getSessionKey(session.ID), session.AccessUuid, session.RefreshUuid)
+	pipe.SRem(ctx, getUserSessionsKey(userId), session.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RevokeSessions deletes all sessions of a user and returns how many were revoked
+func (tk *RedisAuthService) RevokeSessions(ctx context.Context, userId uint64) (int, error) {
+	sessions, err := tk.ListSessions(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(sessions)*3)
+	for _, session := range sessions {
+		keys = append(keys, getSessionKey(session.ID), session.AccessUuid, session.RefreshUuid)
+		tk.evict(session.AccessUuid, session.RefreshUuid)
+	}
+
+	pipe := tk.client.TxPipeline()
+	if len(keys) > 0 {
+		pipe.Del(ctx, keys...)
+	}
+	pipe.Del(ctx, getUserSessionsKey(userId))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sessions), nil
+}
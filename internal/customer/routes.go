@@ -1,6 +1,7 @@
 package customer
 
 import (
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/grpclog"
@@ -12,19 +13,21 @@ type Options struct {
 	Logger       grpclog.LoggerV2
 	TokenManager auth.TokenInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
 }
 
 // RegisterRoutes registers all application routes for customer management
 func RegisterRoutes(opt *Options) {
 	customerController := CustomerController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
 
 	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
 	{
-		v1.POST("/customers", customerController.CreateCustomer)
-		v1.GET("/customers", customerController.ListCustomers)
-		v1.GET("/customers/:id", customerController.GetCustomer)
-		v1.PATCH("/customers/:id", customerController.UpdateCustomer)
-		v1.DELETE("/customers/:id", customerController.DeleteCustomer)
-		v1.GET("/customer-stats", customerController.GetStats)
+		v1.POST("/customers", authorize("customers", auth.ActionCreate), customerController.CreateCustomer)
+		v1.GET("/customers", authorize("customers", auth.ActionRead), customerController.ListCustomers)
+		v1.GET("/customers/:id", authorize("customers", auth.ActionRead), customerController.GetCustomer)
+		v1.PATCH("/customers/:id", authorize("customers", auth.ActionUpdate), customerController.UpdateCustomer)
+		v1.DELETE("/customers/:id", authorize("customers", auth.ActionDelete), customerController.DeleteCustomer)
+		v1.GET("/customer-stats", authorize("customers", auth.ActionRead), customerController.GetStats)
 	}
 }
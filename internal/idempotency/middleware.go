@@ -0,0 +1,201 @@
+// Package idempotency makes retried POST requests safe by replaying the response of the first
+// request that carried the same Idempotency-Key.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Header is the request header carrying the idempotency key
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on responses that are replayed from an earlier request
+const ReplayedHeader = "Idempotent-Replayed"
+
+const (
+	maxKeyLength = 255
+	maxBodySize  = 1 << 20
+
+	// Requests are locked for long enough to cover slow upstream calls such as M-Pesa payouts
+	lockTTL = 2 * time.Minute
+	// Responses are kept for as long as clients retry
+	responseTTL = 24 * time.Hour
+)
+
+const (
+	statusProcessing = "processing"
+	statusCompleted  = "completed"
+)
+
+// record is the state of an idempotency key stored in redis
+type record struct {
+	Status      string `json:"status"`
+	Fingerprint string `json:"fingerprint"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// responseRecorder captures the response written by the handler
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func getKey(subject, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s", subject, key)
+}
+
+// fingerprint identifies a request by its method, path and body
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Middleware stores the first response to a request with an Idempotency-Key header for each key and
+// user, and replays it when the request is retried. A retry whose body differs from the first request
+// is rejected with 422, and a retry that arrives while the first request is still being processed is
+// rejected with 409. Server errors are not stored so that the request can be retried. Requests
+// without the header are passed through, as are all requests when client is nil.
+func Middleware(client *redis.Client, tokenManager auth.TokenInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" || client == nil {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must not be longer than %d characters", Header, maxKeyLength)})
+			return
+		}
+
+		metadata, err := tokenManager.ExtractTokenMetadata(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		if len(body) > maxBodySize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var (
+			ctx      = c.Request.Context()
+			redisKey = getKey(metadata.Subject(), key)
+			current  = &record{Status: statusProcessing, Fingerprint: fingerprint(c.Request, body)}
+		)
+
+		bs, err := json.Marshal(current)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process idempotency key"})
+			return
+		}
+
+		acquired, err := client.SetNX(ctx, redisKey, bs, lockTTL).Result()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to process idempotency key"})
+			return
+		}
+
+		if !acquired {
+			replay(c, client, redisKey, current.Fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		// The response must be stored even if the client has gone away
+		ctx = context.WithoutCancel(ctx)
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			client.Del(ctx, redisKey)
+			return
+		}
+
+		current.Status = statusCompleted
+		current.StatusCode = status
+		current.ContentType = recorder.Header().Get("Content-Type")
+		current.Body = recorder.body.Bytes()
+
+		bs, err = json.Marshal(current)
+		if err == nil {
+			err = client.Set(ctx, redisKey, bs, responseTTL).Err()
+		}
+		if err != nil {
+			// Releasing the key lets the client retry rather than be locked out until it expires
+			client.Del(ctx, redisKey)
+		}
+	}
+}
+
+// replay writes the stored response of an idempotency key
+func replay(c *gin.Context, client *redis.Client, redisKey, requestFingerprint string) {
+	bs, err := client.Get(c.Request.Context(), redisKey).Bytes()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		// The first request failed and released the key in the meantime
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this idempotency key is being processed, retry later"})
+		return
+	default:
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to process idempotency key"})
+		return
+	}
+
+	var stored record
+	if err := json.Unmarshal(bs, &stored); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process idempotency key"})
+		return
+	}
+
+	switch {
+	case stored.Fingerprint != requestFingerprint:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency key was already used for a different request"})
+	case stored.Status != statusCompleted:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this idempotency key is being processed, retry later"})
+	default:
+		c.Header(ReplayedHeader, "true")
+		c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+		c.Abort()
+	}
+}
@@ -0,0 +1,245 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-memory redis implementing the commands used by the middleware. It is installed
+// as a hook, so commands never reach a server.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+}
+
+func newFakeRedisClient() (*redis.Client, *fakeRedis) {
+	fake := &fakeRedis{strings: make(map[string]string)}
+	client := redis.NewClient(&redis.Options{Addr: "fake:6379"})
+	client.AddHook(fake)
+	return client, fake
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (f *fakeRedis) ProcessHook(redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			f.process(cmd)
+		}
+		return nil
+	}
+}
+
+func (f *fakeRedis) exists(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.strings[key]
+	return ok
+}
+
+func (f *fakeRedis) process(cmd redis.Cmder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	args := make([]string, 0, len(cmd.Args()))
+	for _, arg := range cmd.Args() {
+		if bs, ok := arg.([]byte); ok {
+			args = append(args, string(bs))
+		} else {
+			args = append(args, fmt.Sprint(arg))
+		}
+	}
+
+	switch strings.ToLower(cmd.Name()) {
+	case "get":
+		value, ok := f.strings[args[1]]
+		if !ok {
+			cmd.SetErr(redis.Nil)
+			return
+		}
+		cmd.(*redis.StringCmd).SetVal(value)
+	case "set":
+		_, exists := f.strings[args[1]]
+		if strings.EqualFold(args[len(args)-1], "nx") {
+			if !exists {
+				f.strings[args[1]] = args[2]
+			}
+			cmd.(*redis.BoolCmd).SetVal(!exists)
+			return
+		}
+		f.strings[args[1]] = args[2]
+		cmd.(*redis.StatusCmd).SetVal("OK")
+	case "del":
+		var deleted int64
+		for _, key := range args[1:] {
+			if _, ok := f.strings[key]; ok {
+				deleted++
+			}
+			delete(f.strings, key)
+		}
+		cmd.(*redis.IntCmd).SetVal(deleted)
+	default:
+		cmd.SetErr(fmt.Errorf("fake redis does not support %s", cmd.Name()))
+	}
+}
+
+// fakeTokenManager authenticates every request as the same user
+type fakeTokenManager struct {
+	auth.TokenInterface
+}
+
+func (fakeTokenManager) ExtractTokenMetadata(*http.Request) (*auth.AccessDetails, error) {
+	return &auth.AccessDetails{UserId: 7}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const (
+		key  = "key-1"
+		body = `{"amount":100}`
+	)
+
+	tests := []struct {
+		name         string
+		firstStatus  int
+		retryBody    string
+		inFlight     bool // The retry arrives while the first request is being processed
+		wantStatus   int
+		wantBody     string
+		wantReplayed bool
+		wantCalls    int
+	}{
+		{
+			name:         "retry replays the stored response",
+			firstStatus:  http.StatusCreated,
+			retryBody:    body,
+			wantStatus:   http.StatusCreated,
+			wantBody:     `{"call":1}`,
+			wantReplayed: true,
+			wantCalls:    1,
+		},
+		{
+			name:         "replayed client errors keep their status",
+			firstStatus:  http.StatusBadRequest,
+			retryBody:    body,
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     `{"call":1}`,
+			wantReplayed: true,
+			wantCalls:    1,
+		},
+		{
+			name:        "retry with a different body is rejected",
+			firstStatus: http.StatusCreated,
+			retryBody:   `{"amount":200}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCalls:   1,
+		},
+		{
+			name:        "retry while the first request is in flight is rejected",
+			firstStatus: http.StatusCreated,
+			retryBody:   body,
+			inFlight:    true,
+			wantStatus:  http.StatusConflict,
+			wantCalls:   1,
+		},
+		{
+			name:        "server error releases the key",
+			firstStatus: http.StatusInternalServerError,
+			retryBody:   body,
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"call":2}`,
+			wantCalls:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newFakeRedisClient()
+
+			var (
+				mu      sync.Mutex
+				calls   int
+				started = make(chan struct{})
+				release = make(chan struct{})
+			)
+
+			router := gin.New()
+			router.POST("/payments", Middleware(client, fakeTokenManager{}), func(c *gin.Context) {
+				mu.Lock()
+				calls++
+				call := calls
+				mu.Unlock()
+
+				status := http.StatusCreated
+				if call == 1 {
+					status = tt.firstStatus
+					if tt.inFlight {
+						close(started)
+						<-release
+					}
+				}
+				c.JSON(status, gin.H{"call": call})
+			})
+
+			send := func(body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+				req.Header.Set(Header, key)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w
+			}
+
+			first := make(chan *httptest.ResponseRecorder, 1)
+			if tt.inFlight {
+				go func() { first <- send(body) }()
+				<-started
+			} else {
+				first <- send(body)
+			}
+
+			retry := send(tt.retryBody)
+			close(release)
+
+			if w := <-first; w.Code != tt.firstStatus {
+				t.Errorf("first request status = %d, want %d", w.Code, tt.firstStatus)
+			}
+
+			if retry.Code != tt.wantStatus {
+				t.Errorf("retry status = %d, want %d", retry.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && retry.Body.String() != tt.wantBody {
+				t.Errorf("retry body = %s, want %s", retry.Body.String(), tt.wantBody)
+			}
+			if replayed := retry.Header().Get(ReplayedHeader) == "true"; replayed != tt.wantReplayed {
+				t.Errorf("retry replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+
+			// Every request here succeeds in the end, so its response is kept
+			if !fake.exists(getKey("7", key)) {
+				t.Error("idempotency key was not kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+package ledger
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+)
+
+type Options struct {
+	DB           *gorm.DB
+	Logger       grpclog.LoggerV2
+	TokenManager auth.TokenInterface
+	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
+	RedisDB      *redis.Client
+}
+
+// LedgerController structure
+type LedgerController struct {
+	*Options
+}
+
+// SourceManual is the source type of journal entries posted by finance users
+const SourceManual = "MANUAL"
+
+// maxStatementLines caps the number of lines returned in an account statement
+const maxStatementLines = 5000
+
+// reconciliationControls are the control accounts that must equal the balances of loan and savings accounts
+var reconciliationControls = []struct {
+	code          string
+	description   string
+	table         string
+	column        string
+	subledgerType string
+	where         string
+}{
+	{AccountLoansReceivable, "Outstanding loan principal", "loan_account", "outstanding_principle", SubledgerLoan, "status_id > 0"},
+	{AccountInterestReceivable, "Outstanding loan interest", "loan_account", "outstanding_interest", SubledgerLoan, "status_id > 0"},
+	{AccountFeesReceivable, "Outstanding loan fees", "loan_account", "outstanding_setup_fees", SubledgerLoan, "status_id > 0"},
+	{AccountPenaltiesReceivable, "Outstanding loan penalties", "loan_account", "outstanding_penalty_fees", SubledgerLoan, "status_id > 0"},
+	{AccountSavingsDeposits, "Savings account balances", "savings_account", "balance", SubledgerSavings, "1 = 1"},
+}
+
+// parseDate parses an optional YYYY-MM-DD query parameter
+func parseDate(value string) (time.Time, bool, error) {
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	return t, err == nil, err
+}
+
+// ListAccounts lists the chart of accounts
+func (ctrl *LedgerController) ListAccounts(c *gin.Context) {
+	var accounts []*Account
+	if err := ctrl.DB.WithContext(c.Request.Context()).Order("code ASC").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ledger accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// CreateAccount adds an account to the chart of accounts
+func (ctrl *LedgerController) CreateAccount(c *gin.Context) {
+	var dto CreateAccountDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	if err := ctrl.DB.WithContext(c.Request.Context()).Model(&Account{}).Where("code = ?", dto.Code).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ledger account"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ledger account code already exists"})
+		return
+	}
+
+	account := &Account{Code: dto.Code, Name: dto.Name, Type: dto.Type}
+
+	if err := ctrl.DB.WithContext(c.Request.Context()).Create(account).Error; err != nil {
+		ctrl.Logger.Errorf("Failed to create ledger account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ledger account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+// CreateJournalEntry posts a manual journal entry
+func (ctrl *LedgerController) CreateJournalEntry(c *gin.Context) {
+	var dto CreateJournalEntryDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entryDate, _, err := parseDate(dto.EntryDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry_date, expected YYYY-MM-DD"})
+		return
+	}
+
+	entry := &JournalEntry{
+		EntryDate:  entryDate,
+		SourceType: SourceManual,
+		SourceID:   uuid.NewString(),
+		Reference:  dto.Reference,
+		Narration:  dto.Narration,
+		Lines:      make([]*JournalLine, 0, len(dto.Lines)),
+	}
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		entry.PostedBy = metadata.UserId
+	}
+	for _, line := range dto.Lines {
+		entry.Lines = append(entry.Lines, &JournalLine{
+			AccountCode:   line.AccountCode,
+			Debit:         line.Debit,
+			Credit:        line.Credit,
+			SubledgerType: line.SubledgerType,
+			SubledgerID:   line.SubledgerID,
+			Narration:     line.Narration,
+		})
+	}
+
+	err = ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		return Post(tx, entry)
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrUnbalanced), errors.Is(err, ErrInvalidLine), errors.Is(err, ErrUnknownAccount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		ctrl.Logger.Errorf("Failed to post journal entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post journal entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ToJournalEntryResponse(entry))
+}
+
+// ListJournalEntries lists journal entries with their lines, newest first
+func (ctrl *LedgerController) ListJournalEntries(c *gin.Context) {
+	var (
+		queryParams = c.Request.URL.Query()
+		sourceType  = queryParams.Get("source_type")
+		sourceID    = queryParams.Get("source_id")
+	)
+
+	pageSize, _ := strconv.Atoi(queryParams.Get("pageSize"))
+	switch {
+	case pageSize <= 0:
+		pageSize = 50
+	case pageSize > 500:
+		pageSize = 500
+	}
+
+	from, hasFrom, errFrom := parseDate(queryParams.Get("from"))
+	to, hasTo, errTo := parseDate(queryParams.Get("to"))
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from or to date, expected YYYY-MM-DD"})
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Preload("Lines").Order("id DESC").Limit(pageSize)
+	if sourceType != "" {
+		db = db.Where("source_type = ?", sourceType)
+	}
+	if sourceID != "" {
+		db = db.Where("source_id = ?", sourceID)
+	}
+	if hasFrom {
+		db = db.Where("entry_date >= ?", from)
+	}
+	if hasTo {
+		db = db.Where("entry_date <= ?", to)
+	}
+
+	var entries []*JournalEntry
+	if err := db.Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve journal entries"})
+		return
+	}
+
+	response := make([]*JournalEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, ToJournalEntryResponse(entry))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"journal_entries": response})
+}
+
+// GetTrialBalance reports the debit and credit totals of every ledger account as at a date
+func (ctrl *LedgerController) GetTrialBalance(c *gin.Context) {
+	asOf, hasAsOf, err := parseDate(c.Query("as_of"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of date, expected YYYY-MM-DD"})
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Table("journal_line").
+		Select("journal_line.account_code, COALESCE(SUM(journal_line.debit), 0) AS debit, COALESCE(SUM(journal_line.credit), 0) AS credit").
+		Joins("JOIN journal_entry ON journal_entry.id = journal_line.journal_entry_id").
+		Group("journal_line.account_code")
+	if hasAsOf {
+		db = db.Where("journal_entry.entry_date <= ?", asOf)
+	}
+
+	var totals []struct {
+		AccountCode string
+		Debit       float64
+		Credit      float64
+	}
+	if err := db.Scan(&totals).Error; err != nil {
+		ctrl.Logger.Errorf("Failed to compute trial balance: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute trial balance"})
+		return
+	}
+
+	var accounts []*Account
+	if err := ctrl.DB.WithContext(c.Request.Context()).Order("code ASC").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ledger accounts"})
+		return
+	}
+
+	byCode := make(map[string]int, len(totals))
+	for i, total := range totals {
+		byCode[total.AccountCode] = i
+	}
+
+	var (
+		lines        = make([]*TrialBalanceLine, 0, len(accounts))
+		totalDebits  float64
+		totalCredits float64
+	)
+	for _, account := range accounts {
+		line := &TrialBalanceLine{Code: account.Code, Name: account.Name, Type: account.Type}
+		if i, ok := byCode[account.Code]; ok {
+			line.Debit = moneyutil.Round(totals[i].Debit)
+			line.Credit = moneyutil.Round(totals[i].Credit)
+		}
+		line.Balance = signedBalance(account, line.Debit, line.Credit)
+		totalDebits += line.Debit
+		totalCredits += line.Credit
+		lines = append(lines, line)
+	}
+
+	response := gin.H{
+		"accounts":      lines,
+		"total_debits":  moneyutil.Round(totalDebits),
+		"total_credits": moneyutil.Round(totalCredits),
+		"balanced":      moneyutil.Round(totalDebits) == moneyutil.Round(totalCredits),
+	}
+	if hasAsOf {
+		response["as_of"] = asOf.Format(time.DateOnly)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// signedBalance returns the balance of an account signed by its normal balance
+func signedBalance(account *Account, debit, credit float64) float64 {
+	if account.DebitNormal() {
+		return moneyutil.Round(debit - credit)
+	}
+	return moneyutil.Round(credit - debit)
+}
+
+// GetAccountStatement lists the journal lines of a ledger account with a running balance. The
+// statement can be narrowed to a single loan or savings account with subledger_type and subledger_id.
+func (ctrl *LedgerController) GetAccountStatement(c *gin.Context) {
+	var (
+		code          = c.Param("code")
+		queryParams   = c.Request.URL.Query()
+		subledgerType = queryParams.Get("subledger_type")
+		subledgerID   = queryParams.Get("subledger_id")
+	)
+
+	var account Account
+	if err := ctrl.DB.WithContext(c.Request.Context()).First(&account, "code = ?", code).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Ledger account not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ledger account"})
+		}
+		return
+	}
+
+	from, hasFrom, errFrom := parseDate(queryParams.Get("from"))
+	to, hasTo, errTo := parseDate(queryParams.Get("to"))
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from or to date, expected YYYY-MM-DD"})
+		return
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Table("journal_line").
+			Joins("JOIN journal_entry ON journal_entry.id = journal_line.journal_entry_id").
+			Where("journal_line.account_code = ?", code)
+		if subledgerType != "" {
+			db = db.Where("journal_line.subledger_type = ?", subledgerType)
+		}
+		if subledgerID != "" {
+			db = db.Where("journal_line.subledger_id = ?", subledgerID)
+		}
+		return db
+	}
+
+	// Opening balance is the sum of lines before the statement period
+	var opening struct {
+		Debit  float64
+		Credit float64
+	}
+	if hasFrom {
+		err := filter(ctrl.DB.WithContext(c.Request.Context())).
+			Select("COALESCE(SUM(journal_line.debit), 0) AS debit, COALESCE(SUM(journal_line.credit), 0) AS credit").
+			Where("journal_entry.entry_date < ?", from).
+			Scan(&opening).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute opening balance"})
+			return
+		}
+	}
+
+	db := filter(ctrl.DB.WithContext(c.Request.Context())).
+		Select(`journal_entry.id AS journal_entry_id, journal_entry.entry_date, journal_entry.source_type, journal_entry.source_id,
+			journal_entry.reference, COALESCE(NULLIF(journal_line.narration, ''), journal_entry.narration) AS narration,
+			journal_line.subledger_type, journal_line.subledger_id, journal_line.debit, journal_line.credit`).
+		Order("journal_entry.entry_date ASC, journal_line.id ASC").
+		Limit(maxStatementLines)
+	if hasFrom {
+		db = db.Where("journal_entry.entry_date >= ?", from)
+	}
+	if hasTo {
+		db = db.Where("journal_entry.entry_date <= ?", to)
+	}
+
+	var rows []struct {
+		JournalEntryID uint
+		EntryDate      time.Time
+		SourceType     string
+		SourceID       string
+		Reference      string
+		Narration      string
+		SubledgerType  string
+		SubledgerID    uint
+		Debit          float64
+		Credit         float64
+	}
+	if err := db.Scan(&rows).Error; err != nil {
+		ctrl.Logger.Errorf("Failed to retrieve account statement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve account statement"})
+		return
+	}
+
+	openingBalance := signedBalance(&account, opening.Debit, opening.Credit)
+	balance := openingBalance
+	lines := make([]*StatementLine, 0, len(rows))
+	for _, row := range rows {
+		balance = moneyutil.Round(balance + signedBalance(&account, row.Debit, row.Credit))
+		lines = append(lines, &StatementLine{
+			JournalEntryID: row.JournalEntryID,
+			EntryDate:      row.EntryDate.Format(time.DateOnly),
+			SourceType:     row.SourceType,
+			SourceID:       row.SourceID,
+			Reference:      row.Reference,
+			Narration:      row.Narration,
+			SubledgerType:  row.SubledgerType,
+			SubledgerID:    row.SubledgerID,
+			Debit:          row.Debit,
+			Credit:         row.Credit,
+			RunningBalance: balance,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"account":         account,
+		"opening_balance": openingBalance,
+		"closing_balance": balance,
+		"lines":           lines,
+	})
+}
+
+// GetReconciliation compares the ledger control accounts with the balances of loan and savings
+// accounts, both in total and per customer account
+func (ctrl *LedgerController) GetReconciliation(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var (
+		controls   = make([]*ReconciliationLine, 0, len(reconciliationControls))
+		mismatches = make([]*SubledgerMismatch, 0)
+		reconciled = true
+	)
+
+	for _, control := range reconciliationControls {
+		account := &Account{Code: control.code}
+		if err := ctrl.DB.WithContext(ctx).First(account, "code = ?", control.code).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ledger account " + control.code})
+			return
+		}
+
+		var ledgerBalances []struct {
+			SubledgerID uint
+			Debit       float64
+			Credit      float64
+		}
+		err := ctrl.DB.WithContext(ctx).Table("journal_line").
+			Select("subledger_id, COALESCE(SUM(debit), 0) AS debit, COALESCE(SUM(credit), 0) AS credit").
+			Where("account_code = ?", control.code).
+			Group("subledger_id").
+			Scan(&ledgerBalances).Error
+		if err != nil {
+			ctrl.Logger.Errorf("Failed to compute ledger balances: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute ledger balances"})
+			return
+		}
+
+		var sourceBalances []struct {
+			ID      uint
+			Balance float64
+		}
+		err = ctrl.DB.WithContext(ctx).Table(control.table).
+			Select("id, " + control.column + " AS balance").
+			Where(control.where).
+			Scan(&sourceBalances).Error
+		if err != nil {
+			ctrl.Logger.Errorf("Failed to retrieve %s balances: %v", control.table, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve account balances"})
+			return
+		}
+
+		line := &ReconciliationLine{AccountCode: control.code, Description: control.description}
+		ledgerByID := make(map[uint]float64, len(ledgerBalances))
+		for _, balance := range ledgerBalances {
+			amount := signedBalance(account, balance.Debit, balance.Credit)
+			ledgerByID[balance.SubledgerID] = amount
+			line.LedgerBalance += amount
+		}
+
+		seen := make(map[uint]struct{}, len(sourceBalances))
+		for _, balance := range sourceBalances {
+			line.SourceBalance += balance.Balance
+			seen[balance.ID] = struct{}{}
+			if difference := moneyutil.Round(ledgerByID[balance.ID] - balance.Balance); difference != 0 {
+				mismatches = append(mismatches, &SubledgerMismatch{
+					AccountCode:   control.code,
+					SubledgerType: control.subledgerType,
+					SubledgerID:   balance.ID,
+					LedgerBalance: moneyutil.Round(ledgerByID[balance.ID]),
+					SourceBalance: moneyutil.Round(balance.Balance),
+					Difference:    difference,
+				})
+			}
+		}
+		// Ledger balances without a matching customer account
+		for id, amount := range ledgerByID {
+			if _, ok := seen[id]; !ok && moneyutil.Round(amount) != 0 {
+				mismatches = append(mismatches, &SubledgerMismatch{
+					AccountCode:   control.code,
+					SubledgerType: control.subledgerType,
+					SubledgerID:   id,
+					LedgerBalance: moneyutil.Round(amount),
+					Difference:    moneyutil.Round(amount),
+				})
+			}
+		}
+
+		line.LedgerBalance = moneyutil.Round(line.LedgerBalance)
+		line.SourceBalance = moneyutil.Round(line.SourceBalance)
+		line.Difference = moneyutil.Round(line.LedgerBalance - line.SourceBalance)
+		if line.Difference != 0 {
+			reconciled = false
+		}
+		controls = append(controls, line)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reconciled": reconciled && len(mismatches) == 0,
+		"controls":   controls,
+		"mismatches": mismatches,
+	})
+}
@@ -0,0 +1,124 @@
+package ledger
+
+import "time"
+
+// CreateAccountDTO defines the JSON structure for adding a ledger account
+type CreateAccountDTO struct {
+	Code string `json:"code" binding:"required,max=20"`
+	Name string `json:"name" binding:"required,max=100"`
+	Type string `json:"type" binding:"required,oneof=ASSET LIABILITY EQUITY INCOME EXPENSE"`
+}
+
+// JournalLineDTO defines the JSON structure of a manual journal line
+type JournalLineDTO struct {
+	AccountCode   string  `json:"account_code" binding:"required"`
+	Debit         float64 `json:"debit" binding:"gte=0"`
+	Credit        float64 `json:"credit" binding:"gte=0"`
+	SubledgerType string  `json:"subledger_type" binding:"omitempty,oneof=LOAN SAVINGS"`
+	SubledgerID   uint    `json:"subledger_id"`
+	Narration     string  `json:"narration"`
+}
+
+// CreateJournalEntryDTO defines the JSON structure for posting a manual journal entry
+type CreateJournalEntryDTO struct {
+	EntryDate string            `json:"entry_date"` // YYYY-MM-DD, defaults to today
+	Reference string            `json:"reference" binding:"required"`
+	Narration string            `json:"narration" binding:"required"`
+	Lines     []*JournalLineDTO `json:"lines" binding:"required,min=2,dive"`
+}
+
+// JournalLineResponse defines the structure of a journal line returned in the response
+type JournalLineResponse struct {
+	ID            uint    `json:"id"`
+	AccountCode   string  `json:"account_code"`
+	Debit         float64 `json:"debit"`
+	Credit        float64 `json:"credit"`
+	SubledgerType string  `json:"subledger_type,omitempty"`
+	SubledgerID   uint    `json:"subledger_id,omitempty"`
+	Narration     string  `json:"narration,omitempty"`
+}
+
+// JournalEntryResponse defines the structure of a journal entry returned in the response
+type JournalEntryResponse struct {
+	ID         uint                   `json:"id"`
+	EntryDate  string                 `json:"entry_date"`
+	SourceType string                 `json:"source_type"`
+	SourceID   string                 `json:"source_id"`
+	Reference  string                 `json:"reference"`
+	Narration  string                 `json:"narration"`
+	PostedBy   uint64                 `json:"posted_by"`
+	Lines      []*JournalLineResponse `json:"lines"`
+	CreatedAt  string                 `json:"created_at"`
+}
+
+// ToJournalEntryResponse converts a JournalEntry to a JournalEntryResponse
+func ToJournalEntryResponse(entry *JournalEntry) *JournalEntryResponse {
+	lines := make([]*JournalLineResponse, 0, len(entry.Lines))
+	for _, line := range entry.Lines {
+		lines = append(lines, &JournalLineResponse{
+			ID:            line.ID,
+			AccountCode:   line.AccountCode,
+			Debit:         line.Debit,
+			Credit:        line.Credit,
+			SubledgerType: line.SubledgerType,
+			SubledgerID:   line.SubledgerID,
+			Narration:     line.Narration,
+		})
+	}
+
+	return &JournalEntryResponse{
+		ID:         entry.ID,
+		EntryDate:  entry.EntryDate.Format(time.DateOnly),
+		SourceType: entry.SourceType,
+		SourceID:   entry.SourceID,
+		Reference:  entry.Reference,
+		Narration:  entry.Narration,
+		PostedBy:   entry.PostedBy,
+		Lines:      lines,
+		CreatedAt:  entry.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// TrialBalanceLine is the balance of a ledger account in the trial balance
+type TrialBalanceLine struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+	Balance float64 `json:"balance"` // Signed by the normal balance of the account
+}
+
+// StatementLine is a journal line in an account statement
+type StatementLine struct {
+	JournalEntryID uint    `json:"journal_entry_id"`
+	EntryDate      string  `json:"entry_date"`
+	SourceType     string  `json:"source_type"`
+	SourceID       string  `json:"source_id"`
+	Reference      string  `json:"reference"`
+	Narration      string  `json:"narration"`
+	SubledgerType  string  `json:"subledger_type,omitempty"`
+	SubledgerID    uint    `json:"subledger_id,omitempty"`
+	Debit          float64 `json:"debit"`
+	Credit         float64 `json:"credit"`
+	RunningBalance float64 `json:"running_balance"`
+}
+
+// ReconciliationLine compares a ledger control account with the balances it should equal
+type ReconciliationLine struct {
+	AccountCode   string  `json:"account_code"`
+	Description   string  `json:"description"`
+	LedgerBalance float64 `json:"ledger_balance"`
+	SourceBalance float64 `json:"source_balance"`
+	Difference    float64 `json:"difference"`
+}
+
+// SubledgerMismatch is a customer account whose balance differs from its ledger balance
+type SubledgerMismatch struct {
+	AccountCode   string  `json:"account_code"`
+	SubledgerType string  `json:"subledger_type"`
+	SubledgerID   uint    `json:"subledger_id"`
+	LedgerBalance float64 `json:"ledger_balance"`
+	SourceBalance float64 `json:"source_balance"`
+	Difference    float64 `json:"difference"`
+}
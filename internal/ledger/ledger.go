@@ -0,0 +1,132 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"gorm.io/gorm"
+)
+
+// Errors returned when a journal entry cannot be posted
+var (
+	ErrUnbalanced     = errors.New("journal entry debits and credits do not balance")
+	ErrInvalidLine    = errors.New("journal line must have either a debit or a credit amount")
+	ErrUnknownAccount = errors.New("journal line references an unknown ledger account")
+	ErrMissingSource  = errors.New("journal entry is missing its source")
+)
+
+// Line is a convenience for building journal lines
+type Line struct {
+	Account       string
+	Amount        float64 // Positive amounts are debited, negative amounts are credited
+	SubledgerType string
+	SubledgerID   uint
+	Narration     string
+}
+
+// Debit returns a line debiting amount to account
+func Debit(account string, amount float64) *Line {
+	return &Line{Account: account, Amount: amount}
+}
+
+// Credit returns a line crediting amount to account
+func Credit(account string, amount float64) *Line {
+	return &Line{Account: account, Amount: -amount}
+}
+
+// For links the line to a customer loan or savings account
+func (l *Line) For(subledgerType string, subledgerID uint) *Line {
+	l.SubledgerType = subledgerType
+	l.SubledgerID = subledgerID
+	return l
+}
+
+// NewEntry builds a journal entry from lines, dropping lines with a zero amount
+func NewEntry(sourceType, sourceID, reference, narration string, lines ...*Line) *JournalEntry {
+	entry := &JournalEntry{
+		SourceType: sourceType,
+		SourceID:   sourceID,
+		Reference:  reference,
+		Narration:  narration,
+		Lines:      make([]*JournalLine, 0, len(lines)),
+	}
+	for _, line := range lines {
+		amount := moneyutil.Round(line.Amount)
+		if amount == 0 {
+			continue
+		}
+		journalLine := &JournalLine{
+			AccountCode:   line.Account,
+			SubledgerType: line.SubledgerType,
+			SubledgerID:   line.SubledgerID,
+			Narration:     line.Narration,
+		}
+		if amount > 0 {
+			journalLine.Debit = amount
+		} else {
+			journalLine.Credit = -amount
+		}
+		entry.Lines = append(entry.Lines, journalLine)
+	}
+	return entry
+}
+
+// Post validates and records a journal entry within tx. The debits and credits of the entry must
+// balance and every line must post to a known account. An entry is posted once per source, so
+// posting the same source again is a no-op. Entries without lines are ignored.
+func Post(tx *gorm.DB, entry *JournalEntry) error {
+	if len(entry.Lines) == 0 {
+		return nil
+	}
+	if entry.SourceType == "" || entry.SourceID == "" {
+		return ErrMissingSource
+	}
+
+	var debits, credits float64
+	codes := make(map[string]struct{}, len(entry.Lines))
+
+	for _, line := range entry.Lines {
+		line.Debit = moneyutil.Round(line.Debit)
+		line.Credit = moneyutil.Round(line.Credit)
+		if line.Debit < 0 || line.Credit < 0 || (line.Debit > 0) == (line.Credit > 0) {
+			return ErrInvalidLine
+		}
+		debits += line.Debit
+		credits += line.Credit
+		codes[line.AccountCode] = struct{}{}
+	}
+
+	if moneyutil.Round(debits) != moneyutil.Round(credits) {
+		return fmt.Errorf("%w: debits %.2f, credits %.2f", ErrUnbalanced, debits, credits)
+	}
+
+	accountCodes := make([]string, 0, len(codes))
+	for code := range codes {
+		accountCodes = append(accountCodes, code)
+	}
+
+	var known int64
+	if err := tx.Model(&Account{}).Where("code IN ?", accountCodes).Count(&known).Error; err != nil {
+		return err
+	}
+	if int(known) != len(accountCodes) {
+		return ErrUnknownAccount
+	}
+
+	var existing int64
+	err := tx.Model(&JournalEntry{}).Where("source_type = ? AND source_id = ?", entry.SourceType, entry.SourceID).Count(&existing).Error
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	if entry.EntryDate.IsZero() {
+		entry.EntryDate = time.Now().UTC()
+	}
+
+	return tx.Create(entry).Error
+}
@@ -0,0 +1,110 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPostRejectsInvalidEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   *JournalEntry
+		wantErr error
+	}{
+		{
+			name:  "entry without lines is ignored",
+			entry: NewEntry("TEST", "1", "", "", Debit(AccountCash, 0), Credit(AccountMpesa, 0)),
+		},
+		{
+			name:    "missing source type",
+			entry:   NewEntry("", "1", "", "", Debit(AccountCash, 100), Credit(AccountMpesa, 100)),
+			wantErr: ErrMissingSource,
+		},
+		{
+			name:    "missing source id",
+			entry:   NewEntry("TEST", "", "", "", Debit(AccountCash, 100), Credit(AccountMpesa, 100)),
+			wantErr: ErrMissingSource,
+		},
+		{
+			name:    "debits exceed credits",
+			entry:   NewEntry("TEST", "1", "", "", Debit(AccountCash, 100), Credit(AccountMpesa, 99.99)),
+			wantErr: ErrUnbalanced,
+		},
+		{
+			name: "credits exceed debits",
+			entry: NewEntry("TEST", "1", "", "",
+				Debit(AccountLoansReceivable, 1000),
+				Credit(AccountMpesa, 950),
+				Credit(AccountLoanFeeIncome, 60),
+			),
+			wantErr: ErrUnbalanced,
+		},
+		{
+			name: "line with both a debit and a credit",
+			entry: &JournalEntry{SourceType: "TEST", SourceID: "1", Lines: []*JournalLine{
+				{AccountCode: AccountCash, Debit: 100, Credit: 100},
+			}},
+			wantErr: ErrInvalidLine,
+		},
+		{
+			name: "line without an amount",
+			entry: &JournalEntry{SourceType: "TEST", SourceID: "1", Lines: []*JournalLine{
+				{AccountCode: AccountCash, Debit: 100},
+				{AccountCode: AccountMpesa, Credit: 100},
+				{AccountCode: AccountSuspense},
+			}},
+			wantErr: ErrInvalidLine,
+		},
+		{
+			name: "negative amount",
+			entry: &JournalEntry{SourceType: "TEST", SourceID: "1", Lines: []*JournalLine{
+				{AccountCode: AccountCash, Debit: -100},
+				{AccountCode: AccountMpesa, Credit: -100},
+			}},
+			wantErr: ErrInvalidLine,
+		},
+		{
+			name: "amounts below a cent round to zero",
+			entry: &JournalEntry{SourceType: "TEST", SourceID: "1", Lines: []*JournalLine{
+				{AccountCode: AccountCash, Debit: 100},
+				{AccountCode: AccountMpesa, Credit: 100.004},
+				{AccountCode: AccountSuspense, Credit: 0.004},
+			}},
+			wantErr: ErrInvalidLine,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Invalid entries are rejected before the database is used
+			err := Post(nil, tt.entry)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Post() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	entry := NewEntry("TEST", "1", "REF", "Narration",
+		Debit(AccountLoansReceivable, 1000).For(SubledgerLoan, 5),
+		Credit(AccountMpesa, 949.999),
+		Credit(AccountLoanFeeIncome, 50),
+		Debit(AccountFeesReceivable, 0),
+	)
+
+	want := []JournalLine{
+		{AccountCode: AccountLoansReceivable, Debit: 1000, SubledgerType: SubledgerLoan, SubledgerID: 5},
+		{AccountCode: AccountMpesa, Credit: 950},
+		{AccountCode: AccountLoanFeeIncome, Credit: 50},
+	}
+
+	if len(entry.Lines) != len(want) {
+		t.Fatalf("NewEntry() has %d lines, want %d", len(entry.Lines), len(want))
+	}
+	for i, line := range entry.Lines {
+		if *line != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, *line, want[i])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Account types
+const (
+	TypeAsset     = "ASSET"
+	TypeLiability = "LIABILITY"
+	TypeEquity    = "EQUITY"
+	TypeIncome    = "INCOME"
+	TypeExpense   = "EXPENSE"
+)
+
+// Subledger types linking journal lines to customer accounts
+const (
+	SubledgerLoan    = "LOAN"
+	SubledgerSavings = "SAVINGS"
+)
+
+// System account codes posted to by loans and savings
+const (
+	AccountCash                  = "1000"
+	AccountMpesa                 = "1010"
+	AccountLoansReceivable       = "1100"
+	AccountInterestReceivable    = "1110"
+	AccountFeesReceivable        = "1120"
+	AccountPenaltiesReceivable   = "1130"
+	AccountTransferClearing      = "1900" // Moves between loans and savings accounts net to zero here
+	AccountSavingsDeposits       = "2000"
+	AccountSuspense              = "2100"
+	AccountUnearnedInterest      = "2200" // Scheduled loan interest that has not been accrued yet
+	AccountWithholdingTaxPayable = "2300"
+	AccountRetainedEarnings      = "3000"
+	AccountLoanInterestIncome    = "4000"
+	AccountLoanFeeIncome         = "4100"
+	AccountLoanPenaltyIncome     = "4200"
+	AccountSavingsPenaltyIncome  = "4300"
+	AccountSavingsInterestCost   = "5000"
+)
+
+// chartOfAccounts is the default chart of accounts seeded on startup
+var chartOfAccounts = []*Account{
+	{Code: AccountCash, Name: "Cash and bank", Type: TypeAsset},
+	{Code: AccountMpesa, Name: "M-Pesa float", Type: TypeAsset},
+	{Code: AccountLoansReceivable, Name: "Loans receivable - principal", Type: TypeAsset},
+	{Code: AccountInterestReceivable, Name: "Loans receivable - interest", Type: TypeAsset},
+	{Code: AccountFeesReceivable, Name: "Loans receivable - fees", Type: TypeAsset},
+	{Code: AccountPenaltiesReceivable, Name: "Loans receivable - penalties", Type: TypeAsset},
+	{Code: AccountTransferClearing, Name: "Internal transfer clearing", Type: TypeAsset},
+	{Code: AccountSavingsDeposits, Name: "Customer savings deposits", Type: TypeLiability},
+	{Code: AccountSuspense, Name: "Suspense", Type: TypeLiability},
+	{Code: AccountUnearnedInterest, Name: "Unearned loan interest", Type: TypeLiability},
+	{Code: AccountWithholdingTaxPayable, Name: "Withholding tax payable", Type: TypeLiability},
+	{Code: AccountRetainedEarnings, Name: "Retained earnings", Type: TypeEquity},
+	{Code: AccountLoanInterestIncome, Name: "Loan interest income", Type: TypeIncome},
+	{Code: AccountLoanFeeIncome, Name: "Loan fee income", Type: TypeIncome},
+	{Code: AccountLoanPenaltyIncome, Name: "Loan penalty income", Type: TypeIncome},
+	{Code: AccountSavingsPenaltyIncome, Name: "Savings penalty income", Type: TypeIncome},
+	{Code: AccountSavingsInterestCost, Name: "Savings interest expense", Type: TypeExpense},
+}
+
+// Account is a general ledger account in the chart of accounts
+type Account struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Code      string    `gorm:"size:20;unique;not null" json:"code"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	Type      string    `gorm:"size:20;index;not null" json:"type"`
+	IsSystem  bool      `gorm:"default:false" json:"is_system"` // System accounts are posted to by the application
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (*Account) TableName() string {
+	return "ledger_account"
+}
+
+// DebitNormal reports whether the account balance increases with debits
+func (a *Account) DebitNormal() bool {
+	return a.Type == TypeAsset || a.Type == TypeExpense
+}
+
+// JournalEntry is a balanced set of journal lines posted together
+type JournalEntry struct {
+	ID         uint           `gorm:"primaryKey"`
+	EntryDate  time.Time      `gorm:"type:date;index;not null"`
+	SourceType string         `gorm:"size:40;uniqueIndex:idx_journal_source;not null"` // Operation that produced the entry, e.g. LOAN_REPAYMENT
+	SourceID   string         `gorm:"size:100;uniqueIndex:idx_journal_source;not null"`
+	Reference  string         `gorm:"size:100;index"`
+	Narration  string         `gorm:"size:255"`
+	PostedBy   uint64         `gorm:"default:0"`
+	Lines      []*JournalLine `gorm:"foreignKey:JournalEntryID"`
+	CreatedAt  time.Time      `gorm:"autoCreateTime"`
+}
+
+func (*JournalEntry) TableName() string {
+	return "journal_entry"
+}
+
+// JournalLine debits or credits a single ledger account
+type JournalLine struct {
+	ID             uint    `gorm:"primaryKey"`
+	JournalEntryID uint    `gorm:"index;not null"`
+	AccountCode    string  `gorm:"size:20;index;not null"`
+	Debit          float64 `gorm:"type:double(20,2);default:0.00"`
+	Credit         float64 `gorm:"type:double(20,2);default:0.00"`
+	SubledgerType  string  `gorm:"size:20;index:idx_journal_subledger"`
+	SubledgerID    uint    `gorm:"index:idx_journal_subledger;default:0"`
+	Narration      string  `gorm:"size:255"`
+}
+
+func (*JournalLine) TableName() string {
+	return "journal_line"
+}
+
+// Migrate creates the ledger tables and seeds the system chart of accounts
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+
+	for _, model := range []interface{}{&Account{}, &JournalEntry{}, &JournalLine{}} {
+		if !migrator.HasTable(model) {
+			if err := migrator.AutoMigrate(model); err != nil {
+				return err
+			}
+		}
+	}
+
+	accounts := make([]*Account, 0, len(chartOfAccounts))
+	for _, account := range chartOfAccounts {
+		seeded := *account
+		seeded.IsSystem = true
+		accounts = append(accounts, &seeded)
+	}
+
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&accounts).Error
+}
@@ -0,0 +1,24 @@
+package ledger
+
+import (
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gidyon/pesapalm/internal/idempotency"
+)
+
+// RegisterRoutes registers all application routes for the general ledger
+func RegisterRoutes(opt *Options) {
+	ledgerController := LedgerController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
+	idempotent := idempotency.Middleware(opt.RedisDB, opt.TokenManager)
+
+	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
+	{
+		v1.GET("/ledger/accounts", authorize("ledger_accounts", auth.ActionRead), ledgerController.ListAccounts)
+		v1.POST("/ledger/accounts", authorize("ledger_accounts", auth.ActionCreate), ledgerController.CreateAccount)
+		v1.GET("/ledger/accounts/:code/statement", authorize("ledger_accounts", auth.ActionRead), ledgerController.GetAccountStatement)
+		v1.GET("/ledger/journal-entries", authorize("journal_entries", auth.ActionRead), ledgerController.ListJournalEntries)
+		v1.POST("/ledger/journal-entries", authorize("journal_entries", auth.ActionCreate), idempotent, ledgerController.CreateJournalEntry)
+		v1.GET("/ledger/trial-balance", authorize("ledger_reports", auth.ActionRead), ledgerController.GetTrialBalance)
+		v1.GET("/ledger/reconciliation", authorize("ledger_reports", auth.ActionRead), ledgerController.GetReconciliation)
+	}
+}
@@ -2,6 +2,7 @@ package loans_product
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -20,6 +21,12 @@ func (ctrl *LoanProductController) CreateLoanProduct(c *gin.Context) {
 		return
 	}
 
+	order, err := allocationOrder(dto.RepaymentAllocationOrder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	product := LoanProduct{
 		Name:                      dto.Name,
 		ProductID:                 dto.ProductID,
@@ -31,8 +38,20 @@ func (ctrl *LoanProductController) CreateLoanProduct(c *gin.Context) {
 		InterestRate:              dto.InterestRate,
 		InterestCalculationPeriod: dto.InterestCalculationPeriod,
 		InterestCalculationUnit:   dto.InterestCalculationUnit,
+		InterestMethod:            interestMethod(dto.InterestMethod),
 		RepaymentPeriod:           dto.RepaymentPeriod,
 		RepaymentPeriodUnit:       dto.RepaymentPeriodUnit,
+		RepaymentAllocationOrder:  order,
+		SetupFeeType:              setupFeeType(dto.SetupFeeType),
+		SetupFee:                  dto.SetupFee,
+		SetupFeeDeducted:          dto.SetupFeeDeducted,
+		PenaltyType:               penaltyType(dto.PenaltyType),
+		PenaltyValue:              dto.PenaltyValue,
+		PenaltyGraceDays:          dto.PenaltyGraceDays,
+		PenaltyFrequencyDays:      dto.PenaltyFrequencyDays,
+		PenaltyCap:                dto.PenaltyCap,
+		PenaltyCompounding:        dto.PenaltyCompounding,
+		DefaultAfterDays:          dto.DefaultAfterDays,
 	}
 
 	if result := ctrl.DB.Create(&product); result.Error != nil {
@@ -69,6 +88,12 @@ func (ctrl *LoanProductController) UpdateLoanProduct(c *gin.Context) {
 		return
 	}
 
+	order, err := allocationOrder(dto.RepaymentAllocationOrder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var product LoanProduct
 	if result := ctrl.DB.First(&product, id); result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Loan product not found"})
@@ -84,8 +109,20 @@ func (ctrl *LoanProductController) UpdateLoanProduct(c *gin.Context) {
 	product.InterestRate = dto.InterestRate
 	product.InterestCalculationPeriod = dto.InterestCalculationPeriod
 	product.InterestCalculationUnit = dto.InterestCalculationUnit
+	product.InterestMethod = interestMethod(dto.InterestMethod)
 	product.RepaymentPeriod = dto.RepaymentPeriod
 	product.RepaymentPeriodUnit = dto.RepaymentPeriodUnit
+	product.RepaymentAllocationOrder = order
+	product.SetupFeeType = setupFeeType(dto.SetupFeeType)
+	product.SetupFee = dto.SetupFee
+	product.SetupFeeDeducted = dto.SetupFeeDeducted
+	product.PenaltyType = penaltyType(dto.PenaltyType)
+	product.PenaltyValue = dto.PenaltyValue
+	product.PenaltyGraceDays = dto.PenaltyGraceDays
+	product.PenaltyFrequencyDays = dto.PenaltyFrequencyDays
+	product.PenaltyCap = dto.PenaltyCap
+	product.PenaltyCompounding = dto.PenaltyCompounding
+	product.DefaultAfterDays = dto.DefaultAfterDays
 
 	if result := ctrl.DB.Save(&product); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
@@ -117,3 +154,36 @@ func (ctrl *LoanProductController) ListLoanProducts(c *gin.Context) {
 
 	c.JSON(http.StatusOK, products)
 }
+
+func setupFeeType(feeType string) string {
+	if feeType == "" {
+		return SetupFeeFixed
+	}
+	return feeType
+}
+
+func penaltyType(penaltyType string) string {
+	if penaltyType == "" {
+		return PenaltyFixed
+	}
+	return penaltyType
+}
+
+func interestMethod(method string) string {
+	if method == "" {
+		return InterestMethodFlat
+	}
+	return method
+}
+
+// allocationOrder validates and normalizes a repayment allocation order, defaulting when empty
+func allocationOrder(order string) (string, error) {
+	buckets, err := ParseAllocationOrder(order)
+	if err != nil {
+		return "", err
+	}
+	if len(buckets) == 0 {
+		return DefaultAllocationOrder, nil
+	}
+	return strings.Join(buckets, ","), nil
+}
@@ -14,8 +14,20 @@ type CreateLoanProductDTO struct {
 	InterestRate              float64 `json:"interest_rate"`
 	InterestCalculationPeriod int     `json:"interest_calculation_period"`
 	InterestCalculationUnit   string  `json:"interest_calculation_unit"`
+	InterestMethod            string  `json:"interest_method" binding:"omitempty,oneof=FLAT DECLINING_BALANCE ANNUITY"`
 	RepaymentPeriod           int     `json:"repayment_period"`
 	RepaymentPeriodUnit       string  `json:"repayment_period_unit"`
+	RepaymentAllocationOrder  string  `json:"repayment_allocation_order"`
+	SetupFeeType              string  `json:"setup_fee_type" binding:"omitempty,oneof=FIXED PERCENTAGE"`
+	SetupFee                  float64 `json:"setup_fee"`
+	SetupFeeDeducted          bool    `json:"setup_fee_deducted"`
+	PenaltyType               string  `json:"penalty_type" binding:"omitempty,oneof=FIXED PERCENTAGE"`
+	PenaltyValue              float64 `json:"penalty_value"`
+	PenaltyGraceDays          int     `json:"penalty_grace_days"`
+	PenaltyFrequencyDays      int     `json:"penalty_frequency_days"`
+	PenaltyCap                float64 `json:"penalty_cap"`
+	PenaltyCompounding        bool    `json:"penalty_compounding"`
+	DefaultAfterDays          int     `json:"default_after_days"`
 }
 
 // UpdateLoanProductDTO defines the JSON structure for updating a loan product
@@ -28,8 +40,20 @@ type UpdateLoanProductDTO struct {
 	InterestRate              float64 `json:"interest_rate"`
 	InterestCalculationPeriod int     `json:"interest_calculation_period"`
 	InterestCalculationUnit   string  `json:"interest_calculation_unit"`
+	InterestMethod            string  `json:"interest_method" binding:"omitempty,oneof=FLAT DECLINING_BALANCE ANNUITY"`
 	RepaymentPeriod           int     `json:"repayment_period"`
 	RepaymentPeriodUnit       string  `json:"repayment_period_unit"`
+	RepaymentAllocationOrder  string  `json:"repayment_allocation_order"`
+	SetupFeeType              string  `json:"setup_fee_type" binding:"omitempty,oneof=FIXED PERCENTAGE"`
+	SetupFee                  float64 `json:"setup_fee"`
+	SetupFeeDeducted          bool    `json:"setup_fee_deducted"`
+	PenaltyType               string  `json:"penalty_type" binding:"omitempty,oneof=FIXED PERCENTAGE"`
+	PenaltyValue              float64 `json:"penalty_value"`
+	PenaltyGraceDays          int     `json:"penalty_grace_days"`
+	PenaltyFrequencyDays      int     `json:"penalty_frequency_days"`
+	PenaltyCap                float64 `json:"penalty_cap"`
+	PenaltyCompounding        bool    `json:"penalty_compounding"`
+	DefaultAfterDays          int     `json:"default_after_days"`
 }
 
 // LoanProductResponse defines the structure of the loan product data returned in the response
@@ -45,8 +69,20 @@ type LoanProductResponse struct {
 	InterestRate              float64 `json:"interest_rate"`
 	InterestCalculationPeriod int     `json:"interest_calculation_period"`
 	InterestCalculationUnit   string  `json:"interest_calculation_unit"`
+	InterestMethod            string  `json:"interest_method"`
 	RepaymentPeriod           int     `json:"repayment_period"`
 	RepaymentPeriodUnit       string  `json:"repayment_period_unit"`
+	RepaymentAllocationOrder  string  `json:"repayment_allocation_order"`
+	SetupFeeType              string  `json:"setup_fee_type"`
+	SetupFee                  float64 `json:"setup_fee"`
+	SetupFeeDeducted          bool    `json:"setup_fee_deducted"`
+	PenaltyType               string  `json:"penalty_type"`
+	PenaltyValue              float64 `json:"penalty_value"`
+	PenaltyGraceDays          int     `json:"penalty_grace_days"`
+	PenaltyFrequencyDays      int     `json:"penalty_frequency_days"`
+	PenaltyCap                float64 `json:"penalty_cap"`
+	PenaltyCompounding        bool    `json:"penalty_compounding"`
+	DefaultAfterDays          int     `json:"default_after_days"`
 	CreatedAt                 string  `json:"created_at"`
 	UpdatedAt                 string  `json:"updated_at"`
 }
@@ -65,8 +101,20 @@ func ToLoanProductResponse(product *LoanProduct) *LoanProductResponse {
 		InterestRate:              product.InterestRate,
 		InterestCalculationPeriod: product.InterestCalculationPeriod,
 		InterestCalculationUnit:   product.InterestCalculationUnit,
+		InterestMethod:            product.InterestMethod,
 		RepaymentPeriod:           product.RepaymentPeriod,
 		RepaymentPeriodUnit:       product.RepaymentPeriodUnit,
+		RepaymentAllocationOrder:  product.RepaymentAllocationOrder,
+		SetupFeeType:              product.SetupFeeType,
+		SetupFee:                  product.SetupFee,
+		SetupFeeDeducted:          product.SetupFeeDeducted,
+		PenaltyType:               product.PenaltyType,
+		PenaltyValue:              product.PenaltyValue,
+		PenaltyGraceDays:          product.PenaltyGraceDays,
+		PenaltyFrequencyDays:      product.PenaltyFrequencyDays,
+		PenaltyCap:                product.PenaltyCap,
+		PenaltyCompounding:        product.PenaltyCompounding,
+		DefaultAfterDays:          product.DefaultAfterDays,
 		CreatedAt:                 product.CreatedAt.UTC().Format(time.RFC3339),
 		UpdatedAt:                 product.UpdatedAt.UTC().Format(time.RFC3339),
 	}
@@ -1,9 +1,45 @@
 package loans_product
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"gorm.io/gorm"
+)
+
+// Setup fee types
+const (
+	SetupFeeFixed      = "FIXED"
+	SetupFeePercentage = "PERCENTAGE"
+)
+
+// Penalty types
+const (
+	PenaltyFixed      = "FIXED"
+	PenaltyPercentage = "PERCENTAGE"
+)
+
+// Interest methods used to build repayment schedules
+const (
+	InterestMethodFlat             = "FLAT"
+	InterestMethodDecliningBalance = "DECLINING_BALANCE"
+	InterestMethodAnnuity          = "ANNUITY"
 )
 
+// Repayment allocation buckets
+const (
+	AllocationPenalty   = "PENALTY"
+	AllocationInterest  = "INTEREST"
+	AllocationFees      = "FEES"
+	AllocationPrincipal = "PRINCIPAL"
+)
+
+// DefaultAllocationOrder is the order in which repayments are allocated when a product does not configure one
+const DefaultAllocationOrder = "PENALTY,INTEREST,FEES,PRINCIPAL"
+
 // LoanProduct defines the GORM model for the loan_product table
 type LoanProduct struct {
 	ID                        uint      `gorm:"primaryKey"`
@@ -17,8 +53,20 @@ type LoanProduct struct {
 	InterestRate              float64   `gorm:"type:double(30,2);default:0.00"`
 	InterestCalculationPeriod int       `gorm:"type:int"`
 	InterestCalculationUnit   string    `gorm:"size:10;default:DAY"`
+	InterestMethod            string    `gorm:"size:20;default:FLAT"`
 	RepaymentPeriod           int       `gorm:"type:int"`
 	RepaymentPeriodUnit       string    `gorm:"size:10;default:DAY"`
+	RepaymentAllocationOrder  string    `gorm:"size:100;default:'PENALTY,INTEREST,FEES,PRINCIPAL'"`
+	SetupFeeType              string    `gorm:"size:20;default:FIXED"`
+	SetupFee                  float64   `gorm:"type:double(20,2);default:0.00"`
+	SetupFeeDeducted          bool      `gorm:"default:false"` // Deduct setup fee from the disbursed amount
+	PenaltyType               string    `gorm:"size:20;default:FIXED"`
+	PenaltyValue              float64   `gorm:"type:double(20,2);default:0.00"`
+	PenaltyGraceDays          int       `gorm:"type:int;default:0"`
+	PenaltyFrequencyDays      int       `gorm:"type:int;default:0"`             // 0 = charge once per overdue installment
+	PenaltyCap                float64   `gorm:"type:double(20,2);default:0.00"` // Maximum penalty per installment, 0 = no cap
+	PenaltyCompounding        bool      `gorm:"default:false"`                  // Charge percentage penalties on previously charged penalties
+	DefaultAfterDays          int       `gorm:"type:int;default:0"`             // Days past due after which a loan is defaulted, 0 = never
 	CreatedAt                 time.Time `gorm:"autoCreateTime"`
 	UpdatedAt                 time.Time `gorm:"autoUpdateTime"`
 }
@@ -26,3 +74,95 @@ type LoanProduct struct {
 func (*LoanProduct) TableName() string {
 	return "loan_product"
 }
+
+// columns added to loan_product after the initial schema
+var migratedColumns = []string{
+	"InterestMethod",
+	"RepaymentAllocationOrder",
+	"SetupFeeType",
+	"SetupFee",
+	"SetupFeeDeducted",
+	"PenaltyType",
+	"PenaltyValue",
+	"PenaltyGraceDays",
+	"PenaltyFrequencyDays",
+	"PenaltyCap",
+	"PenaltyCompounding",
+	"DefaultAfterDays",
+}
+
+// Migrate adds loan product columns that are missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+	if !migrator.HasTable(&LoanProduct{}) {
+		return migrator.AutoMigrate(&LoanProduct{})
+	}
+	for _, column := range migratedColumns {
+		if migrator.HasColumn(&LoanProduct{}, column) {
+			continue
+		}
+		if err := migrator.AddColumn(&LoanProduct{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupFeeAmount computes the setup fee charged on a loan amount
+func (p *LoanProduct) SetupFeeAmount(loanAmount float64) float64 {
+	switch p.SetupFeeType {
+	case SetupFeePercentage:
+		return moneyutil.Percent(loanAmount, p.SetupFee)
+	default:
+		return moneyutil.Round(p.SetupFee)
+	}
+}
+
+// AllocationOrder returns the buckets in the order repayments are allocated to them
+func (p *LoanProduct) AllocationOrder() []string {
+	order, err := ParseAllocationOrder(p.RepaymentAllocationOrder)
+	if err != nil || len(order) == 0 {
+		order, _ = ParseAllocationOrder(DefaultAllocationOrder)
+	}
+	return order
+}
+
+// ParseAllocationOrder parses a comma separated allocation order. Every bucket must appear exactly once.
+func ParseAllocationOrder(order string) ([]string, error) {
+	if strings.TrimSpace(order) == "" {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, 4)
+	buckets := make([]string, 0, 4)
+
+	for _, bucket := range strings.Split(order, ",") {
+		bucket = strings.ToUpper(strings.TrimSpace(bucket))
+		switch bucket {
+		case AllocationPenalty, AllocationInterest, AllocationFees, AllocationPrincipal:
+		default:
+			return nil, fmt.Errorf("unknown allocation bucket %q", bucket)
+		}
+		if seen[bucket] {
+			return nil, fmt.Errorf("allocation bucket %s is repeated", bucket)
+		}
+		seen[bucket] = true
+		buckets = append(buckets, bucket)
+	}
+
+	if len(buckets) != 4 {
+		return nil, fmt.Errorf("allocation order must include %s", DefaultAllocationOrder)
+	}
+
+	return buckets, nil
+}
+
+// PenaltyAmount computes the penalty charged on an overdue base amount
+func (p *LoanProduct) PenaltyAmount(base float64) float64 {
+	switch p.PenaltyType {
+	case PenaltyPercentage:
+		return moneyutil.Percent(base, p.PenaltyValue)
+	default:
+		return moneyutil.Round(p.PenaltyValue)
+	}
+}
@@ -0,0 +1,94 @@
+package loans_product
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseAllocationOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "default order",
+			order: DefaultAllocationOrder,
+			want:  []string{AllocationPenalty, AllocationInterest, AllocationFees, AllocationPrincipal},
+		},
+		{
+			name:  "spaces and case are normalized",
+			order: " principal, Interest ,fees,PENALTY ",
+			want:  []string{AllocationPrincipal, AllocationInterest, AllocationFees, AllocationPenalty},
+		},
+		{
+			name:  "empty order",
+			order: "  ",
+		},
+		{
+			name:    "unknown bucket",
+			order:   "PENALTY,INTEREST,FEES,CAPITAL",
+			wantErr: true,
+		},
+		{
+			name:    "repeated bucket",
+			order:   "PENALTY,INTEREST,INTEREST,PRINCIPAL",
+			wantErr: true,
+		},
+		{
+			name:    "missing bucket",
+			order:   "PENALTY,INTEREST,PRINCIPAL",
+			wantErr: true,
+		},
+		{
+			name:    "empty bucket",
+			order:   "PENALTY,,INTEREST,FEES,PRINCIPAL",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAllocationOrder(tt.order)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAllocationOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseAllocationOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocationOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		want  []string
+	}{
+		{
+			name:  "configured order",
+			order: "PRINCIPAL,INTEREST,FEES,PENALTY",
+			want:  []string{AllocationPrincipal, AllocationInterest, AllocationFees, AllocationPenalty},
+		},
+		{
+			name: "unset order falls back to the default",
+			want: []string{AllocationPenalty, AllocationInterest, AllocationFees, AllocationPrincipal},
+		},
+		{
+			name:  "invalid order falls back to the default",
+			order: "PRINCIPAL",
+			want:  []string{AllocationPenalty, AllocationInterest, AllocationFees, AllocationPrincipal},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := &LoanProduct{RepaymentAllocationOrder: tt.order}
+			if got := product.AllocationOrder(); !slices.Equal(got, tt.want) {
+				t.Errorf("AllocationOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
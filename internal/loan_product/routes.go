@@ -1,6 +1,7 @@
 package loans_product
 
 import (
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/grpclog"
@@ -12,18 +13,20 @@ type Options struct {
 	Logger       grpclog.LoggerV2
 	TokenManager auth.TokenInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
 }
 
 // RegisterRoutes registers all application routes for loan products
 func RegisterRoutes(opt *Options) {
 	productController := LoanProductController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
 
 	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
 	{
-		v1.POST("/loan-products", productController.CreateLoanProduct)
-		v1.GET("/loan-products/:id", productController.GetLoanProduct)
-		v1.PUT("/loan-products/:id", productController.UpdateLoanProduct)
-		v1.DELETE("/loan-products/:id", productController.DeleteLoanProduct)
-		v1.GET("/loan-products", productController.ListLoanProducts)
+		v1.POST("/loan-products", authorize("loan_products", auth.ActionCreate), productController.CreateLoanProduct)
+		v1.GET("/loan-products/:id", authorize("loan_products", auth.ActionRead), productController.GetLoanProduct)
+		v1.PUT("/loan-products/:id", authorize("loan_products", auth.ActionUpdate), productController.UpdateLoanProduct)
+		v1.DELETE("/loan-products/:id", authorize("loan_products", auth.ActionDelete), productController.DeleteLoanProduct)
+		v1.GET("/loan-products", authorize("loan_products", auth.ActionRead), productController.ListLoanProducts)
 	}
 }
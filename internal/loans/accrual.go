@@ -0,0 +1,287 @@
+package loans
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobResult summarizes a run of a loan job
+type JobResult struct {
+	BusinessDate string  `json:"business_date"`
+	Processed    int     `json:"processed"`
+	Skipped      int     `json:"skipped"`
+	Failed       int     `json:"failed"`
+	Amount       float64 `json:"amount"`
+}
+
+// BusinessDay truncates t to the start of its day in UTC
+func BusinessDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// daysBetween returns the number of whole days from a to b
+func daysBetween(a, b time.Time) int {
+	return int(BusinessDay(b).Sub(BusinessDay(a)).Hours() / 24)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// activeLoanIDs returns the ids of all active loan accounts
+func activeLoanIDs(ctx context.Context, db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.WithContext(ctx).Model(&LoanAccount{}).Where("status_id = ?", LoanStatusActive).Order("id ASC").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// AccrueInterest accrues interest on all active loan accounts up to the business date.
+//
+// Each loan is accrued in its own transaction and at most once per business date, so the job can be
+// re-run safely. Accrual covers the whole period since the last accrual, which lets a run after an
+// outage catch up on the missed days.
+func AccrueInterest(ctx context.Context, db *gorm.DB, logger grpclog.LoggerV2, businessDate time.Time) (*JobResult, error) {
+	businessDate = BusinessDay(businessDate)
+
+	ids, err := activeLoanIDs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &JobResult{BusinessDate: businessDate.Format(time.DateOnly)}
+	products := map[int]*loans_product.LoanProduct{}
+
+	for _, id := range ids {
+		var amount float64
+		var accrued bool
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			amount, accrued, err = accrueLoanInterest(tx, id, businessDate, products)
+			return err
+		})
+		switch {
+		case err != nil:
+			logger.Errorf("Failed to accrue interest for loan account %d: %v", id, err)
+			result.Failed++
+		case !accrued:
+			result.Skipped++
+		default:
+			result.Processed++
+			result.Amount = moneyutil.Round(result.Amount + amount)
+		}
+	}
+
+	return result, nil
+}
+
+// accrueLoanInterest accrues interest on a single loan account. It reports false when the loan was not due.
+func accrueLoanInterest(tx *gorm.DB, id uint, businessDate time.Time, products map[int]*loans_product.LoanProduct) (float64, bool, error) {
+	var account LoanAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "id = ?", id).Error; err != nil {
+		return 0, false, err
+	}
+
+	if account.StatusID != LoanStatusActive {
+		return 0, false, nil
+	}
+
+	// Already accrued for the business date
+	var count int64
+	err := tx.Model(&LoanInterestAccrual{}).Where("loan_account_id = ? AND business_date = ?", id, businessDate).Count(&count).Error
+	if err != nil {
+		return 0, false, err
+	}
+	if count > 0 {
+		return 0, false, nil
+	}
+
+	product, ok := products[account.LoanProductID]
+	if !ok {
+		product = &loans_product.LoanProduct{}
+		if err := tx.First(product, account.LoanProductID).Error; err != nil {
+			return 0, false, err
+		}
+		products[account.LoanProductID] = product
+	}
+
+	startDate := account.CreatedAt
+	if account.DisbursementDate.Valid {
+		startDate = account.DisbursementDate.Time
+	}
+	startDate = BusinessDay(startDate)
+
+	from := startDate
+	if account.LastInterestCalcDate.Valid {
+		from = BusinessDay(account.LastInterestCalcDate.Time)
+	}
+
+	// Interest is accrued once every calculation period of the product
+	calcPeriod := product.InterestCalculationPeriod
+	if calcPeriod <= 0 {
+		calcPeriod = 1
+	}
+	if businessDate.Before(periodutil.Add(from, calcPeriod, product.InterestCalculationUnit)) {
+		return 0, false, nil
+	}
+
+	var schedule []*LoanSchedule
+	if err := tx.Where("loan_id = ?", account.ID).Order("installment_number ASC, due_date ASC").Find(&schedule).Error; err != nil {
+		return 0, false, err
+	}
+
+	var accrued float64
+
+	if len(schedule) > 0 {
+		accrued, err = accrueScheduleInterest(tx, schedule, startDate, from, businessDate)
+		if err != nil {
+			return 0, false, err
+		}
+
+		fullyEarned := true
+		for _, installment := range schedule {
+			if installment.InstallmentInterestEarned < installment.InstallmentInterest {
+				fullyEarned = false
+				break
+			}
+		}
+		if fullyEarned {
+			account.InterestCalculated = 1
+		}
+	} else {
+		// Loans without a schedule accrue interest on the outstanding principal
+		terms := &ScheduleTerms{
+			InterestRate:    product.InterestRate,
+			InterestPeriod:  product.InterestCalculationPeriod,
+			InterestUnit:    product.InterestCalculationUnit,
+			RepaymentPeriod: daysBetween(from, businessDate),
+			RepaymentUnit:   periodutil.Day,
+		}
+		accrued = moneyutil.Round(account.OutstandingPrinciple * terms.periodicRate())
+		account.OutstandingInterest = moneyutil.Round(account.OutstandingInterest + accrued)
+		account.LoanBalance = moneyutil.Round(account.LoanBalance + accrued)
+	}
+
+	account.InterestEarned = moneyutil.Round(account.InterestEarned + accrued)
+	account.LastInterestCalcDate = nullTime(businessDate)
+
+	if err := tx.Save(&account).Error; err != nil {
+		return 0, false, err
+	}
+
+	accrual := &LoanInterestAccrual{
+		LoanAccountID: account.ID,
+		BusinessDate:  businessDate,
+		FromDate:      from,
+		ToDate:        businessDate,
+		Amount:        accrued,
+	}
+	if err := tx.Create(accrual).Error; err != nil {
+		return 0, false, err
+	}
+
+	if err := postInterestAccrual(tx, &account, accrual, len(schedule) > 0); err != nil {
+		return 0, false, err
+	}
+
+	return accrued, true, nil
+}
+
+// accrueScheduleInterest recognizes the scheduled interest of each installment pro rata for the days
+// between from and to that fall within the installment period
+func accrueScheduleInterest(tx *gorm.DB, schedule []*LoanSchedule, startDate, from, to time.Time) (float64, error) {
+	var (
+		accrued     float64
+		periodStart = startDate
+	)
+
+	for _, installment := range schedule {
+		periodEnd := BusinessDay(installment.DueDate.Time)
+		start := periodStart
+		periodStart = periodEnd
+
+		remaining := moneyutil.Round(installment.InstallmentInterest - installment.InstallmentInterestEarned)
+		if remaining <= 0 {
+			continue
+		}
+
+		var earned float64
+		if !to.Before(periodEnd) {
+			earned = remaining
+		} else {
+			overlap := daysBetween(maxTime(from, start), minTime(to, periodEnd))
+			if overlap <= 0 {
+				continue
+			}
+			periodDays := daysBetween(start, periodEnd)
+			if periodDays <= 0 {
+				periodDays = 1
+			}
+			earned = moneyutil.Round(installment.InstallmentInterest * float64(overlap) / float64(periodDays))
+			if earned > remaining {
+				earned = remaining
+			}
+		}
+
+		if earned <= 0 {
+			continue
+		}
+
+		installment.InstallmentInterestEarned = moneyutil.Round(installment.InstallmentInterestEarned + earned)
+		installment.InterestCalcDate = nullTime(to)
+		if installment.InstallmentInterestEarned >= installment.InstallmentInterest {
+			installment.InterestCalculated = 1
+		}
+
+		err := tx.Model(installment).Updates(map[string]interface{}{
+			"installment_interest_earned": installment.InstallmentInterestEarned,
+			"interest_calc_date":          installment.InterestCalcDate,
+			"interest_calculated":         installment.InterestCalculated,
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+
+		accrued += earned
+	}
+
+	return moneyutil.Round(accrued), nil
+}
+
+// ListInterestAccruals lists the interest accruals recorded for a loan account
+func (ctrl *LoanController) ListInterestAccruals(c *gin.Context) {
+	id := c.Param("id")
+
+	var accruals []*LoanInterestAccrual
+	if err := ctrl.DB.WithContext(c.Request.Context()).Where("loan_account_id = ?", id).Order("business_date DESC").Find(&accruals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve interest accruals"})
+		return
+	}
+
+	responses := make([]*LoanInterestAccrualResponse, 0, len(accruals))
+	for _, accrual := range accruals {
+		responses = append(responses, ToLoanInterestAccrualResponse(accrual))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"interest_accruals": responses})
+}
@@ -33,7 +33,8 @@ func (ctrl *LoanController) CreateLoanAccount(c *gin.Context) {
 		RepaymentInstallments: dto.RepaymentInstallments,
 		RepaymentPeriod:       dto.RepaymentPeriod,
 		RepaymentPeriodUnit:   dto.RepaymentPeriodUnit,
-		StatusID:              1,
+		SavingsAccountID:      dto.SavingsAccountID,
+		StatusID:              LoanStatusPending,
 	}
 
 	if result := ctrl.DB.WithContext(c.Request.Context()).Create(&account); result.Error != nil {
@@ -210,12 +211,17 @@ func (ctrl *LoanController) GetLoanSchedule(c *gin.Context) {
 	loanID := c.Param("loan_id")
 	var schedule []LoanSchedule
 
-	if result := ctrl.DB.WithContext(c.Request.Context()).Where("loan_id = ?", loanID).Find(&schedule); result.Error != nil {
+	if result := ctrl.DB.WithContext(c.Request.Context()).Where("loan_id = ?", loanID).Order("installment_number ASC, due_date ASC").Find(&schedule); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, schedule)
+	response := make([]*LoanScheduleResponse, 0, len(schedule))
+	for i := range schedule {
+		response = append(response, ToLoanScheduleResponse(&schedule[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // GetLoanEligibility retrieves loan eligibility for a customer
@@ -0,0 +1,388 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+	"github.com/gidyon/pesapalm/internal/savings"
+	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DisburseLoanAccount disburses a pending loan account and activates it. Mobile money disbursements
+// are paid out asynchronously; the loan stays disbursing until the payout result arrives.
+func (ctrl *LoanController) DisburseLoanAccount(c *gin.Context) {
+	id := c.Param("id")
+
+	var dto DisburseLoanDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if dto.Channel == DisbursementChannelMpesa && ctrl.PayoutSender == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Mobile money disbursements are not configured"})
+		return
+	}
+
+	var disbursedBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		disbursedBy = metadata.UserId
+	}
+
+	var (
+		account      LoanAccount
+		disbursement *LoanDisbursement
+	)
+
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		disbursement, err = disburseLoan(tx, id, &dto, disbursedBy, &account)
+		return err
+	})
+	if err != nil {
+		ctrl.writeError(c, err, "Failed to disburse loan account")
+		return
+	}
+
+	if disbursement.Status == DisbursementStatusPending {
+		// A payout cut short by the client disconnecting would leave its outcome unknown
+		ctx := context.WithoutCancel(c.Request.Context())
+
+		err := ctrl.PayoutSender.SendPayout(ctx, disbursement)
+		switch {
+		case err == nil:
+			ctrl.Logger.Infof("Sent payout of loan account %s to %s", account.LoanID, disbursement.PhoneNumber)
+		case errors.Is(err, ErrPayoutRejected):
+			ctrl.Logger.Errorf("Payout of loan account %s was rejected: %v", account.LoanID, err)
+
+			err := ctrl.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				_, err := FailDisbursement(tx, disbursement.ID, formatutil.Truncate("Payout could not be sent: "+err.Error(), 256))
+				return err
+			})
+			if err != nil {
+				ctrl.Logger.Errorf("Failed to reverse disbursement of loan account %s: %v", account.LoanID, err)
+			}
+
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send mobile money payout"})
+			return
+		default:
+			// The payout may still be made, so the disbursement stays pending until its result arrives
+			ctrl.Logger.Warningf("Payout of loan account %s is pending an unknown outcome: %v", account.LoanID, err)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"loan_account": account,
+			"disbursement": ToLoanDisbursementResponse(disbursement),
+		})
+		return
+	}
+
+	ctrl.Logger.Infof("Disbursed loan account %s via %s", account.LoanID, disbursement.Channel)
+
+	c.JSON(http.StatusOK, gin.H{
+		"loan_account": account,
+		"disbursement": ToLoanDisbursementResponse(disbursement),
+	})
+}
+
+// disburseLoan disburses a pending loan account within tx. The loan account is locked for the
+// duration of the transaction so that concurrent disbursements of the same loan are serialized.
+//
+// Savings and external disbursements activate the loan immediately. Mobile money disbursements
+// move the loan to disbursing and return a pending disbursement to be paid out.
+func disburseLoan(tx *gorm.DB, id string, dto *DisburseLoanDTO, disbursedBy uint64, account *LoanAccount) (*LoanDisbursement, error) {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(account, "id = ?", id).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, newStatusError(http.StatusNotFound, "Loan account not found")
+	default:
+		return nil, err
+	}
+
+	switch account.StatusID {
+	case LoanStatusPending:
+	case LoanStatusDisbursing:
+		return nil, newStatusError(http.StatusConflict, "Loan account is already being disbursed")
+	default:
+		return nil, newStatusError(http.StatusBadRequest, "Loan account is not pending disbursement")
+	}
+
+	product, err := loanProduct(tx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateLoanTerms(account, product); err != nil {
+		return nil, err
+	}
+
+	// Compute setup fees; deducted fees are collected upfront, otherwise they are owed on the loan
+	setupFees := product.SetupFeeAmount(account.LoanAmount)
+	netAmount := account.LoanAmount
+	if product.SetupFeeDeducted {
+		netAmount = moneyutil.Round(netAmount - setupFees)
+	}
+	if netAmount <= 0 {
+		return nil, newStatusError(http.StatusBadRequest, "Setup fees exceed the loan amount")
+	}
+
+	disbursement := &LoanDisbursement{
+		LoanAccountID:     account.ID,
+		LoanID:            account.LoanID,
+		Channel:           dto.Channel,
+		Status:            DisbursementStatusCompleted,
+		Amount:            account.LoanAmount,
+		SetupFees:         setupFees,
+		NetAmount:         netAmount,
+		ExternalReference: dto.ExternalReference,
+		Notes:             dto.Notes,
+		DisbursedBy:       disbursedBy,
+	}
+
+	switch dto.Channel {
+	case DisbursementChannelSavings:
+		savingsAccountID := dto.SavingsAccountID
+		if savingsAccountID == 0 {
+			savingsAccountID = account.SavingsAccountID
+		}
+		if savingsAccountID == 0 {
+			return nil, newStatusError(http.StatusBadRequest, "Loan account has no linked savings account")
+		}
+		if err := creditSavingsAccount(tx, savingsAccountID, account.CustomerID, savings.TransactionLoanDisbursement, account.LoanID, netAmount); err != nil {
+			return nil, err
+		}
+		account.SavingsAccountID = savingsAccountID
+		disbursement.SavingsAccountID = savingsAccountID
+	case DisbursementChannelExternal:
+		if dto.ExternalReference == "" {
+			return nil, newStatusError(http.StatusBadRequest, "External reference is required for external payouts")
+		}
+	case DisbursementChannelMpesa:
+		phone, err := customerPhone(tx, account.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+		if phone == "" {
+			return nil, newStatusError(http.StatusBadRequest, "Customer has no phone number to disburse to")
+		}
+
+		disbursement.Status = DisbursementStatusPending
+		disbursement.PhoneNumber = phone
+		account.StatusID = LoanStatusDisbursing
+
+		if err := tx.Model(account).Update("status_id", account.StatusID).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Create(disbursement).Error; err != nil {
+			return nil, err
+		}
+		return disbursement, nil
+	default:
+		return nil, newStatusError(http.StatusBadRequest, fmt.Sprintf("Unknown disbursement channel %s", dto.Channel))
+	}
+
+	if err := activateLoan(tx, account, product, disbursement); err != nil {
+		return nil, err
+	}
+
+	return disbursement, nil
+}
+
+// activateLoan builds the repayment schedule of a disbursed loan, opens its balances and records the
+// disbursement
+func activateLoan(tx *gorm.DB, account *LoanAccount, product *loans_product.LoanProduct, disbursement *LoanDisbursement) error {
+	now := time.Now().UTC()
+
+	// Fees that were not deducted from the disbursed amount are owed on the loan
+	outstandingFees := moneyutil.Round(disbursement.SetupFees - (disbursement.Amount - disbursement.NetAmount))
+
+	// Build the repayment schedule from the product terms
+	installments, err := BuildSchedule(scheduleTerms(account, product, outstandingFees, now))
+	if err != nil {
+		return newStatusError(http.StatusBadRequest, err.Error())
+	}
+
+	var totalInterest float64
+	for _, installment := range installments {
+		totalInterest += installment.Interest
+	}
+	totalInterest = moneyutil.Round(totalInterest)
+
+	account.StatusID = LoanStatusActive
+	account.OutstandingPrinciple = account.LoanAmount
+	account.OutstandingSetupFees = outstandingFees
+	account.OutstandingInterest = totalInterest
+	account.LoanBalance = moneyutil.Round(account.LoanAmount + outstandingFees + totalInterest)
+	account.DisbursementDate = nullTime(now)
+	account.DueDate = nullTime(installments[len(installments)-1].DueDate)
+
+	if err := tx.Save(account).Error; err != nil {
+		return err
+	}
+
+	if err := createLoanSchedule(tx, account, installments); err != nil {
+		return err
+	}
+
+	disbursement.Status = DisbursementStatusCompleted
+	disbursement.CompletedAt = nullTime(now)
+
+	if err := tx.Save(disbursement).Error; err != nil {
+		return err
+	}
+
+	return postDisbursement(tx, account, disbursement, outstandingFees, totalInterest)
+}
+
+// CompleteDisbursement activates a loan whose mobile money payout succeeded within tx. Completing a
+// disbursement that is no longer pending has no effect.
+func CompleteDisbursement(tx *gorm.DB, disbursementID uint, externalReference string) (*LoanDisbursement, error) {
+	disbursement, account, err := lockPendingDisbursement(tx, disbursementID)
+	if err != nil || disbursement.Status != DisbursementStatusPending {
+		return disbursement, err
+	}
+
+	product, err := loanProduct(tx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	disbursement.ExternalReference = externalReference
+
+	if err := activateLoan(tx, account, product, disbursement); err != nil {
+		return nil, err
+	}
+
+	return disbursement, nil
+}
+
+// FailDisbursement returns a loan whose mobile money payout failed to pending within tx so that it
+// can be disbursed again. Failing a disbursement that is no longer pending has no effect.
+func FailDisbursement(tx *gorm.DB, disbursementID uint, reason string) (*LoanDisbursement, error) {
+	disbursement, account, err := lockPendingDisbursement(tx, disbursementID)
+	if err != nil || disbursement.Status != DisbursementStatusPending {
+		return disbursement, err
+	}
+
+	disbursement.Status = DisbursementStatusFailed
+	disbursement.FailureReason = reason
+
+	if err := tx.Save(disbursement).Error; err != nil {
+		return nil, err
+	}
+
+	if account.StatusID == LoanStatusDisbursing {
+		account.StatusID = LoanStatusPending
+		if err := tx.Model(account).Update("status_id", account.StatusID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return disbursement, nil
+}
+
+// lockPendingDisbursement locks a disbursement and its loan account for the rest of tx
+func lockPendingDisbursement(tx *gorm.DB, disbursementID uint) (*LoanDisbursement, *LoanAccount, error) {
+	var disbursement LoanDisbursement
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&disbursement, "id = ?", disbursementID).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, nil, newStatusError(http.StatusNotFound, "Loan disbursement not found")
+	default:
+		return nil, nil, err
+	}
+
+	if disbursement.Status != DisbursementStatusPending {
+		return &disbursement, nil, nil
+	}
+
+	var account LoanAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "id = ?", disbursement.LoanAccountID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &disbursement, &account, nil
+}
+
+func loanProduct(tx *gorm.DB, account *LoanAccount) (*loans_product.LoanProduct, error) {
+	var product loans_product.LoanProduct
+	err := tx.First(&product, account.LoanProductID).Error
+	switch {
+	case err == nil:
+		return &product, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, newStatusError(http.StatusBadRequest, "Loan product not found")
+	default:
+		return nil, err
+	}
+}
+
+// customerPhone returns the primary phone number of a loan customer in international format
+func customerPhone(tx *gorm.DB, customerID string) (string, error) {
+	var phones []sql.NullString
+	if err := tx.Table("customer").Where("id = ?", customerID).Limit(1).Pluck("msisdn1", &phones).Error; err != nil {
+		return "", err
+	}
+	if len(phones) == 0 || !phones[0].Valid {
+		return "", nil
+	}
+	return formatutil.FormatPhoneKE(phones[0].String), nil
+}
+
+// validateLoanTerms checks the loan account against the limits of its loan product
+func validateLoanTerms(account *LoanAccount, product *loans_product.LoanProduct) error {
+	switch {
+	case account.LoanAmount <= 0:
+		return newStatusError(http.StatusBadRequest, "Loan amount must be greater than zero")
+	case product.MaxLoanAmount > 0 && account.LoanAmount > product.MaxLoanAmount:
+		return newStatusError(http.StatusBadRequest, fmt.Sprintf("Loan amount exceeds the product maximum of %.2f", product.MaxLoanAmount))
+	case account.RepaymentInstallments <= 0:
+		return newStatusError(http.StatusBadRequest, "Repayment installments must be greater than zero")
+	case product.MinInstallments > 0 && account.RepaymentInstallments < product.MinInstallments:
+		return newStatusError(http.StatusBadRequest, fmt.Sprintf("Repayment installments are below the product minimum of %d", product.MinInstallments))
+	case product.MaxInstallments > 0 && account.RepaymentInstallments > product.MaxInstallments:
+		return newStatusError(http.StatusBadRequest, fmt.Sprintf("Repayment installments exceed the product maximum of %d", product.MaxInstallments))
+	case account.RepaymentPeriod <= 0:
+		return newStatusError(http.StatusBadRequest, "Repayment period must be greater than zero")
+	case !periodutil.Valid(account.RepaymentPeriodUnit):
+		return newStatusError(http.StatusBadRequest, fmt.Sprintf("Unsupported repayment period unit %s", account.RepaymentPeriodUnit))
+	}
+	return nil
+}
+
+// creditSavingsAccount credits the customer's active savings account with amount
+func creditSavingsAccount(tx *gorm.DB, savingsAccountID int, customerID, transactionType, reference string, amount float64) error {
+	customer, _ := strconv.Atoi(customerID)
+
+	_, _, err := savings.Credit(tx, &savings.TransactionRequest{
+		SavingsAccountID: uint(savingsAccountID),
+		CustomerID:       customer,
+		TransactionType:  transactionType,
+		Amount:           amount,
+		Reference:        reference,
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, savings.ErrAccountNotFound),
+		errors.Is(err, savings.ErrAccountNotActive),
+		errors.Is(err, savings.ErrCustomerMismatch),
+		errors.Is(err, savings.ErrInvalidAmount):
+		return newStatusError(http.StatusBadRequest, err.Error())
+	default:
+		return err
+	}
+}
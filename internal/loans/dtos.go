@@ -1,12 +1,16 @@
 package loans
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 // CreateLoanAccountDTO defines the JSON structure for creating a loan account
 type CreateLoanAccountDTO struct {
 	LoanID                string  `json:"loan_id"`
 	CustomerID            string  `json:"customer_id"`
 	LoanProductID         int     `json:"loan_product_id"`
+	SavingsAccountID      int     `json:"savings_account_id"`
 	CurrencyID            int     `json:"currency_id"`
 	LoanAmount            float64 `json:"loan_amount"`
 	RepaymentInstallments int     `json:"repayment_installments"`
@@ -38,6 +42,7 @@ type LoanAccountResponse struct {
 	StatusID               int         `json:"status_id,omitempty"`
 	Defaulted              int         `json:"defaulted,omitempty"`
 	InterestCalculated     int         `json:"interest_calculated,omitempty"`
+	DisbursementDate       *string     `json:"disbursement_date,omitempty"`
 	DueDate                *string     `json:"due_date,omitempty"`
 	LastRepaymentDate      *string     `json:"last_repayment_date,omitempty"`
 	LastInterestCalcDate   *string     `json:"last_interest_calc_date,omitempty"`
@@ -71,6 +76,7 @@ func ToLoanAccountResponse(account *LoanAccountRead) *LoanAccountResponse {
 		StatusID:               account.StatusID,
 		Defaulted:              account.Defaulted,
 		InterestCalculated:     account.InterestCalculated,
+		DisbursementDate:       formatNullableTime(account.DisbursementDate.Time),
 		DueDate:                formatNullableTime(account.DueDate.Time),
 		LastRepaymentDate:      formatNullableTime(account.LastRepaymentDate.Time),
 		LastInterestCalcDate:   formatNullableTime(account.LastInterestCalcDate.Time),
@@ -90,6 +96,7 @@ type LoanScheduleResponse struct {
 	CustomerID                        int     `json:"customer_id"`
 	CurrencyID                        int     `json:"currency_id"`
 	CurrencyCode                      string  `json:"currency_code"`
+	InstallmentNumber                 int     `json:"installment_number"`
 	InstallmentAmount                 float64 `json:"installment_amount"`
 	InstallmentBalance                float64 `json:"installment_balance"`
 	InstallmentAmountPaid             float64 `json:"installment_amount_paid"`
@@ -97,6 +104,7 @@ type LoanScheduleResponse struct {
 	InstallmentOutstandingSetupFees   float64 `json:"installment_outstanding_setup_fees"`
 	InstallmentOutstandingInterest    float64 `json:"installment_outstanding_interest"`
 	InstallmentOutstandingPenaltyFees float64 `json:"installment_outstanding_penalty_fees"`
+	InstallmentInterest               float64 `json:"installment_interest"`
 	InstallmentInterestEarned         float64 `json:"installment_interest_earned"`
 	StatusID                          int     `json:"status_id"`
 	Defaulted                         int     `json:"defaulted"`
@@ -118,6 +126,7 @@ func ToLoanScheduleResponse(schedule *LoanSchedule) *LoanScheduleResponse {
 		CustomerID:                        schedule.CustomerID,
 		CurrencyID:                        schedule.CurrencyID,
 		CurrencyCode:                      schedule.CurrencyCode,
+		InstallmentNumber:                 schedule.InstallmentNumber,
 		InstallmentAmount:                 schedule.InstallmentAmount,
 		InstallmentBalance:                schedule.InstallmentBalance,
 		InstallmentAmountPaid:             schedule.InstallmentAmountPaid,
@@ -125,6 +134,7 @@ func ToLoanScheduleResponse(schedule *LoanSchedule) *LoanScheduleResponse {
 		InstallmentOutstandingSetupFees:   schedule.InstallmentOutstandingSetupFees,
 		InstallmentOutstandingInterest:    schedule.InstallmentOutstandingInterest,
 		InstallmentOutstandingPenaltyFees: schedule.InstallmentOutstandingPenaltyFees,
+		InstallmentInterest:               schedule.InstallmentInterest,
 		InstallmentInterestEarned:         schedule.InstallmentInterestEarned,
 		StatusID:                          schedule.StatusID,
 		Defaulted:                         schedule.Defaulted,
@@ -161,6 +171,11 @@ func ToLoanEligibilityResponse(eligibility *LoanEligibility) *LoanEligibilityRes
 	}
 }
 
+// nullTime converts t to a valid sql.NullTime
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: true}
+}
+
 // Helper function to format nullable time fields
 func formatNullableTime(t time.Time) *string {
 	if !t.IsZero() {
@@ -169,3 +184,163 @@ func formatNullableTime(t time.Time) *string {
 	}
 	return nil
 }
+
+// DisburseLoanDTO defines the JSON structure for disbursing a loan account
+type DisburseLoanDTO struct {
+	Channel           string `json:"channel" binding:"required,oneof=SAVINGS EXTERNAL MPESA"`
+	SavingsAccountID  int    `json:"savings_account_id"`
+	ExternalReference string `json:"external_reference"`
+	Notes             string `json:"notes"`
+}
+
+// LoanDisbursementResponse defines the structure of the loan disbursement data returned in the response
+type LoanDisbursementResponse struct {
+	ID                uint    `json:"id"`
+	LoanAccountID     uint    `json:"loan_account_id"`
+	LoanID            string  `json:"loan_id"`
+	Channel           string  `json:"channel"`
+	Status            string  `json:"status"`
+	SavingsAccountID  int     `json:"savings_account_id,omitempty"`
+	PhoneNumber       string  `json:"phone_number,omitempty"`
+	Amount            float64 `json:"amount"`
+	SetupFees         float64 `json:"setup_fees"`
+	NetAmount         float64 `json:"net_amount"`
+	ExternalReference string  `json:"external_reference,omitempty"`
+	Notes             string  `json:"notes,omitempty"`
+	FailureReason     string  `json:"failure_reason,omitempty"`
+	DisbursedBy       uint64  `json:"disbursed_by,omitempty"`
+	CompletedAt       *string `json:"completed_at,omitempty"`
+	CreatedAt         string  `json:"created_at"`
+}
+
+// ToLoanDisbursementResponse converts a LoanDisbursement to a LoanDisbursementResponse
+func ToLoanDisbursementResponse(disbursement *LoanDisbursement) *LoanDisbursementResponse {
+	return &LoanDisbursementResponse{
+		ID:                disbursement.ID,
+		LoanAccountID:     disbursement.LoanAccountID,
+		LoanID:            disbursement.LoanID,
+		Channel:           disbursement.Channel,
+		Status:            disbursement.Status,
+		SavingsAccountID:  disbursement.SavingsAccountID,
+		PhoneNumber:       disbursement.PhoneNumber,
+		Amount:            disbursement.Amount,
+		SetupFees:         disbursement.SetupFees,
+		NetAmount:         disbursement.NetAmount,
+		ExternalReference: disbursement.ExternalReference,
+		Notes:             disbursement.Notes,
+		FailureReason:     disbursement.FailureReason,
+		DisbursedBy:       disbursement.DisbursedBy,
+		CompletedAt:       formatNullableTime(disbursement.CompletedAt.Time),
+		CreatedAt:         disbursement.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// RepayLoanDTO defines the JSON structure for posting a loan repayment
+type RepayLoanDTO struct {
+	Amount    float64 `json:"amount" binding:"required,gt=0"`
+	Channel   string  `json:"channel" binding:"required,oneof=CASH BANK MPESA"`
+	Reference string  `json:"reference"`
+	Notes     string  `json:"notes"`
+}
+
+// LoanRepaymentResponse defines the structure of the loan repayment data returned in the response
+type LoanRepaymentResponse struct {
+	ID               uint    `json:"id"`
+	LoanAccountID    uint    `json:"loan_account_id"`
+	LoanID           string  `json:"loan_id"`
+	Channel          string  `json:"channel"`
+	Reference        string  `json:"reference,omitempty"`
+	Amount           float64 `json:"amount"`
+	PrincipalPaid    float64 `json:"principal_paid"`
+	InterestPaid     float64 `json:"interest_paid"`
+	FeesPaid         float64 `json:"fees_paid"`
+	PenaltyPaid      float64 `json:"penalty_paid"`
+	Overpayment      float64 `json:"overpayment"`
+	SavingsAccountID int     `json:"savings_account_id,omitempty"`
+	LoanBalance      float64 `json:"loan_balance"`
+	Notes            string  `json:"notes,omitempty"`
+	ReceivedBy       uint64  `json:"received_by,omitempty"`
+	RepaymentDate    *string `json:"repayment_date,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+}
+
+// ToLoanRepaymentResponse converts a LoanRepayment to a LoanRepaymentResponse
+func ToLoanRepaymentResponse(repayment *LoanRepayment) *LoanRepaymentResponse {
+	return &LoanRepaymentResponse{
+		ID:               repayment.ID,
+		LoanAccountID:    repayment.LoanAccountID,
+		LoanID:           repayment.LoanID,
+		Channel:          repayment.Channel,
+		Reference:        repayment.Reference,
+		Amount:           repayment.Amount,
+		PrincipalPaid:    repayment.PrincipalPaid,
+		InterestPaid:     repayment.InterestPaid,
+		FeesPaid:         repayment.FeesPaid,
+		PenaltyPaid:      repayment.PenaltyPaid,
+		Overpayment:      repayment.Overpayment,
+		SavingsAccountID: repayment.SavingsAccountID,
+		LoanBalance:      repayment.LoanBalance,
+		Notes:            repayment.Notes,
+		ReceivedBy:       repayment.ReceivedBy,
+		RepaymentDate:    formatNullableTime(repayment.RepaymentDate.Time),
+		CreatedAt:        repayment.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// LoanInterestAccrualResponse defines the structure of a loan interest accrual returned in the response
+type LoanInterestAccrualResponse struct {
+	ID            uint    `json:"id"`
+	LoanAccountID uint    `json:"loan_account_id"`
+	BusinessDate  string  `json:"business_date"`
+	FromDate      string  `json:"from_date"`
+	ToDate        string  `json:"to_date"`
+	Amount        float64 `json:"amount"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+// ToLoanInterestAccrualResponse converts a LoanInterestAccrual to a LoanInterestAccrualResponse
+func ToLoanInterestAccrualResponse(accrual *LoanInterestAccrual) *LoanInterestAccrualResponse {
+	return &LoanInterestAccrualResponse{
+		ID:            accrual.ID,
+		LoanAccountID: accrual.LoanAccountID,
+		BusinessDate:  accrual.BusinessDate.Format(time.DateOnly),
+		FromDate:      accrual.FromDate.Format(time.DateOnly),
+		ToDate:        accrual.ToDate.Format(time.DateOnly),
+		Amount:        accrual.Amount,
+		CreatedAt:     accrual.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// LoanPenaltyChargeResponse defines the structure of a loan penalty charge returned in the response
+type LoanPenaltyChargeResponse struct {
+	ID                uint    `json:"id"`
+	LoanAccountID     uint    `json:"loan_account_id"`
+	LoanScheduleID    uint    `json:"loan_schedule_id"`
+	BusinessDate      string  `json:"business_date"`
+	InstallmentNumber int     `json:"installment_number"`
+	DaysPastDue       int     `json:"days_past_due"`
+	PenaltyType       string  `json:"penalty_type"`
+	PenaltyValue      float64 `json:"penalty_value"`
+	BaseAmount        float64 `json:"base_amount"`
+	Amount            float64 `json:"amount"`
+	Reason            string  `json:"reason,omitempty"`
+	CreatedAt         string  `json:"created_at"`
+}
+
+// ToLoanPenaltyChargeResponse converts a LoanPenaltyCharge to a LoanPenaltyChargeResponse
+func ToLoanPenaltyChargeResponse(charge *LoanPenaltyCharge) *LoanPenaltyChargeResponse {
+	return &LoanPenaltyChargeResponse{
+		ID:                charge.ID,
+		LoanAccountID:     charge.LoanAccountID,
+		LoanScheduleID:    charge.LoanScheduleID,
+		BusinessDate:      charge.BusinessDate.Format(time.DateOnly),
+		InstallmentNumber: charge.InstallmentNumber,
+		DaysPastDue:       charge.DaysPastDue,
+		PenaltyType:       charge.PenaltyType,
+		PenaltyValue:      charge.PenaltyValue,
+		BaseAmount:        charge.BaseAmount,
+		Amount:            charge.Amount,
+		Reason:            charge.Reason,
+		CreatedAt:         charge.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
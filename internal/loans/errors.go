@@ -0,0 +1,33 @@
+package loans
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusError is an error that carries the HTTP status to respond with
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func newStatusError(status int, message string) error {
+	return &statusError{status: status, message: message}
+}
+
+// writeError responds with the status carried by err or 500 for unknown errors
+func (ctrl *LoanController) writeError(c *gin.Context, err error, message string) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		c.JSON(statusErr.status, gin.H{"error": statusErr.message})
+		return
+	}
+	ctrl.Logger.Errorln(err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
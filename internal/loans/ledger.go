@@ -0,0 +1,106 @@
+package loans
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/ledger"
+	"gorm.io/gorm"
+)
+
+// Journal entry source types of loan operations
+const (
+	SourceLoanDisbursement    = "LOAN_DISBURSEMENT"
+	SourceLoanRepayment       = "LOAN_REPAYMENT"
+	SourceLoanInterestAccrual = "LOAN_INTEREST_ACCRUAL"
+	SourceLoanPenalty         = "LOAN_PENALTY"
+)
+
+// postDisbursement records a loan disbursement in the general ledger. Scheduled interest is recognized
+// as a receivable against unearned interest and moved to income as it accrues.
+func postDisbursement(tx *gorm.DB, account *LoanAccount, disbursement *LoanDisbursement, outstandingFees, scheduledInterest float64) error {
+	funding := ledger.AccountCash
+	switch disbursement.Channel {
+	case DisbursementChannelSavings:
+		funding = ledger.AccountTransferClearing
+	case DisbursementChannelMpesa:
+		funding = ledger.AccountMpesa
+	}
+
+	return ledger.Post(tx, ledger.NewEntry(
+		SourceLoanDisbursement,
+		fmt.Sprint(disbursement.ID),
+		account.LoanID,
+		fmt.Sprintf("Disbursement of loan %s via %s", account.LoanID, disbursement.Channel),
+		ledger.Debit(ledger.AccountLoansReceivable, disbursement.Amount).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(funding, disbursement.NetAmount),
+		ledger.Credit(ledger.AccountLoanFeeIncome, disbursement.Amount-disbursement.NetAmount),
+		ledger.Debit(ledger.AccountFeesReceivable, outstandingFees).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(ledger.AccountLoanFeeIncome, outstandingFees),
+		ledger.Debit(ledger.AccountInterestReceivable, scheduledInterest).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(ledger.AccountUnearnedInterest, scheduledInterest).For(ledger.SubledgerLoan, account.ID),
+	))
+}
+
+// postRepayment records a loan repayment in the general ledger
+func postRepayment(tx *gorm.DB, account *LoanAccount, repayment *LoanRepayment) error {
+	receiving := ledger.AccountCash
+	if repayment.Channel == RepaymentChannelMpesa {
+		receiving = ledger.AccountMpesa
+	}
+
+	// Overpayments without a savings account to move to are held in suspense
+	overpayment := ledger.AccountTransferClearing
+	if repayment.SavingsAccountID == 0 {
+		overpayment = ledger.AccountSuspense
+	}
+
+	return ledger.Post(tx, ledger.NewEntry(
+		SourceLoanRepayment,
+		fmt.Sprint(repayment.ID),
+		repayment.Reference,
+		fmt.Sprintf("Repayment of loan %s via %s", account.LoanID, repayment.Channel),
+		ledger.Debit(receiving, repayment.Amount),
+		ledger.Credit(ledger.AccountLoansReceivable, repayment.PrincipalPaid).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(ledger.AccountInterestReceivable, repayment.InterestPaid).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(ledger.AccountFeesReceivable, repayment.FeesPaid).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(ledger.AccountPenaltiesReceivable, repayment.PenaltyPaid).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(overpayment, repayment.Overpayment),
+	))
+}
+
+// postInterestAccrual records accrued loan interest in the general ledger. Interest of scheduled
+// loans is already receivable and is released from unearned interest.
+func postInterestAccrual(tx *gorm.DB, account *LoanAccount, accrual *LoanInterestAccrual, scheduled bool) error {
+	debit := ledger.Debit(ledger.AccountInterestReceivable, accrual.Amount).For(ledger.SubledgerLoan, account.ID)
+	if scheduled {
+		debit = ledger.Debit(ledger.AccountUnearnedInterest, accrual.Amount).For(ledger.SubledgerLoan, account.ID)
+	}
+
+	entry := ledger.NewEntry(
+		SourceLoanInterestAccrual,
+		fmt.Sprint(accrual.ID),
+		account.LoanID,
+		fmt.Sprintf("Interest accrued on loan %s for %s", account.LoanID, accrual.BusinessDate.Format(time.DateOnly)),
+		debit,
+		ledger.Credit(ledger.AccountLoanInterestIncome, accrual.Amount),
+	)
+	entry.EntryDate = accrual.BusinessDate
+
+	return ledger.Post(tx, entry)
+}
+
+// postPenalty records a penalty charge in the general ledger
+func postPenalty(tx *gorm.DB, account *LoanAccount, charge *LoanPenaltyCharge) error {
+	entry := ledger.NewEntry(
+		SourceLoanPenalty,
+		fmt.Sprint(charge.ID),
+		account.LoanID,
+		charge.Reason,
+		ledger.Debit(ledger.AccountPenaltiesReceivable, charge.Amount).For(ledger.SubledgerLoan, account.ID),
+		ledger.Credit(ledger.AccountLoanPenaltyIncome, charge.Amount),
+	)
+	entry.EntryDate = charge.BusinessDate
+
+	return ledger.Post(tx, entry)
+}
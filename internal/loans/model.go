@@ -1,8 +1,20 @@
 package loans
 
 import (
+	"context"
 	"database/sql"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// Loan account statuses
+const (
+	LoanStatusPending    = 0
+	LoanStatusActive     = 1
+	LoanStatusPaid       = 2
+	LoanStatusErrored    = 3
+	LoanStatusDisbursing = 4 // Waiting for the result of a mobile money payout
 )
 
 // LoanAccount defines the GORM model for the loan_account table
@@ -25,9 +37,10 @@ type LoanAccount struct {
 	OutstandingInterest    float64      `gorm:"type:double(20,2);default:0.00"`
 	OutstandingPenaltyFees float64      `gorm:"type:double(20,2);default:0.00"`
 	InterestEarned         float64      `gorm:"type:double(20,2);default:0.00"`
-	StatusID               int          `gorm:"default:0"` // 0 = PENDING, 1 = ACTIVE, 2 = PAID, 3 = ERRORED
+	StatusID               int          `gorm:"default:0"` // 0 = PENDING, 1 = ACTIVE, 2 = PAID, 3 = ERRORED, 4 = DISBURSING
 	Defaulted              int          `gorm:"default:0"` // 0 = ACTIVE, 1 = DEFAULTED
 	InterestCalculated     int          `gorm:"default:0"` // 0 = PENDING, 1 = CALCULATED
+	DisbursementDate       sql.NullTime `gorm:"type:datetime"`
 	DueDate                sql.NullTime `gorm:"type:datetime"`
 	LastRepaymentDate      sql.NullTime `gorm:"type:datetime"`
 	LastInterestCalcDate   sql.NullTime `gorm:"type:datetime"`
@@ -66,6 +79,7 @@ type LoanSchedule struct {
 	CustomerID                        int          `gorm:"index"`
 	CurrencyID                        int          `gorm:"type:TINYINT(1);default:1"`
 	CurrencyCode                      string       `gorm:"size:10;default:USD"`
+	InstallmentNumber                 int          `gorm:"default:0"`
 	InstallmentAmount                 float64      `gorm:"type:double(15,2);not null"`
 	InstallmentBalance                float64      `gorm:"type:double(15,2);not null"`
 	InstallmentAmountPaid             float64      `gorm:"type:double(15,2);default:0.00"`
@@ -73,6 +87,7 @@ type LoanSchedule struct {
 	InstallmentOutstandingSetupFees   float64      `gorm:"type:double(15,2);default:0.00"`
 	InstallmentOutstandingInterest    float64      `gorm:"type:double(15,2);default:0.00"`
 	InstallmentOutstandingPenaltyFees float64      `gorm:"type:double(15,2);default:0.00"`
+	InstallmentInterest               float64      `gorm:"type:double(15,2);default:0.00"` // Scheduled interest of the installment
 	InstallmentInterestEarned         float64      `gorm:"type:double(15,2);default:0.00"`
 	StatusID                          int          `gorm:"default:0"` // 0 = PENDING, 1 = ACTIVE, 2 = PAID, 3 = ERRORED
 	Defaulted                         int          `gorm:"default:0"` // 0 = ACTIVE, 1 = DEFAULTED
@@ -102,3 +117,147 @@ type LoanEligibility struct {
 func (*LoanEligibility) TableName() string {
 	return "loan_eligibility"
 }
+
+// Disbursement channels
+const (
+	DisbursementChannelSavings  = "SAVINGS"
+	DisbursementChannelExternal = "EXTERNAL"
+	DisbursementChannelMpesa    = "MPESA"
+)
+
+// Disbursement statuses
+const (
+	DisbursementStatusPending   = "PENDING"
+	DisbursementStatusCompleted = "COMPLETED"
+	DisbursementStatusFailed    = "FAILED"
+)
+
+// LoanDisbursement defines the GORM model for the loan_disbursement table
+type LoanDisbursement struct {
+	ID                uint         `gorm:"primaryKey"`
+	LoanAccountID     uint         `gorm:"index;not null"`
+	LoanID            string       `gorm:"size:36;index"`
+	Channel           string       `gorm:"size:20;not null"`
+	Status            string       `gorm:"size:20;index;default:COMPLETED"`
+	SavingsAccountID  int          `gorm:"index;default:null"`
+	PhoneNumber       string       `gorm:"size:20"`
+	Amount            float64      `gorm:"type:double(20,2);not null"`
+	SetupFees         float64      `gorm:"type:double(20,2);default:0.00"`
+	NetAmount         float64      `gorm:"type:double(20,2);not null"`
+	ExternalReference string       `gorm:"size:100"`
+	Notes             string       `gorm:"type:mediumtext"`
+	FailureReason     string       `gorm:"size:256"`
+	DisbursedBy       uint64       `gorm:"type:bigint"`
+	CompletedAt       sql.NullTime `gorm:"type:datetime"`
+	CreatedAt         time.Time    `gorm:"autoCreateTime"`
+}
+
+func (*LoanDisbursement) TableName() string {
+	return "loan_disbursement"
+}
+
+// Repayment channels
+const (
+	RepaymentChannelCash  = "CASH"
+	RepaymentChannelBank  = "BANK"
+	RepaymentChannelMpesa = "MPESA"
+)
+
+// LoanRepayment defines the GORM model for the loan_repayment table
+type LoanRepayment struct {
+	ID               uint         `gorm:"primaryKey"`
+	LoanAccountID    uint         `gorm:"index;not null"`
+	LoanID           string       `gorm:"size:36;index"`
+	Channel          string       `gorm:"size:20;not null"`
+	Reference        string       `gorm:"size:100;index"`
+	Amount           float64      `gorm:"type:double(20,2);not null"`
+	PrincipalPaid    float64      `gorm:"type:double(20,2);default:0.00"`
+	InterestPaid     float64      `gorm:"type:double(20,2);default:0.00"`
+	FeesPaid         float64      `gorm:"type:double(20,2);default:0.00"`
+	PenaltyPaid      float64      `gorm:"type:double(20,2);default:0.00"`
+	Overpayment      float64      `gorm:"type:double(20,2);default:0.00"`
+	SavingsAccountID int          `gorm:"index;default:null"`
+	LoanBalance      float64      `gorm:"type:double(20,2);default:0.00"` // Loan balance after the repayment
+	Notes            string       `gorm:"type:mediumtext"`
+	ReceivedBy       uint64       `gorm:"type:bigint"`
+	RepaymentDate    sql.NullTime `gorm:"type:datetime"`
+	CreatedAt        time.Time    `gorm:"autoCreateTime"`
+}
+
+func (*LoanRepayment) TableName() string {
+	return "loan_repayment"
+}
+
+// LoanInterestAccrual defines the GORM model for the loan_interest_accrual table.
+// There is at most one accrual per loan account and business date.
+type LoanInterestAccrual struct {
+	ID            uint      `gorm:"primaryKey"`
+	LoanAccountID uint      `gorm:"uniqueIndex:idx_loan_accrual_date;not null"`
+	BusinessDate  time.Time `gorm:"type:date;uniqueIndex:idx_loan_accrual_date;not null"`
+	FromDate      time.Time `gorm:"type:date;not null"`
+	ToDate        time.Time `gorm:"type:date;not null"`
+	Amount        float64   `gorm:"type:double(20,2);default:0.00"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+}
+
+func (*LoanInterestAccrual) TableName() string {
+	return "loan_interest_accrual"
+}
+
+// LoanPenaltyCharge is a penalty charged on an overdue installment for a business date
+type LoanPenaltyCharge struct {
+	ID                uint      `gorm:"primaryKey"`
+	LoanAccountID     uint      `gorm:"index;not null"`
+	LoanScheduleID    uint      `gorm:"uniqueIndex:idx_loan_penalty_date;not null"`
+	BusinessDate      time.Time `gorm:"type:date;uniqueIndex:idx_loan_penalty_date;not null"`
+	InstallmentNumber int       `gorm:"default:0"`
+	DaysPastDue       int       `gorm:"default:0"`
+	PenaltyType       string    `gorm:"size:20"`
+	PenaltyValue      float64   `gorm:"type:double(20,2);default:0.00"`
+	BaseAmount        float64   `gorm:"type:double(20,2);default:0.00"`
+	Amount            float64   `gorm:"type:double(20,2);default:0.00"`
+	Reason            string    `gorm:"size:255"`
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+}
+
+func (*LoanPenaltyCharge) TableName() string {
+	return "loan_penalty_charge"
+}
+
+// columns added to loan tables after the initial schema
+var migratedColumns = []struct {
+	model  interface{}
+	column string
+}{
+	{&LoanAccount{}, "DisbursementDate"},
+	{&LoanSchedule{}, "InstallmentNumber"},
+	{&LoanSchedule{}, "InstallmentInterest"},
+	{&LoanDisbursement{}, "Status"},
+	{&LoanDisbursement{}, "PhoneNumber"},
+	{&LoanDisbursement{}, "FailureReason"},
+	{&LoanDisbursement{}, "CompletedAt"},
+}
+
+// Migrate creates loan tables and columns that are missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+
+	for _, model := range []interface{}{&LoanAccount{}, &LoanSchedule{}, &LoanEligibility{}, &LoanDisbursement{}, &LoanRepayment{}, &LoanInterestAccrual{}, &LoanPenaltyCharge{}} {
+		if !migrator.HasTable(model) {
+			if err := migrator.AutoMigrate(model); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, migrated := range migratedColumns {
+		if migrator.HasColumn(migrated.model, migrated.column) {
+			continue
+		}
+		if err := migrator.AddColumn(migrated.model, migrated.column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
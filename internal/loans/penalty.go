@@ -0,0 +1,243 @@
+package loans
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ApplyPenalties charges penalties on the overdue installments of all active loan accounts and
+// defaults loans that are past the days-past-due threshold of their product.
+//
+// Every charge is recorded against its installment and business date, so re-running the job for the
+// same date does not charge an installment twice.
+func ApplyPenalties(ctx context.Context, db *gorm.DB, logger grpclog.LoggerV2, businessDate time.Time) (*JobResult, error) {
+	businessDate = BusinessDay(businessDate)
+
+	ids, err := activeLoanIDs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &JobResult{BusinessDate: businessDate.Format(time.DateOnly)}
+	products := map[int]*loans_product.LoanProduct{}
+
+	for _, id := range ids {
+		var amount float64
+		var applied bool
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			amount, applied, err = applyLoanPenalties(tx, id, businessDate, products)
+			return err
+		})
+		switch {
+		case err != nil:
+			logger.Errorf("Failed to apply penalties for loan account %d: %v", id, err)
+			result.Failed++
+		case !applied:
+			result.Skipped++
+		default:
+			result.Processed++
+			result.Amount = moneyutil.Round(result.Amount + amount)
+		}
+	}
+
+	return result, nil
+}
+
+// applyLoanPenalties charges penalties on the overdue installments of a single loan account. It reports
+// false when nothing was charged and the loan's default status did not change.
+func applyLoanPenalties(tx *gorm.DB, id uint, businessDate time.Time, products map[int]*loans_product.LoanProduct) (float64, bool, error) {
+	var account LoanAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "id = ?", id).Error; err != nil {
+		return 0, false, err
+	}
+
+	if account.StatusID != LoanStatusActive {
+		return 0, false, nil
+	}
+
+	product, ok := products[account.LoanProductID]
+	if !ok {
+		product = &loans_product.LoanProduct{}
+		if err := tx.First(product, account.LoanProductID).Error; err != nil {
+			return 0, false, err
+		}
+		products[account.LoanProductID] = product
+	}
+
+	var overdue []*LoanSchedule
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("loan_id = ? AND status_id <> ? AND due_date < ? AND installment_balance > 0", account.ID, LoanStatusPaid, businessDate).
+		Order("due_date ASC, installment_number ASC").
+		Find(&overdue).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	var (
+		charged  float64
+		changed  bool
+		maxDays  int
+		defaults = product.DefaultAfterDays > 0
+	)
+
+	for _, installment := range overdue {
+		daysPastDue := daysBetween(installment.DueDate.Time, businessDate)
+		if daysPastDue > maxDays {
+			maxDays = daysPastDue
+		}
+
+		updated := false
+
+		charge, err := penaltyCharge(tx, product, installment, daysPastDue, businessDate)
+		if err != nil {
+			return 0, false, err
+		}
+		if charge != nil {
+			charge.LoanAccountID = account.ID
+			if err := tx.Create(charge).Error; err != nil {
+				return 0, false, err
+			}
+			if err := postPenalty(tx, &account, charge); err != nil {
+				return 0, false, err
+			}
+
+			installment.InstallmentOutstandingPenaltyFees = moneyutil.Round(installment.InstallmentOutstandingPenaltyFees + charge.Amount)
+			installment.InstallmentBalance = moneyutil.Round(installment.InstallmentBalance + charge.Amount)
+			charged = moneyutil.Round(charged + charge.Amount)
+			updated = true
+		}
+
+		if defaults && daysPastDue >= product.DefaultAfterDays && installment.Defaulted == 0 {
+			installment.Defaulted = 1
+			updated = true
+		}
+
+		if updated {
+			if err := tx.Save(installment).Error; err != nil {
+				return 0, false, err
+			}
+		}
+	}
+
+	if charged > 0 {
+		account.OutstandingPenaltyFees = moneyutil.Round(account.OutstandingPenaltyFees + charged)
+		account.LoanBalance = moneyutil.Round(account.LoanBalance + charged)
+		changed = true
+	}
+
+	if defaults && maxDays >= product.DefaultAfterDays && account.Defaulted == 0 {
+		account.Defaulted = 1
+		changed = true
+	}
+
+	if !changed {
+		return 0, false, nil
+	}
+
+	if err := tx.Save(&account).Error; err != nil {
+		return 0, false, err
+	}
+
+	return charged, true, nil
+}
+
+// penaltyCharge computes the penalty due on an overdue installment for the business date. It returns
+// nil when the installment is within its grace period, was already charged for the period or has
+// reached the product's penalty cap.
+func penaltyCharge(tx *gorm.DB, product *loans_product.LoanProduct, installment *LoanSchedule, daysPastDue int, businessDate time.Time) (*LoanPenaltyCharge, error) {
+	var charges []*LoanPenaltyCharge
+	if err := tx.Where("loan_schedule_id = ?", installment.ID).Order("business_date DESC").Find(&charges).Error; err != nil {
+		return nil, err
+	}
+
+	return nextPenaltyCharge(product, installment, charges, daysPastDue, businessDate), nil
+}
+
+// nextPenaltyCharge computes the penalty due on an installment given its previous charges, newest first
+func nextPenaltyCharge(product *loans_product.LoanProduct, installment *LoanSchedule, charges []*LoanPenaltyCharge, daysPastDue int, businessDate time.Time) *LoanPenaltyCharge {
+	if product.PenaltyValue <= 0 || daysPastDue <= product.PenaltyGraceDays {
+		return nil
+	}
+
+	var totalCharged float64
+	for _, charge := range charges {
+		totalCharged += charge.Amount
+	}
+	totalCharged = moneyutil.Round(totalCharged)
+
+	// Without a frequency an installment is only charged once, otherwise it is charged every
+	// PenaltyFrequencyDays while it remains overdue
+	if len(charges) > 0 {
+		sinceLast := daysBetween(charges[0].BusinessDate, businessDate)
+		if product.PenaltyFrequencyDays <= 0 || sinceLast < product.PenaltyFrequencyDays {
+			return nil
+		}
+	}
+
+	base := moneyutil.Round(installment.InstallmentOutstandingPrinciple + installment.InstallmentOutstandingInterest + installment.InstallmentOutstandingSetupFees)
+	if product.PenaltyCompounding {
+		base = moneyutil.Round(base + installment.InstallmentOutstandingPenaltyFees)
+	}
+
+	amount := product.PenaltyAmount(base)
+	capped := false
+	if product.PenaltyCap > 0 && totalCharged+amount > product.PenaltyCap {
+		amount = moneyutil.Round(product.PenaltyCap - totalCharged)
+		capped = true
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	var reason string
+	switch product.PenaltyType {
+	case loans_product.PenaltyPercentage:
+		reason = fmt.Sprintf("Installment %d is %d days past due; %.2f%% penalty on %.2f overdue", installment.InstallmentNumber, daysPastDue, product.PenaltyValue, base)
+	default:
+		reason = fmt.Sprintf("Installment %d is %d days past due; fixed penalty of %.2f", installment.InstallmentNumber, daysPastDue, product.PenaltyValue)
+	}
+	if capped {
+		reason += fmt.Sprintf(", capped at %.2f per installment", product.PenaltyCap)
+	}
+
+	return &LoanPenaltyCharge{
+		LoanScheduleID:    installment.ID,
+		BusinessDate:      businessDate,
+		InstallmentNumber: installment.InstallmentNumber,
+		DaysPastDue:       daysPastDue,
+		PenaltyType:       product.PenaltyType,
+		PenaltyValue:      product.PenaltyValue,
+		BaseAmount:        base,
+		Amount:            amount,
+		Reason:            reason,
+	}
+}
+
+// ListPenaltyCharges lists the penalties charged on a loan account
+func (ctrl *LoanController) ListPenaltyCharges(c *gin.Context) {
+	id := c.Param("id")
+
+	var charges []*LoanPenaltyCharge
+	if err := ctrl.DB.WithContext(c.Request.Context()).Where("loan_account_id = ?", id).Order("business_date DESC, installment_number ASC").Find(&charges).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve penalty charges"})
+		return
+	}
+
+	responses := make([]*LoanPenaltyChargeResponse, 0, len(charges))
+	for _, charge := range charges {
+		responses = append(responses, ToLoanPenaltyChargeResponse(charge))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"penalties": responses})
+}
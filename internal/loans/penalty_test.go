@@ -0,0 +1,142 @@
+package loans
+
+import (
+	"testing"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+)
+
+func TestPenaltyCharge(t *testing.T) {
+	businessDate := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) time.Time { return businessDate.AddDate(0, 0, -n) }
+
+	installment := &LoanSchedule{
+		ID:                                7,
+		InstallmentNumber:                 2,
+		InstallmentOutstandingPrinciple:   800,
+		InstallmentOutstandingInterest:    150,
+		InstallmentOutstandingSetupFees:   50,
+		InstallmentOutstandingPenaltyFees: 100,
+	}
+
+	percentage := func(modify func(*loans_product.LoanProduct)) *loans_product.LoanProduct {
+		product := &loans_product.LoanProduct{PenaltyType: loans_product.PenaltyPercentage, PenaltyValue: 5, PenaltyGraceDays: 3}
+		if modify != nil {
+			modify(product)
+		}
+		return product
+	}
+
+	tests := []struct {
+		name        string
+		product     *loans_product.LoanProduct
+		charges     []*LoanPenaltyCharge
+		daysPastDue int
+		wantAmount  float64 // Zero when no penalty is due
+		wantBase    float64
+	}{
+		{
+			name:        "percentage of the overdue amount",
+			product:     percentage(nil),
+			daysPastDue: 4,
+			wantAmount:  50,
+			wantBase:    1000,
+		},
+		{
+			name:        "fixed penalty",
+			product:     &loans_product.LoanProduct{PenaltyType: loans_product.PenaltyFixed, PenaltyValue: 250},
+			daysPastDue: 1,
+			wantAmount:  250,
+			wantBase:    1000,
+		},
+		{
+			name:        "within the grace period",
+			product:     percentage(nil),
+			daysPastDue: 3,
+		},
+		{
+			name:        "penalties disabled",
+			product:     percentage(func(p *loans_product.LoanProduct) { p.PenaltyValue = 0 }),
+			daysPastDue: 30,
+		},
+		{
+			name:        "compounding includes outstanding penalties",
+			product:     percentage(func(p *loans_product.LoanProduct) { p.PenaltyCompounding = true }),
+			daysPastDue: 4,
+			wantAmount:  55,
+			wantBase:    1100,
+		},
+		{
+			name:        "charged once without a frequency",
+			product:     percentage(nil),
+			charges:     []*LoanPenaltyCharge{{Amount: 50, BusinessDate: daysAgo(30)}},
+			daysPastDue: 34,
+		},
+		{
+			name:        "charged again once the frequency has passed",
+			product:     percentage(func(p *loans_product.LoanProduct) { p.PenaltyFrequencyDays = 7 }),
+			charges:     []*LoanPenaltyCharge{{Amount: 50, BusinessDate: daysAgo(7)}},
+			daysPastDue: 11,
+			wantAmount:  50,
+			wantBase:    1000,
+		},
+		{
+			name:        "not charged again within the frequency",
+			product:     percentage(func(p *loans_product.LoanProduct) { p.PenaltyFrequencyDays = 7 }),
+			charges:     []*LoanPenaltyCharge{{Amount: 50, BusinessDate: daysAgo(6)}},
+			daysPastDue: 10,
+		},
+		{
+			name: "capped per installment",
+			product: percentage(func(p *loans_product.LoanProduct) {
+				p.PenaltyFrequencyDays = 1
+				p.PenaltyCap = 120
+			}),
+			charges:     []*LoanPenaltyCharge{{Amount: 50, BusinessDate: daysAgo(1)}, {Amount: 50, BusinessDate: daysAgo(2)}},
+			daysPastDue: 6,
+			wantAmount:  20,
+			wantBase:    1000,
+		},
+		{
+			name: "cap reached",
+			product: percentage(func(p *loans_product.LoanProduct) {
+				p.PenaltyFrequencyDays = 1
+				p.PenaltyCap = 100
+			}),
+			charges:     []*LoanPenaltyCharge{{Amount: 50, BusinessDate: daysAgo(1)}, {Amount: 50, BusinessDate: daysAgo(2)}},
+			daysPastDue: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextPenaltyCharge(tt.product, installment, tt.charges, tt.daysPastDue, businessDate)
+			if tt.wantAmount == 0 {
+				if got != nil {
+					t.Fatalf("nextPenaltyCharge() = %+v, want no charge", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("nextPenaltyCharge() = nil, want a charge")
+			}
+
+			if got.Amount != tt.wantAmount {
+				t.Errorf("amount = %v, want %v", got.Amount, tt.wantAmount)
+			}
+			if got.BaseAmount != tt.wantBase {
+				t.Errorf("base amount = %v, want %v", got.BaseAmount, tt.wantBase)
+			}
+			if got.LoanScheduleID != installment.ID || got.InstallmentNumber != installment.InstallmentNumber {
+				t.Errorf("charge is for installment %d (%d), want %d (%d)", got.LoanScheduleID, got.InstallmentNumber, installment.ID, installment.InstallmentNumber)
+			}
+			if !got.BusinessDate.Equal(businessDate) || got.DaysPastDue != tt.daysPastDue {
+				t.Errorf("charge is for %s at %d days past due", got.BusinessDate.Format(time.DateOnly), got.DaysPastDue)
+			}
+			if got.Reason == "" {
+				t.Error("charge has no reason")
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package loans
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+	"github.com/gidyon/pesapalm/internal/savings"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RepaymentRequest contains the details of a repayment applied to a loan account
+type RepaymentRequest struct {
+	Amount     float64
+	Channel    string
+	Reference  string
+	Notes      string
+	ReceivedBy uint64
+	Date       time.Time
+}
+
+// buckets holds amounts for each repayment allocation bucket
+type buckets struct {
+	Penalty   float64
+	Interest  float64
+	Fees      float64
+	Principal float64
+}
+
+func (b *buckets) get(bucket string) *float64 {
+	switch bucket {
+	case loans_product.AllocationPenalty:
+		return &b.Penalty
+	case loans_product.AllocationInterest:
+		return &b.Interest
+	case loans_product.AllocationFees:
+		return &b.Fees
+	default:
+		return &b.Principal
+	}
+}
+
+func (b *buckets) total() float64 {
+	return moneyutil.Round(b.Penalty + b.Interest + b.Fees + b.Principal)
+}
+
+// allocate pays down outstanding in the given bucket order, adding the allocated amounts to paid.
+// It returns the amount that could not be allocated.
+func allocate(amount float64, order []string, outstanding, paid *buckets) float64 {
+	for _, bucket := range order {
+		if amount <= 0 {
+			break
+		}
+		due := outstanding.get(bucket)
+		if *due <= 0 {
+			continue
+		}
+		pay := *due
+		if amount < pay {
+			pay = amount
+		}
+		*due = moneyutil.Round(*due - pay)
+		*paid.get(bucket) = moneyutil.Round(*paid.get(bucket) + pay)
+		amount = moneyutil.Round(amount - pay)
+	}
+	return amount
+}
+
+// RepayLoanAccount posts a repayment against a loan account
+func (ctrl *LoanController) RepayLoanAccount(c *gin.Context) {
+	id := c.Param("id")
+
+	var dto RepayLoanDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var receivedBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		receivedBy = metadata.UserId
+	}
+
+	var (
+		account   LoanAccount
+		repayment *LoanRepayment
+	)
+
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Select("id").First(&account, "id = ?", id).Error
+		switch {
+		case err == nil:
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return newStatusError(http.StatusNotFound, "Loan account not found")
+		default:
+			return err
+		}
+
+		repayment, err = ApplyRepayment(tx, account.ID, &RepaymentRequest{
+			Amount:     dto.Amount,
+			Channel:    dto.Channel,
+			Reference:  dto.Reference,
+			Notes:      dto.Notes,
+			ReceivedBy: receivedBy,
+		})
+		return err
+	})
+	if err != nil {
+		ctrl.writeError(c, err, "Failed to post repayment")
+		return
+	}
+
+	c.JSON(http.StatusOK, ToLoanRepaymentResponse(repayment))
+}
+
+// ListLoanRepayments lists the repayments posted against a loan account
+func (ctrl *LoanController) ListLoanRepayments(c *gin.Context) {
+	id := c.Param("id")
+
+	var repayments []*LoanRepayment
+	if err := ctrl.DB.WithContext(c.Request.Context()).Where("loan_account_id = ?", id).Order("id DESC").Find(&repayments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repayments"})
+		return
+	}
+
+	response := make([]*LoanRepaymentResponse, 0, len(repayments))
+	for _, repayment := range repayments {
+		response = append(response, ToLoanRepaymentResponse(repayment))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"repayments": response})
+}
+
+// ApplyRepayment allocates a repayment to a loan account within tx.
+//
+// The amount is allocated across the buckets in the order configured on the loan product, walking
+// unpaid schedule installments oldest first, then across the account balances not on the schedule.
+// Any amount left after the loan is cleared is credited to the linked savings account, or held in
+// suspense when there is none, and the loan is marked as paid.
+func ApplyRepayment(tx *gorm.DB, loanAccountID uint, req *RepaymentRequest) (*LoanRepayment, error) {
+	if req.Amount <= 0 {
+		return nil, newStatusError(http.StatusBadRequest, "Repayment amount must be greater than zero")
+	}
+
+	var account LoanAccount
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "id = ?", loanAccountID).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, newStatusError(http.StatusNotFound, "Loan account not found")
+	default:
+		return nil, err
+	}
+
+	if account.StatusID != LoanStatusActive {
+		return nil, newStatusError(http.StatusBadRequest, "Loan account is not active")
+	}
+
+	var product loans_product.LoanProduct
+	if err := tx.First(&product, account.LoanProductID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	order := product.AllocationOrder()
+
+	var schedule []*LoanSchedule
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("loan_id = ? AND status_id <> ?", account.ID, LoanStatusPaid).
+		Order("due_date ASC, installment_number ASC").
+		Find(&schedule).Error
+	if err != nil {
+		return nil, err
+	}
+
+	repaymentDate := req.Date
+	if repaymentDate.IsZero() {
+		repaymentDate = time.Now().UTC()
+	}
+
+	var (
+		remaining = moneyutil.Round(req.Amount)
+		paid      = &buckets{}
+	)
+
+	// Walk installments oldest first
+	for _, installment := range schedule {
+		if remaining <= 0 {
+			break
+		}
+
+		outstanding := &buckets{
+			Penalty:   installment.InstallmentOutstandingPenaltyFees,
+			Interest:  installment.InstallmentOutstandingInterest,
+			Fees:      installment.InstallmentOutstandingSetupFees,
+			Principal: installment.InstallmentOutstandingPrinciple,
+		}
+		installmentPaid := &buckets{}
+
+		remaining = allocate(remaining, order, outstanding, installmentPaid)
+
+		allocated := installmentPaid.total()
+		if allocated == 0 {
+			continue
+		}
+
+		paid.Penalty += installmentPaid.Penalty
+		paid.Interest += installmentPaid.Interest
+		paid.Fees += installmentPaid.Fees
+		paid.Principal += installmentPaid.Principal
+
+		installment.InstallmentOutstandingPenaltyFees = outstanding.Penalty
+		installment.InstallmentOutstandingInterest = outstanding.Interest
+		installment.InstallmentOutstandingSetupFees = outstanding.Fees
+		installment.InstallmentOutstandingPrinciple = outstanding.Principal
+		installment.InstallmentAmountPaid = moneyutil.Round(installment.InstallmentAmountPaid + allocated)
+		installment.InstallmentBalance = outstanding.total()
+		installment.RepaymentDate = nullTime(repaymentDate)
+		if installment.InstallmentBalance <= 0 {
+			installment.StatusID = LoanStatusPaid
+			installment.Defaulted = 0
+		}
+
+		if err := tx.Save(installment).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// The rest is allocated against the account balances that are not on the schedule, such as
+	// accrued interest and penalties. Loans without a schedule are allocated here entirely.
+	outstanding := &buckets{
+		Penalty:   moneyutil.Round(account.OutstandingPenaltyFees - paid.Penalty),
+		Interest:  moneyutil.Round(account.OutstandingInterest - paid.Interest),
+		Fees:      moneyutil.Round(account.OutstandingSetupFees - paid.Fees),
+		Principal: moneyutil.Round(account.OutstandingPrinciple - paid.Principal),
+	}
+	remaining = allocate(remaining, order, outstanding, paid)
+
+	account.OutstandingPenaltyFees = moneyutil.Round(account.OutstandingPenaltyFees - paid.Penalty)
+	account.OutstandingInterest = moneyutil.Round(account.OutstandingInterest - paid.Interest)
+	account.OutstandingSetupFees = moneyutil.Round(account.OutstandingSetupFees - paid.Fees)
+	account.OutstandingPrinciple = moneyutil.Round(account.OutstandingPrinciple - paid.Principal)
+	account.LoanBalance = moneyutil.Round(account.OutstandingPenaltyFees + account.OutstandingInterest + account.OutstandingSetupFees + account.OutstandingPrinciple)
+	account.AmountPaid = moneyutil.Round(account.AmountPaid + paid.total())
+	account.LastRepaymentDate = nullTime(repaymentDate)
+	if account.LoanBalance <= 0 {
+		account.StatusID = LoanStatusPaid
+	}
+
+	// The loan is cured once none of its defaulted installments remain unpaid
+	if account.Defaulted != 0 && !hasDefaultedInstallment(schedule) {
+		account.Defaulted = 0
+	}
+
+	repayment := &LoanRepayment{
+		LoanAccountID: account.ID,
+		LoanID:        account.LoanID,
+		Channel:       req.Channel,
+		Reference:     req.Reference,
+		Amount:        moneyutil.Round(req.Amount),
+		PrincipalPaid: moneyutil.Round(paid.Principal),
+		InterestPaid:  moneyutil.Round(paid.Interest),
+		FeesPaid:      moneyutil.Round(paid.Fees),
+		PenaltyPaid:   moneyutil.Round(paid.Penalty),
+		Overpayment:   remaining,
+		LoanBalance:   account.LoanBalance,
+		Notes:         req.Notes,
+		ReceivedBy:    req.ReceivedBy,
+		RepaymentDate: nullTime(repaymentDate),
+	}
+
+	// Overpayments are moved to the linked savings account, or held in suspense to be refunded
+	if remaining > 0 && account.SavingsAccountID != 0 {
+		if err := creditSavingsAccount(tx, account.SavingsAccountID, account.CustomerID, savings.TransactionLoanOverpayment, account.LoanID, remaining); err != nil {
+			return nil, err
+		}
+		repayment.SavingsAccountID = account.SavingsAccountID
+	}
+
+	if err := tx.Save(&account).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(repayment).Error; err != nil {
+		return nil, err
+	}
+
+	if err := postRepayment(tx, &account, repayment); err != nil {
+		return nil, err
+	}
+
+	return repayment, nil
+}
+
+// hasDefaultedInstallment reports whether any unpaid installment of the schedule is defaulted
+func hasDefaultedInstallment(schedule []*LoanSchedule) bool {
+	for _, installment := range schedule {
+		if installment.Defaulted != 0 && installment.StatusID != LoanStatusPaid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package loans
+
+import (
+	"testing"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+)
+
+func TestAllocate(t *testing.T) {
+	var (
+		defaultOrder   = []string{loans_product.AllocationPenalty, loans_product.AllocationInterest, loans_product.AllocationFees, loans_product.AllocationPrincipal}
+		principalFirst = []string{loans_product.AllocationPrincipal, loans_product.AllocationInterest, loans_product.AllocationFees, loans_product.AllocationPenalty}
+	)
+
+	tests := []struct {
+		name            string
+		amount          float64
+		order           []string
+		outstanding     buckets
+		paid            buckets
+		wantRemaining   float64
+		wantOutstanding buckets
+		wantPaid        buckets
+	}{
+		{
+			name:            "partial payment stops within a bucket",
+			amount:          150,
+			order:           defaultOrder,
+			outstanding:     buckets{Penalty: 50, Interest: 200, Fees: 30, Principal: 1000},
+			wantOutstanding: buckets{Penalty: 0, Interest: 100, Fees: 30, Principal: 1000},
+			wantPaid:        buckets{Penalty: 50, Interest: 100},
+		},
+		{
+			name:            "order of the product is followed",
+			amount:          1100,
+			order:           principalFirst,
+			outstanding:     buckets{Penalty: 50, Interest: 200, Fees: 30, Principal: 1000},
+			wantOutstanding: buckets{Penalty: 50, Interest: 100, Fees: 30},
+			wantPaid:        buckets{Interest: 100, Principal: 1000},
+		},
+		{
+			name:            "overpayment is returned",
+			amount:          1500,
+			order:           defaultOrder,
+			outstanding:     buckets{Penalty: 50, Interest: 200, Fees: 30, Principal: 1000},
+			wantRemaining:   220,
+			wantOutstanding: buckets{},
+			wantPaid:        buckets{Penalty: 50, Interest: 200, Fees: 30, Principal: 1000},
+		},
+		{
+			name:            "paid amounts accumulate",
+			amount:          100,
+			order:           defaultOrder,
+			outstanding:     buckets{Interest: 60, Principal: 500},
+			paid:            buckets{Interest: 40, Principal: 250},
+			wantOutstanding: buckets{Principal: 460},
+			wantPaid:        buckets{Interest: 100, Principal: 290},
+		},
+		{
+			name:            "negative balances are skipped",
+			amount:          100,
+			order:           defaultOrder,
+			outstanding:     buckets{Penalty: -10, Interest: 30, Principal: 500},
+			wantOutstanding: buckets{Penalty: -10, Principal: 430},
+			wantPaid:        buckets{Interest: 30, Principal: 70},
+		},
+		{
+			name:            "cents are rounded",
+			amount:          100.1,
+			order:           defaultOrder,
+			outstanding:     buckets{Interest: 33.33, Principal: 66.67},
+			wantRemaining:   0.1,
+			wantOutstanding: buckets{},
+			wantPaid:        buckets{Interest: 33.33, Principal: 66.67},
+		},
+		{
+			name:            "nothing to allocate",
+			amount:          0,
+			order:           defaultOrder,
+			outstanding:     buckets{Principal: 500},
+			wantOutstanding: buckets{Principal: 500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outstanding, paid := tt.outstanding, tt.paid
+
+			remaining := allocate(tt.amount, tt.order, &outstanding, &paid)
+			if remaining != tt.wantRemaining {
+				t.Errorf("allocate() = %v, want %v", remaining, tt.wantRemaining)
+			}
+			if outstanding != tt.wantOutstanding {
+				t.Errorf("outstanding = %+v, want %+v", outstanding, tt.wantOutstanding)
+			}
+			if paid != tt.wantPaid {
+				t.Errorf("paid = %+v, want %+v", paid, tt.wantPaid)
+			}
+		})
+	}
+}
+
+func TestHasDefaultedInstallment(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule []*LoanSchedule
+		want     bool
+	}{
+		{"no installments", nil, false},
+		{"installments in arrears but not defaulted", []*LoanSchedule{{StatusID: LoanStatusActive}, {StatusID: LoanStatusActive}}, false},
+		{"defaulted installment unpaid", []*LoanSchedule{{StatusID: LoanStatusActive, Defaulted: 1}, {StatusID: LoanStatusActive}}, true},
+		{"defaulted installment paid", []*LoanSchedule{{StatusID: LoanStatusPaid, Defaulted: 1}, {StatusID: LoanStatusActive}}, false},
+		{"one of the defaulted installments paid", []*LoanSchedule{{StatusID: LoanStatusPaid}, {StatusID: LoanStatusActive, Defaulted: 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasDefaultedInstallment(tt.schedule); got != tt.want {
+				t.Errorf("hasDefaultedInstallment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
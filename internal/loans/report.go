@@ -0,0 +1,216 @@
+package loans
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+)
+
+// parThresholds are the days past due reported as portfolio at risk, e.g. PAR30 is the share of the
+// outstanding principal held by loans that are at least 30 days in arrears
+var parThresholds = []struct {
+	name string
+	days int
+}{
+	{"par1", 1},
+	{"par30", 30},
+	{"par60", 60},
+	{"par90", 90},
+}
+
+// agingBuckets are the arrears aging buckets in days past due; a max of -1 means no upper bound
+var agingBuckets = []struct {
+	name string
+	min  int
+	max  int
+}{
+	{"current", 0, 0},
+	{"1-30", 1, 30},
+	{"31-60", 31, 60},
+	{"61-90", 61, 90},
+	{"91-180", 91, 180},
+	{"180+", 181, -1},
+}
+
+// loanArrears is the arrears position of an active loan account
+type loanArrears struct {
+	LoanAccountID         uint    `json:"loan_account_id"`
+	LoanID                string  `json:"loan_id"`
+	CustomerID            string  `json:"customer_id"`
+	CustomerFirstName     string  `json:"customer_first_name"`
+	CustomerLastName      string  `json:"customer_last_name"`
+	BranchID              int     `json:"branch_id"`
+	LoanProductID         int     `json:"loan_product_id"`
+	LoanProductName       string  `json:"loan_product_name"`
+	CurrencyCode          string  `json:"currency_code"`
+	OutstandingPrinciple  float64 `json:"outstanding_principle"`
+	LoanBalance           float64 `json:"loan_balance"`
+	ArrearsAmount         float64 `json:"arrears_amount"`
+	PrincipalInArrears    float64 `json:"principal_in_arrears"`
+	InstallmentsInArrears int     `json:"installments_in_arrears"`
+	DaysPastDue           int     `json:"days_past_due"`
+	AgingBucket           string  `json:"aging_bucket" gorm:"-"`
+}
+
+// ParRatio is the portfolio at risk for a days past due threshold
+type ParRatio struct {
+	Days                 int     `json:"days"`
+	Loans                int     `json:"loans"`
+	OutstandingPrinciple float64 `json:"outstanding_principle"`
+	Ratio                float64 `json:"ratio"` // Percentage of the gross outstanding principal
+}
+
+// AgingBucket summarizes the loans that fall in an arrears aging bucket
+type AgingBucket struct {
+	Bucket               string  `json:"bucket"`
+	Loans                int     `json:"loans"`
+	OutstandingPrinciple float64 `json:"outstanding_principle"`
+	ArrearsAmount        float64 `json:"arrears_amount"`
+}
+
+func agingBucket(daysPastDue int) string {
+	for _, bucket := range agingBuckets {
+		if daysPastDue >= bucket.min && (bucket.max < 0 || daysPastDue <= bucket.max) {
+			return bucket.name
+		}
+	}
+	return agingBuckets[0].name
+}
+
+// GetPortfolioAtRisk reports portfolio at risk ratios and arrears aging of active loans as at a date.
+//
+// Arrears are computed from the unpaid balances of schedule installments that fell due before the
+// report date. Loans can be filtered by product, customer branch and currency, and the loans in
+// arrears are listed with the oldest arrears first.
+func (ctrl *LoanController) GetPortfolioAtRisk(c *gin.Context) {
+	var (
+		queryParams = c.Request.URL.Query()
+		productID   = queryParams.Get("product_id")
+		branchID    = queryParams.Get("branch_id")
+		currencyID  = queryParams.Get("currency_id")
+		asOf        = queryParams.Get("as_of") // Report date (YYYY-MM-DD), defaults to today
+	)
+
+	reportDate := BusinessDay(time.Now())
+	if asOf != "" {
+		date, err := time.Parse(time.DateOnly, asOf)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of date, expected YYYY-MM-DD"})
+			return
+		}
+		reportDate = date
+	}
+
+	minDays, _ := strconv.Atoi(queryParams.Get("min_days_past_due"))
+	if minDays <= 0 {
+		minDays = 1
+	}
+
+	const overdue = "loan_schedule.due_date < @date AND loan_schedule.status_id <> @paid AND loan_schedule.installment_balance > 0"
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Table("loan_account").
+		Select(`
+			loan_account.id AS loan_account_id,
+			loan_account.loan_id,
+			loan_account.customer_id,
+			customer.first_name AS customer_first_name,
+			customer.last_name AS customer_last_name,
+			customer.branch_id,
+			loan_account.loan_product_id,
+			loan_product.name AS loan_product_name,
+			loan_account.currency_code,
+			loan_account.outstanding_principle,
+			loan_account.loan_balance,
+			COALESCE(SUM(CASE WHEN `+overdue+` THEN loan_schedule.installment_balance END), 0) AS arrears_amount,
+			COALESCE(SUM(CASE WHEN `+overdue+` THEN loan_schedule.installment_outstanding_principle END), 0) AS principal_in_arrears,
+			COUNT(CASE WHEN `+overdue+` THEN 1 END) AS installments_in_arrears,
+			COALESCE(DATEDIFF(@date, MIN(CASE WHEN `+overdue+` THEN loan_schedule.due_date END)), 0) AS days_past_due
+		`, map[string]interface{}{"date": reportDate, "paid": LoanStatusPaid}).
+		Joins("LEFT JOIN loan_schedule ON loan_schedule.loan_id = loan_account.id").
+		Joins("LEFT JOIN loan_product ON loan_product.id = loan_account.loan_product_id").
+		Joins("LEFT JOIN customer ON customer.id = loan_account.customer_id").
+		Where("loan_account.status_id = ?", LoanStatusActive).
+		Group("loan_account.id, customer.id, loan_product.id")
+
+	if productID != "" {
+		db = db.Where("loan_account.loan_product_id = ?", productID)
+	}
+	if branchID != "" {
+		db = db.Where("customer.branch_id = ?", branchID)
+	}
+	if currencyID != "" {
+		db = db.Where("loan_account.currency_id = ?", currencyID)
+	}
+
+	var loans []*loanArrears
+	if err := db.Scan(&loans).Error; err != nil {
+		ctrl.Logger.Errorf("Failed to compute portfolio at risk: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute portfolio at risk"})
+		return
+	}
+
+	var (
+		grossPrincipal float64
+		totalArrears   float64
+		par            = make(map[string]*ParRatio, len(parThresholds))
+		aging          = make([]*AgingBucket, 0, len(agingBuckets))
+		agingIndex     = make(map[string]*AgingBucket, len(agingBuckets))
+		inArrears      = make([]*loanArrears, 0)
+	)
+
+	for _, threshold := range parThresholds {
+		par[threshold.name] = &ParRatio{Days: threshold.days}
+	}
+	for _, bucket := range agingBuckets {
+		agingIndex[bucket.name] = &AgingBucket{Bucket: bucket.name}
+		aging = append(aging, agingIndex[bucket.name])
+	}
+
+	for _, loan := range loans {
+		loan.AgingBucket = agingBucket(loan.DaysPastDue)
+
+		grossPrincipal += loan.OutstandingPrinciple
+		totalArrears += loan.ArrearsAmount
+
+		bucket := agingIndex[loan.AgingBucket]
+		bucket.Loans++
+		bucket.OutstandingPrinciple = moneyutil.Round(bucket.OutstandingPrinciple + loan.OutstandingPrinciple)
+		bucket.ArrearsAmount = moneyutil.Round(bucket.ArrearsAmount + loan.ArrearsAmount)
+
+		for _, threshold := range parThresholds {
+			if loan.DaysPastDue >= threshold.days {
+				ratio := par[threshold.name]
+				ratio.Loans++
+				ratio.OutstandingPrinciple = moneyutil.Round(ratio.OutstandingPrinciple + loan.OutstandingPrinciple)
+			}
+		}
+
+		if loan.DaysPastDue >= minDays {
+			inArrears = append(inArrears, loan)
+		}
+	}
+
+	if grossPrincipal > 0 {
+		for _, ratio := range par {
+			ratio.Ratio = moneyutil.Round(ratio.OutstandingPrinciple / grossPrincipal * 100)
+		}
+	}
+
+	sort.SliceStable(inArrears, func(i, j int) bool {
+		return inArrears[i].DaysPastDue > inArrears[j].DaysPastDue
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"as_of":                       reportDate.Format(time.DateOnly),
+		"active_loans":                len(loans),
+		"gross_outstanding_principle": moneyutil.Round(grossPrincipal),
+		"total_arrears":               moneyutil.Round(totalArrears),
+		"par":                         par,
+		"aging":                       aging,
+		"loans":                       inArrears,
+	})
+}
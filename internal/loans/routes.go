@@ -1,8 +1,14 @@
 package loans
 
 import (
+	"context"
+	"errors"
+
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gidyon/pesapalm/internal/idempotency"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc/grpclog"
 	"gorm.io/gorm"
 )
@@ -12,19 +18,43 @@ type Options struct {
 	Logger       grpclog.LoggerV2
 	TokenManager auth.TokenInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
+	RedisDB      *redis.Client // Optional, stores Idempotency-Key responses
+	PayoutSender PayoutSender  // Optional, enables mobile money disbursements
+}
+
+// ErrPayoutRejected is wrapped by PayoutSender errors when the payout was definitely not sent
+var ErrPayoutRejected = errors.New("mobile money payout rejected")
+
+// PayoutSender sends pending loan disbursements to the customer's mobile money wallet. The payout
+// result is reported back with CompleteDisbursement or FailDisbursement.
+//
+// Errors that do not wrap ErrPayoutRejected leave the outcome unknown; the disbursement must stay
+// pending until the result arrives.
+type PayoutSender interface {
+	SendPayout(ctx context.Context, disbursement *LoanDisbursement) error
 }
 
 // RegisterRoutes registers all application routes for loan management
 func RegisterRoutes(opt *Options) {
 	loanController := LoanController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
+	idempotent := idempotency.Middleware(opt.RedisDB, opt.TokenManager)
 
 	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
 	{
-		v1.POST("/loan-accounts", loanController.CreateLoanAccount)
-		v1.GET("/loan-accounts/:id", loanController.GetLoanAccount)
-		v1.GET("/loan-schedules/:loan_id", loanController.GetLoanSchedule)
-		v1.GET("/loan-eligibility/:customer_id", loanController.GetLoanEligibility)
-		v1.GET("/loan-accounts", loanController.ListLoanAccounts)
-		v1.GET("/loan-stats", loanController.GetStats)
+		v1.POST("/loan-accounts", authorize("loan_accounts", auth.ActionCreate), idempotent, loanController.CreateLoanAccount)
+		v1.GET("/loan-accounts/:id", authorize("loan_accounts", auth.ActionRead), loanController.GetLoanAccount)
+		v1.POST("/loan-accounts/:id/disburse", authorize("loan_accounts", "disburse"), idempotent, loanController.DisburseLoanAccount)
+		v1.GET("/loan-accounts/:id/schedule-preview", authorize("loan_accounts", auth.ActionRead), loanController.PreviewLoanSchedule)
+		v1.POST("/loan-accounts/:id/repayments", authorize("loan_accounts", "repay"), idempotent, loanController.RepayLoanAccount)
+		v1.GET("/loan-accounts/:id/repayments", authorize("loan_accounts", auth.ActionRead), loanController.ListLoanRepayments)
+		v1.GET("/loan-accounts/:id/interest-accruals", authorize("loan_accounts", auth.ActionRead), loanController.ListInterestAccruals)
+		v1.GET("/loan-accounts/:id/penalties", authorize("loan_accounts", auth.ActionRead), loanController.ListPenaltyCharges)
+		v1.GET("/loan-schedules/:loan_id", authorize("loan_accounts", auth.ActionRead), loanController.GetLoanSchedule)
+		v1.GET("/loan-eligibility/:customer_id", authorize("loan_accounts", auth.ActionRead), loanController.GetLoanEligibility)
+		v1.GET("/loan-accounts", authorize("loan_accounts", auth.ActionRead), loanController.ListLoanAccounts)
+		v1.GET("/loan-stats", authorize("loan_reports", auth.ActionRead), loanController.GetStats)
+		v1.GET("/loan-reports/par", authorize("loan_reports", auth.ActionRead), loanController.GetPortfolioAtRisk)
 	}
 }
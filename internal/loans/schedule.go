@@ -0,0 +1,234 @@
+package loans
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ScheduleTerms are the loan terms used to build a repayment schedule
+type ScheduleTerms struct {
+	Principal       float64
+	Fees            float64
+	InterestRate    float64 // Percentage charged per interest period
+	InterestPeriod  int
+	InterestUnit    string
+	InterestMethod  string
+	Installments    int
+	RepaymentPeriod int
+	RepaymentUnit   string
+	StartDate       time.Time
+}
+
+// Installment is a single computed installment of a repayment schedule
+type Installment struct {
+	Number           int       `json:"number"`
+	DueDate          time.Time `json:"due_date"`
+	Principal        float64   `json:"principal"`
+	Interest         float64   `json:"interest"`
+	Fees             float64   `json:"fees"`
+	Total            float64   `json:"total"`
+	PrincipalBalance float64   `json:"principal_balance"`
+}
+
+// approximate length in days of each period unit, used to convert rates between periods
+var unitDays = map[string]float64{
+	periodutil.Day:   1,
+	periodutil.Week:  7,
+	periodutil.Month: 30,
+	periodutil.Year:  365,
+}
+
+// periodicRate converts the interest rate of the terms to a rate per repayment period
+func (terms *ScheduleTerms) periodicRate() float64 {
+	interestPeriod := terms.InterestPeriod
+	if interestPeriod <= 0 {
+		interestPeriod = 1
+	}
+	interestDays := float64(interestPeriod) * unitDays[periodutil.Normalize(terms.InterestUnit)]
+	repaymentDays := float64(terms.RepaymentPeriod) * unitDays[periodutil.Normalize(terms.RepaymentUnit)]
+	return terms.InterestRate / 100 * repaymentDays / interestDays
+}
+
+// BuildSchedule computes the installments for the loan terms using the terms' interest method.
+//
+// FLAT charges interest on the original principal every period, DECLINING_BALANCE repays equal
+// principal with interest on the outstanding balance and ANNUITY repays equal installments.
+// Rounding differences are absorbed by the last installment.
+func BuildSchedule(terms *ScheduleTerms) ([]*Installment, error) {
+	switch {
+	case terms.Principal <= 0:
+		return nil, errors.New("principal must be greater than zero")
+	case terms.Installments <= 0:
+		return nil, errors.New("installments must be greater than zero")
+	case terms.RepaymentPeriod <= 0:
+		return nil, errors.New("repayment period must be greater than zero")
+	case !periodutil.Valid(terms.RepaymentUnit):
+		return nil, fmt.Errorf("unsupported repayment period unit %s", terms.RepaymentUnit)
+	case !periodutil.Valid(terms.InterestUnit):
+		return nil, fmt.Errorf("unsupported interest calculation unit %s", terms.InterestUnit)
+	case terms.InterestRate < 0:
+		return nil, errors.New("interest rate cannot be negative")
+	}
+
+	var (
+		n              = terms.Installments
+		rate           = terms.periodicRate()
+		balance        = moneyutil.Round(terms.Principal)
+		equalPrincipal = moneyutil.Round(balance / float64(n))
+		equalFees      = moneyutil.Round(terms.Fees / float64(n))
+		remainingFees  = moneyutil.Round(terms.Fees)
+		annuityPayment float64
+		installments   = make([]*Installment, 0, n)
+		interestMethod = terms.InterestMethod
+		flatInterest   = moneyutil.Round(balance * rate)
+	)
+
+	if interestMethod == "" {
+		interestMethod = loans_product.InterestMethodFlat
+	}
+
+	if interestMethod == loans_product.InterestMethodAnnuity {
+		if rate == 0 {
+			annuityPayment = equalPrincipal
+		} else {
+			annuityPayment = moneyutil.Round(balance * rate / (1 - math.Pow(1+rate, -float64(n))))
+		}
+	}
+
+	for i := 1; i <= n; i++ {
+		var principal, interest float64
+
+		switch interestMethod {
+		case loans_product.InterestMethodFlat:
+			interest = flatInterest
+			principal = equalPrincipal
+		case loans_product.InterestMethodDecliningBalance:
+			interest = moneyutil.Round(balance * rate)
+			principal = equalPrincipal
+		case loans_product.InterestMethodAnnuity:
+			interest = moneyutil.Round(balance * rate)
+			principal = moneyutil.Round(annuityPayment - interest)
+		default:
+			return nil, fmt.Errorf("unsupported interest method %s", interestMethod)
+		}
+
+		fees := equalFees
+		if i == n || principal > balance {
+			principal = balance
+		}
+		if i == n || fees > remainingFees {
+			fees = remainingFees
+		}
+
+		balance = moneyutil.Round(balance - principal)
+		remainingFees = moneyutil.Round(remainingFees - fees)
+
+		installments = append(installments, &Installment{
+			Number:           i,
+			DueDate:          periodutil.Add(terms.StartDate, i*terms.RepaymentPeriod, terms.RepaymentUnit),
+			Principal:        principal,
+			Interest:         interest,
+			Fees:             fees,
+			Total:            moneyutil.Round(principal + interest + fees),
+			PrincipalBalance: balance,
+		})
+	}
+
+	return installments, nil
+}
+
+// scheduleTerms returns the schedule terms of a loan account and its product
+func scheduleTerms(account *LoanAccount, product *loans_product.LoanProduct, fees float64, startDate time.Time) *ScheduleTerms {
+	return &ScheduleTerms{
+		Principal:       account.LoanAmount,
+		Fees:            fees,
+		InterestRate:    product.InterestRate,
+		InterestPeriod:  product.InterestCalculationPeriod,
+		InterestUnit:    product.InterestCalculationUnit,
+		InterestMethod:  product.InterestMethod,
+		Installments:    account.RepaymentInstallments,
+		RepaymentPeriod: account.RepaymentPeriod,
+		RepaymentUnit:   account.RepaymentPeriodUnit,
+		StartDate:       startDate,
+	}
+}
+
+// createLoanSchedule persists the installments of a loan account
+func createLoanSchedule(tx *gorm.DB, account *LoanAccount, installments []*Installment) error {
+	customerID, _ := strconv.Atoi(account.CustomerID)
+
+	schedule := make([]*LoanSchedule, 0, len(installments))
+	for _, installment := range installments {
+		schedule = append(schedule, &LoanSchedule{
+			LoanID:                          int(account.ID),
+			LoanAccountID:                   account.LoanID,
+			LoanProductID:                   account.LoanProductID,
+			CustomerID:                      customerID,
+			CurrencyID:                      account.CurrencyID,
+			CurrencyCode:                    account.CurrencyCode,
+			InstallmentNumber:               installment.Number,
+			InstallmentAmount:               installment.Total,
+			InstallmentBalance:              installment.Total,
+			InstallmentOutstandingPrinciple: installment.Principal,
+			InstallmentOutstandingSetupFees: installment.Fees,
+			InstallmentOutstandingInterest:  installment.Interest,
+			InstallmentInterest:             installment.Interest,
+			StatusID:                        LoanStatusActive,
+			DueDate:                         nullTime(installment.DueDate),
+		})
+	}
+
+	return tx.CreateInBatches(schedule, 100).Error
+}
+
+// PreviewLoanSchedule computes the repayment schedule of a loan account without persisting it
+func (ctrl *LoanController) PreviewLoanSchedule(c *gin.Context) {
+	id := c.Param("id")
+
+	var account LoanAccount
+	if err := ctrl.DB.WithContext(c.Request.Context()).First(&account, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Loan account not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get loan account"})
+		}
+		return
+	}
+
+	var product loans_product.LoanProduct
+	if err := ctrl.DB.WithContext(c.Request.Context()).First(&product, account.LoanProductID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Loan product not found"})
+		return
+	}
+
+	fees := product.SetupFeeAmount(account.LoanAmount)
+	if product.SetupFeeDeducted {
+		fees = 0
+	}
+
+	startDate := time.Now().UTC()
+	if account.DisbursementDate.Valid {
+		startDate = account.DisbursementDate.Time
+	}
+
+	installments, err := BuildSchedule(scheduleTerms(&account, &product, fees, startDate))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interest_method": product.InterestMethod,
+		"installments":    installments,
+	})
+}
@@ -0,0 +1,155 @@
+package loans
+
+import (
+	"testing"
+	"time"
+
+	loans_product "github.com/gidyon/pesapalm/internal/loan_product"
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
+)
+
+func TestBuildSchedule(t *testing.T) {
+	start := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	type installment struct {
+		principal, interest, fees, total, balance float64
+	}
+
+	tests := []struct {
+		name  string
+		terms ScheduleTerms
+		want  []installment
+	}{
+		{
+			name: "flat interest on the original principal",
+			terms: ScheduleTerms{
+				Principal: 1000, InterestRate: 10, InterestUnit: periodutil.Month, InterestMethod: loans_product.InterestMethodFlat,
+				Installments: 4, RepaymentPeriod: 1, RepaymentUnit: periodutil.Month,
+			},
+			want: []installment{
+				{250, 100, 0, 350, 750},
+				{250, 100, 0, 350, 500},
+				{250, 100, 0, 350, 250},
+				{250, 100, 0, 350, 0},
+			},
+		},
+		{
+			name: "declining balance interest on the outstanding principal",
+			terms: ScheduleTerms{
+				Principal: 1000, InterestRate: 10, InterestUnit: periodutil.Month, InterestMethod: loans_product.InterestMethodDecliningBalance,
+				Installments: 4, RepaymentPeriod: 1, RepaymentUnit: periodutil.Month,
+			},
+			want: []installment{
+				{250, 100, 0, 350, 750},
+				{250, 75, 0, 325, 500},
+				{250, 50, 0, 300, 250},
+				{250, 25, 0, 275, 0},
+			},
+		},
+		{
+			name: "annuity with equal installments",
+			terms: ScheduleTerms{
+				Principal: 1000, InterestRate: 10, InterestUnit: periodutil.Month, InterestMethod: loans_product.InterestMethodAnnuity,
+				Installments: 2, RepaymentPeriod: 1, RepaymentUnit: periodutil.Month,
+			},
+			want: []installment{
+				{476.19, 100, 0, 576.19, 523.81},
+				{523.81, 52.38, 0, 576.19, 0},
+			},
+		},
+		{
+			name: "annuity without interest",
+			terms: ScheduleTerms{
+				Principal: 900, InterestUnit: periodutil.Month, InterestMethod: loans_product.InterestMethodAnnuity,
+				Installments: 3, RepaymentPeriod: 1, RepaymentUnit: periodutil.Month,
+			},
+			want: []installment{
+				{300, 0, 0, 300, 600},
+				{300, 0, 0, 300, 300},
+				{300, 0, 0, 300, 0},
+			},
+		},
+		{
+			name: "rounding absorbed by the last installment",
+			terms: ScheduleTerms{
+				Principal: 1000, Fees: 100, InterestUnit: periodutil.Month,
+				Installments: 3, RepaymentPeriod: 1, RepaymentUnit: periodutil.Month,
+			},
+			want: []installment{
+				{333.33, 0, 33.33, 366.66, 666.67},
+				{333.33, 0, 33.33, 366.66, 333.34},
+				{333.34, 0, 33.34, 366.68, 0},
+			},
+		},
+		{
+			name: "monthly rate converted to weekly installments",
+			terms: ScheduleTerms{
+				Principal: 1000, InterestRate: 10, InterestPeriod: 1, InterestUnit: "months",
+				Installments: 2, RepaymentPeriod: 1, RepaymentUnit: "weeks",
+			},
+			want: []installment{
+				{500, 23.33, 0, 523.33, 500},
+				{500, 23.33, 0, 523.33, 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.terms.StartDate = start
+
+			got, err := BuildSchedule(&tt.terms)
+			if err != nil {
+				t.Fatalf("BuildSchedule() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("BuildSchedule() returned %d installments, want %d", len(got), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				inst := got[i]
+				if inst.Number != i+1 {
+					t.Errorf("installment %d: number = %d", i+1, inst.Number)
+				}
+				if due := periodutil.Add(start, i+1, tt.terms.RepaymentUnit); !inst.DueDate.Equal(due) {
+					t.Errorf("installment %d: due date = %s, want %s", i+1, inst.DueDate.Format(time.DateOnly), due.Format(time.DateOnly))
+				}
+				gotInst := installment{inst.Principal, inst.Interest, inst.Fees, inst.Total, inst.PrincipalBalance}
+				if gotInst != want {
+					t.Errorf("installment %d = %+v, want %+v", i+1, gotInst, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildScheduleInvalidTerms(t *testing.T) {
+	valid := ScheduleTerms{
+		Principal: 1000, InterestRate: 10, InterestUnit: periodutil.Month,
+		Installments: 4, RepaymentPeriod: 1, RepaymentUnit: periodutil.Month,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*ScheduleTerms)
+	}{
+		{"zero principal", func(terms *ScheduleTerms) { terms.Principal = 0 }},
+		{"zero installments", func(terms *ScheduleTerms) { terms.Installments = 0 }},
+		{"zero repayment period", func(terms *ScheduleTerms) { terms.RepaymentPeriod = 0 }},
+		{"unknown repayment unit", func(terms *ScheduleTerms) { terms.RepaymentUnit = "FORTNIGHT" }},
+		{"unknown interest unit", func(terms *ScheduleTerms) { terms.InterestUnit = "QUARTER" }},
+		{"negative interest rate", func(terms *ScheduleTerms) { terms.InterestRate = -1 }},
+		{"unknown interest method", func(terms *ScheduleTerms) { terms.InterestMethod = "COMPOUND" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms := valid
+			tt.modify(&terms)
+
+			if _, err := BuildSchedule(&terms); err == nil {
+				t.Error("BuildSchedule() error = nil, want an error")
+			}
+		})
+	}
+}
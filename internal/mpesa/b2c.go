@@ -0,0 +1,621 @@
+package mpesa
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/ledger"
+	"github.com/gidyon/pesapalm/internal/loans"
+	"github.com/gidyon/pesapalm/pkg/payload"
+	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SourceB2CUnapplied is the journal entry source of payouts that were paid but could not be applied
+const SourceB2CUnapplied = "MPESA_B2C_UNAPPLIED"
+
+// ErrPayoutNotFound is returned when a result references an unknown payout
+var ErrPayoutNotFound = errors.New("mpesa payout not found")
+
+var errPayoutNotPending = errors.New("mpesa payout is not pending")
+
+// Recovery of payouts whose result never arrived
+const (
+	payoutQueryDelay = 10 * time.Minute // Time given to the result before the payout is queried
+	payoutExpiry     = 24 * time.Hour   // Payouts still pending after this are failed
+)
+
+// PayoutService pays out loan disbursements to mobile wallets with B2C payments
+type PayoutService struct {
+	db     *gorm.DB
+	logger grpclog.LoggerV2
+	client *Client
+}
+
+// NewPayoutService creates a loans.PayoutSender backed by B2C payments
+func NewPayoutService(db *gorm.DB, logger grpclog.LoggerV2, client *Client) (*PayoutService, error) {
+	switch {
+	case db == nil:
+		return nil, errors.New("missing db")
+	case logger == nil:
+		return nil, errors.New("missing logger")
+	case client == nil:
+		return nil, errors.New("missing mpesa client")
+	case !client.B2CEnabled():
+		return nil, ErrB2CNotConfigured
+	}
+	return &PayoutService{db: db, logger: logger, client: client}, nil
+}
+
+// SendPayout sends the net amount of a pending disbursement to the customer's phone. The payout is
+// recorded with its originator conversation id before it is sent so that its result can always be
+// matched. Only definite rejections fail the payout; otherwise it stays pending until its result, a
+// status query or its expiry settles it.
+func (s *PayoutService) SendPayout(ctx context.Context, disbursement *loans.LoanDisbursement) error {
+	if disbursement.NetAmount != math.Trunc(disbursement.NetAmount) {
+		return fmt.Errorf("%w: mobile money payouts must be whole shillings, net amount is %.2f", loans.ErrPayoutRejected, disbursement.NetAmount)
+	}
+
+	payout := &Payout{
+		LoanDisbursementID:       disbursement.ID,
+		LoanAccountID:            disbursement.LoanAccountID,
+		PhoneNumber:              disbursement.PhoneNumber,
+		Amount:                   disbursement.NetAmount,
+		OriginatorConversationID: uuid.NewString(),
+		Status:                   StatusPending,
+	}
+
+	db := s.db.WithContext(ctx)
+
+	if err := db.Create(payout).Error; err != nil {
+		return fmt.Errorf("%w: %v", loans.ErrPayoutRejected, err)
+	}
+
+	res, err := s.client.B2CPayment(ctx, payout.OriginatorConversationID, payout.PhoneNumber, int64(payout.Amount), "Loan disbursement", disbursement.LoanID)
+	switch {
+	case err == nil:
+	case IsRejected(err):
+		payout.Status = StatusFailed
+		payout.ResultDesc = formatutil.Truncate(err.Error(), 256)
+		if err := db.Save(payout).Error; err != nil {
+			s.logger.Errorf("Failed to update payout %d: %v", payout.ID, err)
+		}
+		return fmt.Errorf("%w: %v", loans.ErrPayoutRejected, err)
+	default:
+		payout.ResultDesc = formatutil.Truncate("Outcome unknown: "+err.Error(), 256)
+		if err := db.Save(payout).Error; err != nil {
+			s.logger.Errorf("Failed to update payout %d: %v", payout.ID, err)
+		}
+		return err
+	}
+
+	// The result can be matched by the originator conversation id if this update fails
+	payout.ConversationID = sql.NullString{String: res.ConversationID, Valid: res.ConversationID != ""}
+	if err := db.Save(payout).Error; err != nil {
+		s.logger.Errorf("Failed to update payout %d: %v", payout.ID, err)
+	}
+
+	return nil
+}
+
+// payoutResult is the outcome of a payout, from its result callback, a status query or an operator
+type payoutResult struct {
+	Succeeded      bool
+	ResultCode     sql.NullInt32 // Only set by B2C results
+	ResultDesc     string
+	FailureReason  string // Recorded on the disbursement of a failed payout
+	ConversationID string
+	TransactionID  string
+	ReceiverName   string
+}
+
+// settlePayout records the result of a payout within tx and completes or reverses its loan
+// disbursement.
+func settlePayout(tx *gorm.DB, result *payload.B2CResultBody, timedOut bool) (*Payout, error) {
+	db := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	switch {
+	case result.ConversationID != "" && result.OriginatorConversationID != "":
+		db = db.Where("conversation_id = ? OR originator_conversation_id = ?", result.ConversationID, result.OriginatorConversationID)
+	case result.ConversationID != "":
+		db = db.Where("conversation_id = ?", result.ConversationID)
+	default:
+		db = db.Where("originator_conversation_id = ?", result.OriginatorConversationID)
+	}
+
+	var payout Payout
+	err := db.Take(&payout).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrPayoutNotFound
+	default:
+		return nil, err
+	}
+
+	failureReason := "Payout failed: " + result.ResultDesc
+	if timedOut {
+		failureReason = "Payout timed out: " + result.ResultDesc
+	}
+
+	return &payout, applyPayoutResult(tx, &payout, &payoutResult{
+		Succeeded:      !timedOut && result.ResultCode == 0,
+		ResultCode:     sql.NullInt32{Int32: int32(result.ResultCode), Valid: true},
+		ResultDesc:     result.ResultDesc,
+		FailureReason:  failureReason,
+		ConversationID: result.ConversationID,
+		TransactionID:  result.TransactionID,
+		ReceiverName:   result.ResultParameters.ReceiverName(),
+	})
+}
+
+// applyPayoutResult applies the result of a payout locked within tx. Results are idempotent: only the
+// first result of a payout is applied, except for a success that arrives after the payout was failed,
+// which is held in suspense since the money left.
+func applyPayoutResult(tx *gorm.DB, payout *Payout, result *payoutResult) error {
+	switch {
+	case payout.Status == StatusPending:
+	case payout.Status == StatusFailed && result.Succeeded:
+	default:
+		return nil
+	}
+
+	if !payout.ConversationID.Valid && result.ConversationID != "" {
+		payout.ConversationID = sql.NullString{String: result.ConversationID, Valid: true}
+	}
+	if result.ResultCode.Valid {
+		payout.ResultCode = result.ResultCode
+	}
+	payout.ResultDesc = formatutil.Truncate(result.ResultDesc, 256)
+
+	if !result.Succeeded {
+		payout.Status = StatusFailed
+		if _, err := loans.FailDisbursement(tx, payout.LoanDisbursementID, formatutil.Truncate(result.FailureReason, 256)); err != nil {
+			return err
+		}
+
+		return tx.Save(payout).Error
+	}
+
+	payout.TransactionID = result.TransactionID
+	payout.ReceiverName = formatutil.Truncate(result.ReceiverName, 256)
+	payout.CompletedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	// The money has left the wallet even if the loan cannot be activated, so record the result first
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		disbursement, err := loans.CompleteDisbursement(tx, payout.LoanDisbursementID, result.TransactionID)
+		if err == nil && disbursement.Status != loans.DisbursementStatusCompleted {
+			err = fmt.Errorf("disbursement is %s", strings.ToLower(disbursement.Status))
+		}
+		return err
+	})
+	if err != nil {
+		payout.Status = StatusUnapplied
+		payout.ResultDesc = formatutil.Truncate("Loan could not be activated: "+err.Error(), 256)
+
+		err := ledger.Post(tx, ledger.NewEntry(
+			SourceB2CUnapplied,
+			fmt.Sprint(payout.ID),
+			result.TransactionID,
+			fmt.Sprintf("Payout to %s for loan account %d that could not be activated", payout.PhoneNumber, payout.LoanAccountID),
+			ledger.Debit(ledger.AccountSuspense, payout.Amount),
+			ledger.Credit(ledger.AccountMpesa, payout.Amount),
+		))
+		if err != nil {
+			return err
+		}
+	} else {
+		payout.Status = StatusCompleted
+	}
+
+	return tx.Save(payout).Error
+}
+
+// B2CResult receives the result of a payout from Daraja
+func (ctrl *MpesaController) B2CResult(c *gin.Context) {
+	ctrl.handleB2CCallback(c, false)
+}
+
+// B2CTimeout receives a payout that expired in the Daraja queue; such payouts were not processed
+func (ctrl *MpesaController) B2CTimeout(c *gin.Context) {
+	ctrl.handleB2CCallback(c, true)
+}
+
+func (ctrl *MpesaController) handleB2CCallback(c *gin.Context, timedOut bool) {
+	if !ctrl.authorizeCallback(c) {
+		c.JSON(http.StatusUnauthorized, CallbackResponse{ResultCode: 1, ResultDesc: "Unauthorized"})
+		return
+	}
+
+	var body payload.B2CResult
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Invalid payload"})
+		return
+	}
+
+	result := &body.Result
+	if result.ConversationID == "" && result.OriginatorConversationID == "" {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Missing conversation id"})
+		return
+	}
+
+	var payout *Payout
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		payout, err = settlePayout(tx, result, timedOut)
+		return err
+	})
+	switch {
+	case err == nil:
+		ctrl.Logger.Infof("Payout %s settled as %s: %s", result.ConversationID, payout.Status, result.ResultDesc)
+	case errors.Is(err, ErrPayoutNotFound):
+		ctrl.Logger.Warningf("Received result for unknown payout %s", result.ConversationID)
+	default:
+		ctrl.Logger.Errorf("Failed to settle payout %s: %v", result.ConversationID, err)
+		c.JSON(http.StatusInternalServerError, CallbackResponse{ResultCode: 1, ResultDesc: "Failed to process result"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CallbackResponse{ResultCode: 0, ResultDesc: "Accepted"})
+}
+
+// B2CStatusResult receives the status of a payout queried by the payout query job
+func (ctrl *MpesaController) B2CStatusResult(c *gin.Context) {
+	if !ctrl.authorizeCallback(c) {
+		c.JSON(http.StatusUnauthorized, CallbackResponse{ResultCode: 1, ResultDesc: "Unauthorized"})
+		return
+	}
+
+	var body payload.B2CResult
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Invalid payload"})
+		return
+	}
+
+	// Queries carry the originator conversation id of the payout as their occasion
+	originatorID := body.Result.ReferenceData.Get("Occasion")
+	if originatorID == "" {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Missing occasion"})
+		return
+	}
+
+	var payout *Payout
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		payout, err = settlePayoutStatus(tx, originatorID, &body.Result)
+		return err
+	})
+	switch {
+	case err == nil:
+		ctrl.Logger.Infof("Payout %s is %s after a status query: %s", originatorID, payout.Status, body.Result.ResultDesc)
+	case errors.Is(err, ErrPayoutNotFound):
+		ctrl.Logger.Warningf("Received status of unknown payout %s", originatorID)
+	default:
+		ctrl.Logger.Errorf("Failed to settle status of payout %s: %v", originatorID, err)
+		c.JSON(http.StatusInternalServerError, CallbackResponse{ResultCode: 1, ResultDesc: "Failed to process result"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CallbackResponse{ResultCode: 0, ResultDesc: "Accepted"})
+}
+
+// B2CStatusTimeout receives a status query that expired in the Daraja queue; the payout is queried
+// again on the next run of the job
+func (ctrl *MpesaController) B2CStatusTimeout(c *gin.Context) {
+	if !ctrl.authorizeCallback(c) {
+		c.JSON(http.StatusUnauthorized, CallbackResponse{ResultCode: 1, ResultDesc: "Unauthorized"})
+		return
+	}
+
+	var body payload.B2CResult
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Invalid payload"})
+		return
+	}
+
+	ctrl.Logger.Warningf("Status query of payout %s timed out: %s", body.Result.ReferenceData.Get("Occasion"), body.Result.ResultDesc)
+
+	c.JSON(http.StatusOK, CallbackResponse{ResultCode: 0, ResultDesc: "Accepted"})
+}
+
+// settlePayoutStatus applies the status of a payout reported by a transaction status query within tx.
+// Payouts whose status is not final stay pending with the outcome of the query recorded.
+func settlePayoutStatus(tx *gorm.DB, originatorID string, result *payload.B2CResultBody) (*Payout, error) {
+	var payout Payout
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&payout, "originator_conversation_id = ?", originatorID).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrPayoutNotFound
+	default:
+		return nil, err
+	}
+
+	outcome := statusQueryResult(result)
+	if outcome != nil {
+		return &payout, applyPayoutResult(tx, &payout, outcome)
+	}
+
+	if payout.Status != StatusPending {
+		return &payout, nil
+	}
+
+	desc := result.ResultDesc
+	if status := result.ResultParameters.Get("TransactionStatus"); result.ResultCode == 0 && status != "" {
+		desc = "Transaction status is " + status
+	}
+	payout.ResultDesc = formatutil.Truncate("Status query: "+desc, 256)
+
+	return &payout, tx.Model(&payout).Update("result_desc", payout.ResultDesc).Error
+}
+
+// statusQueryResult returns the outcome of a payout reported by a transaction status query, or nil if
+// the query failed or the payout is not final
+func statusQueryResult(result *payload.B2CResultBody) *payoutResult {
+	if result.ResultCode != 0 {
+		return nil
+	}
+
+	status := result.ResultParameters.Get("TransactionStatus")
+	switch strings.ToLower(status) {
+	case "completed":
+		transactionID := result.ResultParameters.Get("ReceiptNo")
+		if transactionID == "" {
+			transactionID = result.TransactionID
+		}
+		return &payoutResult{
+			Succeeded:     true,
+			ResultDesc:    "Completed according to a status query",
+			TransactionID: transactionID,
+			ReceiverName:  result.ResultParameters.Get("CreditPartyName"),
+		}
+	case "failed", "cancelled", "declined", "expired":
+		return &payoutResult{
+			ResultDesc:    status + " according to a status query",
+			FailureReason: "Payout failed according to a status query: " + status,
+		}
+	default:
+		return nil
+	}
+}
+
+// PayoutJobResult summarizes a run of the payout query job
+type PayoutJobResult struct {
+	Unsent  int `json:"unsent"` // Disbursements whose payout was never sent
+	Queried int `json:"queried"`
+	Expired int `json:"expired"`
+	Pending int `json:"pending"`
+	Errors  int `json:"errors"`
+}
+
+// QueryPendingPayouts recovers mobile money disbursements stuck without a payout result. Pending
+// payouts are queried with the transaction status API, whose result settles them, until they expire
+// and are failed. Disbursements whose payout was never recorded, because the service stopped before
+// sending it, are failed so that the loan can be disbursed again.
+func QueryPendingPayouts(ctx context.Context, db *gorm.DB, logger grpclog.LoggerV2, client *Client, now time.Time) (*PayoutJobResult, error) {
+	cutoff := now.Add(-payoutQueryDelay)
+
+	var unsent []uint
+	err := db.WithContext(ctx).Model(&loans.LoanDisbursement{}).
+		Where("channel = ? AND status = ? AND created_at <= ?", loans.DisbursementChannelMpesa, loans.DisbursementStatusPending, cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM mpesa_b2c_payout WHERE mpesa_b2c_payout.loan_disbursement_id = loan_disbursement.id)").
+		Order("id ASC").
+		Pluck("id", &unsent).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Payout
+	err = db.WithContext(ctx).
+		Where("status = ? AND created_at <= ?", StatusPending, cutoff).
+		Where("last_queried_at IS NULL OR last_queried_at <= ?", cutoff).
+		Order("id ASC").
+		Find(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PayoutJobResult{}
+
+	for _, disbursementID := range unsent {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			_, err := loans.FailDisbursement(tx, disbursementID, "Payout was never sent")
+			return err
+		})
+		if err != nil {
+			result.Errors++
+			logger.Errorf("Failed to fail unsent disbursement %d: %v", disbursementID, err)
+			continue
+		}
+		result.Unsent++
+	}
+
+	for _, payout := range pending {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		switch {
+		case payout.CreatedAt.Before(now.Add(-payoutExpiry)):
+			err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return expirePayout(tx, payout.ID)
+			})
+			if err != nil {
+				result.Errors++
+				logger.Errorf("Failed to expire mpesa payout %d: %v", payout.ID, err)
+				continue
+			}
+			result.Expired++
+		case client.StatusQueryEnabled():
+			result.Queried++
+			if err := queryPayout(ctx, db, client, payout, now); err != nil {
+				result.Errors++
+				logger.Errorf("Failed to query mpesa payout %d: %v", payout.ID, err)
+			}
+		default:
+			result.Pending++
+		}
+	}
+
+	return result, nil
+}
+
+// queryPayout requests the status of a payout, which is posted to the status result URL. The attempt
+// is recorded whatever its outcome so that failing queries are retried after the query delay.
+func queryPayout(ctx context.Context, db *gorm.DB, client *Client, payout *Payout, now time.Time) error {
+	_, queryErr := client.QueryTransactionStatus(ctx, payout.OriginatorConversationID, payout.OriginatorConversationID)
+
+	err := db.WithContext(ctx).Model(payout).
+		Where("status = ?", StatusPending).
+		Updates(map[string]interface{}{
+			"query_attempts":  gorm.Expr("query_attempts + 1"),
+			"last_queried_at": now,
+		}).Error
+	if queryErr != nil {
+		return queryErr
+	}
+	return err
+}
+
+// expirePayout fails a payout within tx if it is still pending. A success reported after it expired
+// is held in suspense like any late result.
+func expirePayout(tx *gorm.DB, payoutID uint) error {
+	var payout Payout
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, "id = ?", payoutID).Error; err != nil {
+		return err
+	}
+
+	return applyPayoutResult(tx, &payout, &payoutResult{
+		ResultDesc:    "No result was received",
+		FailureReason: "Payout expired: no result was received",
+	})
+}
+
+// ResolvePayout settles a pending payout from the outcome confirmed by an operator, e.g. on the M-Pesa
+// portal, when neither its result nor a status query settles it. Completing a payout requires the
+// M-Pesa receipt of the payment.
+func (ctrl *MpesaController) ResolvePayout(c *gin.Context) {
+	var dto ResolvePayoutDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dto.TransactionID = strings.TrimSpace(dto.TransactionID)
+	if dto.Action == PayoutActionComplete && dto.TransactionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction ID is required to complete a payout"})
+		return
+	}
+
+	var resolvedBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		resolvedBy = metadata.UserId
+	}
+
+	outcome := &payoutResult{
+		ResultDesc:    "Failed by an operator: " + dto.Reason,
+		FailureReason: "Payout failed: " + dto.Reason,
+	}
+	if dto.Action == PayoutActionComplete {
+		outcome = &payoutResult{
+			Succeeded:     true,
+			ResultDesc:    "Completed by an operator: " + dto.Reason,
+			TransactionID: dto.TransactionID,
+		}
+	}
+
+	var payout Payout
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, "id = ?", c.Param("id")).Error
+		switch {
+		case err == nil:
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrPayoutNotFound
+		default:
+			return err
+		}
+
+		if payout.Status != StatusPending {
+			return errPayoutNotPending
+		}
+
+		payout.ResolvedBy = resolvedBy
+		return applyPayoutResult(tx, &payout, outcome)
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrPayoutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+		return
+	case errors.Is(err, errPayoutNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payout is not pending"})
+		return
+	default:
+		ctrl.Logger.Errorf("Failed to resolve payout %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve payout"})
+		return
+	}
+
+	ctrl.Logger.Infof("Payout %d resolved as %s by user %d: %s", payout.ID, payout.Status, resolvedBy, dto.Reason)
+
+	c.JSON(http.StatusOK, ToPayoutResponse(&payout))
+}
+
+// ListPayouts lists B2C payouts, newest first
+func (ctrl *MpesaController) ListPayouts(c *gin.Context) {
+	queryParams := c.Request.URL.Query()
+
+	pageSize, lastID, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Order("id DESC").Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+	if status := queryParams.Get("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if loanAccountID := queryParams.Get("loan_account_id"); loanAccountID != "" {
+		db = db.Where("loan_account_id = ?", loanAccountID)
+	}
+
+	payouts := make([]*Payout, 0, pageSize+1)
+	if err := db.Find(&payouts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payouts"})
+		return
+	}
+
+	response := make([]*PayoutResponse, 0, len(payouts))
+	for index, payout := range payouts {
+		if index == pageSize {
+			break
+		}
+		response = append(response, ToPayoutResponse(payout))
+	}
+
+	var nextPageToken string
+	if len(payouts) > pageSize {
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(payouts[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"payouts":         response,
+	})
+}
@@ -0,0 +1,94 @@
+package mpesa
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gidyon/pesapalm/pkg/payload"
+)
+
+func TestStatusQueryResult(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              string
+		wantOccasion      string
+		wantFinal         bool
+		wantSucceeded     bool
+		wantTransactionID string
+	}{
+		{
+			name: "completed payout",
+			body: `{"Result":{"ResultCode":0,"TransactionID":"QRY1","ResultParameters":{"ResultParameter":[
+				{"Key":"ReceiptNo","Value":"SCA1"},{"Key":"TransactionStatus","Value":"Completed"},{"Key":"CreditPartyName","Value":"254712345678 - John Doe"}]},
+				"ReferenceData":{"ReferenceItem":{"Key":"Occasion","Value":"payout-1"}}}}`,
+			wantOccasion:      "payout-1",
+			wantFinal:         true,
+			wantSucceeded:     true,
+			wantTransactionID: "SCA1",
+		},
+		{
+			name: "completed payout without a receipt",
+			body: `{"Result":{"ResultCode":0,"TransactionID":"SCA2","ResultParameters":{"ResultParameter":[
+				{"Key":"TransactionStatus","Value":"Completed"}]},
+				"ReferenceData":{"ReferenceItem":[{"Key":"QueueTimeoutURL","Value":"https://example.com"},{"Key":"Occasion","Value":"payout-2"}]}}}`,
+			wantOccasion:      "payout-2",
+			wantFinal:         true,
+			wantSucceeded:     true,
+			wantTransactionID: "SCA2",
+		},
+		{
+			name: "failed payout",
+			body: `{"Result":{"ResultCode":0,"ResultParameters":{"ResultParameter":[{"Key":"TransactionStatus","Value":"Failed"}]},
+				"ReferenceData":{"ReferenceItem":{"Key":"Occasion","Value":"payout-3"}}}}`,
+			wantOccasion: "payout-3",
+			wantFinal:    true,
+		},
+		{
+			name: "payout still being processed",
+			body: `{"Result":{"ResultCode":0,"ResultParameters":{"ResultParameter":[{"Key":"TransactionStatus","Value":"Pending"}]},
+				"ReferenceData":{"ReferenceItem":{"Key":"Occasion","Value":"payout-4"}}}}`,
+			wantOccasion: "payout-4",
+		},
+		{
+			name:         "failed query",
+			body:         `{"Result":{"ResultCode":2001,"ResultDesc":"The initiator information is invalid.","ReferenceData":{"ReferenceItem":{"Key":"Occasion","Value":"payout-5"}}}}`,
+			wantOccasion: "payout-5",
+		},
+		{
+			name:          "missing reference data",
+			body:          `{"Result":{"ResultCode":0,"ResultParameters":{"ResultParameter":[{"Key":"TransactionStatus","Value":"Completed"}]}}}`,
+			wantFinal:     true,
+			wantSucceeded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body payload.B2CResult
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+
+			if got := body.Result.ReferenceData.Get("Occasion"); got != tt.wantOccasion {
+				t.Errorf("occasion = %q, want %q", got, tt.wantOccasion)
+			}
+
+			got := statusQueryResult(&body.Result)
+			if (got != nil) != tt.wantFinal {
+				t.Fatalf("statusQueryResult() = %+v, want final %v", got, tt.wantFinal)
+			}
+			if got == nil {
+				return
+			}
+			if got.Succeeded != tt.wantSucceeded {
+				t.Errorf("statusQueryResult() succeeded = %v, want %v", got.Succeeded, tt.wantSucceeded)
+			}
+			if got.TransactionID != tt.wantTransactionID {
+				t.Errorf("statusQueryResult() transaction id = %q, want %q", got.TransactionID, tt.wantTransactionID)
+			}
+			if !got.Succeeded && got.FailureReason == "" {
+				t.Error("failed payout has no failure reason")
+			}
+		})
+	}
+}
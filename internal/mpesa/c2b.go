@@ -0,0 +1,396 @@
+package mpesa
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gidyon/pesapalm/internal/customer"
+	"github.com/gidyon/pesapalm/internal/loans"
+	"github.com/gidyon/pesapalm/internal/savings"
+	"github.com/gidyon/pesapalm/pkg/payload"
+	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SourceC2BUnapplied is the journal entry source of paybill payments held in suspense
+const SourceC2BUnapplied = "MPESA_C2B_UNAPPLIED"
+
+// C2B validation result codes
+const (
+	c2bAccepted             = "0"
+	c2bInvalidAccountNumber = "C2B00012"
+	c2bInvalidAmount        = "C2B00013"
+)
+
+// errUnknownReference is returned when an account number matches no account that can be paid
+var errUnknownReference = errors.New("account number does not match an active loan or savings account")
+
+// resolveBillRef maps the account number of a paybill payment to the account it pays. The account
+// number is matched against loan IDs, then savings IDs, then customer phone numbers. Payments by
+// phone number repay the customer's oldest active loan, or go to their first savings account.
+func resolveBillRef(db *gorm.DB, billRef string) (*credit, error) {
+	billRef = strings.TrimSpace(billRef)
+	if billRef == "" {
+		return nil, errUnknownReference
+	}
+
+	var loanAccount loans.LoanAccount
+	err := db.Select("id", "customer_id").
+		Where("loan_id = ? AND status_id = ?", billRef, loans.LoanStatusActive).
+		Take(&loanAccount).Error
+	switch {
+	case err == nil:
+		customerID, _ := strconv.Atoi(loanAccount.CustomerID)
+		return &credit{Purpose: PurposeLoanRepayment, LoanAccountID: loanAccount.ID, CustomerID: customerID}, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	var savingsAccount savings.SavingsAccount
+	err = db.Select("id", "customer_id").
+		Where("savings_id = ? AND status_id = ?", billRef, savings.StatusActivated).
+		Take(&savingsAccount).Error
+	switch {
+	case err == nil:
+		return &credit{Purpose: PurposeSavingsDeposit, SavingsAccountID: savingsAccount.ID, CustomerID: savingsAccount.CustomerID}, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	return resolvePhone(db, billRef)
+}
+
+// phoneFormats returns the formats a Kenyan phone number may be stored in, local, international or
+// E.164. It returns false when billRef is not a phone number.
+func phoneFormats(billRef string) ([]string, bool) {
+	phone := formatutil.FormatPhoneKE(billRef)
+	if len(phone) != 12 || !strings.HasPrefix(phone, "254") || strings.Trim(phone, "0123456789") != "" {
+		return nil, false
+	}
+	return []string{phone, "0" + phone[3:], "+" + phone}, true
+}
+
+// resolvePhone finds the account paid by a customer's phone number
+func resolvePhone(db *gorm.DB, phone string) (*credit, error) {
+	formats, ok := phoneFormats(phone)
+	if !ok {
+		return nil, errUnknownReference
+	}
+
+	var c customer.Customer
+	err := db.Select("id").
+		Where("msisdn1 IN ? OR msisdn2 IN ?", formats, formats).
+		Order("id ASC").
+		Take(&c).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, errUnknownReference
+	default:
+		return nil, err
+	}
+
+	var loanAccount loans.LoanAccount
+	err = db.Select("id").
+		Where("customer_id = ? AND status_id = ?", fmt.Sprint(c.ID), loans.LoanStatusActive).
+		Order("id ASC").
+		Take(&loanAccount).Error
+	switch {
+	case err == nil:
+		return &credit{Purpose: PurposeLoanRepayment, LoanAccountID: loanAccount.ID, CustomerID: int(c.ID)}, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	var savingsAccount savings.SavingsAccount
+	err = db.Select("id").
+		Where("customer_id = ? AND status_id = ?", c.ID, savings.StatusActivated).
+		Order("id ASC").
+		Take(&savingsAccount).Error
+	switch {
+	case err == nil:
+		return &credit{Purpose: PurposeSavingsDeposit, SavingsAccountID: savingsAccount.ID, CustomerID: int(c.ID)}, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, errUnknownReference
+	default:
+		return nil, err
+	}
+}
+
+// ParseNetworks parses a comma separated list of IP addresses and CIDR networks
+func ParseNetworks(spec string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid callback address %q: %v", entry, err)
+			}
+			networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		network, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid callback network %q: %v", entry, err)
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// fromCallbackNetwork reports whether the request comes from one of the Daraja callback networks
+func (ctrl *MpesaController) fromCallbackNetwork(c *gin.Context) bool {
+	addr, err := netip.ParseAddr(c.ClientIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range ctrl.CallbackNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeCallback checks that a callback carries the callback token and comes from a Daraja
+// network. Callbacks are rejected when either is not configured since anyone could otherwise report
+// payments.
+func (ctrl *MpesaController) authorizeCallback(c *gin.Context) bool {
+	if ctrl.CallbackToken == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(ctrl.CallbackToken)) != 1 {
+		return false
+	}
+	if !ctrl.fromCallbackNetwork(c) {
+		ctrl.Logger.Warningf("Rejected M-Pesa callback with a valid token from %s, which is not a callback network", c.ClientIP())
+		return false
+	}
+	return true
+}
+
+// ValidateC2B accepts paybill payments whose account number matches an account and rejects the rest
+func (ctrl *MpesaController) ValidateC2B(c *gin.Context) {
+	if !ctrl.authorizeCallback(c) {
+		c.JSON(http.StatusUnauthorized, payload.C2BResponse{ResultCode: c2bInvalidAccountNumber, ResultDesc: "Rejected"})
+		return
+	}
+
+	var body payload.C2BPayload
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, payload.C2BResponse{ResultCode: c2bInvalidAccountNumber, ResultDesc: "Rejected"})
+		return
+	}
+
+	if body.GetAmount() <= 0 {
+		c.JSON(http.StatusOK, payload.C2BResponse{ResultCode: c2bInvalidAmount, ResultDesc: "Rejected"})
+		return
+	}
+
+	_, err := resolveBillRef(ctrl.DB.WithContext(c.Request.Context()), body.BillRefNumber)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, payload.C2BResponse{ResultCode: c2bAccepted, ResultDesc: "Accepted"})
+	case errors.Is(err, errUnknownReference):
+		ctrl.Logger.Infof("Rejected paybill payment %s for unknown account number %q", body.TransID, body.BillRefNumber)
+		c.JSON(http.StatusOK, payload.C2BResponse{ResultCode: c2bInvalidAccountNumber, ResultDesc: "Rejected"})
+	default:
+		// Daraja completes or cancels the payment according to the registered response type
+		ctrl.Logger.Errorf("Failed to validate paybill payment %s: %v", body.TransID, err)
+		c.JSON(http.StatusInternalServerError, payload.C2BResponse{ResultCode: c2bInvalidAccountNumber, ResultDesc: "Rejected"})
+	}
+}
+
+// ConfirmC2B records a completed paybill payment and applies it to the account matching its account
+// number. Confirmations are idempotent on the M-Pesa transaction ID. Payments that cannot be applied
+// are held in suspense.
+func (ctrl *MpesaController) ConfirmC2B(c *gin.Context) {
+	if !ctrl.authorizeCallback(c) {
+		c.JSON(http.StatusUnauthorized, payload.C2BResponse{ResultCode: "1", ResultDesc: "Unauthorized"})
+		return
+	}
+
+	var body payload.C2BPayload
+	if err := c.ShouldBindJSON(&body); err != nil || body.TransID == "" {
+		c.JSON(http.StatusBadRequest, payload.C2BResponse{ResultCode: "1", ResultDesc: "Invalid payload"})
+		return
+	}
+
+	var (
+		payment = &C2BPayment{
+			TransID:           body.TransID,
+			TransactionType:   body.TransactionType,
+			TransTime:         sql.NullTime{Time: body.GetTransTime(), Valid: true},
+			Amount:            moneyutil.Round(body.GetAmount()),
+			BusinessShortCode: body.BusinessShortCode,
+			BillRefNumber:     strings.TrimSpace(body.BillRefNumber),
+			InvoiceNumber:     body.InvoiceNumber,
+			ThirdPartyTransID: body.ThirdPartyTransID,
+			MSISDN:            body.MSISDN,
+			PayerName:         strings.Join(strings.Fields(strings.Join([]string{body.FirstName, body.MiddleName, body.LastName}, " ")), " "),
+			Status:            StatusPending,
+		}
+		duplicate bool
+	)
+
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		duplicate, err = recordC2BPayment(tx, payment)
+		return err
+	})
+	switch {
+	case err != nil:
+		ctrl.Logger.Errorf("Failed to record paybill payment %s: %v", body.TransID, err)
+		c.JSON(http.StatusInternalServerError, payload.C2BResponse{ResultCode: "1", ResultDesc: "Failed to process confirmation"})
+		return
+	case duplicate:
+		ctrl.Logger.Infof("Ignored duplicate confirmation of paybill payment %s", body.TransID)
+	default:
+		ctrl.Logger.Infof("Paybill payment %s of %.2f for %q settled as %s", payment.TransID, payment.Amount, payment.BillRefNumber, payment.Status)
+	}
+
+	c.JSON(http.StatusOK, payload.C2BResponse{ResultCode: c2bAccepted, ResultDesc: "Accepted"})
+}
+
+// recordC2BPayment creates a paybill payment within tx and applies it to the account matching its
+// account number, holding it in suspense if it cannot be applied. It reports whether a payment with
+// the same transaction ID was already recorded, in which case nothing is changed.
+func recordC2BPayment(tx *gorm.DB, payment *C2BPayment) (bool, error) {
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(payment)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return true, nil
+	}
+
+	target, err := resolveBillRef(tx, payment.BillRefNumber)
+	switch {
+	case err == nil:
+		target.Amount = payment.Amount
+		target.Reference = payment.TransID
+		target.Payer = payment.MSISDN
+		target.Date = payment.TransTime.Time
+
+		payment.Purpose = target.Purpose
+		payment.LoanAccountID = target.LoanAccountID
+		payment.SavingsAccountID = target.SavingsAccountID
+		payment.CustomerID = target.CustomerID
+
+		// Apply within a savepoint so that a rejected payment is still recorded
+		err = tx.Transaction(func(tx *gorm.DB) error {
+			var err error
+			payment.AppliedReference, err = applyCredit(tx, target)
+			return err
+		})
+	case errors.Is(err, errUnknownReference):
+	default:
+		return false, err
+	}
+
+	if err != nil {
+		payment.Status = StatusUnapplied
+		payment.ApplyError = formatutil.Truncate(err.Error(), 256)
+
+		err := postSuspense(
+			tx,
+			SourceC2BUnapplied,
+			fmt.Sprint(payment.ID),
+			payment.TransID,
+			fmt.Sprintf("Unapplied paybill payment %s for account %q: %s", payment.TransID, payment.BillRefNumber, payment.ApplyError),
+			payment.Amount,
+		)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		payment.Status = StatusCompleted
+	}
+
+	return false, tx.Save(payment).Error
+}
+
+// ListC2BPayments lists paybill payments, newest first
+func (ctrl *MpesaController) ListC2BPayments(c *gin.Context) {
+	queryParams := c.Request.URL.Query()
+
+	pageSize, lastID, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Order("id DESC").Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+	if status := queryParams.Get("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if transID := queryParams.Get("trans_id"); transID != "" {
+		db = db.Where("trans_id = ?", transID)
+	}
+	if billRef := queryParams.Get("bill_ref_number"); billRef != "" {
+		db = db.Where("bill_ref_number = ?", billRef)
+	}
+
+	payments := make([]*C2BPayment, 0, pageSize+1)
+	if err := db.Find(&payments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve paybill payments"})
+		return
+	}
+
+	response := make([]*C2BPaymentResponse, 0, len(payments))
+	for index, payment := range payments {
+		if index == pageSize {
+			break
+		}
+		response = append(response, ToC2BPaymentResponse(payment))
+	}
+
+	var nextPageToken string
+	if len(payments) > pageSize {
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(payments[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"payments":        response,
+	})
+}
+
+// RegisterC2BURLs registers the paybill validation and confirmation URLs with Daraja
+func (ctrl *MpesaController) RegisterC2BURLs(c *gin.Context) {
+	var dto RegisterC2BURLsDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if dto.ResponseType == "" {
+		dto.ResponseType = ResponseTypeCompleted
+	}
+
+	res, err := ctrl.Client.RegisterC2BURLs(c.Request.Context(), dto.ConfirmationURL, dto.ValidationURL, dto.ResponseType)
+	if err != nil {
+		ctrl.Logger.Errorf("Failed to register paybill URLs: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to register paybill URLs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": res.ResponseDescription})
+}
@@ -0,0 +1,158 @@
+package mpesa
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/grpclog"
+)
+
+func TestPhoneFormats(t *testing.T) {
+	want := []string{"254712345678", "0712345678", "+254712345678"}
+
+	tests := []struct {
+		name    string
+		billRef string
+		want    []string
+	}{
+		{"local format", "0712345678", want},
+		{"international format", "254712345678", want},
+		{"E.164 format", "+254712345678", want},
+		{"without leading zero", "712345678", want},
+		{"international format with leading zero", "2540712345678", want},
+		{"surrounding spaces", " 0712345678 ", want},
+		{"01 prefix", "0110123456", []string{"254110123456", "0110123456", "+254110123456"}},
+		{"too short", "071234567", nil},
+		{"too long", "07123456789", nil},
+		{"letters", "07123456AB", nil},
+		{"foreign number", "447712345678", nil},
+		{"loan id", "LN-2026-000123", nil},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := phoneFormats(tt.billRef)
+			if ok != (tt.want != nil) {
+				t.Fatalf("phoneFormats(%q) ok = %v, want %v", tt.billRef, ok, tt.want != nil)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("phoneFormats(%q) = %v, want %v", tt.billRef, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveBillRefEmpty(t *testing.T) {
+	// Blank account numbers are rejected before the database is used
+	for _, billRef := range []string{"", "   "} {
+		if _, err := resolveBillRef(nil, billRef); !errors.Is(err, errUnknownReference) {
+			t.Errorf("resolveBillRef(%q) error = %v, want %v", billRef, err, errUnknownReference)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "addresses and networks",
+			spec: "196.201.214.200, 196.201.212.0/24,2001:db8::1",
+			want: []string{"196.201.214.200/32", "196.201.212.0/24", "2001:db8::1/128"},
+		},
+		{
+			name: "networks are masked",
+			spec: "196.201.214.77/24",
+			want: []string{"196.201.214.0/24"},
+		},
+		{
+			name: "empty entries are skipped",
+			spec: " ,196.201.214.200,, ",
+			want: []string{"196.201.214.200/32"},
+		},
+		{
+			name: "empty",
+			spec: "",
+		},
+		{
+			name:    "invalid address",
+			spec:    "196.201.214",
+			wantErr: true,
+		},
+		{
+			name:    "invalid network",
+			spec:    "196.201.214.0/33",
+			wantErr: true,
+		},
+		{
+			name:    "host name",
+			spec:    "safaricom.co.ke",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := ParseNetworks(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, network := range networks {
+				got = append(got, network.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseNetworks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeCallback(t *testing.T) {
+	networks := []netip.Prefix{netip.MustParsePrefix("196.201.214.0/24")}
+
+	tests := []struct {
+		name       string
+		token      string
+		networks   []netip.Prefix
+		query      string
+		remoteAddr string
+		want       bool
+	}{
+		{"valid token from a callback network", "secret", networks, "?token=secret", "196.201.214.10:443", true},
+		{"IPv4-mapped address", "secret", networks, "?token=secret", "[::ffff:196.201.214.10]:443", true},
+		{"wrong token", "secret", networks, "?token=guess", "196.201.214.10:443", false},
+		{"missing token", "secret", networks, "", "196.201.214.10:443", false},
+		{"outside the callback networks", "secret", networks, "?token=secret", "203.0.113.5:443", false},
+		{"no token configured", "", networks, "?token=", "196.201.214.10:443", false},
+		{"no networks configured", "secret", nil, "?token=secret", "196.201.214.10:443", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := &MpesaController{Options: &Options{
+				Logger:           grpclog.NewLoggerV2(io.Discard, io.Discard, io.Discard),
+				CallbackToken:    tt.token,
+				CallbackNetworks: tt.networks,
+			}}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/api/v1/mpesa/callbacks/c2b/confirmation"+tt.query, nil)
+			c.Request.RemoteAddr = tt.remoteAddr
+
+			if got := ctrl.authorizeCallback(c); got != tt.want {
+				t.Errorf("authorizeCallback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package mpesa
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gidyon/pesapalm/pkg/payload"
+)
+
+// DefaultBaseURL is the Daraja sandbox API
+const DefaultBaseURL = "https://sandbox.safaricom.co.ke"
+
+// TransactionTypePayBill is the STK transaction type for paybill short codes
+const TransactionTypePayBill = "CustomerPayBillOnline"
+
+// Response types applied by Daraja when the paybill validation URL cannot be reached
+const (
+	ResponseTypeCompleted = "Completed"
+	ResponseTypeCancelled = "Cancelled"
+)
+
+// B2C command IDs
+const (
+	CommandBusinessPayment  = "BusinessPayment"
+	CommandSalaryPayment    = "SalaryPayment"
+	CommandPromotionPayment = "PromotionPayment"
+)
+
+// CommandTransactionStatusQuery is the command ID of transaction status queries
+const CommandTransactionStatusQuery = "TransactionStatusQuery"
+
+// identifierTypeShortCode identifies the querying party of a transaction status query as a short code
+const identifierTypeShortCode = "4"
+
+// ErrB2CNotConfigured is returned when sending a payout without an initiator
+var ErrB2CNotConfigured = errors.New("mpesa b2c payouts are not configured")
+
+// timestampLayout is the layout of Daraja request timestamps, in East Africa Time
+const timestampLayout = "20060102150405"
+
+var eat = time.FixedZone("EAT", 3*60*60)
+
+// ClientOptions contains the Daraja credentials and settings of the paybill
+type ClientOptions struct {
+	BaseURL         string
+	ConsumerKey     string
+	ConsumerSecret  string
+	ShortCode       string
+	PassKey         string
+	TransactionType string
+	CallbackURL     string
+	HTTPClient      *http.Client
+
+	// B2C payouts are enabled when the initiator is set
+	InitiatorName      string
+	SecurityCredential string // Initiator password encrypted with the Daraja certificate
+	B2CShortCode       string // Defaults to ShortCode
+	B2CCommandID       string // Defaults to BusinessPayment
+	B2CResultURL       string
+	B2CTimeoutURL      string
+
+	// Payouts without a result are queried with the transaction status API when both are set
+	StatusResultURL  string
+	StatusTimeoutURL string
+}
+
+// Client calls the Daraja APIs for Lipa na M-Pesa Online
+type Client struct {
+	opt *ClientOptions
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewClient creates a Daraja client. BaseURL can point to a local fake Daraja server in development.
+func NewClient(opt *ClientOptions) (*Client, error) {
+	switch {
+	case opt == nil:
+		return nil, errors.New("missing mpesa client options")
+	case opt.ConsumerKey == "" || opt.ConsumerSecret == "":
+		return nil, errors.New("missing mpesa consumer key or secret")
+	case opt.ShortCode == "":
+		return nil, errors.New("missing mpesa short code")
+	case opt.PassKey == "":
+		return nil, errors.New("missing mpesa pass key")
+	case opt.CallbackURL == "":
+		return nil, errors.New("missing mpesa callback url")
+	}
+
+	options := *opt
+	if options.BaseURL == "" {
+		options.BaseURL = DefaultBaseURL
+	}
+	options.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	if options.TransactionType == "" {
+		options.TransactionType = TransactionTypePayBill
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if options.B2CShortCode == "" {
+		options.B2CShortCode = options.ShortCode
+	}
+	if options.B2CCommandID == "" {
+		options.B2CCommandID = CommandBusinessPayment
+	}
+
+	return &Client{opt: &options}, nil
+}
+
+// STKPushRequest is the request body of an STK push
+type STKPushRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	TransactionType   string `json:"TransactionType"`
+	Amount            int64  `json:"Amount"`
+	PartyA            string `json:"PartyA"`
+	PartyB            string `json:"PartyB"`
+	PhoneNumber       string `json:"PhoneNumber"`
+	CallBackURL       string `json:"CallBackURL"`
+	AccountReference  string `json:"AccountReference"`
+	TransactionDesc   string `json:"TransactionDesc"`
+}
+
+// STKPushResponse is the response of an accepted STK push
+type STKPushResponse struct {
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	CustomerMessage     string `json:"CustomerMessage"`
+}
+
+// APIError is an error returned by Daraja
+type APIError struct {
+	StatusCode   int    `json:"-"`
+	RequestID    string `json:"requestId"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("daraja error %s (status %d): %s", e.ErrorCode, e.StatusCode, e.ErrorMessage)
+}
+
+// errorCodeProcessing is returned by the STK query while the customer has not yet responded
+const errorCodeProcessing = "500.001.1001"
+
+// IsProcessing reports whether err means that the STK transaction is still being processed
+func IsProcessing(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == errorCodeProcessing
+}
+
+// IsRejected reports whether err is a definite rejection of a request by Daraja, i.e. a non-zero
+// response code or a client error. Transport errors, timeouts and server errors are not, since the
+// request may have been processed.
+func IsRejected(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError
+}
+
+// ShortCode returns the paybill short code of the client
+func (c *Client) ShortCode() string {
+	return c.opt.ShortCode
+}
+
+// password returns the STK password and timestamp for a request sent at t
+func (c *Client) password(t time.Time) (string, string) {
+	timestamp := t.In(eat).Format(timestampLayout)
+	return base64.StdEncoding.EncodeToString([]byte(c.opt.ShortCode + c.opt.PassKey + timestamp)), timestamp
+}
+
+// token returns a cached OAuth access token, fetching a new one when it is about to expire
+func (c *Client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken != "" && time.Now().Before(c.expiresAt) {
+		return c.accessToken, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.opt.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.opt.ConsumerKey, c.opt.ConsumerSecret)
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := c.do(req, &res); err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	expiresIn, _ := strconv.Atoi(res.ExpiresIn)
+	if expiresIn <= 0 {
+		expiresIn = 3599
+	}
+
+	c.accessToken = res.AccessToken
+	// Refresh a minute early so in-flight requests do not use an expired token
+	c.expiresAt = time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute)
+
+	return c.accessToken, nil
+}
+
+// post sends an authenticated JSON request and decodes the response into out
+func (c *Client) post(ctx context.Context, path string, body, out interface{}) error {
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opt.BaseURL+path, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out interface{}) error {
+	res, err := c.opt.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode/100 != 2 {
+		apiErr := &APIError{StatusCode: res.StatusCode}
+		if err := json.Unmarshal(bs, apiErr); err != nil || apiErr.ErrorCode == "" {
+			apiErr.ErrorMessage = strings.TrimSpace(string(bs))
+		}
+		return apiErr
+	}
+
+	return json.Unmarshal(bs, out)
+}
+
+// STKPush prompts the customer on phone to pay amount to the paybill under accountReference
+func (c *Client) STKPush(ctx context.Context, phone string, amount int64, accountReference, description string) (*STKPushResponse, error) {
+	password, timestamp := c.password(time.Now())
+
+	var res STKPushResponse
+	err := c.post(ctx, "/mpesa/stkpush/v1/processrequest", &STKPushRequest{
+		BusinessShortCode: c.opt.ShortCode,
+		Password:          password,
+		Timestamp:         timestamp,
+		TransactionType:   c.opt.TransactionType,
+		Amount:            amount,
+		PartyA:            phone,
+		PartyB:            c.opt.ShortCode,
+		PhoneNumber:       phone,
+		CallBackURL:       c.opt.CallbackURL,
+		AccountReference:  accountReference,
+		TransactionDesc:   description,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ResponseCode != "0" {
+		return nil, &APIError{StatusCode: http.StatusOK, ErrorCode: res.ResponseCode, ErrorMessage: res.ResponseDescription}
+	}
+
+	return &res, nil
+}
+
+// QuerySTK queries the status of an STK push
+func (c *Client) QuerySTK(ctx context.Context, checkoutRequestID string) (*payload.QueryStkResponse, error) {
+	password, timestamp := c.password(time.Now())
+
+	var res payload.QueryStkResponse
+	err := c.post(ctx, "/mpesa/stkpushquery/v1/query", &payload.QueryStkRequest{
+		BusinessShortCode: c.opt.ShortCode,
+		Password:          password,
+		Timestamp:         timestamp,
+		CheckoutRequestID: checkoutRequestID,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// RegisterC2BURLsRequest is the request body for registering paybill callback URLs
+type RegisterC2BURLsRequest struct {
+	ShortCode       string `json:"ShortCode"`
+	ResponseType    string `json:"ResponseType"`
+	ConfirmationURL string `json:"ConfirmationURL"`
+	ValidationURL   string `json:"ValidationURL"`
+}
+
+// RegisterC2BURLsResponse is the response of registering paybill callback URLs
+type RegisterC2BURLsResponse struct {
+	OriginatorConversationID string `json:"OriginatorCoversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// RegisterC2BURLs registers the URLs that Daraja calls to validate and confirm paybill payments
+func (c *Client) RegisterC2BURLs(ctx context.Context, confirmationURL, validationURL, responseType string) (*RegisterC2BURLsResponse, error) {
+	var res RegisterC2BURLsResponse
+	err := c.post(ctx, "/mpesa/c2b/v1/registerurl", &RegisterC2BURLsRequest{
+		ShortCode:       c.opt.ShortCode,
+		ResponseType:    responseType,
+		ConfirmationURL: confirmationURL,
+		ValidationURL:   validationURL,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ResponseCode != "0" {
+		return nil, &APIError{StatusCode: http.StatusOK, ErrorCode: res.ResponseCode, ErrorMessage: res.ResponseDescription}
+	}
+
+	return &res, nil
+}
+
+// B2CEnabled reports whether the client is configured to send B2C payouts
+func (c *Client) B2CEnabled() bool {
+	return c.opt.InitiatorName != "" && c.opt.SecurityCredential != "" && c.opt.B2CResultURL != "" && c.opt.B2CTimeoutURL != ""
+}
+
+// B2CRequest is the request body of a B2C payment
+type B2CRequest struct {
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	InitiatorName            string `json:"InitiatorName"`
+	SecurityCredential       string `json:"SecurityCredential"`
+	CommandID                string `json:"CommandID"`
+	Amount                   int64  `json:"Amount"`
+	PartyA                   string `json:"PartyA"`
+	PartyB                   string `json:"PartyB"`
+	Remarks                  string `json:"Remarks"`
+	QueueTimeOutURL          string `json:"QueueTimeOutURL"`
+	ResultURL                string `json:"ResultURL"`
+	Occasion                 string `json:"Occasion"`
+}
+
+// B2CResponse is the response of an accepted B2C payment
+type B2CResponse struct {
+	ConversationID           string `json:"ConversationID"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// B2CPayment sends amount from the B2C short code to the mobile wallet of phone. The outcome is
+// posted to the result URL, or to the timeout URL if the request expires in the queue. The result
+// carries originatorID, so the payout can be matched even when the response is lost.
+//
+// Errors for which IsRejected is false leave the outcome unknown: the payment may still be made.
+func (c *Client) B2CPayment(ctx context.Context, originatorID, phone string, amount int64, remarks, occasion string) (*B2CResponse, error) {
+	if !c.B2CEnabled() {
+		return nil, ErrB2CNotConfigured
+	}
+
+	var res B2CResponse
+	err := c.post(ctx, "/mpesa/b2c/v3/paymentrequest", &B2CRequest{
+		OriginatorConversationID: originatorID,
+		InitiatorName:            c.opt.InitiatorName,
+		SecurityCredential:       c.opt.SecurityCredential,
+		CommandID:                c.opt.B2CCommandID,
+		Amount:                   amount,
+		PartyA:                   c.opt.B2CShortCode,
+		PartyB:                   phone,
+		Remarks:                  remarks,
+		QueueTimeOutURL:          c.opt.B2CTimeoutURL,
+		ResultURL:                c.opt.B2CResultURL,
+		Occasion:                 occasion,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ResponseCode != "0" {
+		return nil, &APIError{StatusCode: http.StatusOK, ErrorCode: res.ResponseCode, ErrorMessage: res.ResponseDescription}
+	}
+
+	return &res, nil
+}
+
+// StatusQueryEnabled reports whether the client is configured to query the status of B2C payouts
+func (c *Client) StatusQueryEnabled() bool {
+	return c.B2CEnabled() && c.opt.StatusResultURL != "" && c.opt.StatusTimeoutURL != ""
+}
+
+// TransactionStatusRequest is the request body of a transaction status query
+type TransactionStatusRequest struct {
+	Initiator              string `json:"Initiator"`
+	SecurityCredential     string `json:"SecurityCredential"`
+	CommandID              string `json:"CommandID"`
+	TransactionID          string `json:"TransactionID"`
+	OriginalConversationID string `json:"OriginalConversationID"`
+	PartyA                 string `json:"PartyA"`
+	IdentifierType         string `json:"IdentifierType"`
+	ResultURL              string `json:"ResultURL"`
+	QueueTimeOutURL        string `json:"QueueTimeOutURL"`
+	Remarks                string `json:"Remarks"`
+	Occasion               string `json:"Occasion"`
+}
+
+// TransactionStatusResponse is the response of an accepted transaction status query
+type TransactionStatusResponse struct {
+	ConversationID           string `json:"ConversationID"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+}
+
+// QueryTransactionStatus queries the status of the B2C payment sent with originatorID. The status is
+// posted to the status result URL and carries occasion in its reference data.
+func (c *Client) QueryTransactionStatus(ctx context.Context, originatorID, occasion string) (*TransactionStatusResponse, error) {
+	if !c.StatusQueryEnabled() {
+		return nil, ErrB2CNotConfigured
+	}
+
+	var res TransactionStatusResponse
+	err := c.post(ctx, "/mpesa/transactionstatus/v1/query", &TransactionStatusRequest{
+		Initiator:              c.opt.InitiatorName,
+		SecurityCredential:     c.opt.SecurityCredential,
+		CommandID:              CommandTransactionStatusQuery,
+		OriginalConversationID: originatorID,
+		PartyA:                 c.opt.B2CShortCode,
+		IdentifierType:         identifierTypeShortCode,
+		ResultURL:              c.opt.StatusResultURL,
+		QueueTimeOutURL:        c.opt.StatusTimeoutURL,
+		Remarks:                "Payout status",
+		Occasion:               occasion,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.ResponseCode != "0" {
+		return nil, &APIError{StatusCode: http.StatusOK, ErrorCode: res.ResponseCode, ErrorMessage: res.ResponseDescription}
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,351 @@
+package mpesa
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gidyon/pesapalm/internal/customer"
+	"github.com/gidyon/pesapalm/internal/loans"
+	"github.com/gidyon/pesapalm/internal/savings"
+	"github.com/gidyon/pesapalm/pkg/payload"
+	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 200
+	maxPageSize     = 1000
+)
+
+// accountReferenceLength is the maximum length of an STK account reference
+const accountReferenceLength = 12
+
+// MpesaController handles M-Pesa payments
+type MpesaController struct {
+	*Options
+}
+
+// payee is the account an STK payment is made to
+type payee struct {
+	customerID int
+	reference  string
+	phone      string
+}
+
+// InitiateSTKPush prompts a customer to pay a loan repayment or savings deposit on their phone
+func (ctrl *MpesaController) InitiateSTKPush(c *gin.Context) {
+	var dto STKPushDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if dto.Amount != math.Trunc(dto.Amount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "M-Pesa amounts must be whole shillings"})
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context())
+
+	var (
+		to  *payee
+		err error
+	)
+	switch dto.Purpose {
+	case PurposeLoanRepayment:
+		to, err = loanPayee(db, dto.AccountID)
+	case PurposeSavingsDeposit:
+		to, err = savingsPayee(db, dto.AccountID)
+	}
+	if err != nil {
+		var statusErr *payeeError
+		if errors.As(err, &statusErr) {
+			c.JSON(statusErr.status, gin.H{"error": statusErr.message})
+			return
+		}
+		ctrl.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve account"})
+		return
+	}
+
+	phone := dto.PhoneNumber
+	if phone == "" {
+		phone = to.phone
+	}
+	phone = formatutil.FormatPhoneKE(phone)
+	if phone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required"})
+		return
+	}
+
+	var initiatedBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		initiatedBy = metadata.UserId
+	}
+
+	reference := to.reference
+	if len(reference) > accountReferenceLength {
+		reference = reference[:accountReferenceLength]
+	}
+
+	res, err := ctrl.Client.STKPush(c.Request.Context(), phone, int64(dto.Amount), reference, "Payment")
+	if err != nil {
+		ctrl.Logger.Errorf("Failed to send STK push to %s: %v", phone, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send M-Pesa payment request"})
+		return
+	}
+
+	payment := &Payment{
+		Purpose:           dto.Purpose,
+		CustomerID:        to.customerID,
+		AccountReference:  reference,
+		PhoneNumber:       phone,
+		Amount:            dto.Amount,
+		MerchantRequestID: res.MerchantRequestID,
+		CheckoutRequestID: res.CheckoutRequestID,
+		Status:            StatusPending,
+		InitiatedBy:       initiatedBy,
+	}
+	if dto.Purpose == PurposeLoanRepayment {
+		payment.LoanAccountID = dto.AccountID
+	} else {
+		payment.SavingsAccountID = dto.AccountID
+	}
+
+	if err := db.Create(payment).Error; err != nil {
+		ctrl.Logger.Errorf("Failed to save STK push %s: %v", res.CheckoutRequestID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save M-Pesa payment request"})
+		return
+	}
+
+	ctrl.Logger.Infof("Sent STK push %s of %.0f to %s for %s", payment.CheckoutRequestID, payment.Amount, phone, reference)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"customer_message": res.CustomerMessage,
+		"payment":          ToPaymentResponse(payment),
+	})
+}
+
+// payeeError is an error resolving the account being paid that carries the HTTP status to respond with
+type payeeError struct {
+	status  int
+	message string
+}
+
+func (e *payeeError) Error() string {
+	return e.message
+}
+
+func loanPayee(db *gorm.DB, id uint) (*payee, error) {
+	var account loans.LoanAccount
+	err := db.First(&account, "id = ?", id).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, &payeeError{http.StatusNotFound, "Loan account not found"}
+	default:
+		return nil, err
+	}
+
+	if account.StatusID != loans.LoanStatusActive {
+		return nil, &payeeError{http.StatusBadRequest, "Loan account is not active"}
+	}
+
+	customerID, _ := strconv.Atoi(account.CustomerID)
+	phone, err := customerPhone(db, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payee{customerID: customerID, reference: account.LoanID, phone: phone}, nil
+}
+
+func savingsPayee(db *gorm.DB, id uint) (*payee, error) {
+	var account savings.SavingsAccount
+	err := db.First(&account, "id = ?", id).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, &payeeError{http.StatusNotFound, "Savings account not found"}
+	default:
+		return nil, err
+	}
+
+	if account.StatusID != savings.StatusActivated {
+		return nil, &payeeError{http.StatusBadRequest, "Savings account is not active"}
+	}
+
+	phone, err := customerPhone(db, account.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payee{customerID: account.CustomerID, reference: account.SavingsID, phone: phone}, nil
+}
+
+// customerPhone returns the primary phone number of a customer, or an empty string if there is none
+func customerPhone(db *gorm.DB, customerID int) (string, error) {
+	var c customer.Customer
+	err := db.Select("id", "msisdn1").First(&c, "id = ?", customerID).Error
+	switch {
+	case err == nil:
+		return c.MSISDN1.String, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+// STKCallback receives the result of an STK push from Daraja and applies successful payments
+func (ctrl *MpesaController) STKCallback(c *gin.Context) {
+	if !ctrl.authorizeCallback(c) {
+		c.JSON(http.StatusUnauthorized, CallbackResponse{ResultCode: 1, ResultDesc: "Unauthorized"})
+		return
+	}
+
+	var body payload.STKPayload
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Invalid payload"})
+		return
+	}
+
+	callback := body.Body.STKCallback
+	if callback.CheckoutRequestID == "" {
+		c.JSON(http.StatusBadRequest, CallbackResponse{ResultCode: 1, ResultDesc: "Missing checkout request id"})
+		return
+	}
+
+	result := &stkResult{
+		ResultCode: callback.ResultCode,
+		ResultDesc: callback.ResultDesc,
+	}
+	if callback.ResultCode == 0 {
+		meta := &callback.CallbackMetadata
+		result.Amount = float64(meta.GetAmount())
+		result.Receipt = meta.MpesaReceiptNumber()
+		result.TransactionDate = meta.GetTransTime()
+	}
+
+	var payment *Payment
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		payment, err = settlePayment(tx, callback.CheckoutRequestID, result)
+		return err
+	})
+	switch {
+	case err == nil:
+		ctrl.Logger.Infof("STK push %s settled as %s: %s", payment.CheckoutRequestID, payment.Status, callback.ResultDesc)
+	case errors.Is(err, ErrPaymentNotFound):
+		// The status query job picks up payments whose callback raced their creation
+		ctrl.Logger.Warningf("Received callback for unknown STK push %s", callback.CheckoutRequestID)
+	default:
+		ctrl.Logger.Errorf("Failed to settle STK push %s: %v", callback.CheckoutRequestID, err)
+		c.JSON(http.StatusInternalServerError, CallbackResponse{ResultCode: 1, ResultDesc: "Failed to process callback"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CallbackResponse{ResultCode: 0, ResultDesc: "Accepted"})
+}
+
+// GetPayment retrieves an M-Pesa payment
+func (ctrl *MpesaController) GetPayment(c *gin.Context) {
+	var payment Payment
+	err := ctrl.DB.WithContext(c.Request.Context()).First(&payment, "id = ?", c.Param("id")).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "M-Pesa payment not found"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve M-Pesa payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ToPaymentResponse(&payment))
+}
+
+// pagination reads the page size and page token of a list request, writing an error if the token
+// is invalid
+func pagination(c *gin.Context) (int, int, bool) {
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	var lastID int
+	if pageToken := c.Query("pageToken"); pageToken != "" {
+		bs, err := base64.StdEncoding.DecodeString(pageToken)
+		if err == nil {
+			lastID, err = strconv.Atoi(string(bs))
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return 0, 0, false
+		}
+	}
+
+	return pageSize, lastID, true
+}
+
+// ListPayments lists M-Pesa payments, newest first
+func (ctrl *MpesaController) ListPayments(c *gin.Context) {
+	queryParams := c.Request.URL.Query()
+
+	pageSize, lastID, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Order("id DESC").Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+	if status := queryParams.Get("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if purpose := queryParams.Get("purpose"); purpose != "" {
+		db = db.Where("purpose = ?", purpose)
+	}
+	if loanAccountID := queryParams.Get("loan_account_id"); loanAccountID != "" {
+		db = db.Where("loan_account_id = ?", loanAccountID)
+	}
+	if savingsAccountID := queryParams.Get("savings_account_id"); savingsAccountID != "" {
+		db = db.Where("savings_account_id = ?", savingsAccountID)
+	}
+	if phone := queryParams.Get("phone_number"); phone != "" {
+		db = db.Where("phone_number = ?", formatutil.FormatPhoneKE(phone))
+	}
+
+	payments := make([]*Payment, 0, pageSize+1)
+	if err := db.Find(&payments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve M-Pesa payments"})
+		return
+	}
+
+	response := make([]*PaymentResponse, 0, len(payments))
+	for index, payment := range payments {
+		if index == pageSize {
+			break
+		}
+		response = append(response, ToPaymentResponse(payment))
+	}
+
+	var nextPageToken string
+	if len(payments) > pageSize {
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(payments[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"payments":        response,
+	})
+}
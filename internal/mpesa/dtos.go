@@ -0,0 +1,278 @@
+package mpesa
+
+import "time"
+
+// STKPushDTO defines the JSON structure for prompting a customer to pay with M-Pesa
+type STKPushDTO struct {
+	Purpose     string  `json:"purpose" binding:"required,oneof=LOAN_REPAYMENT SAVINGS_DEPOSIT"`
+	AccountID   uint    `json:"account_id" binding:"required"` // Loan account or savings account id
+	PhoneNumber string  `json:"phone_number"`                  // Defaults to the customer's primary phone number
+	Amount      float64 `json:"amount" binding:"required,gte=1"`
+}
+
+// PaymentResponse defines the structure of an M-Pesa payment returned in the response
+type PaymentResponse struct {
+	ID                 uint    `json:"id"`
+	Purpose            string  `json:"purpose"`
+	LoanAccountID      uint    `json:"loan_account_id,omitempty"`
+	SavingsAccountID   uint    `json:"savings_account_id,omitempty"`
+	CustomerID         int     `json:"customer_id"`
+	AccountReference   string  `json:"account_reference"`
+	PhoneNumber        string  `json:"phone_number"`
+	Amount             float64 `json:"amount"`
+	AmountPaid         float64 `json:"amount_paid"`
+	MerchantRequestID  string  `json:"merchant_request_id"`
+	CheckoutRequestID  string  `json:"checkout_request_id"`
+	Status             string  `json:"status"`
+	ResultCode         *int32  `json:"result_code"`
+	ResultDesc         string  `json:"result_desc,omitempty"`
+	MpesaReceiptNumber string  `json:"mpesa_receipt_number,omitempty"`
+	TransactionDate    *string `json:"transaction_date"`
+	ApplyError         string  `json:"apply_error,omitempty"`
+	AppliedReference   string  `json:"applied_reference,omitempty"`
+	QueryAttempts      int     `json:"query_attempts"`
+	InitiatedBy        uint64  `json:"initiated_by"`
+	CreatedAt          string  `json:"created_at"`
+	UpdatedAt          string  `json:"updated_at"`
+}
+
+// ToPaymentResponse converts a Payment to a PaymentResponse
+func ToPaymentResponse(payment *Payment) *PaymentResponse {
+	response := &PaymentResponse{
+		ID:                 payment.ID,
+		Purpose:            payment.Purpose,
+		LoanAccountID:      payment.LoanAccountID,
+		SavingsAccountID:   payment.SavingsAccountID,
+		CustomerID:         payment.CustomerID,
+		AccountReference:   payment.AccountReference,
+		PhoneNumber:        payment.PhoneNumber,
+		Amount:             payment.Amount,
+		AmountPaid:         payment.AmountPaid,
+		MerchantRequestID:  payment.MerchantRequestID,
+		CheckoutRequestID:  payment.CheckoutRequestID,
+		Status:             payment.Status,
+		ResultDesc:         payment.ResultDesc,
+		MpesaReceiptNumber: payment.MpesaReceiptNumber.String,
+		ApplyError:         payment.ApplyError,
+		AppliedReference:   payment.AppliedReference,
+		QueryAttempts:      payment.QueryAttempts,
+		InitiatedBy:        payment.InitiatedBy,
+		CreatedAt:          payment.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:          payment.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+	if payment.ResultCode.Valid {
+		response.ResultCode = &payment.ResultCode.Int32
+	}
+	if payment.TransactionDate.Valid {
+		transactionDate := payment.TransactionDate.Time.UTC().Format(time.RFC3339)
+		response.TransactionDate = &transactionDate
+	}
+	return response
+}
+
+// CallbackResponse is the acknowledgement returned to Daraja
+type CallbackResponse struct {
+	ResultCode int    `json:"ResultCode"`
+	ResultDesc string `json:"ResultDesc"`
+}
+
+// RegisterC2BURLsDTO defines the JSON structure for registering the paybill callback URLs
+type RegisterC2BURLsDTO struct {
+	ConfirmationURL string `json:"confirmation_url" binding:"required,url"`
+	ValidationURL   string `json:"validation_url" binding:"required,url"`
+	ResponseType    string `json:"response_type" binding:"omitempty,oneof=Completed Cancelled"` // Outcome when validation is unreachable
+}
+
+// C2BPaymentResponse defines the structure of a paybill payment returned in the response
+type C2BPaymentResponse struct {
+	ID                uint    `json:"id"`
+	TransID           string  `json:"trans_id"`
+	TransactionType   string  `json:"transaction_type"`
+	TransTime         *string `json:"trans_time"`
+	Amount            float64 `json:"amount"`
+	BusinessShortCode string  `json:"business_short_code"`
+	BillRefNumber     string  `json:"bill_ref_number"`
+	InvoiceNumber     string  `json:"invoice_number,omitempty"`
+	MSISDN            string  `json:"msisdn"`
+	PayerName         string  `json:"payer_name"`
+	Purpose           string  `json:"purpose,omitempty"`
+	LoanAccountID     uint    `json:"loan_account_id,omitempty"`
+	SavingsAccountID  uint    `json:"savings_account_id,omitempty"`
+	CustomerID        int     `json:"customer_id,omitempty"`
+	Status            string  `json:"status"`
+	ApplyError        string  `json:"apply_error,omitempty"`
+	AppliedReference  string  `json:"applied_reference,omitempty"`
+	CreatedAt         string  `json:"created_at"`
+}
+
+// ToC2BPaymentResponse converts a C2BPayment to a C2BPaymentResponse
+func ToC2BPaymentResponse(payment *C2BPayment) *C2BPaymentResponse {
+	response := &C2BPaymentResponse{
+		ID:                payment.ID,
+		TransID:           payment.TransID,
+		TransactionType:   payment.TransactionType,
+		Amount:            payment.Amount,
+		BusinessShortCode: payment.BusinessShortCode,
+		BillRefNumber:     payment.BillRefNumber,
+		InvoiceNumber:     payment.InvoiceNumber,
+		MSISDN:            payment.MSISDN,
+		PayerName:         payment.PayerName,
+		Purpose:           payment.Purpose,
+		LoanAccountID:     payment.LoanAccountID,
+		SavingsAccountID:  payment.SavingsAccountID,
+		CustomerID:        payment.CustomerID,
+		Status:            payment.Status,
+		ApplyError:        payment.ApplyError,
+		AppliedReference:  payment.AppliedReference,
+		CreatedAt:         payment.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if payment.TransTime.Valid {
+		transTime := payment.TransTime.Time.UTC().Format(time.RFC3339)
+		response.TransTime = &transTime
+	}
+	return response
+}
+
+// PayoutResponse defines the structure of a B2C payout returned in the response
+type PayoutResponse struct {
+	ID                       uint    `json:"id"`
+	LoanDisbursementID       uint    `json:"loan_disbursement_id"`
+	LoanAccountID            uint    `json:"loan_account_id"`
+	PhoneNumber              string  `json:"phone_number"`
+	Amount                   float64 `json:"amount"`
+	ConversationID           string  `json:"conversation_id,omitempty"`
+	OriginatorConversationID string  `json:"originator_conversation_id,omitempty"`
+	Status                   string  `json:"status"`
+	ResultCode               *int32  `json:"result_code"`
+	ResultDesc               string  `json:"result_desc,omitempty"`
+	TransactionID            string  `json:"transaction_id,omitempty"`
+	ReceiverName             string  `json:"receiver_name,omitempty"`
+	QueryAttempts            int     `json:"query_attempts"`
+	ResolvedBy               uint64  `json:"resolved_by,omitempty"`
+	CompletedAt              *string `json:"completed_at"`
+	CreatedAt                string  `json:"created_at"`
+}
+
+// ToPayoutResponse converts a Payout to a PayoutResponse
+func ToPayoutResponse(payout *Payout) *PayoutResponse {
+	response := &PayoutResponse{
+		ID:                       payout.ID,
+		LoanDisbursementID:       payout.LoanDisbursementID,
+		LoanAccountID:            payout.LoanAccountID,
+		PhoneNumber:              payout.PhoneNumber,
+		Amount:                   payout.Amount,
+		ConversationID:           payout.ConversationID.String,
+		OriginatorConversationID: payout.OriginatorConversationID,
+		Status:                   payout.Status,
+		ResultDesc:               payout.ResultDesc,
+		TransactionID:            payout.TransactionID,
+		ReceiverName:             payout.ReceiverName,
+		QueryAttempts:            payout.QueryAttempts,
+		ResolvedBy:               payout.ResolvedBy,
+		CreatedAt:                payout.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if payout.ResultCode.Valid {
+		response.ResultCode = &payout.ResultCode.Int32
+	}
+	if payout.CompletedAt.Valid {
+		completedAt := payout.CompletedAt.Time.UTC().Format(time.RFC3339)
+		response.CompletedAt = &completedAt
+	}
+	return response
+}
+
+// Actions that resolve a payout without a result
+const (
+	PayoutActionComplete = "complete"
+	PayoutActionFail     = "fail"
+)
+
+// ResolvePayoutDTO defines the JSON structure for settling a pending payout by hand
+type ResolvePayoutDTO struct {
+	Action        string `json:"action" binding:"required,oneof=complete fail"`
+	TransactionID string `json:"transaction_id"` // M-Pesa receipt, required to complete the payout
+	Reason        string `json:"reason" binding:"required,max=200"`
+}
+
+// PostStatementLinesDTO defines the JSON structure for posting statement transactions missing in the system
+type PostStatementLinesDTO struct {
+	LineIDs []uint `json:"line_ids"` // Defaults to all unposted lines
+}
+
+// StatementResponse defines the reconciliation summary of an imported statement
+type StatementResponse struct {
+	ID                 uint   `json:"id"`
+	FileName           string `json:"file_name"`
+	PeriodStart        string `json:"period_start"`
+	PeriodEnd          string `json:"period_end"`
+	Rows               int    `json:"rows"`
+	Skipped            int    `json:"skipped"`
+	Matched            int    `json:"matched"`
+	AmountMismatches   int    `json:"amount_mismatches"`
+	MissingInSystem    int    `json:"missing_in_system"`
+	MissingInStatement int    `json:"missing_in_statement"`
+	Posted             int    `json:"posted"`
+	ImportedBy         uint64 `json:"imported_by"`
+	CreatedAt          string `json:"created_at"`
+}
+
+// ToStatementResponse converts a Statement to a StatementResponse
+func ToStatementResponse(statement *Statement) *StatementResponse {
+	return &StatementResponse{
+		ID:                 statement.ID,
+		FileName:           statement.FileName,
+		PeriodStart:        statement.PeriodStart.Format(time.DateTime),
+		PeriodEnd:          statement.PeriodEnd.Format(time.DateTime),
+		Rows:               statement.Rows,
+		Skipped:            statement.Skipped,
+		Matched:            statement.Matched,
+		AmountMismatches:   statement.AmountMismatches,
+		MissingInSystem:    statement.MissingInSystem,
+		MissingInStatement: statement.MissingInStatement,
+		Posted:             statement.Posted,
+		ImportedBy:         statement.ImportedBy,
+		CreatedAt:          statement.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// StatementLineResponse defines the structure of a reconciled statement line returned in the response
+type StatementLineResponse struct {
+	ID             uint    `json:"id"`
+	ReceiptNumber  string  `json:"receipt_number"`
+	CompletionTime string  `json:"completion_time"`
+	Details        string  `json:"details,omitempty"`
+	OtherPartyInfo string  `json:"other_party_info,omitempty"`
+	AccountNumber  string  `json:"account_number,omitempty"`
+	PaidIn         float64 `json:"paid_in"`
+	Withdrawn      float64 `json:"withdrawn"`
+	MatchStatus    string  `json:"match_status"`
+	RecordType     string  `json:"record_type,omitempty"`
+	RecordID       uint    `json:"record_id,omitempty"`
+	RecordAmount   float64 `json:"record_amount"`
+	PostedAt       *string `json:"posted_at"`
+	PostedBy       uint64  `json:"posted_by,omitempty"`
+}
+
+// ToStatementLineResponse converts a StatementLine to a StatementLineResponse
+func ToStatementLineResponse(line *StatementLine) *StatementLineResponse {
+	response := &StatementLineResponse{
+		ID:             line.ID,
+		ReceiptNumber:  line.ReceiptNumber,
+		CompletionTime: line.CompletionTime.Format(time.DateTime),
+		Details:        line.Details,
+		OtherPartyInfo: line.OtherPartyInfo,
+		AccountNumber:  line.AccountNumber,
+		PaidIn:         line.PaidIn,
+		Withdrawn:      line.Withdrawn,
+		MatchStatus:    line.MatchStatus,
+		RecordType:     line.RecordType,
+		RecordID:       line.RecordID,
+		RecordAmount:   line.RecordAmount,
+		PostedBy:       line.PostedBy,
+	}
+	if line.PostedAt.Valid {
+		postedAt := line.PostedAt.Time.UTC().Format(time.RFC3339)
+		response.PostedAt = &postedAt
+	}
+	return response
+}
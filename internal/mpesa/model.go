@@ -0,0 +1,206 @@
+package mpesa
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Payment purposes
+const (
+	PurposeLoanRepayment  = "LOAN_REPAYMENT"
+	PurposeSavingsDeposit = "SAVINGS_DEPOSIT"
+)
+
+// Payment statuses
+const (
+	StatusPending   = "PENDING"   // Waiting for the customer to respond to the prompt
+	StatusCompleted = "COMPLETED" // Paid and applied to the account
+	StatusUnapplied = "UNAPPLIED" // Paid but could not be applied to the account, held in suspense
+	StatusFailed    = "FAILED"    // Cancelled by the customer or rejected by M-Pesa
+	StatusExpired   = "EXPIRED"   // No result was received within the query window
+)
+
+// Payment is an M-Pesa payment initiated with an STK push
+type Payment struct {
+	ID                 uint           `gorm:"primaryKey"`
+	Purpose            string         `gorm:"size:30;not null"`
+	LoanAccountID      uint           `gorm:"index;default:0"`
+	SavingsAccountID   uint           `gorm:"index;default:0"`
+	CustomerID         int            `gorm:"index;default:0"`
+	AccountReference   string         `gorm:"size:50"`
+	PhoneNumber        string         `gorm:"size:20;not null"`
+	Amount             float64        `gorm:"type:double(20,2);not null"`
+	AmountPaid         float64        `gorm:"type:double(20,2);default:0.00"`
+	MerchantRequestID  string         `gorm:"size:100"`
+	CheckoutRequestID  string         `gorm:"size:100;uniqueIndex;not null"`
+	Status             string         `gorm:"size:20;index;not null"`
+	ResultCode         sql.NullInt32  `gorm:"type:int"`
+	ResultDesc         string         `gorm:"size:256"`
+	MpesaReceiptNumber sql.NullString `gorm:"size:50;uniqueIndex"`
+	TransactionDate    sql.NullTime   `gorm:"type:datetime"`
+	ApplyError         string         `gorm:"size:256"`
+	AppliedReference   string         `gorm:"size:50"` // ID of the loan repayment or savings transaction
+	QueryAttempts      int            `gorm:"default:0"`
+	LastQueriedAt      sql.NullTime   `gorm:"type:datetime"`
+	InitiatedBy        uint64         `gorm:"type:bigint"`
+	CreatedAt          time.Time      `gorm:"autoCreateTime;index"`
+	UpdatedAt          time.Time      `gorm:"autoUpdateTime"`
+}
+
+func (*Payment) TableName() string {
+	return "mpesa_payment"
+}
+
+// C2BPayment is a payment received on the paybill, keyed by its M-Pesa transaction ID
+type C2BPayment struct {
+	ID                uint         `gorm:"primaryKey"`
+	TransID           string       `gorm:"size:50;uniqueIndex;not null"`
+	TransactionType   string       `gorm:"size:50"`
+	TransTime         sql.NullTime `gorm:"type:datetime"`
+	Amount            float64      `gorm:"type:double(20,2);not null"`
+	BusinessShortCode string       `gorm:"size:20"`
+	BillRefNumber     string       `gorm:"size:50;index"`
+	InvoiceNumber     string       `gorm:"size:50"`
+	ThirdPartyTransID string       `gorm:"size:50"`
+	MSISDN            string       `gorm:"size:50"`
+	PayerName         string       `gorm:"size:256"`
+	Purpose           string       `gorm:"size:30"`
+	LoanAccountID     uint         `gorm:"index;default:0"`
+	SavingsAccountID  uint         `gorm:"index;default:0"`
+	CustomerID        int          `gorm:"index;default:0"`
+	Status            string       `gorm:"size:20;index;not null"`
+	ApplyError        string       `gorm:"size:256"`
+	AppliedReference  string       `gorm:"size:50"` // ID of the loan repayment or savings transaction
+	CreatedAt         time.Time    `gorm:"autoCreateTime;index"`
+	UpdatedAt         time.Time    `gorm:"autoUpdateTime"`
+}
+
+func (*C2BPayment) TableName() string {
+	return "mpesa_c2b_payment"
+}
+
+// Payout is a B2C payment of a loan disbursement to a customer's mobile wallet
+type Payout struct {
+	ID                       uint           `gorm:"primaryKey"`
+	LoanDisbursementID       uint           `gorm:"uniqueIndex;not null"`
+	LoanAccountID            uint           `gorm:"index;not null"`
+	PhoneNumber              string         `gorm:"size:20;not null"`
+	Amount                   float64        `gorm:"type:double(20,2);not null"`
+	ConversationID           sql.NullString `gorm:"size:100;uniqueIndex"`
+	OriginatorConversationID string         `gorm:"size:100;index"`
+	Status                   string         `gorm:"size:20;index;not null"`
+	ResultCode               sql.NullInt32  `gorm:"type:int"`
+	ResultDesc               string         `gorm:"size:256"`
+	TransactionID            string         `gorm:"size:50"`
+	ReceiverName             string         `gorm:"size:256"`
+	QueryAttempts            int            `gorm:"default:0"`
+	LastQueriedAt            sql.NullTime   `gorm:"type:datetime"`
+	ResolvedBy               uint64         `gorm:"type:bigint"` // Operator who settled a payout without a result
+	CompletedAt              sql.NullTime   `gorm:"type:datetime"`
+	CreatedAt                time.Time      `gorm:"autoCreateTime;index"`
+	UpdatedAt                time.Time      `gorm:"autoUpdateTime"`
+}
+
+func (*Payout) TableName() string {
+	return "mpesa_b2c_payout"
+}
+
+// Statement line match statuses
+const (
+	MatchMatched            = "MATCHED"              // Recorded in the system with the same amount
+	MatchAmountMismatch     = "AMOUNT_MISMATCH"      // Recorded in the system with a different amount
+	MatchMissingInSystem    = "MISSING_IN_SYSTEM"    // Settled by Safaricom but not recorded in the system
+	MatchMissingInStatement = "MISSING_IN_STATEMENT" // Recorded in the system but not settled by Safaricom
+)
+
+// Record types that statement lines are matched to
+const (
+	RecordSTK = "STK"
+	RecordC2B = "C2B"
+	RecordB2C = "B2C"
+)
+
+// Statement is an imported M-Pesa organization statement and the summary of its reconciliation
+type Statement struct {
+	ID                 uint      `gorm:"primaryKey"`
+	FileName           string    `gorm:"size:256"`
+	PeriodStart        time.Time `gorm:"type:datetime;not null"`
+	PeriodEnd          time.Time `gorm:"type:datetime;not null"`
+	Rows               int       `gorm:"default:0"`
+	Skipped            int       `gorm:"default:0"` // Rows that were not completed transactions
+	Matched            int       `gorm:"default:0"`
+	AmountMismatches   int       `gorm:"default:0"`
+	MissingInSystem    int       `gorm:"default:0"`
+	MissingInStatement int       `gorm:"default:0"`
+	Posted             int       `gorm:"default:0"`
+	ImportedBy         uint64    `gorm:"type:bigint"`
+	CreatedAt          time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
+}
+
+func (*Statement) TableName() string {
+	return "mpesa_statement"
+}
+
+// StatementLine is a transaction of an imported statement, or a transaction recorded in the system
+// during the statement period that the statement does not contain
+type StatementLine struct {
+	ID             uint         `gorm:"primaryKey"`
+	StatementID    uint         `gorm:"index;not null"`
+	ReceiptNumber  string       `gorm:"size:50;index;not null"`
+	CompletionTime time.Time    `gorm:"type:datetime"`
+	Details        string       `gorm:"size:256"`
+	OtherPartyInfo string       `gorm:"size:256"`
+	AccountNumber  string       `gorm:"size:50"`
+	PaidIn         float64      `gorm:"type:double(20,2);default:0.00"`
+	Withdrawn      float64      `gorm:"type:double(20,2);default:0.00"`
+	MatchStatus    string       `gorm:"size:30;index;not null"`
+	RecordType     string       `gorm:"size:10"`
+	RecordID       uint         `gorm:"default:0"`
+	RecordAmount   float64      `gorm:"type:double(20,2);default:0.00"`
+	PostedAt       sql.NullTime `gorm:"type:datetime"`
+	PostedBy       uint64       `gorm:"type:bigint"`
+	CreatedAt      time.Time    `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time    `gorm:"autoUpdateTime"`
+}
+
+func (*StatementLine) TableName() string {
+	return "mpesa_statement_line"
+}
+
+// columns added to mpesa tables after the initial schema
+var migratedColumns = []struct {
+	model  interface{}
+	column string
+}{
+	{&Payout{}, "QueryAttempts"},
+	{&Payout{}, "LastQueriedAt"},
+	{&Payout{}, "ResolvedBy"},
+}
+
+// Migrate creates the mpesa tables and columns that are missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+
+	for _, model := range []interface{}{&Payment{}, &C2BPayment{}, &Payout{}, &Statement{}, &StatementLine{}} {
+		if !migrator.HasTable(model) {
+			if err := migrator.AutoMigrate(model); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, migrated := range migratedColumns {
+		if migrator.HasColumn(migrated.model, migrated.column) {
+			continue
+		}
+		if err := migrator.AddColumn(migrated.model, migrated.column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package mpesa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/ledger"
+	"github.com/gidyon/pesapalm/internal/loans"
+	"github.com/gidyon/pesapalm/internal/savings"
+	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Channel is the channel recorded on repayments and deposits received through M-Pesa
+const Channel = loans.RepaymentChannelMpesa
+
+// SourceUnapplied is the journal entry source of payments held in suspense
+const SourceUnapplied = "MPESA_UNAPPLIED"
+
+// ErrPaymentNotFound is returned when a result references an unknown STK push
+var ErrPaymentNotFound = errors.New("mpesa payment not found")
+
+// Polling of payments whose callback never arrived
+const (
+	queryDelay  = 2 * time.Minute // Time given to the callback before the payment is queried
+	queryExpiry = 24 * time.Hour  // Payments still pending after this are expired
+)
+
+// stkResult is the outcome of an STK push, from its callback or a status query
+type stkResult struct {
+	ResultCode      int
+	ResultDesc      string
+	Receipt         string
+	Amount          float64 // Falls back to the requested amount when zero
+	TransactionDate time.Time
+}
+
+// settlePayment records the result of an STK push within tx and applies successful payments to the
+// loan or savings account. Results are idempotent: a payment is applied once no matter how many
+// callbacks or queries report it. Payments that cannot be applied are held in suspense.
+func settlePayment(tx *gorm.DB, checkoutRequestID string, result *stkResult) (*Payment, error) {
+	var payment Payment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "checkout_request_id = ?", checkoutRequestID).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrPaymentNotFound
+	default:
+		return nil, err
+	}
+
+	switch payment.Status {
+	case StatusCompleted, StatusUnapplied:
+		// Already applied; a late callback may still carry the receipt a query could not
+		if !payment.MpesaReceiptNumber.Valid && result.Receipt != "" {
+			payment.MpesaReceiptNumber = sql.NullString{String: result.Receipt, Valid: true}
+			return &payment, tx.Model(&payment).Update("mpesa_receipt_number", payment.MpesaReceiptNumber).Error
+		}
+		return &payment, nil
+	}
+
+	payment.ResultCode = sql.NullInt32{Int32: int32(result.ResultCode), Valid: true}
+	payment.ResultDesc = result.ResultDesc
+
+	if result.ResultCode != 0 {
+		if payment.Status == StatusPending {
+			payment.Status = StatusFailed
+		}
+		return &payment, tx.Save(&payment).Error
+	}
+
+	// A payment that expired or failed may still succeed later, in which case the money was received
+	payment.AmountPaid = moneyutil.Round(result.Amount)
+	if payment.AmountPaid <= 0 {
+		payment.AmountPaid = payment.Amount
+	}
+	if result.Receipt != "" {
+		payment.MpesaReceiptNumber = sql.NullString{String: result.Receipt, Valid: true}
+	}
+	payment.TransactionDate = sql.NullTime{Time: result.TransactionDate, Valid: !result.TransactionDate.IsZero()}
+
+	// A receipt posted from a statement before its result arrived was already credited
+	var recorded []uint
+	if payment.MpesaReceiptNumber.Valid {
+		err := tx.Model(&C2BPayment{}).Where("trans_id = ?", payment.MpesaReceiptNumber.String).Limit(1).Pluck("id", &recorded).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(recorded) > 0 {
+		err = fmt.Errorf("receipt %s was already recorded as paybill payment %d", payment.MpesaReceiptNumber.String, recorded[0])
+	} else {
+		// Apply within a savepoint so that a rejected payment does not roll back the result
+		err = tx.Transaction(func(tx *gorm.DB) error {
+			return applyPayment(tx, &payment)
+		})
+	}
+	if err != nil {
+		payment.Status = StatusUnapplied
+		payment.ApplyError = formatutil.Truncate(err.Error(), 256)
+
+		if err := postUnapplied(tx, &payment); err != nil {
+			return nil, err
+		}
+	} else {
+		payment.Status = StatusCompleted
+		payment.ApplyError = ""
+	}
+
+	return &payment, tx.Save(&payment).Error
+}
+
+// applyPayment credits a received STK payment to its loan or savings account
+func applyPayment(tx *gorm.DB, payment *Payment) error {
+	reference := payment.CheckoutRequestID
+	if payment.MpesaReceiptNumber.Valid {
+		reference = payment.MpesaReceiptNumber.String
+	}
+
+	applied, err := applyCredit(tx, &credit{
+		Purpose:          payment.Purpose,
+		LoanAccountID:    payment.LoanAccountID,
+		SavingsAccountID: payment.SavingsAccountID,
+		CustomerID:       payment.CustomerID,
+		Amount:           payment.AmountPaid,
+		Reference:        reference,
+		Payer:            payment.PhoneNumber,
+		Date:             payment.TransactionDate.Time,
+		CreatedBy:        payment.InitiatedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	payment.AppliedReference = applied
+	return nil
+}
+
+// credit is money received through M-Pesa for a loan or savings account
+type credit struct {
+	Purpose          string
+	LoanAccountID    uint
+	SavingsAccountID uint
+	CustomerID       int
+	Amount           float64
+	Reference        string
+	Payer            string
+	Date             time.Time
+	CreatedBy        uint64
+}
+
+// applyCredit posts a loan repayment or savings deposit and returns the ID of the repayment or
+// savings transaction
+func applyCredit(tx *gorm.DB, c *credit) (string, error) {
+	switch c.Purpose {
+	case PurposeLoanRepayment:
+		repayment, err := loans.ApplyRepayment(tx, c.LoanAccountID, &loans.RepaymentRequest{
+			Amount:     c.Amount,
+			Channel:    Channel,
+			Reference:  c.Reference,
+			Notes:      fmt.Sprintf("M-Pesa payment from %s", c.Payer),
+			ReceivedBy: c.CreatedBy,
+			Date:       c.Date,
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(repayment.ID), nil
+	case PurposeSavingsDeposit:
+		transaction, _, err := savings.Credit(tx, &savings.TransactionRequest{
+			SavingsAccountID: c.SavingsAccountID,
+			CustomerID:       c.CustomerID,
+			TransactionType:  savings.TransactionDeposit,
+			Amount:           c.Amount,
+			Channel:          Channel,
+			Reference:        c.Reference,
+			Narration:        fmt.Sprintf("M-Pesa deposit from %s", c.Payer),
+			CreatedBy:        c.CreatedBy,
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(transaction.ID), nil
+	default:
+		return "", fmt.Errorf("unknown payment purpose %s", c.Purpose)
+	}
+}
+
+// postUnapplied holds a received STK payment that could not be applied in the suspense account
+func postUnapplied(tx *gorm.DB, payment *Payment) error {
+	return postSuspense(
+		tx,
+		SourceUnapplied,
+		fmt.Sprint(payment.ID),
+		payment.CheckoutRequestID,
+		fmt.Sprintf("Unapplied M-Pesa payment from %s: %s", payment.PhoneNumber, payment.ApplyError),
+		payment.AmountPaid,
+	)
+}
+
+// postSuspense records money received on the paybill that is not yet owed to any account
+func postSuspense(tx *gorm.DB, sourceType, sourceID, reference, narration string, amount float64) error {
+	return ledger.Post(tx, ledger.NewEntry(
+		sourceType,
+		sourceID,
+		reference,
+		narration,
+		ledger.Debit(ledger.AccountMpesa, amount),
+		ledger.Credit(ledger.AccountSuspense, amount),
+	))
+}
+
+// QueryJobResult summarizes a run of the STK query job
+type QueryJobResult struct {
+	Queried   int `json:"queried"`
+	Completed int `json:"completed"`
+	Unapplied int `json:"unapplied"`
+	Failed    int `json:"failed"`
+	Expired   int `json:"expired"`
+	Pending   int `json:"pending"`
+	Errors    int `json:"errors"`
+}
+
+// QueryPendingPayments queries Daraja for STK pushes whose callback has not arrived and settles them.
+// Payments still being processed or whose query fails are retried on the next run until they expire.
+func QueryPendingPayments(ctx context.Context, db *gorm.DB, logger grpclog.LoggerV2, client *Client, now time.Time) (*QueryJobResult, error) {
+	cutoff := now.Add(-queryDelay)
+
+	var pending []*Payment
+	err := db.WithContext(ctx).
+		Where("status = ? AND created_at <= ?", StatusPending, cutoff).
+		Where("last_queried_at IS NULL OR last_queried_at <= ?", cutoff).
+		Order("id ASC").
+		Find(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryJobResult{}
+
+	for _, payment := range pending {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		result.Queried++
+
+		status, err := queryPayment(ctx, db, client, payment, now)
+		if err != nil {
+			result.Errors++
+			logger.Errorf("Failed to query mpesa payment %d: %v", payment.ID, err)
+			continue
+		}
+
+		switch status {
+		case StatusCompleted:
+			result.Completed++
+		case StatusUnapplied:
+			result.Unapplied++
+		case StatusFailed:
+			result.Failed++
+		case StatusExpired:
+			result.Expired++
+		default:
+			result.Pending++
+		}
+	}
+
+	return result, nil
+}
+
+// queryPayment queries the status of a single payment and returns its new status. Every query is
+// recorded on the payment, and a payment that no query settled is expired after the query expiry
+// whatever the error.
+func queryPayment(ctx context.Context, db *gorm.DB, client *Client, payment *Payment, now time.Time) (string, error) {
+	var resultCode int
+	res, err := client.QuerySTK(ctx, payment.CheckoutRequestID)
+	if err == nil {
+		resultCode, err = strconv.Atoi(res.ResultCode)
+		if err != nil {
+			err = fmt.Errorf("unexpected result code %q", res.ResultCode)
+		}
+	}
+	if err != nil {
+		status := StatusPending
+		if payment.CreatedAt.Before(now.Add(-queryExpiry)) {
+			status = StatusExpired
+		}
+		updateErr := db.WithContext(ctx).Model(payment).
+			Where("status = ?", StatusPending).
+			Updates(map[string]interface{}{
+				"status":          status,
+				"query_attempts":  gorm.Expr("query_attempts + 1"),
+				"last_queried_at": now,
+			}).Error
+		switch {
+		case updateErr != nil:
+			return "", updateErr
+		case status == StatusExpired, IsProcessing(err):
+			return status, nil
+		default:
+			return "", err
+		}
+	}
+
+	var settled *Payment
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		settled, err = settlePayment(tx, payment.CheckoutRequestID, &stkResult{
+			ResultCode: resultCode,
+			ResultDesc: res.ResultDesc,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Model(settled).Updates(map[string]interface{}{
+			"query_attempts":  gorm.Expr("query_attempts + 1"),
+			"last_queried_at": now,
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return settled.Status, nil
+}
@@ -0,0 +1,61 @@
+package mpesa
+
+import (
+	"net/netip"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gidyon/pesapalm/internal/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+)
+
+type Options struct {
+	DB            *gorm.DB
+	Logger        grpclog.LoggerV2
+	TokenManager  auth.TokenInterface
+	GinEngine     *gin.Engine
+	Enforcer      *casbin.Enforcer
+	RedisDB       *redis.Client // Idempotency-Key responses are replayed from here when set
+	Client        *Client
+	CallbackToken string // Daraja callbacks must carry it in the token query parameter, all are rejected when it is empty
+	// Daraja callbacks must come from these networks, all are rejected when there are none
+	CallbackNetworks []netip.Prefix
+}
+
+// RegisterRoutes registers all application routes for M-Pesa payments
+func RegisterRoutes(opt *Options) {
+	mpesaController := MpesaController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
+	idempotent := idempotency.Middleware(opt.RedisDB, opt.TokenManager)
+
+	// Daraja cannot authenticate with a bearer token
+	callbacks := opt.GinEngine.Group("/api/v1/mpesa/callbacks")
+	{
+		callbacks.POST("/stk", mpesaController.STKCallback)
+		callbacks.POST("/c2b/validation", mpesaController.ValidateC2B)
+		callbacks.POST("/c2b/confirmation", mpesaController.ConfirmC2B)
+		callbacks.POST("/b2c/result", mpesaController.B2CResult)
+		callbacks.POST("/b2c/timeout", mpesaController.B2CTimeout)
+		callbacks.POST("/b2c/status/result", mpesaController.B2CStatusResult)
+		callbacks.POST("/b2c/status/timeout", mpesaController.B2CStatusTimeout)
+	}
+
+	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
+	{
+		v1.POST("/mpesa/stk-push", authorize("mpesa_payments", auth.ActionCreate), idempotent, mpesaController.InitiateSTKPush)
+		v1.GET("/mpesa/payments", authorize("mpesa_payments", auth.ActionRead), mpesaController.ListPayments)
+		v1.GET("/mpesa/payments/:id", authorize("mpesa_payments", auth.ActionRead), mpesaController.GetPayment)
+		v1.GET("/mpesa/c2b-payments", authorize("mpesa_payments", auth.ActionRead), mpesaController.ListC2BPayments)
+		v1.POST("/mpesa/c2b/register-urls", authorize("mpesa_settings", auth.ActionUpdate), mpesaController.RegisterC2BURLs)
+		v1.GET("/mpesa/payouts", authorize("mpesa_payouts", auth.ActionRead), mpesaController.ListPayouts)
+		v1.POST("/mpesa/payouts/:id/resolve", authorize("mpesa_payouts", auth.ActionUpdate), idempotent, mpesaController.ResolvePayout)
+		v1.POST("/mpesa/statements", authorize("mpesa_statements", auth.ActionCreate), mpesaController.ImportStatement)
+		v1.GET("/mpesa/statements", authorize("mpesa_statements", auth.ActionRead), mpesaController.ListStatements)
+		v1.GET("/mpesa/statements/:id", authorize("mpesa_statements", auth.ActionRead), mpesaController.GetStatement)
+		v1.GET("/mpesa/statements/:id/lines", authorize("mpesa_statements", auth.ActionRead), mpesaController.ListStatementLines)
+		v1.POST("/mpesa/statements/:id/post", authorize("mpesa_statements", "post"), idempotent, mpesaController.PostStatementLines)
+	}
+}
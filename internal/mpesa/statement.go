@@ -0,0 +1,922 @@
+package mpesa
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gidyon/pesapalm/internal/ledger"
+	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SourceStatementWithdrawal is the journal entry source of statement withdrawals posted to suspense
+const SourceStatementWithdrawal = "MPESA_STATEMENT_WITHDRAWAL"
+
+// RecordStatement is the record type of a statement line posted from an earlier statement
+const RecordStatement = "STATEMENT"
+
+const (
+	maxStatementSize = 10 << 20
+	receiptBatchSize = 500
+	// STK pushes without a result created this close to a line paid in may be the same payment
+	stkMatchWindow = 24 * time.Hour
+)
+
+// Statement times have no zone; like callback timestamps they are stored as East Africa wall time.
+// Payout completion times are real UTC, so they are shifted when compared with statement times.
+const eatOffset = 3 * time.Hour
+
+var statementTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02-01-2006 15:04:05",
+	"02-01-2006 15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+	"2006-01-02T15:04:05",
+}
+
+// statementColumns maps the normalized headers of a statement export to the fields they hold
+var statementColumns = map[string]string{
+	"receiptno":         "receipt",
+	"receiptnumber":     "receipt",
+	"transactionid":     "receipt",
+	"completiontime":    "time",
+	"details":           "details",
+	"transactionstatus": "status",
+	"paidin":            "paid_in",
+	"withdrawn":         "withdrawn",
+	"withdrawen":        "withdrawn", // Spelling used by older portal exports
+	"otherpartyinfo":    "other_party",
+	"acno":              "account",
+	"accountnumber":     "account",
+}
+
+func normalizeHeader(header string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, header)
+}
+
+// parseStatement reads the transactions of an M-Pesa organization statement exported as CSV. Rows
+// before the header, such as the account summary of portal exports, are ignored. Transactions that
+// did not complete are skipped.
+func parseStatement(r io.Reader) ([]*StatementLine, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	var (
+		columns map[string]int
+		lines   []*StatementLine
+		skipped int
+		row     int
+	)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("row %d: %v", row+1, err)
+		}
+		row++
+
+		if columns == nil {
+			columns = statementHeader(record)
+			continue
+		}
+
+		get := func(field string) string {
+			index, ok := columns[field]
+			if !ok || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+
+		receipt := get("receipt")
+		if receipt == "" {
+			continue
+		}
+
+		if status := get("status"); status != "" && !strings.EqualFold(status, "Completed") {
+			skipped++
+			continue
+		}
+
+		completionTime, err := parseStatementTime(get("time"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("row %d: invalid completion time %q", row, get("time"))
+		}
+
+		paidIn, err := parseStatementAmount(get("paid_in"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("row %d: invalid paid in amount %q", row, get("paid_in"))
+		}
+
+		withdrawn, err := parseStatementAmount(get("withdrawn"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("row %d: invalid withdrawn amount %q", row, get("withdrawn"))
+		}
+
+		if paidIn == 0 && withdrawn == 0 {
+			skipped++
+			continue
+		}
+
+		lines = append(lines, &StatementLine{
+			ReceiptNumber:  formatutil.Truncate(receipt, 50),
+			CompletionTime: completionTime,
+			Details:        formatutil.Truncate(get("details"), 256),
+			OtherPartyInfo: formatutil.Truncate(get("other_party"), 256),
+			AccountNumber:  formatutil.Truncate(get("account"), 50),
+			PaidIn:         paidIn,
+			Withdrawn:      withdrawn,
+		})
+	}
+
+	if columns == nil {
+		return nil, 0, errors.New("statement has no Receipt No. column")
+	}
+
+	return lines, skipped, nil
+}
+
+// statementHeader returns the column of each field if record is the header row of a statement
+func statementHeader(record []string) map[string]int {
+	columns := make(map[string]int, len(record))
+	for index, header := range record {
+		if field, ok := statementColumns[normalizeHeader(header)]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = index
+			}
+		}
+	}
+
+	for _, field := range []string{"receipt", "time", "paid_in", "withdrawn"} {
+		if _, ok := columns[field]; !ok {
+			return nil
+		}
+	}
+
+	return columns
+}
+
+func parseStatementTime(value string) (time.Time, error) {
+	for _, layout := range statementTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", value)
+}
+
+// parseStatementAmount parses an amount such as "1,000.00"; withdrawals may be exported as negative
+func parseStatementAmount(value string) (float64, error) {
+	value = strings.NewReplacer(",", "", " ", "").Replace(value)
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return moneyutil.Round(math.Abs(v)), nil
+}
+
+// systemRecord is money movement recorded in the system that a statement line can match
+type systemRecord struct {
+	Type    string
+	ID      uint
+	Receipt string
+	Amount  float64 // Positive for money received and negative for money paid out
+	Time    time.Time
+	Details string
+	Account string
+}
+
+func (r *systemRecord) key() string {
+	return r.Type + ":" + fmt.Sprint(r.ID)
+}
+
+// amount is the signed amount of the line, positive for money received
+func (l *StatementLine) amount() float64 {
+	return moneyutil.Round(l.PaidIn - l.Withdrawn)
+}
+
+// recordsByReceipt loads the system records of receipts
+func recordsByReceipt(tx *gorm.DB, statementID uint, receipts []string) (map[string][]*systemRecord, error) {
+	records := make(map[string][]*systemRecord, len(receipts))
+	add := func(record *systemRecord) {
+		records[record.Receipt] = append(records[record.Receipt], record)
+	}
+
+	for start := 0; start < len(receipts); start += receiptBatchSize {
+		batch := receipts[start:min(start+receiptBatchSize, len(receipts))]
+
+		var payments []*Payment
+		if err := tx.Where("mpesa_receipt_number IN ?", batch).Find(&payments).Error; err != nil {
+			return nil, err
+		}
+		for _, payment := range payments {
+			add(stkRecord(payment))
+		}
+
+		var c2bPayments []*C2BPayment
+		if err := tx.Where("trans_id IN ?", batch).Find(&c2bPayments).Error; err != nil {
+			return nil, err
+		}
+		for _, payment := range c2bPayments {
+			add(c2bRecord(payment))
+		}
+
+		var payouts []*Payout
+		err := tx.Where("transaction_id IN ? AND status IN ?", batch, []string{StatusCompleted, StatusUnapplied}).
+			Find(&payouts).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, payout := range payouts {
+			add(b2cRecord(payout))
+		}
+
+		// Withdrawals posted from earlier statements have no record of their own
+		var posted []*StatementLine
+		err = tx.Where("receipt_number IN ? AND statement_id <> ? AND posted_at IS NOT NULL AND withdrawn > 0", batch, statementID).
+			Find(&posted).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range posted {
+			add(&systemRecord{Type: RecordStatement, ID: line.ID, Receipt: line.ReceiptNumber, Amount: line.amount()})
+		}
+	}
+
+	return records, nil
+}
+
+// recordsInPeriod loads the system records that should appear on a statement covering start to end
+func recordsInPeriod(tx *gorm.DB, start, end time.Time) ([]*systemRecord, error) {
+	var records []*systemRecord
+
+	var payments []*Payment
+	err := tx.Where("status IN ? AND mpesa_receipt_number IS NOT NULL", []string{StatusCompleted, StatusUnapplied}).
+		Where("transaction_date BETWEEN ? AND ?", start, end).
+		Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range payments {
+		records = append(records, stkRecord(payment))
+	}
+
+	var c2bPayments []*C2BPayment
+	if err := tx.Where("trans_time BETWEEN ? AND ?", start, end).Find(&c2bPayments).Error; err != nil {
+		return nil, err
+	}
+	for _, payment := range c2bPayments {
+		records = append(records, c2bRecord(payment))
+	}
+
+	var payouts []*Payout
+	err = tx.Where("status IN ? AND transaction_id <> ''", []string{StatusCompleted, StatusUnapplied}).
+		Where("completed_at BETWEEN ? AND ?", start.Add(-eatOffset), end.Add(-eatOffset)).
+		Find(&payouts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, payout := range payouts {
+		records = append(records, b2cRecord(payout))
+	}
+
+	return records, nil
+}
+
+func stkRecord(payment *Payment) *systemRecord {
+	return &systemRecord{
+		Type:    RecordSTK,
+		ID:      payment.ID,
+		Receipt: payment.MpesaReceiptNumber.String,
+		Amount:  payment.AmountPaid,
+		Time:    payment.TransactionDate.Time,
+		Details: fmt.Sprintf("STK payment from %s", payment.PhoneNumber),
+		Account: payment.AccountReference,
+	}
+}
+
+func c2bRecord(payment *C2BPayment) *systemRecord {
+	return &systemRecord{
+		Type:    RecordC2B,
+		ID:      payment.ID,
+		Receipt: payment.TransID,
+		Amount:  payment.Amount,
+		Time:    payment.TransTime.Time,
+		Details: fmt.Sprintf("Paybill payment from %s", payment.MSISDN),
+		Account: payment.BillRefNumber,
+	}
+}
+
+func b2cRecord(payout *Payout) *systemRecord {
+	return &systemRecord{
+		Type:    RecordB2C,
+		ID:      payout.ID,
+		Receipt: payout.TransactionID,
+		Amount:  -payout.Amount,
+		Time:    payout.CompletedAt.Time.Add(eatOffset),
+		Details: fmt.Sprintf("Loan disbursement to %s", payout.PhoneNumber),
+		Account: fmt.Sprint(payout.LoanAccountID),
+	}
+}
+
+// reconcileStatement matches the lines of a statement to system records by receipt number and adds
+// a line for each system record in the statement period that the statement does not contain. When
+// several lines share a receipt, such as a payment and its charge, the record is matched to the
+// line with the same amount.
+func reconcileStatement(tx *gorm.DB, statement *Statement, lines []*StatementLine) ([]*StatementLine, error) {
+	receipts := make([]string, 0, len(lines))
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if !seen[line.ReceiptNumber] {
+			seen[line.ReceiptNumber] = true
+			receipts = append(receipts, line.ReceiptNumber)
+		}
+	}
+
+	records, err := recordsByReceipt(tx, statement.ID, receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	match := func(line *StatementLine, record *systemRecord, status string) {
+		used[record.key()] = true
+		line.MatchStatus = status
+		line.RecordType = record.Type
+		line.RecordID = record.ID
+		line.RecordAmount = record.Amount
+	}
+
+	// Exact amounts first so that a charge sharing a receipt does not take the payment's record
+	var unmatched []*StatementLine
+	for _, line := range lines {
+		line.StatementID = statement.ID
+		line.MatchStatus = MatchMissingInSystem
+
+		for _, record := range records[line.ReceiptNumber] {
+			if !used[record.key()] && record.Amount == line.amount() {
+				match(line, record, MatchMatched)
+				break
+			}
+		}
+		if line.MatchStatus == MatchMissingInSystem {
+			unmatched = append(unmatched, line)
+		}
+	}
+	for _, line := range unmatched {
+		for _, record := range records[line.ReceiptNumber] {
+			if !used[record.key()] {
+				match(line, record, MatchAmountMismatch)
+				break
+			}
+		}
+	}
+
+	statement.Rows = len(lines)
+
+	inPeriod, err := recordsInPeriod(tx, statement.PeriodStart, statement.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range inPeriod {
+		if used[record.key()] || seen[record.Receipt] {
+			continue
+		}
+		used[record.key()] = true
+
+		lines = append(lines, &StatementLine{
+			StatementID:    statement.ID,
+			ReceiptNumber:  record.Receipt,
+			CompletionTime: record.Time,
+			Details:        formatutil.Truncate(record.Details, 256),
+			AccountNumber:  formatutil.Truncate(record.Account, 50),
+			MatchStatus:    MatchMissingInStatement,
+			RecordType:     record.Type,
+			RecordID:       record.ID,
+			RecordAmount:   record.Amount,
+		})
+	}
+
+	statement.Matched, statement.AmountMismatches, statement.MissingInSystem, statement.MissingInStatement = 0, 0, 0, 0
+	for _, line := range lines {
+		switch line.MatchStatus {
+		case MatchMatched:
+			statement.Matched++
+		case MatchAmountMismatch:
+			statement.AmountMismatches++
+		case MatchMissingInSystem:
+			statement.MissingInSystem++
+		case MatchMissingInStatement:
+			statement.MissingInStatement++
+		}
+	}
+
+	return lines, nil
+}
+
+// postStatementLine records a statement transaction that is missing in the system. Money paid in
+// settles the STK push it belongs to, or is recorded as a paybill payment and applied to the account
+// in its account number, or held in suspense. Money withdrawn is posted against suspense until it is identified.
+func postStatementLine(tx *gorm.DB, line *StatementLine, postedBy uint64) error {
+	if line.PaidIn > 0 {
+		msisdn, name, _ := strings.Cut(line.OtherPartyInfo, " - ")
+
+		matched, err := postSTKLine(tx, line, strings.TrimSpace(msisdn), postedBy)
+		if err != nil || matched {
+			return err
+		}
+
+		payment := &C2BPayment{
+			TransID:         line.ReceiptNumber,
+			TransactionType: "Statement import",
+			TransTime:       sql.NullTime{Time: line.CompletionTime, Valid: true},
+			Amount:          line.PaidIn,
+			BillRefNumber:   line.AccountNumber,
+			MSISDN:          formatutil.Truncate(strings.TrimSpace(msisdn), 50),
+			PayerName:       formatutil.Truncate(strings.TrimSpace(name), 256),
+			Status:          StatusPending,
+		}
+
+		duplicate, err := recordC2BPayment(tx, payment)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			// Confirmed since the statement was imported
+			if err := tx.Where("trans_id = ?", line.ReceiptNumber).Take(payment).Error; err != nil {
+				return err
+			}
+			line.MatchStatus = MatchAmountMismatch
+			if payment.Amount == line.PaidIn {
+				line.MatchStatus = MatchMatched
+			}
+			line.RecordType = RecordC2B
+			line.RecordID = payment.ID
+			line.RecordAmount = payment.Amount
+			return tx.Save(line).Error
+		}
+
+		line.RecordType = RecordC2B
+		line.RecordID = payment.ID
+		line.RecordAmount = payment.Amount
+	} else {
+		err := ledger.Post(tx, ledger.NewEntry(
+			SourceStatementWithdrawal,
+			fmt.Sprint(line.ID),
+			line.ReceiptNumber,
+			formatutil.Truncate(fmt.Sprintf("Unrecorded M-Pesa withdrawal %s: %s", line.ReceiptNumber, line.Details), 255),
+			ledger.Debit(ledger.AccountSuspense, line.Withdrawn),
+			ledger.Credit(ledger.AccountMpesa, line.Withdrawn),
+		))
+		if err != nil {
+			return err
+		}
+	}
+
+	line.PostedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	line.PostedBy = postedBy
+
+	return tx.Save(line).Error
+}
+
+// postSTKLine matches a statement line paid in to an STK push, either settled with the same receipt
+// since the statement was imported or still without a result for the same phone and amount, so that
+// the payment is not credited a second time as a paybill payment. A push without a result is settled
+// from the line. It reports whether the line was matched.
+func postSTKLine(tx *gorm.DB, line *StatementLine, msisdn string, postedBy uint64) (bool, error) {
+	var payment Payment
+	err := tx.Where("mpesa_receipt_number = ?", line.ReceiptNumber).Take(&payment).Error
+	switch {
+	case err == nil:
+		// Settled since the statement was imported
+		line.MatchStatus = MatchAmountMismatch
+		if payment.AmountPaid == line.PaidIn {
+			line.MatchStatus = MatchMatched
+		}
+		line.RecordType = RecordSTK
+		line.RecordID = payment.ID
+		line.RecordAmount = payment.AmountPaid
+		return true, tx.Save(line).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	default:
+		return false, err
+	}
+
+	var candidates []*Payment
+	err = tx.Where("status IN ? AND mpesa_receipt_number IS NULL AND amount = ?", []string{StatusPending, StatusExpired}, line.PaidIn).
+		Where("created_at BETWEEN ? AND ?", line.CompletionTime.Add(-stkMatchWindow), line.CompletionTime.Add(stkMatchWindow)).
+		Order("id ASC").
+		Find(&candidates).Error
+	if err != nil {
+		return false, err
+	}
+
+	var matches []uint
+	for _, candidate := range candidates {
+		if phoneMatches(msisdn, candidate.PhoneNumber) {
+			payment = *candidate
+			matches = append(matches, candidate.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return false, nil
+	case 1:
+	default:
+		return false, fmt.Errorf("line may be any of the STK payments %v, which have no result", matches)
+	}
+
+	settled, err := settlePayment(tx, payment.CheckoutRequestID, &stkResult{
+		ResultCode:      0,
+		ResultDesc:      "Settled from statement line " + fmt.Sprint(line.ID),
+		Receipt:         line.ReceiptNumber,
+		Amount:          line.PaidIn,
+		TransactionDate: line.CompletionTime,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	line.RecordType = RecordSTK
+	line.RecordID = settled.ID
+	line.RecordAmount = settled.AmountPaid
+	line.PostedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	line.PostedBy = postedBy
+
+	return true, tx.Save(line).Error
+}
+
+// phoneMatches reports whether the phone of a statement line, which newer statements mask as in
+// 2547****5678, can be the phone number of a payment. Lines without a phone match any payment.
+func phoneMatches(masked, phone string) bool {
+	if masked == "" {
+		return true
+	}
+	if len(masked) < 9 || len(phone) < 9 {
+		return false
+	}
+
+	// The last nine digits are the same in local and international formats
+	masked, phone = masked[len(masked)-9:], phone[len(phone)-9:]
+	for i := range masked {
+		if masked[i] != '*' && masked[i] != phone[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshStatementCounts recomputes the summary of a statement from its lines
+func refreshStatementCounts(tx *gorm.DB, statement *Statement) error {
+	type count struct {
+		MatchStatus string
+		Total       int
+		Posted      int
+	}
+
+	var counts []*count
+	err := tx.Model(&StatementLine{}).
+		Select("match_status, COUNT(*) AS total, COUNT(posted_at) AS posted").
+		Where("statement_id = ?", statement.ID).
+		Group("match_status").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	statement.Matched, statement.AmountMismatches, statement.MissingInSystem, statement.MissingInStatement, statement.Posted = 0, 0, 0, 0, 0
+	for _, c := range counts {
+		statement.Posted += c.Posted
+		switch c.MatchStatus {
+		case MatchMatched:
+			statement.Matched += c.Total
+		case MatchAmountMismatch:
+			statement.AmountMismatches += c.Total
+		case MatchMissingInSystem:
+			statement.MissingInSystem += c.Total
+		case MatchMissingInStatement:
+			statement.MissingInStatement += c.Total
+		}
+	}
+
+	return tx.Save(statement).Error
+}
+
+// parseStatementDate parses an optional period bound given as a date or RFC3339 time
+func parseStatementDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// ImportStatement reconciles an M-Pesa statement CSV uploaded in the file form field against the
+// payments recorded in the system. The statement period defaults to the times of its transactions
+// and can be set with the period_start and period_end form fields.
+func (ctrl *MpesaController) ImportStatement(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Statement file is required"})
+		return
+	}
+	if fileHeader.Size > maxStatementSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Statement file is too large"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read statement file"})
+		return
+	}
+	defer file.Close()
+
+	lines, skipped, err := parseStatement(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement: " + err.Error()})
+		return
+	}
+	if len(lines) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Statement has no completed transactions"})
+		return
+	}
+
+	statement := &Statement{
+		FileName:    formatutil.Truncate(fileHeader.Filename, 256),
+		PeriodStart: lines[0].CompletionTime,
+		PeriodEnd:   lines[0].CompletionTime,
+		Skipped:     skipped,
+	}
+	for _, line := range lines {
+		if line.CompletionTime.Before(statement.PeriodStart) {
+			statement.PeriodStart = line.CompletionTime
+		}
+		if line.CompletionTime.After(statement.PeriodEnd) {
+			statement.PeriodEnd = line.CompletionTime
+		}
+	}
+
+	if value := c.PostForm("period_start"); value != "" {
+		if statement.PeriodStart, err = parseStatementDate(value, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period_start"})
+			return
+		}
+	}
+	if value := c.PostForm("period_end"); value != "" {
+		if statement.PeriodEnd, err = parseStatementDate(value, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period_end"})
+			return
+		}
+	}
+	if statement.PeriodEnd.Before(statement.PeriodStart) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Statement period ends before it starts"})
+		return
+	}
+
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		statement.ImportedBy = metadata.UserId
+	}
+
+	err = ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(statement).Error; err != nil {
+			return err
+		}
+
+		reconciled, err := reconcileStatement(tx, statement, lines)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.CreateInBatches(reconciled, receiptBatchSize).Error; err != nil {
+			return err
+		}
+
+		return tx.Save(statement).Error
+	})
+	if err != nil {
+		ctrl.Logger.Errorf("Failed to import statement %s: %v", fileHeader.Filename, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import statement"})
+		return
+	}
+
+	ctrl.Logger.Infof(
+		"Imported statement %d with %d matched, %d mismatched, %d missing in system and %d missing in statement",
+		statement.ID, statement.Matched, statement.AmountMismatches, statement.MissingInSystem, statement.MissingInStatement,
+	)
+
+	c.JSON(http.StatusCreated, ToStatementResponse(statement))
+}
+
+// GetStatement retrieves the reconciliation summary of an imported statement
+func (ctrl *MpesaController) GetStatement(c *gin.Context) {
+	var statement Statement
+	err := ctrl.DB.WithContext(c.Request.Context()).First(&statement, "id = ?", c.Param("id")).Error
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, ToStatementResponse(&statement))
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statement"})
+	}
+}
+
+// ListStatements lists imported statements, newest first
+func (ctrl *MpesaController) ListStatements(c *gin.Context) {
+	pageSize, lastID, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).Order("id DESC").Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+
+	statements := make([]*Statement, 0, pageSize+1)
+	if err := db.Find(&statements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statements"})
+		return
+	}
+
+	response := make([]*StatementResponse, 0, len(statements))
+	for index, statement := range statements {
+		if index == pageSize {
+			break
+		}
+		response = append(response, ToStatementResponse(statement))
+	}
+
+	var nextPageToken string
+	if len(statements) > pageSize {
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(statements[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"statements":      response,
+	})
+}
+
+// ListStatementLines lists the reconciled lines of a statement, optionally filtered by match status
+func (ctrl *MpesaController) ListStatementLines(c *gin.Context) {
+	pageSize, lastID, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).
+		Where("statement_id = ?", c.Param("id")).
+		Order("id ASC").
+		Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id > ?", lastID)
+	}
+	if status := c.Query("match_status"); status != "" {
+		db = db.Where("match_status = ?", status)
+	}
+	switch c.Query("posted") {
+	case "true":
+		db = db.Where("posted_at IS NOT NULL")
+	case "false":
+		db = db.Where("posted_at IS NULL")
+	}
+
+	lines := make([]*StatementLine, 0, pageSize+1)
+	if err := db.Find(&lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statement lines"})
+		return
+	}
+
+	response := make([]*StatementLineResponse, 0, len(lines))
+	for index, line := range lines {
+		if index == pageSize {
+			break
+		}
+		response = append(response, ToStatementLineResponse(line))
+	}
+
+	var nextPageToken string
+	if len(lines) > pageSize {
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(lines[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"lines":           response,
+	})
+}
+
+// PostStatementLines records the statement transactions that are missing in the system. All unposted
+// lines are posted unless line_ids is given. Each line is posted on its own so that one failure does
+// not hold back the rest.
+func (ctrl *MpesaController) PostStatementLines(c *gin.Context) {
+	var dto PostStatementLinesDTO
+	if err := c.ShouldBindJSON(&dto); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context())
+
+	var statement Statement
+	err := db.First(&statement, "id = ?", c.Param("id")).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statement"})
+		return
+	}
+
+	var postedBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		postedBy = metadata.UserId
+	}
+
+	query := db.Model(&StatementLine{}).
+		Where("statement_id = ? AND match_status = ? AND posted_at IS NULL", statement.ID, MatchMissingInSystem)
+	if len(dto.LineIDs) > 0 {
+		query = query.Where("id IN ?", dto.LineIDs)
+	}
+
+	var lineIDs []uint
+	if err := query.Order("id ASC").Pluck("id", &lineIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve statement lines"})
+		return
+	}
+
+	var (
+		posted = make([]*StatementLineResponse, 0, len(lineIDs))
+		failed = make([]gin.H, 0)
+	)
+	for _, lineID := range lineIDs {
+		var line StatementLine
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("posted_at IS NULL AND match_status = ?", MatchMissingInSystem).
+				Take(&line, lineID).Error
+			if err != nil {
+				return err
+			}
+			return postStatementLine(tx, &line, postedBy)
+		})
+		switch {
+		case err == nil:
+			posted = append(posted, ToStatementLineResponse(&line))
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// Posted concurrently
+		default:
+			ctrl.Logger.Errorf("Failed to post statement line %d: %v", lineID, err)
+			failed = append(failed, gin.H{"line_id": lineID, "error": err.Error()})
+		}
+	}
+
+	if err := refreshStatementCounts(db, &statement); err != nil {
+		ctrl.Logger.Errorf("Failed to update statement %d: %v", statement.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statement": ToStatementResponse(&statement),
+		"posted":    posted,
+		"failed":    failed,
+	})
+}
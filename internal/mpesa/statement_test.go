@@ -0,0 +1,145 @@
+package mpesa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatement(t *testing.T) {
+	type line struct {
+		receipt   string
+		time      time.Time
+		account   string
+		paidIn    float64
+		withdrawn float64
+	}
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 3, 10, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		csv         string
+		want        []line
+		wantSkipped int
+		wantErr     bool
+	}{
+		{
+			name: "portal export with account summary",
+			csv: `Account Holder,Pesapalm Ltd
+Short Code,600000
+Receipt No.,Completion Time,Details,Transaction Status,Paid In,Withdrawn,Balance,Other Party Info,A/C No.
+SCA1,2026-03-10 09:15:00,Pay Bill from 254712345678,Completed,"1,500.00",,"10,000.00",254712345678 - JOHN DOE,LN-1
+SCA2,10-03-2026 10:30,Business Payment to 254712345678,Completed,,-2500.00,7500.00,254712345678 - JOHN DOE,
+SCA3,10/03/2026 11:00:00,Pay Bill from 254700000000,Completed,200,,7700.00,254700000000 - JANE DOE,SV-9
+`,
+			want: []line{
+				{"SCA1", at(9, 15), "LN-1", 1500, 0},
+				{"SCA2", at(10, 30), "", 0, 2500},
+				{"SCA3", at(11, 0), "SV-9", 200, 0},
+			},
+		},
+		{
+			name: "incomplete and zero amount transactions are skipped",
+			csv: `Receipt No,Completion Time,Transaction Status,Paid In,Withdrawen
+SCA1,2026-03-10T09:15:00,Completed,100,
+SCA2,2026-03-10 09:16:00,Failed,100,
+SCA3,2026-03-10 09:17:00,Completed,0.00,0.00
+,2026-03-10 09:18:00,Completed,100,
+`,
+			want:        []line{{"SCA1", at(9, 15), "", 100, 0}},
+			wantSkipped: 2,
+		},
+		{
+			name: "transaction id header and short rows",
+			csv: `Transaction ID,Completion Time,Paid In,Withdrawn,Account Number
+SCA1,2026-03-10 09:15,50,,LN-7
+SCA2,2026-03-10 09:20,75
+`,
+			want: []line{
+				{"SCA1", at(9, 15), "LN-7", 50, 0},
+				{"SCA2", at(9, 20), "", 75, 0},
+			},
+		},
+		{
+			name:    "missing header",
+			csv:     "SCA1,2026-03-10 09:15:00,100\n",
+			wantErr: true,
+		},
+		{
+			name: "header without amount columns",
+			csv: `Receipt No.,Completion Time,Details
+SCA1,2026-03-10 09:15:00,Pay Bill
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid completion time",
+			csv: `Receipt No.,Completion Time,Paid In,Withdrawn
+SCA1,March 10,100,
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid amount",
+			csv: `Receipt No.,Completion Time,Paid In,Withdrawn
+SCA1,2026-03-10 09:15:00,KES 100,
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, skipped, err := parseStatement(strings.NewReader(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStatement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("parseStatement() skipped %d rows, want %d", skipped, tt.wantSkipped)
+			}
+			if len(lines) != len(tt.want) {
+				t.Fatalf("parseStatement() returned %d lines, want %d", len(lines), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				got := line{lines[i].ReceiptNumber, lines[i].CompletionTime, lines[i].AccountNumber, lines[i].PaidIn, lines[i].Withdrawn}
+				if !got.time.Equal(want.time) {
+					t.Errorf("line %d completion time = %s, want %s", i, got.time, want.time)
+				}
+				got.time = want.time
+				if got != want {
+					t.Errorf("line %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPhoneMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		masked string
+		phone  string
+		want   bool
+	}{
+		{"same number", "254712345678", "254712345678", true},
+		{"local format", "0712345678", "254712345678", true},
+		{"masked number", "2547****5678", "254712345678", true},
+		{"masked local format", "07****5678", "254712345678", true},
+		{"different number", "254712345679", "254712345678", false},
+		{"masked different number", "2547****5679", "254712345678", false},
+		{"no phone on the line", "", "254712345678", true},
+		{"too short", "5678", "254712345678", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := phoneMatches(tt.masked, tt.phone); got != tt.want {
+				t.Errorf("phoneMatches(%q, %q) = %v, want %v", tt.masked, tt.phone, got, tt.want)
+			}
+		})
+	}
+}
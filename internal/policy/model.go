@@ -1,8 +1,73 @@
 package policy
 
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
 type PolicyRequest struct {
 	Policy  string `json:"policy,omitempty"`
 	Subject string `json:"subject,omitempty"`
 	Object  string `json:"object,omitempty"`
 	Action  string `json:"action,omitempty"`
 }
+
+// UpdatePolicyRequest replaces a policy with another
+type UpdatePolicyRequest struct {
+	Old PolicyRequest `json:"old"`
+	New PolicyRequest `json:"new"`
+}
+
+// GroupingRequest is a g rule making subject, a user ID or a role, a member of role
+type GroupingRequest struct {
+	Subject string `json:"subject,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+// UpdateGroupingRequest replaces a g rule with another
+type UpdateGroupingRequest struct {
+	Old GroupingRequest `json:"old"`
+	New GroupingRequest `json:"new"`
+}
+
+// UserRolesRequest sets the roles of a user
+type UserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// Audited policy changes
+const (
+	AuditAddPolicies     = "ADD_POLICIES"
+	AuditRemovePolicies  = "REMOVE_POLICIES"
+	AuditUpdatePolicy    = "UPDATE_POLICY"
+	AuditAddGroupings    = "ADD_GROUPINGS"
+	AuditRemoveGroupings = "REMOVE_GROUPINGS"
+	AuditUpdateGrouping  = "UPDATE_GROUPING"
+	AuditSetUserRoles    = "SET_USER_ROLES"
+)
+
+// Audit records a change to the casbin policies
+type Audit struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ActorID    uint64    `gorm:"index;not null" json:"actor_id"`
+	ChangeType string    `gorm:"size:30;index;not null" json:"change_type"`
+	Before     string    `gorm:"type:text" json:"before,omitempty"` // JSON encoded rules
+	After      string    `gorm:"type:text" json:"after,omitempty"`  // JSON encoded rules
+	ClientIP   string    `gorm:"size:50" json:"client_ip"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+func (*Audit) TableName() string {
+	return "policy_audit"
+}
+
+// Migrate creates the policy audit table if it is missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+	if !migrator.HasTable(&Audit{}) {
+		return migrator.AutoMigrate(&Audit{})
+	}
+	return nil
+}
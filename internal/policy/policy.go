@@ -2,16 +2,41 @@ package policy
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+)
+
+// Object is the casbin object guarding the policy API
+const Object = "policies"
+
+// DefaultAdminRole is the role granted the policy API when no admin role is configured
+const DefaultAdminRole = "admin"
+
+const (
+	defaultPageSize = 200
+	maxPageSize     = 1000
 )
 
 type Options struct {
-	Enforcer *casbin.Enforcer
+	Enforcer     *casbin.Enforcer
+	DB           *gorm.DB
+	Logger       grpclog.LoggerV2
+	TokenManager auth.TokenInterface
+	GinEngine    *gin.Engine
+	AdminRole    string   // Role allowed to manage policies, defaults to DefaultAdminRole
+	AdminUsers   []string // IDs of users given the admin role on startup
 }
 
 type APIServer struct {
@@ -35,19 +60,201 @@ func NewPolicyAPI(ctx context.Context, opt *Options) (_ *APIServer, err error) {
 		err = errors.New("missing options")
 	case opt.Enforcer == nil:
 		err = errors.New("missing enforcer")
+	case opt.DB == nil:
+		err = errors.New("missing db")
+	case opt.Logger == nil:
+		err = errors.New("missing logger")
+	case opt.TokenManager == nil:
+		err = errors.New("missing token manager")
+	case opt.GinEngine == nil:
+		err = errors.New("missing gin engine")
 	}
 	if err != nil {
 		return nil, err
 	}
 
+	if opt.AdminRole == "" {
+		opt.AdminRole = DefaultAdminRole
+	}
+
 	// Account API
 	api := &APIServer{
 		Options: opt,
 	}
 
+	if err := api.seedAdmin(); err != nil {
+		return nil, err
+	}
+
+	// Register routes
+	api.registerRoutes()
+
 	return api, nil
 }
 
+// seedAdmin grants the admin role the policy API so that policies can always be managed
+func (api *APIServer) seedAdmin() error {
+	for _, action := range []string{auth.ActionRead, auth.ActionCreate, auth.ActionUpdate, auth.ActionDelete} {
+		if _, err := api.Enforcer.AddPolicy(api.AdminRole, Object, action); err != nil {
+			return err
+		}
+	}
+
+	for _, userID := range api.AdminUsers {
+		userID = strings.TrimSpace(userID)
+		if userID == "" {
+			continue
+		}
+		added, err := api.Enforcer.AddGroupingPolicy(userID, api.AdminRole)
+		if err != nil {
+			return err
+		}
+		if added {
+			api.Logger.Infof("Assigned user %s the %s role", userID, api.AdminRole)
+		}
+	}
+
+	return nil
+}
+
+// isAdminPolicy reports whether rule grants the admin role access to the policy API. Such rules
+// cannot be removed through the API since that would lock everyone out of it.
+func (api *APIServer) isAdminPolicy(rule []string) bool {
+	return len(rule) == 3 && rule[0] == api.AdminRole && rule[1] == Object
+}
+
+// errLastAdmin is returned by role changes that would leave the admin role with no members
+var errLastAdmin = errors.New("the admin role must keep at least one member")
+
+// checkAdminMembers returns errLastAdmin if removing the g rules in removed and then adding those in
+// added would leave the admin role with no members, locking everyone out of the policy API
+func (api *APIServer) checkAdminMembers(removed, added [][]string) error {
+	current, err := api.Enforcer.GetFilteredGroupingPolicy(1, api.AdminRole)
+	if err != nil {
+		return err
+	}
+	if adminMembers(api.AdminRole, current, removed, added) == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// adminMembers counts the members of role left by removing the g rules in removed from current and
+// then adding those in added
+func adminMembers(role string, current, removed, added [][]string) int {
+	members := make(map[string]bool, len(current))
+	for _, rule := range current {
+		if len(rule) >= 2 && rule[1] == role {
+			members[rule[0]] = true
+		}
+	}
+	for _, rule := range removed {
+		if len(rule) >= 2 && rule[1] == role {
+			delete(members, rule[0])
+		}
+	}
+	for _, rule := range added {
+		if len(rule) >= 2 && rule[1] == role {
+			members[rule[0]] = true
+		}
+	}
+	return len(members)
+}
+
+func policyRule(policy *PolicyRequest) ([]string, error) {
+	switch {
+	case policy.Policy == "":
+		return nil, errors.New("missing policy")
+	case policy.Policy != "p":
+		return nil, fmt.Errorf("unsupported policy %q, only p rules can be managed", policy.Policy)
+	case policy.Action == "":
+		return nil, errors.New("missing action")
+	case policy.Subject == "":
+		return nil, errors.New("missing subject")
+	case policy.Object == "":
+		return nil, errors.New("missing object")
+	}
+	return []string{policy.Subject, policy.Object, policy.Action}, nil
+}
+
+func groupingRule(grouping *GroupingRequest) ([]string, error) {
+	switch {
+	case grouping.Subject == "":
+		return nil, errors.New("missing subject")
+	case grouping.Role == "":
+		return nil, errors.New("missing role")
+	case grouping.Subject == grouping.Role:
+		return nil, errors.New("subject cannot be a member of itself")
+	}
+	return []string{grouping.Subject, grouping.Role}, nil
+}
+
+func toPolicies(rules [][]string) []*PolicyRequest {
+	policies := make([]*PolicyRequest, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule) < 3 {
+			continue
+		}
+		policies = append(policies, &PolicyRequest{Policy: "p", Subject: rule[0], Object: rule[1], Action: rule[2]})
+	}
+	return policies
+}
+
+func toGroupings(rules [][]string) []*GroupingRequest {
+	groupings := make([]*GroupingRequest, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule) < 2 {
+			continue
+		}
+		groupings = append(groupings, &GroupingRequest{Subject: rule[0], Role: rule[1]})
+	}
+	return groupings
+}
+
+// writeAdminError writes the error of checkAdminMembers
+func (api *APIServer) writeAdminError(c *gin.Context, err error) {
+	if errors.Is(err, errLastAdmin) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to check admin role members", "details": err.Error()})
+}
+
+// audit records a policy change. Failures are logged since the change has already been applied.
+func (api *APIServer) audit(c *gin.Context, change string, before, after interface{}) {
+	entry := &Audit{
+		ChangeType: change,
+		ClientIP:   c.ClientIP(),
+	}
+
+	if metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		entry.ActorID = metadata.UserId
+	}
+	if before != nil {
+		bs, _ := json.Marshal(before)
+		entry.Before = string(bs)
+	}
+	if after != nil {
+		bs, _ := json.Marshal(after)
+		entry.After = string(bs)
+	}
+
+	if err := api.DB.WithContext(c.Request.Context()).Create(entry).Error; err != nil {
+		api.Logger.Errorf("Failed to audit %s by user %d: %v", change, entry.ActorID, err)
+	}
+}
+
+// ListPolicies lists p rules, optionally filtered by subject, object and action
+func (api *APIServer) ListPolicies(c *gin.Context) {
+	rules, err := api.Enforcer.GetFilteredPolicy(0, c.Query("subject"), c.Query("object"), c.Query("action"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list policies", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": toPolicies(rules)})
+}
+
 func (api *APIServer) AddPolicies(c *gin.Context) {
 	var policies []PolicyRequest
 
@@ -59,29 +266,479 @@ func (api *APIServer) AddPolicies(c *gin.Context) {
 	rules := make([][]string, 0, len(policies))
 
 	for _, policy := range policies {
-		switch {
-		case policy.Policy == "":
-			c.JSON(http.StatusBadRequest, gin.H{"message": "missing policy"})
+		rule, err := policyRule(&policy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
-		case policy.Action == "":
-			c.JSON(http.StatusBadRequest, gin.H{"message": "missing action"})
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing policies"})
+		return
+	}
+
+	ok, err := api.Enforcer.AddPolicies(rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to add policy", "details": err.Error()})
+		return
+	}
+
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"message": "policy already exists", "success": ok})
+		return
+	}
+
+	api.audit(c, AuditAddPolicies, nil, toPolicies(rules))
+
+	c.JSON(http.StatusCreated, gin.H{"message": "policy added", "success": ok})
+}
+
+// RemovePolicies removes p rules
+func (api *APIServer) RemovePolicies(c *gin.Context) {
+	var policies []PolicyRequest
+
+	if err := c.ShouldBindJSON(&policies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid json"})
+		return
+	}
+
+	rules := make([][]string, 0, len(policies))
+
+	for _, policy := range policies {
+		rule, err := policyRule(&policy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
-		case policy.Subject == "":
-			c.JSON(http.StatusBadRequest, gin.H{"message": "missing subject"})
+		}
+		if api.isAdminPolicy(rule) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "admin policies cannot be removed"})
 			return
-		case policy.Object == "":
-			c.JSON(http.StatusBadRequest, gin.H{"message": "missing object"})
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing policies"})
+		return
+	}
+
+	ok, err := api.Enforcer.RemovePolicies(rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to remove policy", "details": err.Error()})
+		return
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "policy not found", "success": ok})
+		return
+	}
+
+	api.audit(c, AuditRemovePolicies, toPolicies(rules), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "policy removed", "success": ok})
+}
+
+// UpdatePolicy replaces a p rule with another
+func (api *APIServer) UpdatePolicy(c *gin.Context) {
+	var req UpdatePolicyRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid json"})
+		return
+	}
+
+	oldRule, err := policyRule(&req.Old)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "old " + err.Error()})
+		return
+	}
+	newRule, err := policyRule(&req.New)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "new " + err.Error()})
+		return
+	}
+	if api.isAdminPolicy(oldRule) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "admin policies cannot be changed"})
+		return
+	}
+
+	ok, err := api.Enforcer.UpdatePolicy(oldRule, newRule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to update policy", "details": err.Error()})
+		return
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "policy not found", "success": ok})
+		return
+	}
+
+	api.audit(c, AuditUpdatePolicy, toPolicies([][]string{oldRule}), toPolicies([][]string{newRule}))
+
+	c.JSON(http.StatusOK, gin.H{"message": "policy updated", "success": ok})
+}
+
+// ListGroupings lists g rules, optionally filtered by subject and role
+func (api *APIServer) ListGroupings(c *gin.Context) {
+	rules, err := api.Enforcer.GetFilteredGroupingPolicy(0, c.Query("subject"), c.Query("role"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list role assignments", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groupings": toGroupings(rules)})
+}
+
+// AddGroupings adds g rules, assigning users or roles to roles
+func (api *APIServer) AddGroupings(c *gin.Context) {
+	var groupings []GroupingRequest
+
+	if err := c.ShouldBindJSON(&groupings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid json"})
+		return
+	}
+
+	rules := make([][]string, 0, len(groupings))
+
+	for _, grouping := range groupings {
+		rule, err := groupingRule(&grouping)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
 		}
 
-		rules = append(rules, []string{policy.Subject, policy.Object, policy.Action})
+		rules = append(rules, rule)
 	}
 
-	ok, err := api.Enforcer.AddPolicies(rules)
+	if len(rules) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing role assignments"})
+		return
+	}
+
+	ok, err := api.Enforcer.AddGroupingPolicies(rules)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to add policy", "details": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to add role assignment", "details": err.Error()})
+		return
+	}
+
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"message": "role assignment already exists", "success": ok})
+		return
+	}
+
+	api.audit(c, AuditAddGroupings, nil, toGroupings(rules))
+
+	c.JSON(http.StatusCreated, gin.H{"message": "role assignment added", "success": ok})
+}
+
+// RemoveGroupings removes g rules
+func (api *APIServer) RemoveGroupings(c *gin.Context) {
+	var groupings []GroupingRequest
+
+	if err := c.ShouldBindJSON(&groupings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid json"})
+		return
+	}
+
+	rules := make([][]string, 0, len(groupings))
+
+	for _, grouping := range groupings {
+		rule, err := groupingRule(&grouping)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing role assignments"})
+		return
+	}
+
+	if err := api.checkAdminMembers(rules, nil); err != nil {
+		api.writeAdminError(c, err)
+		return
+	}
+
+	ok, err := api.Enforcer.RemoveGroupingPolicies(rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to remove role assignment", "details": err.Error()})
+		return
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "role assignment not found", "success": ok})
 		return
 	}
 
-	c.JSON(http.StatusBadRequest, gin.H{"message": "policy added", "success": ok})
+	api.audit(c, AuditRemoveGroupings, toGroupings(rules), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "role assignment removed", "success": ok})
+}
+
+// UpdateGrouping replaces a g rule with another
+func (api *APIServer) UpdateGrouping(c *gin.Context) {
+	var req UpdateGroupingRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid json"})
+		return
+	}
+
+	oldRule, err := groupingRule(&req.Old)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "old " + err.Error()})
+		return
+	}
+	newRule, err := groupingRule(&req.New)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "new " + err.Error()})
+		return
+	}
+
+	if err := api.checkAdminMembers([][]string{oldRule}, [][]string{newRule}); err != nil {
+		api.writeAdminError(c, err)
+		return
+	}
+
+	ok, err := api.Enforcer.UpdateGroupingPolicy(oldRule, newRule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to update role assignment", "details": err.Error()})
+		return
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "role assignment not found", "success": ok})
+		return
+	}
+
+	api.audit(c, AuditUpdateGrouping, toGroupings([][]string{oldRule}), toGroupings([][]string{newRule}))
+
+	c.JSON(http.StatusOK, gin.H{"message": "role assignment updated", "success": ok})
+}
+
+// GetUserRoles retrieves the roles assigned to a user, including roles inherited through other roles
+func (api *APIServer) GetUserRoles(c *gin.Context) {
+	userID := c.Param("userId")
+
+	roles, err := api.Enforcer.GetRolesForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get user roles", "details": err.Error()})
+		return
+	}
+
+	implicitRoles, err := api.Enforcer.GetImplicitRolesForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get user roles", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":        userID,
+		"roles":          roles,
+		"implicit_roles": implicitRoles,
+	})
+}
+
+// SetUserRoles replaces the roles assigned to a user
+func (api *APIServer) SetUserRoles(c *gin.Context) {
+	userID := c.Param("userId")
+	if _, err := strconv.ParseUint(userID, 10, 64); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+
+	var req UserRolesRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid json"})
+		return
+	}
+
+	roles := make([]string, 0, len(req.Roles))
+	seen := make(map[string]bool, len(req.Roles))
+	for _, role := range req.Roles {
+		role = strings.TrimSpace(role)
+		if role == "" || seen[role] {
+			continue
+		}
+		seen[role] = true
+		roles = append(roles, role)
+	}
+
+	before, err := api.Enforcer.GetRolesForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get user roles", "details": err.Error()})
+		return
+	}
+
+	// Missing roles are added before extra roles are removed so that a failure never leaves the
+	// user with fewer roles than before
+	var added, removed [][]string
+	for _, role := range roles {
+		if !slices.Contains(before, role) {
+			added = append(added, []string{userID, role})
+		}
+	}
+	for _, role := range before {
+		if !seen[role] {
+			removed = append(removed, []string{userID, role})
+		}
+	}
+
+	if err := api.checkAdminMembers(removed, added); err != nil {
+		api.writeAdminError(c, err)
+		return
+	}
+
+	if len(added) > 0 {
+		if _, err := api.Enforcer.AddGroupingPolicies(added); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set user roles", "details": err.Error()})
+			return
+		}
+	}
+
+	if len(removed) > 0 {
+		if _, err := api.Enforcer.RemoveGroupingPolicies(removed); err != nil {
+			if len(added) > 0 {
+				if _, rollbackErr := api.Enforcer.RemoveGroupingPolicies(added); rollbackErr != nil {
+					api.Logger.Errorf("failed to restore roles of user %s: %v", userID, rollbackErr)
+				}
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set user roles", "details": err.Error()})
+			return
+		}
+	}
+
+	api.audit(c, AuditSetUserRoles, gin.H{"user_id": userID, "roles": before}, gin.H{"user_id": userID, "roles": roles})
+
+	c.JSON(http.StatusOK, gin.H{"message": "user roles updated", "user_id": userID, "roles": roles})
+}
+
+// CheckPermission reports whether a subject, usually a user ID, can take an action on an object
+func (api *APIServer) CheckPermission(c *gin.Context) {
+	var (
+		subject = c.Query("subject")
+		object  = c.Query("object")
+		action  = c.Query("action")
+	)
+
+	switch {
+	case subject == "":
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing subject"})
+		return
+	case object == "":
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing object"})
+		return
+	case action == "":
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing action"})
+		return
+	}
+
+	allowed, explain, err := api.Enforcer.EnforceEx(subject, object, action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to check permission", "details": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"subject": subject,
+		"object":  object,
+		"action":  action,
+		"allowed": allowed,
+	}
+	if len(explain) > 0 {
+		response["matched_policy"] = toPolicies([][]string{explain})[0]
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListPermissions lists the object and action of every authorized route and whether any policy grants it
+func (api *APIServer) ListPermissions(c *gin.Context) {
+	missing, err := auth.MissingPolicies(api.Enforcer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list permissions", "details": err.Error()})
+		return
+	}
+
+	ungranted := make(map[auth.Permission]bool, len(missing))
+	for _, permission := range missing {
+		ungranted[permission] = true
+	}
+
+	permissions := auth.Permissions()
+	response := make([]gin.H, 0, len(permissions))
+	for _, permission := range permissions {
+		response = append(response, gin.H{
+			"object":  permission.Object,
+			"action":  permission.Action,
+			"granted": !ungranted[permission],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": response})
+}
+
+// ListAudit lists policy changes, newest first
+func (api *APIServer) ListAudit(c *gin.Context) {
+	queryParams := c.Request.URL.Query()
+
+	pageSize, _ := strconv.Atoi(queryParams.Get("pageSize"))
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	var lastID int
+
+	pageToken := queryParams.Get("pageToken")
+	if pageToken != "" {
+		bs, err := base64.StdEncoding.DecodeString(pageToken)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return
+		}
+		lastID, err = strconv.Atoi(string(bs))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return
+		}
+	}
+
+	db := api.DB.WithContext(c.Request.Context()).Order("id DESC").Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+	if actorID := queryParams.Get("actor_id"); actorID != "" {
+		db = db.Where("actor_id = ?", actorID)
+	}
+	if change := queryParams.Get("change_type"); change != "" {
+		db = db.Where("change_type = ?", change)
+	}
+
+	entries := make([]*Audit, 0, pageSize+1)
+	if err := db.Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list policy audit"})
+		return
+	}
+
+	var nextPageToken string
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(entries[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"audit":           entries,
+	})
 }
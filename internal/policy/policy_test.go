@@ -0,0 +1,54 @@
+package policy
+
+import "testing"
+
+func TestAdminMembers(t *testing.T) {
+	current := [][]string{{"1", "admin"}, {"2", "admin"}, {"3", "teller"}}
+
+	tests := []struct {
+		name    string
+		removed [][]string
+		added   [][]string
+		want    int
+	}{
+		{"no change", nil, nil, 2},
+		{"remove one admin", [][]string{{"1", "admin"}}, nil, 1},
+		{"remove every admin", [][]string{{"1", "admin"}, {"2", "admin"}}, nil, 0},
+		{"remove other roles", [][]string{{"3", "teller"}}, nil, 2},
+		{"replace the last admins", [][]string{{"1", "admin"}, {"2", "admin"}}, [][]string{{"4", "admin"}}, 1},
+		{"move an admin to another role", [][]string{{"1", "admin"}, {"2", "admin"}}, [][]string{{"2", "teller"}}, 0},
+		{"remove an unknown assignment", [][]string{{"5", "admin"}}, nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := adminMembers("admin", current, tt.removed, tt.added); got != tt.want {
+				t.Errorf("adminMembers() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PolicyRequest
+		wantErr bool
+	}{
+		{"p rule", PolicyRequest{Policy: "p", Subject: "admin", Object: "loans", Action: "read"}, false},
+		{"g rule", PolicyRequest{Policy: "g", Subject: "admin", Object: "loans", Action: "read"}, true},
+		{"unknown policy type", PolicyRequest{Policy: "p2", Subject: "admin", Object: "loans", Action: "read"}, true},
+		{"missing policy", PolicyRequest{Subject: "admin", Object: "loans", Action: "read"}, true},
+		{"missing subject", PolicyRequest{Policy: "p", Object: "loans", Action: "read"}, true},
+		{"missing object", PolicyRequest{Policy: "p", Subject: "admin", Action: "read"}, true},
+		{"missing action", PolicyRequest{Policy: "p", Subject: "admin", Object: "loans"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := policyRule(&tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("policyRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
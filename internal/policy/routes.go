@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"github.com/gidyon/pesapalm/internal/auth"
+)
+
+func (api *APIServer) registerRoutes() {
+	authorize := auth.Authorizer(api.Enforcer, api.TokenManager)
+
+	// Integrations could grant themselves more permissions, so API keys cannot manage policies
+	v1 := api.GinEngine.Group("/api/v1/policies", auth.TokenAuthMiddleware(api.TokenManager), auth.RequireUser())
+	{
+		v1.GET("", authorize(Object, auth.ActionRead), api.ListPolicies)
+		v1.POST("", authorize(Object, auth.ActionCreate), api.AddPolicies)
+		v1.PUT("", authorize(Object, auth.ActionUpdate), api.UpdatePolicy)
+		v1.DELETE("", authorize(Object, auth.ActionDelete), api.RemovePolicies)
+
+		v1.GET("/groupings", authorize(Object, auth.ActionRead), api.ListGroupings)
+		v1.POST("/groupings", authorize(Object, auth.ActionCreate), api.AddGroupings)
+		v1.PUT("/groupings", authorize(Object, auth.ActionUpdate), api.UpdateGrouping)
+		v1.DELETE("/groupings", authorize(Object, auth.ActionDelete), api.RemoveGroupings)
+
+		v1.GET("/users/:userId/roles", authorize(Object, auth.ActionRead), api.GetUserRoles)
+		v1.PUT("/users/:userId/roles", authorize(Object, auth.ActionUpdate), api.SetUserRoles)
+
+		v1.GET("/check", authorize(Object, auth.ActionRead), api.CheckPermission)
+		v1.GET("/permissions", authorize(Object, auth.ActionRead), api.ListPermissions)
+		v1.GET("/audit", authorize(Object, auth.ActionRead), api.ListAudit)
+	}
+}
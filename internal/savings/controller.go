@@ -3,15 +3,19 @@ package savings
 import (
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc/grpclog"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Options struct {
@@ -19,6 +23,8 @@ type Options struct {
 	Logger       grpclog.LoggerV2
 	TokenManager auth.TokenInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
+	RedisDB      *redis.Client
 }
 
 // Controller structure
@@ -26,9 +32,17 @@ type SavingsAccountController struct {
 	*Options
 }
 
+// Errors returned when a status action does not apply to a savings account
+var (
+	errAccountApproved  = errors.New("savings account is already approved")
+	errAccountActivated = errors.New("savings account is already activated")
+	errAccountClosed    = errors.New("savings account is closed")
+)
+
 const selectFields = "savings_account.*, savings_product.id AS saving_product_id, savings_product.name as saving_product_name, savings_product.product_code as saving_product_code, customer.id AS customer_id, customer.first_name as customer_first_name, customer.last_name as customer_last_name, customer.middle_name as customer_middle_name"
 
-// CreateSavingsAccount creates a new savings account
+// CreateSavingsAccount creates a new savings account. Accounts start in the default status and are
+// activated with UpdateSavingsAccountStatus.
 func (ctrl *SavingsAccountController) CreateSavingsAccount(c *gin.Context) {
 	var dto CreateSavingsAccountDTO
 	if err := c.ShouldBindJSON(&dto); err != nil {
@@ -37,19 +51,45 @@ func (ctrl *SavingsAccountController) CreateSavingsAccount(c *gin.Context) {
 		return
 	}
 
+	if dto.Balance < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Opening balance cannot be negative"})
+		return
+	}
+
 	account := SavingsAccount{
-		SavingsID:    dto.SavingsID,
-		CustomerID:   dto.CustomerID,
-		ProductID:    dto.ProductID,
-		CurrencyID:   dto.CurrencyID,
-		CurrencyCode: dto.CurrencyCode,
-		Balance:      dto.Balance,
-		StatusID:     dto.StatusID,
+		SavingsID:                 dto.SavingsID,
+		CustomerID:                dto.CustomerID,
+		ProductID:                 dto.ProductID,
+		CurrencyID:                dto.CurrencyID,
+		CurrencyCode:              dto.CurrencyCode,
+		StatusID:                  StatusDefault,
+		MaturityTransferAccountID: dto.MaturityTransferAccountID,
 	}
 
-	if result := ctrl.DB.WithContext(c.Request.Context()).Create(&account); result.Error != nil {
-		ctrl.Logger.Errorf("Failed to create savings account: %v", result.Error)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	var createdBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		createdBy = metadata.UserId
+	}
+
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		// The opening balance is recorded as the first transaction of the account
+		if dto.Balance > 0 {
+			_, err := applyTransaction(tx, &account, &TransactionRequest{
+				SavingsAccountID: account.ID,
+				TransactionType:  TransactionOpeningBalance,
+				Narration:        "Opening balance",
+				CreatedBy:        createdBy,
+			}, dto.Balance)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		ctrl.Logger.Errorf("Failed to create savings account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -91,18 +131,33 @@ func (ctrl *SavingsAccountController) UpdateSavingsAccount(c *gin.Context) {
 		return
 	}
 
+	// Only the changed columns are written so that the balance, which is derived from the
+	// transactions, is never overwritten
 	var account SavingsAccount
-	if result := ctrl.DB.WithContext(c.Request.Context()).First(&account, id); result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Savings account not found"})
-		return
-	}
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error
+		switch {
+		case err == nil:
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrAccountNotFound
+		default:
+			return err
+		}
 
-	// Update fields
-	account.Balance = dto.Balance
-	account.StatusID = dto.StatusID
+		if dto.MaturityTransferAccountID == nil {
+			return nil
+		}
+		account.MaturityTransferAccountID = *dto.MaturityTransferAccountID
 
-	if result := ctrl.DB.Save(&account); result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return tx.Model(&account).Update("maturity_transfer_account_id", account.MaturityTransferAccountID).Error
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Savings account not found"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -278,59 +333,83 @@ func (ctrl *SavingsAccountController) UpdateSavingsAccountStatus(c *gin.Context)
 		return
 	}
 
-	// Fetch the savings account from the database
-	var account SavingsAccount
-	if err := ctrl.DB.Select("id").First(&account, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Savings account not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving savings account"})
-		}
+	switch dto.Action {
+	case "approve", "activate", "close":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "allowed actions are approve, activate or close"})
 		return
 	}
 
-	var err error
-
-	// Perform action based on the value of "action"
-	switch dto.Action {
-	case "approve":
-		// Approve the account by setting DateApproved and StatusID
-		if account.DateApproved.Valid {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Savings account is already approved"})
-			return
-		}
-		now := time.Now()
-		account.DateApproved = sql.NullTime{Time: now, Valid: true}
-		account.StatusID = 2 // Approved status
-		err = ctrl.DB.Updates(&account).Error
-
-	case "activate":
-		// Activate the account by setting DateActivated and StatusID
-		if account.DateActivated.Valid {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Savings account is already activated"})
-			return
+	// The account is locked so that transactions posted meanwhile are not overwritten and a fixed
+	// deposit locks its current balance. Only the status columns are written.
+	var account SavingsAccount
+	err := ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error
+		switch {
+		case err == nil:
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrAccountNotFound
+		default:
+			return err
 		}
+
 		now := time.Now()
-		account.DateActivated = sql.NullTime{Time: now, Valid: true}
-		account.StatusID = 3 // Activated status
-		err = ctrl.DB.Updates(&account).Error
-
-	case "close":
-		// Close the account by setting DateClosed and StatusID
-		if account.DateClosed.Valid {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Savings account is already closed"})
-			return
+		updates := make(map[string]interface{}, 5)
+
+		// Perform action based on the value of "action"
+		switch dto.Action {
+		case "approve":
+			// Approve the account by setting DateApproved and StatusID
+			switch {
+			case account.DateClosed.Valid:
+				return errAccountClosed
+			case account.DateApproved.Valid:
+				return errAccountApproved
+			}
+			account.DateApproved = sql.NullTime{Time: now, Valid: true}
+			account.StatusID = StatusApproved
+			updates["date_approved"] = account.DateApproved
+
+		case "activate":
+			// Activate the account by setting DateActivated and StatusID
+			switch {
+			case account.DateClosed.Valid:
+				return errAccountClosed
+			case account.DateActivated.Valid:
+				return errAccountActivated
+			}
+			account.DateActivated = sql.NullTime{Time: now, Valid: true}
+			account.StatusID = StatusActivated
+
+			// Fixed deposits are locked for the product term from activation
+			if err := lockFixedDeposit(tx, &account, now); err != nil {
+				return err
+			}
+			updates["date_activated"] = account.DateActivated
+			updates["locked_balance"] = account.LockedBalance
+			updates["date_locked"] = account.DateLocked
+			updates["maturity_date"] = account.MaturityDate
+
+		case "close":
+			// Close the account by setting DateClosed and StatusID
+			if account.DateClosed.Valid {
+				return errAccountClosed
+			}
+			account.DateClosed = sql.NullTime{Time: now, Valid: true}
+			account.StatusID = StatusClosed
+			updates["date_closed"] = account.DateClosed
 		}
-		now := time.Now()
-		account.DateClosed = sql.NullTime{Time: now, Valid: true}
-		account.StatusID = 4 // Closed status
-		err = ctrl.DB.Updates(&account).Error
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "allowed actions are approve, activate or close"})
-		return
-	}
 
+		updates["status_id"] = account.StatusID
+
+		return tx.Model(&account).Updates(updates).Error
+	})
 	if err != nil {
+		if status, ok := transactionStatus(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		ctrl.Logger.Errorf("Failed to %s savings account %s: %v", dto.Action, id, err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
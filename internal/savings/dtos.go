@@ -7,19 +7,20 @@ import (
 
 // CreateSavingsAccountDTO defines the JSON structure for creating a new savings account
 type CreateSavingsAccountDTO struct {
-	SavingsID    string  `json:"savings_id"`
-	CustomerID   int     `json:"customer_id"`
-	ProductID    int     `json:"product_id"`
-	CurrencyID   int     `json:"currency_id"`
-	CurrencyCode string  `json:"currency_code"`
-	Balance      float64 `json:"balance"`
-	StatusID     int     `json:"status_id"`
+	SavingsID                 string  `json:"savings_id"`
+	CustomerID                int     `json:"customer_id"`
+	ProductID                 int     `json:"product_id"`
+	CurrencyID                int     `json:"currency_id"`
+	CurrencyCode              string  `json:"currency_code"`
+	Balance                   float64 `json:"balance"`
+	MaturityTransferAccountID int     `json:"maturity_transfer_account_id"`
 }
 
-// UpdateSavingsAccountDTO defines the JSON structure for updating a savings account
+// UpdateSavingsAccountDTO defines the JSON structure for updating a savings account.
+// Balances can only be changed through deposits and withdrawals, and the status through the
+// approve, activate and close actions.
 type UpdateSavingsAccountDTO struct {
-	Balance  float64 `json:"balance"`
-	StatusID int     `json:"status_id"`
+	MaturityTransferAccountID *int `json:"maturity_transfer_account_id"`
 }
 
 // SavingsAccountResponse defines the structure of the savings account data returned in the response
@@ -36,11 +37,14 @@ type SavingsAccountResponse struct {
 	DateApproved                *string       `json:"date_approved"`
 	DateActivated               *string       `json:"date_activated"`
 	LastInterestCalculationDate *string       `json:"last_interest_calculation_date"`
+	LastInterestPostingDate     *string       `json:"last_interest_posting_date"`
+	AccruedInterest             float64       `json:"accrued_interest"`
 	MaturityDate                *string       `json:"maturity_date"`
 	MaximumWithdrawableAmount   *float64      `json:"maximum_withdrawable_amount"`
 	FeesDue                     float64       `json:"fees_due"`
 	LockedBalance               float64       `json:"locked_balance"`
 	DateLocked                  *string       `json:"date_locked"`
+	MaturityTransferAccountID   int           `json:"maturity_transfer_account_id"`
 	Customer                    Customer      `json:"customer,omitempty"`
 	SavingProduct               SavingProduct `json:"saving_product,omitempty"`
 	CreatedAt                   string        `json:"created_at"`
@@ -62,11 +66,14 @@ func ToSavingsAccountResponse(account *SavingsAccountRead) *SavingsAccountRespon
 		DateApproved:                formatNullableTime(account.DateApproved),
 		DateActivated:               formatNullableTime(account.DateActivated),
 		LastInterestCalculationDate: formatNullableTime(account.LastInterestCalculationDate),
+		LastInterestPostingDate:     formatNullableTime(account.LastInterestPostingDate),
+		AccruedInterest:             account.AccruedInterest,
 		MaturityDate:                formatNullableTime(account.MaturityDate),
 		MaximumWithdrawableAmount:   account.MaximumWithdrawableAmount,
 		FeesDue:                     account.FeesDue,
 		LockedBalance:               account.LockedBalance,
 		DateLocked:                  formatNullableTime(account.DateLocked),
+		MaturityTransferAccountID:   account.MaturityTransferAccountID,
 		CreatedAt:                   account.CreatedAt.UTC().Format(time.RFC3339),
 		UpdatedAt:                   account.UpdatedAt.UTC().Format(time.RFC3339),
 		Customer:                    account.Customer,
@@ -86,3 +93,71 @@ func formatNullableTime(t sql.NullTime) *string {
 type UpdateSavingsAccountStatusDTO struct {
 	Action string `json:"action" binding:"required,oneof=approve activate close"`
 }
+
+// SavingsTransactionDTO defines the JSON structure for a deposit or withdrawal
+type SavingsTransactionDTO struct {
+	Amount    float64 `json:"amount" binding:"required,gt=0"`
+	Channel   string  `json:"channel"`
+	Reference string  `json:"reference"`
+	Narration string  `json:"narration"`
+}
+
+// SavingsTransactionResponse defines the structure of a savings transaction returned in the response
+type SavingsTransactionResponse struct {
+	ID               uint    `json:"id"`
+	SavingsAccountID uint    `json:"savings_account_id"`
+	TransactionType  string  `json:"transaction_type"`
+	Amount           float64 `json:"amount"`
+	RunningBalance   float64 `json:"running_balance"`
+	Channel          string  `json:"channel"`
+	Reference        string  `json:"reference"`
+	Narration        string  `json:"narration"`
+	CreatedBy        uint64  `json:"created_by"`
+	CreatedAt        string  `json:"created_at"`
+}
+
+// ToSavingsTransactionResponse converts a SavingsTransaction to a SavingsTransactionResponse
+func ToSavingsTransactionResponse(transaction *SavingsTransaction) *SavingsTransactionResponse {
+	return &SavingsTransactionResponse{
+		ID:               transaction.ID,
+		SavingsAccountID: transaction.SavingsAccountID,
+		TransactionType:  transaction.TransactionType,
+		Amount:           transaction.Amount,
+		RunningBalance:   transaction.RunningBalance,
+		Channel:          transaction.Channel,
+		Reference:        transaction.Reference,
+		Narration:        transaction.Narration,
+		CreatedBy:        transaction.CreatedBy,
+		CreatedAt:        transaction.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// SavingsInterestAccrualResponse defines the structure of a savings interest accrual returned in the response
+type SavingsInterestAccrualResponse struct {
+	ID                  uint    `json:"id"`
+	SavingsAccountID    uint    `json:"savings_account_id"`
+	BusinessDate        string  `json:"business_date"`
+	ClosingBalance      float64 `json:"closing_balance"`
+	MinimumBalance      float64 `json:"minimum_balance"`
+	InterestRate        float64 `json:"interest_rate"`
+	Amount              float64 `json:"amount"`
+	Posted              bool    `json:"posted"`
+	PostedTransactionID uint    `json:"posted_transaction_id,omitempty"`
+	CreatedAt           string  `json:"created_at"`
+}
+
+// ToSavingsInterestAccrualResponse converts a SavingsInterestAccrual to a SavingsInterestAccrualResponse
+func ToSavingsInterestAccrualResponse(accrual *SavingsInterestAccrual) *SavingsInterestAccrualResponse {
+	return &SavingsInterestAccrualResponse{
+		ID:                  accrual.ID,
+		SavingsAccountID:    accrual.SavingsAccountID,
+		BusinessDate:        accrual.BusinessDate.Format(time.DateOnly),
+		ClosingBalance:      accrual.ClosingBalance,
+		MinimumBalance:      accrual.MinimumBalance,
+		InterestRate:        accrual.InterestRate,
+		Amount:              accrual.Amount,
+		Posted:              accrual.Posted,
+		PostedTransactionID: accrual.PostedTransactionID,
+		CreatedAt:           accrual.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
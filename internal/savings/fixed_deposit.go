@@ -0,0 +1,310 @@
+package savings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/savings_product"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaturityJobResult summarizes a run of the fixed deposit maturity job
+type MaturityJobResult struct {
+	BusinessDate string  `json:"business_date"`
+	Matured      int     `json:"matured"`
+	RolledOver   int     `json:"rolled_over"`
+	Transferred  int     `json:"transferred"`
+	Failed       int     `json:"failed"`
+	Principal    float64 `json:"principal"`
+	Interest     float64 `json:"interest"`
+}
+
+// lockFixedDeposit locks the balance of a fixed deposit account for the term of its product starting
+// at start. Accounts of other products are left unchanged.
+func lockFixedDeposit(db *gorm.DB, account *SavingsAccount, start time.Time) error {
+	var product savings_product.SavingsProduct
+	if err := db.First(&product, account.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("savings product not found")
+		}
+		return err
+	}
+
+	if !product.IsFixedDeposit {
+		return nil
+	}
+	if account.Balance <= 0 {
+		return errors.New("fixed deposit has no balance to lock")
+	}
+
+	account.LockedBalance = account.Balance
+	account.DateLocked = sql.NullTime{Time: start, Valid: true}
+	account.MaturityDate = sql.NullTime{Time: periodutil.Add(businessDay(start), product.TermLength, product.TermUnit), Valid: true}
+
+	return nil
+}
+
+// BreakFixedDeposit releases a fixed deposit before maturity. The early withdrawal penalty of the
+// product is charged on the locked balance and interest accrued for the term is forfeited.
+func (ctrl *SavingsAccountController) BreakFixedDeposit(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid savings account id"})
+		return
+	}
+
+	var createdBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		createdBy = metadata.UserId
+	}
+
+	var (
+		account SavingsAccount
+		penalty *SavingsTransaction
+	)
+
+	err = ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAccountNotFound
+			}
+			return err
+		}
+
+		var product savings_product.SavingsProduct
+		if err := tx.First(&product, account.ProductID).Error; err != nil {
+			return err
+		}
+
+		switch {
+		case !product.IsFixedDeposit:
+			return errNotFixedDeposit
+		case account.StatusID != StatusActivated:
+			return ErrAccountNotActive
+		case account.LockedBalance <= 0 || !account.MaturityDate.Valid:
+			return errDepositNotLocked
+		case !time.Now().UTC().Before(account.MaturityDate.Time):
+			return errDepositMatured
+		}
+
+		// Interest accrued during the term is forfeited
+		err = tx.Model(&SavingsInterestAccrual{}).
+			Where("savings_account_id = ? AND posted = ?", account.ID, false).
+			Update("posted", true).Error
+		if err != nil {
+			return err
+		}
+
+		amount := moneyutil.Percent(account.LockedBalance, product.EarlyWithdrawalPenaltyRate)
+		if amount > 0 {
+			penalty, err = applyTransaction(tx, &account, &TransactionRequest{
+				SavingsAccountID: account.ID,
+				TransactionType:  TransactionEarlyWithdrawal,
+				Narration:        fmt.Sprintf("Early withdrawal penalty at %.2f%% on %.2f", product.EarlyWithdrawalPenaltyRate, account.LockedBalance),
+				CreatedBy:        createdBy,
+			}, -amount)
+			if err != nil {
+				return err
+			}
+		}
+
+		account.LockedBalance = 0
+		account.AccruedInterest = 0
+		account.DateLocked = sql.NullTime{}
+		account.MaturityDate = sql.NullTime{}
+
+		return tx.Model(&account).Updates(map[string]interface{}{
+			"locked_balance":   account.LockedBalance,
+			"accrued_interest": account.AccruedInterest,
+			"date_locked":      account.DateLocked,
+			"maturity_date":    account.MaturityDate,
+		}).Error
+	})
+	if err != nil {
+		if status, ok := transactionStatus(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		ctrl.Logger.Errorf("Failed to break fixed deposit %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to break fixed deposit"})
+		return
+	}
+
+	response := gin.H{"balance": account.Balance}
+	if penalty != nil {
+		response["penalty"] = ToSavingsTransactionResponse(penalty)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// MatureFixedDeposits settles fixed deposits whose maturity date has been reached by the business
+// date. Interest accrued for the term is posted, the locked balance is released and the deposit is
+// then rolled over or transferred according to the rollover policy of the product.
+func MatureFixedDeposits(ctx context.Context, db *gorm.DB, logger grpclog.LoggerV2, businessDate time.Time) (*MaturityJobResult, error) {
+	businessDate = businessDay(businessDate)
+
+	var ids []uint
+	err := db.WithContext(ctx).Model(&SavingsAccount{}).
+		Where("status_id = ? AND locked_balance > 0 AND maturity_date IS NOT NULL AND maturity_date <= ?", StatusActivated, businessDate).
+		Order("id ASC").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MaturityJobResult{BusinessDate: businessDate.Format(time.DateOnly)}
+	products := map[int]*savings_product.SavingsProduct{}
+
+	for _, id := range ids {
+		var (
+			policy    string
+			principal float64
+			interest  float64
+		)
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			policy, principal, interest, err = matureFixedDeposit(tx, id, businessDate, products)
+			return err
+		})
+		if err != nil {
+			logger.Errorf("Failed to mature fixed deposit %d: %v", id, err)
+			result.Failed++
+			continue
+		}
+		if policy == "" {
+			continue
+		}
+
+		result.Matured++
+		result.Principal = moneyutil.Round(result.Principal + principal)
+		result.Interest = moneyutil.Round(result.Interest + interest)
+		switch policy {
+		case savings_product.RolloverPrincipal, savings_product.RolloverAll:
+			result.RolledOver++
+		case savings_product.RolloverTransfer:
+			result.Transferred++
+		}
+	}
+
+	return result, nil
+}
+
+// matureFixedDeposit settles a single matured fixed deposit. It returns the applied rollover policy,
+// or an empty policy when the deposit was not due.
+func matureFixedDeposit(tx *gorm.DB, id uint, businessDate time.Time, products map[int]*savings_product.SavingsProduct) (string, float64, float64, error) {
+	var account SavingsAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error; err != nil {
+		return "", 0, 0, err
+	}
+
+	if account.StatusID != StatusActivated || account.LockedBalance <= 0 || !account.MaturityDate.Valid {
+		return "", 0, 0, nil
+	}
+
+	maturity := businessDay(account.MaturityDate.Time)
+	if businessDate.Before(maturity) {
+		return "", 0, 0, nil
+	}
+
+	product, ok := products[account.ProductID]
+	if !ok {
+		product = &savings_product.SavingsProduct{}
+		if err := tx.First(product, account.ProductID).Error; err != nil {
+			return "", 0, 0, err
+		}
+		products[account.ProductID] = product
+	}
+
+	// Bring interest accruals up to the maturity date and pay out the interest of the term
+	if _, err := accrueAccountInterest(tx, account.ID, maturity, products); err != nil {
+		return "", 0, 0, err
+	}
+	if err := tx.First(&account, id).Error; err != nil {
+		return "", 0, 0, err
+	}
+
+	periodStart := businessDay(account.DateLocked.Time)
+	if account.LastInterestPostingDate.Valid && account.LastInterestPostingDate.Time.After(periodStart) {
+		periodStart = businessDay(account.LastInterestPostingDate.Time)
+	}
+
+	var gross, withholdingTax float64
+	if periodStart.Before(maturity) {
+		var err error
+		gross, withholdingTax, err = postPeriodInterest(tx, &account, product, periodStart, maturity)
+		if err != nil {
+			return "", 0, 0, err
+		}
+	}
+
+	var (
+		principal   = account.LockedBalance
+		netInterest = moneyutil.Round(gross - withholdingTax)
+		policy      = product.RolloverPolicy
+		updates     = map[string]interface{}{
+			"last_interest_posting_date": sql.NullTime{Time: maturity, Valid: true},
+		}
+	)
+
+	switch policy {
+	case savings_product.RolloverPrincipal, savings_product.RolloverAll:
+		locked := principal
+		if policy == savings_product.RolloverAll {
+			locked = moneyutil.Round(principal + netInterest)
+		}
+		updates["locked_balance"] = locked
+		updates["date_locked"] = sql.NullTime{Time: maturity, Valid: true}
+		updates["maturity_date"] = sql.NullTime{Time: periodutil.Add(maturity, product.TermLength, product.TermUnit), Valid: true}
+	case savings_product.RolloverTransfer:
+		if account.MaturityTransferAccountID == 0 {
+			return "", 0, 0, errors.New("fixed deposit has no maturity transfer account")
+		}
+		amount := moneyutil.Round(principal + netInterest)
+		reference := fmt.Sprintf("MAT-%d-%s", account.ID, maturity.Format("20060102"))
+
+		_, err := applyTransaction(tx, &account, &TransactionRequest{
+			SavingsAccountID: account.ID,
+			TransactionType:  TransactionMaturityTransfer,
+			Reference:        reference,
+			Narration:        fmt.Sprintf("Fixed deposit maturity transfer to savings account %d", account.MaturityTransferAccountID),
+		}, -amount)
+		if err != nil {
+			return "", 0, 0, err
+		}
+
+		_, _, err = Credit(tx, &TransactionRequest{
+			SavingsAccountID: uint(account.MaturityTransferAccountID),
+			CustomerID:       account.CustomerID,
+			TransactionType:  TransactionMaturityTransfer,
+			Amount:           amount,
+			Reference:        reference,
+			Narration:        fmt.Sprintf("Fixed deposit maturity transfer from savings account %d", account.ID),
+		})
+		if err != nil {
+			return "", 0, 0, err
+		}
+		updates["locked_balance"] = 0
+	default:
+		policy = savings_product.RolloverNone
+		updates["locked_balance"] = 0
+	}
+
+	if err := tx.Model(&account).Updates(updates).Error; err != nil {
+		return "", 0, 0, err
+	}
+
+	return policy, principal, netInterest, nil
+}
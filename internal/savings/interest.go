@@ -0,0 +1,358 @@
+package savings
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gidyon/pesapalm/internal/savings_product"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// daysInYear is the day count used to convert the annual interest rate of a product to a daily rate
+const daysInYear = 365
+
+// InterestJobResult summarizes a run of the savings interest job
+type InterestJobResult struct {
+	BusinessDate   string  `json:"business_date"`
+	Processed      int     `json:"processed"`
+	Skipped        int     `json:"skipped"`
+	Failed         int     `json:"failed"`
+	Accrued        float64 `json:"accrued"`
+	Posted         float64 `json:"posted"`
+	WithholdingTax float64 `json:"withholding_tax"`
+}
+
+// interestRun is the outcome of accruing and posting interest for one savings account
+type interestRun struct {
+	accrued        float64
+	posted         float64
+	withholdingTax float64
+}
+
+func businessDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// AccrueInterest accrues daily interest on activated savings accounts for every day before the
+// business date and posts the accrued interest at the end of each product interest period.
+//
+// Interest is earned on the closing balance of each day, or on the lowest balance of the period for
+// products using the minimum balance method. Withholding tax on posted interest is deducted as a
+// separate transaction. Accruals are recorded per account and day so the job can be re-run safely.
+func AccrueInterest(ctx context.Context, db *gorm.DB, logger grpclog.LoggerV2, businessDate time.Time) (*InterestJobResult, error) {
+	businessDate = businessDay(businessDate)
+
+	var ids []uint
+	err := db.WithContext(ctx).Model(&SavingsAccount{}).
+		Where("status_id = ?", StatusActivated).
+		Order("id ASC").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InterestJobResult{BusinessDate: businessDate.Format(time.DateOnly)}
+	products := map[int]*savings_product.SavingsProduct{}
+
+	for _, id := range ids {
+		var run *interestRun
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			run, err = accrueAccountInterest(tx, id, businessDate, products)
+			return err
+		})
+		switch {
+		case err != nil:
+			logger.Errorf("Failed to accrue interest for savings account %d: %v", id, err)
+			result.Failed++
+		case run == nil:
+			result.Skipped++
+		default:
+			result.Processed++
+			result.Accrued = moneyutil.Round(result.Accrued + run.accrued)
+			result.Posted = moneyutil.Round(result.Posted + run.posted)
+			result.WithholdingTax = moneyutil.Round(result.WithholdingTax + run.withholdingTax)
+		}
+	}
+
+	return result, nil
+}
+
+// accrueAccountInterest accrues and posts interest for a single savings account. It returns nil when
+// the account earns no interest or has nothing to accrue for the business date.
+func accrueAccountInterest(tx *gorm.DB, id uint, businessDate time.Time, products map[int]*savings_product.SavingsProduct) (*interestRun, error) {
+	var account SavingsAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error; err != nil {
+		return nil, err
+	}
+
+	if account.StatusID != StatusActivated {
+		return nil, nil
+	}
+
+	product, ok := products[account.ProductID]
+	if !ok {
+		product = &savings_product.SavingsProduct{}
+		if err := tx.First(product, account.ProductID).Error; err != nil {
+			return nil, err
+		}
+		products[account.ProductID] = product
+	}
+
+	if product.AnnualInterestRate <= 0 {
+		return nil, nil
+	}
+
+	startDate := account.CreatedAt
+	if account.DateActivated.Valid {
+		startDate = account.DateActivated.Time
+	}
+	startDate = businessDay(startDate)
+
+	// LastInterestCalculationDate is the first day that has not been accrued yet
+	from := startDate
+	if account.LastInterestCalculationDate.Valid {
+		from = businessDay(account.LastInterestCalculationDate.Time)
+	}
+
+	var (
+		run     = &interestRun{}
+		changed bool
+	)
+
+	if from.Before(businessDate) {
+		accruals, err := dailyAccruals(tx, &account, product, from, businessDate)
+		if err != nil {
+			return nil, err
+		}
+		if len(accruals) > 0 {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(accruals, 100).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, accrual := range accruals {
+			run.accrued += accrual.Amount
+		}
+		run.accrued = moneyutil.Round(run.accrued)
+		account.LastInterestCalculationDate = sql.NullTime{Time: businessDate, Valid: true}
+		changed = true
+	}
+
+	// Post interest for every period that has ended. Interest on a locked fixed deposit is only
+	// paid out at maturity.
+	period := product.InterestPostingPeriod
+	if period <= 0 {
+		period = 1
+	}
+	if product.IsFixedDeposit && account.LockedBalance > 0 {
+		period = 0
+	}
+
+	periodStart := startDate
+	if account.LastInterestPostingDate.Valid {
+		periodStart = businessDay(account.LastInterestPostingDate.Time)
+	}
+
+	for periodEnd := periodutil.Add(periodStart, period, product.InterestPostingUnit); period > 0 && !businessDate.Before(periodEnd); periodEnd = periodutil.Add(periodStart, period, product.InterestPostingUnit) {
+		posted, withholdingTax, err := postPeriodInterest(tx, &account, product, periodStart, periodEnd)
+		if err != nil {
+			return nil, err
+		}
+		run.posted = moneyutil.Round(run.posted + posted)
+		run.withholdingTax = moneyutil.Round(run.withholdingTax + withholdingTax)
+
+		periodStart = periodEnd
+		account.LastInterestPostingDate = sql.NullTime{Time: periodEnd, Valid: true}
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	var accruedInterest float64
+	err := tx.Model(&SavingsInterestAccrual{}).
+		Where("savings_account_id = ? AND posted = ?", account.ID, false).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&accruedInterest).Error
+	if err != nil {
+		return nil, err
+	}
+	account.AccruedInterest = moneyutil.Round(accruedInterest)
+
+	err = tx.Model(&account).Updates(map[string]interface{}{
+		"last_interest_calculation_date": account.LastInterestCalculationDate,
+		"last_interest_posting_date":     account.LastInterestPostingDate,
+		"accrued_interest":               account.AccruedInterest,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// dailyAccruals computes the interest earned by the account for each day from from up to the day
+// before to. Daily balances are rebuilt from the transactions of the account, working back from the
+// current balance so that accounts opened before the transaction ledger are handled correctly.
+func dailyAccruals(tx *gorm.DB, account *SavingsAccount, product *savings_product.SavingsProduct, from, to time.Time) ([]*SavingsInterestAccrual, error) {
+	var transactions []*SavingsTransaction
+	err := tx.Where("savings_account_id = ? AND created_at >= ?", account.ID, from).Order("id ASC").Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	balance := account.Balance
+	for _, transaction := range transactions {
+		balance -= transaction.Amount
+	}
+	balance = moneyutil.Round(balance)
+
+	var (
+		accruals  = make([]*SavingsInterestAccrual, 0, int(to.Sub(from).Hours()/24))
+		dailyRate = product.AnnualInterestRate / 100 / daysInYear
+		next      = 0
+	)
+
+	for day := businessDay(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		minimum := balance
+		// Transactions belong to the UTC business day they were made on, whatever the time zone of the database
+		for ; next < len(transactions) && !businessDay(transactions[next].CreatedAt).After(day); next++ {
+			balance = moneyutil.Round(balance + transactions[next].Amount)
+			if balance < minimum {
+				minimum = balance
+			}
+		}
+
+		earning := balance
+		if product.InterestBalanceMethod == savings_product.BalanceMethodMinimum {
+			earning = minimum
+		}
+
+		var amount float64
+		if earning > 0 {
+			amount = earning * dailyRate
+		}
+
+		accruals = append(accruals, &SavingsInterestAccrual{
+			SavingsAccountID: account.ID,
+			BusinessDate:     day,
+			ClosingBalance:   balance,
+			MinimumBalance:   minimum,
+			InterestRate:     product.AnnualInterestRate,
+			Amount:           amount,
+		})
+	}
+
+	return accruals, nil
+}
+
+// postPeriodInterest posts the interest accrued for the period [start, end) to the account and
+// deducts withholding tax as a separate transaction
+func postPeriodInterest(tx *gorm.DB, account *SavingsAccount, product *savings_product.SavingsProduct, start, end time.Time) (float64, float64, error) {
+	var accruals []*SavingsInterestAccrual
+	err := tx.Where("savings_account_id = ? AND posted = ? AND business_date >= ? AND business_date < ?", account.ID, false, start, end).
+		Order("business_date ASC").
+		Find(&accruals).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(accruals) == 0 {
+		return 0, 0, nil
+	}
+
+	var gross float64
+	switch product.InterestBalanceMethod {
+	case savings_product.BalanceMethodMinimum:
+		// Interest for the whole period is earned on the lowest balance held during the period
+		minimum := accruals[0].MinimumBalance
+		for _, accrual := range accruals {
+			if accrual.MinimumBalance < minimum {
+				minimum = accrual.MinimumBalance
+			}
+		}
+		if minimum > 0 {
+			gross = minimum * product.AnnualInterestRate / 100 / daysInYear * float64(len(accruals))
+		}
+	default:
+		for _, accrual := range accruals {
+			gross += accrual.Amount
+		}
+	}
+	gross = moneyutil.Round(gross)
+
+	ids := make([]uint, 0, len(accruals))
+	for _, accrual := range accruals {
+		ids = append(ids, accrual.ID)
+	}
+
+	if gross <= 0 {
+		// Nothing to post; mark the accruals as settled so they are not carried to the next period
+		return 0, 0, tx.Model(&SavingsInterestAccrual{}).Where("id IN ?", ids).Update("posted", true).Error
+	}
+
+	periodLabel := fmt.Sprintf("%s to %s", start.Format(time.DateOnly), end.AddDate(0, 0, -1).Format(time.DateOnly))
+	reference := fmt.Sprintf("INT-%d-%s", account.ID, end.Format("20060102"))
+
+	interest, err := applyTransaction(tx, account, &TransactionRequest{
+		SavingsAccountID: account.ID,
+		TransactionType:  TransactionInterest,
+		Reference:        reference,
+		Narration:        "Interest for " + periodLabel,
+	}, gross)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	withholdingTax := moneyutil.Percent(gross, product.WithholdingTaxRate)
+	if withholdingTax > 0 {
+		_, err := applyTransaction(tx, account, &TransactionRequest{
+			SavingsAccountID: account.ID,
+			TransactionType:  TransactionWithholdingTax,
+			Reference:        reference,
+			Narration:        fmt.Sprintf("Withholding tax at %.2f%% on interest for %s", product.WithholdingTaxRate, periodLabel),
+		}, -withholdingTax)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	err = tx.Model(&SavingsInterestAccrual{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"posted":                true,
+		"posted_transaction_id": interest.ID,
+	}).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return gross, withholdingTax, nil
+}
+
+// ListInterestAccruals lists the daily interest accruals of a savings account
+func (ctrl *SavingsAccountController) ListInterestAccruals(c *gin.Context) {
+	id := c.Param("id")
+
+	var accruals []*SavingsInterestAccrual
+	if err := ctrl.DB.WithContext(c.Request.Context()).Where("savings_account_id = ?", id).Order("business_date DESC").Limit(maxPageSize).Find(&accruals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve interest accruals"})
+		return
+	}
+
+	responses := make([]*SavingsInterestAccrualResponse, 0, len(accruals))
+	for _, accrual := range accruals {
+		responses = append(responses, ToSavingsInterestAccrualResponse(accrual))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"interest_accruals": responses})
+}
@@ -1,8 +1,32 @@
 package savings
 
 import (
+	"context"
 	"database/sql"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// Savings account statuses
+const (
+	StatusDefault   = 1
+	StatusApproved  = 2
+	StatusActivated = 3
+	StatusClosed    = 4
+)
+
+// Savings transaction types
+const (
+	TransactionDeposit          = "DEPOSIT"
+	TransactionWithdrawal       = "WITHDRAWAL"
+	TransactionOpeningBalance   = "OPENING_BALANCE"
+	TransactionLoanDisbursement = "LOAN_DISBURSEMENT"
+	TransactionLoanOverpayment  = "LOAN_OVERPAYMENT"
+	TransactionInterest         = "INTEREST"
+	TransactionWithholdingTax   = "WITHHOLDING_TAX"
+	TransactionEarlyWithdrawal  = "EARLY_WITHDRAWAL_PENALTY"
+	TransactionMaturityTransfer = "MATURITY_TRANSFER"
 )
 
 // SavingsAccount defines the GORM model for the savings_account table
@@ -19,11 +43,14 @@ type SavingsAccount struct {
 	DateApproved                sql.NullTime `gorm:"type:DATETIME"`
 	DateActivated               sql.NullTime `gorm:"type:DATETIME"`
 	LastInterestCalculationDate sql.NullTime `gorm:"type:DATETIME"`
+	LastInterestPostingDate     sql.NullTime `gorm:"type:DATETIME"`
+	AccruedInterest             float64      `gorm:"type:double(20,2);default:0.00"` // Interest accrued but not yet posted
 	MaturityDate                sql.NullTime `gorm:"type:DATETIME"`
 	MaximumWithdrawableAmount   *float64     `gorm:"type:double(20,2)"`
 	FeesDue                     float64      `gorm:"type:double(20,2);default:0.00"`
 	LockedBalance               float64      `gorm:"type:double(20,2);default:0.00"`
 	DateLocked                  sql.NullTime `gorm:"type:DATETIME"`
+	MaturityTransferAccountID   int          `gorm:"type:INT(11);default:0"` // Savings account that receives a fixed deposit at maturity
 	CreatedAt                   time.Time    `gorm:"type:datetime(6);autoCreateTime;->;<-:create;index;not null"`
 	UpdatedAt                   time.Time    `gorm:"type:datetime(6);autoUpdateTime"`
 }
@@ -49,3 +76,74 @@ type SavingProduct struct {
 	ID          uint   `gorm:"-:migration;<-:false;column:saving_product_id" json:"saving_product_id,omitempty"`
 	ProductName string `gorm:"-:migration;<-:false;column:saving_product_name" json:"saving_product_name,omitempty"`
 }
+
+// SavingsTransaction defines the GORM model for the savings_transaction table. Every change to a
+// savings account balance is recorded as a transaction together with the resulting running balance.
+type SavingsTransaction struct {
+	ID               uint      `gorm:"primaryKey"`
+	SavingsAccountID uint      `gorm:"index;not null"`
+	TransactionType  string    `gorm:"size:30;index;not null"`
+	Amount           float64   `gorm:"type:double(20,2);not null"` // Positive for credits and negative for debits
+	RunningBalance   float64   `gorm:"type:double(20,2);not null"`
+	Channel          string    `gorm:"size:20"`
+	Reference        string    `gorm:"size:100;index"`
+	Narration        string    `gorm:"size:255"`
+	CreatedBy        uint64    `gorm:"default:0"`
+	CreatedAt        time.Time `gorm:"type:datetime(6);autoCreateTime;index"`
+}
+
+func (*SavingsTransaction) TableName() string {
+	return "savings_transaction"
+}
+
+// SavingsInterestAccrual is the interest earned by a savings account on a single day
+type SavingsInterestAccrual struct {
+	ID                  uint      `gorm:"primaryKey"`
+	SavingsAccountID    uint      `gorm:"uniqueIndex:idx_savings_accrual_date;not null"`
+	BusinessDate        time.Time `gorm:"type:date;uniqueIndex:idx_savings_accrual_date;not null"`
+	ClosingBalance      float64   `gorm:"type:double(20,2);default:0.00"`
+	MinimumBalance      float64   `gorm:"type:double(20,2);default:0.00"`
+	InterestRate        float64   `gorm:"type:double(10,5);default:0.00000"`
+	Amount              float64   `gorm:"type:double(20,6);default:0.000000"`
+	Posted              bool      `gorm:"index;default:false"`
+	PostedTransactionID uint      `gorm:"default:0"` // Interest transaction the accrual was posted with
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+}
+
+func (*SavingsInterestAccrual) TableName() string {
+	return "savings_interest_accrual"
+}
+
+// columns added to savings tables after the initial schema
+var migratedColumns = []struct {
+	model  interface{}
+	column string
+}{
+	{&SavingsAccount{}, "LastInterestPostingDate"},
+	{&SavingsAccount{}, "AccruedInterest"},
+	{&SavingsAccount{}, "MaturityTransferAccountID"},
+}
+
+// Migrate creates savings tables and columns that are missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+
+	for _, model := range []interface{}{&SavingsAccount{}, &SavingsTransaction{}, &SavingsInterestAccrual{}} {
+		if !migrator.HasTable(model) {
+			if err := migrator.AutoMigrate(model); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, migrated := range migratedColumns {
+		if migrator.HasColumn(migrated.model, migrated.column) {
+			continue
+		}
+		if err := migrator.AddColumn(migrated.model, migrated.column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,20 +2,28 @@ package savings
 
 import (
 	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gidyon/pesapalm/internal/idempotency"
 )
 
 // RegisterRoutes registers all application routes
 func RegisterRoutes(opt *Options) {
 	savingsController := SavingsAccountController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
+	idempotent := idempotency.Middleware(opt.RedisDB, opt.TokenManager)
 
 	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
 	{
-		v1.POST("/savings", savingsController.CreateSavingsAccount)
-		v1.GET("/savings", savingsController.ListSavingsAccounts)
-		v1.GET("/savings/:id", savingsController.GetSavingsAccount)
-		v1.PUT("/savings/:id", savingsController.UpdateSavingsAccount)
-		v1.PATCH("/savings/:id/status", savingsController.UpdateSavingsAccountStatus)
-		v1.DELETE("/savings/:id", savingsController.DeleteSavingsAccount)
-		v1.GET("/saving-stats", savingsController.GetStats)
+		v1.POST("/savings", authorize("savings_accounts", auth.ActionCreate), idempotent, savingsController.CreateSavingsAccount)
+		v1.GET("/savings", authorize("savings_accounts", auth.ActionRead), savingsController.ListSavingsAccounts)
+		v1.GET("/savings/:id", authorize("savings_accounts", auth.ActionRead), savingsController.GetSavingsAccount)
+		v1.PUT("/savings/:id", authorize("savings_accounts", auth.ActionUpdate), savingsController.UpdateSavingsAccount)
+		v1.PATCH("/savings/:id/status", authorize("savings_accounts", auth.ActionUpdate), savingsController.UpdateSavingsAccountStatus)
+		v1.POST("/savings/:id/deposits", authorize("savings_accounts", "deposit"), idempotent, savingsController.DepositSavings)
+		v1.POST("/savings/:id/withdrawals", authorize("savings_accounts", "withdraw"), idempotent, savingsController.WithdrawSavings)
+		v1.GET("/savings/:id/transactions", authorize("savings_accounts", auth.ActionRead), savingsController.ListSavingsTransactions)
+		v1.GET("/savings/:id/interest-accruals", authorize("savings_accounts", auth.ActionRead), savingsController.ListInterestAccruals)
+		v1.POST("/savings/:id/break", authorize("savings_accounts", "break"), idempotent, savingsController.BreakFixedDeposit)
+		v1.DELETE("/savings/:id", authorize("savings_accounts", auth.ActionDelete), savingsController.DeleteSavingsAccount)
+		v1.GET("/saving-stats", authorize("savings_reports", auth.ActionRead), savingsController.GetStats)
 	}
 }
@@ -0,0 +1,303 @@
+package savings
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gidyon/pesapalm/internal/ledger"
+	"github.com/gidyon/pesapalm/pkg/utils/moneyutil"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned when a savings transaction cannot be posted
+var (
+	ErrInvalidAmount           = errors.New("transaction amount must be greater than zero")
+	ErrAccountNotFound         = errors.New("savings account not found")
+	ErrAccountNotActive        = errors.New("savings account is not active")
+	ErrCustomerMismatch        = errors.New("savings account belongs to a different customer")
+	ErrInsufficientFunds       = errors.New("insufficient available balance")
+	ErrWithdrawalLimitExceeded = errors.New("amount exceeds the maximum withdrawable amount")
+
+	errNotFixedDeposit  = errors.New("savings account is not a fixed deposit")
+	errDepositNotLocked = errors.New("fixed deposit is not locked")
+	errDepositMatured   = errors.New("fixed deposit has already matured")
+)
+
+// TransactionRequest contains the details of a transaction posted to a savings account
+type TransactionRequest struct {
+	SavingsAccountID uint
+	CustomerID       int // When set, the savings account must belong to this customer
+	TransactionType  string
+	Amount           float64
+	Channel          string
+	Reference        string
+	Narration        string
+	CreatedBy        uint64
+}
+
+// Credit adds the request amount to an activated savings account within tx
+func Credit(tx *gorm.DB, req *TransactionRequest) (*SavingsTransaction, *SavingsAccount, error) {
+	return post(tx, req, 1)
+}
+
+// Debit removes the request amount from an activated savings account within tx. Debits cannot dip
+// into the locked balance and withdrawals are limited to the account's maximum withdrawable amount.
+func Debit(tx *gorm.DB, req *TransactionRequest) (*SavingsTransaction, *SavingsAccount, error) {
+	return post(tx, req, -1)
+}
+
+// post locks the savings account for the duration of tx so that concurrent transactions on the same
+// account are serialized and cannot overdraw it
+func post(tx *gorm.DB, req *TransactionRequest, sign float64) (*SavingsTransaction, *SavingsAccount, error) {
+	amount := moneyutil.Round(req.Amount)
+	if amount <= 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+
+	var account SavingsAccount
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, req.SavingsAccountID).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, nil, ErrAccountNotFound
+	default:
+		return nil, nil, err
+	}
+
+	switch {
+	case req.CustomerID != 0 && account.CustomerID != req.CustomerID:
+		return nil, nil, ErrCustomerMismatch
+	case account.StatusID != StatusActivated:
+		return nil, nil, ErrAccountNotActive
+	}
+
+	if sign < 0 {
+		available := moneyutil.Round(account.Balance - account.LockedBalance)
+		if amount > available {
+			return nil, nil, fmt.Errorf("%w: available balance is %.2f", ErrInsufficientFunds, available)
+		}
+		max := account.MaximumWithdrawableAmount
+		if req.TransactionType == TransactionWithdrawal && max != nil && *max > 0 && amount > *max {
+			return nil, nil, fmt.Errorf("%w of %.2f", ErrWithdrawalLimitExceeded, *max)
+		}
+	}
+
+	transaction, err := applyTransaction(tx, &account, req, sign*amount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return transaction, &account, nil
+}
+
+// applyTransaction moves the balance of a locked account by amount and records the transaction
+func applyTransaction(tx *gorm.DB, account *SavingsAccount, req *TransactionRequest, amount float64) (*SavingsTransaction, error) {
+	account.Balance = moneyutil.Round(account.Balance + amount)
+
+	if err := tx.Model(account).Update("balance", account.Balance).Error; err != nil {
+		return nil, err
+	}
+
+	transaction := &SavingsTransaction{
+		SavingsAccountID: account.ID,
+		TransactionType:  req.TransactionType,
+		Amount:           amount,
+		RunningBalance:   account.Balance,
+		Channel:          req.Channel,
+		Reference:        req.Reference,
+		Narration:        req.Narration,
+		CreatedBy:        req.CreatedBy,
+	}
+	if err := tx.Create(transaction).Error; err != nil {
+		return nil, err
+	}
+
+	if err := postToLedger(tx, transaction); err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// ledgerCounterAccounts maps savings transaction types to the ledger account on the other side of
+// the customer deposits account
+var ledgerCounterAccounts = map[string]string{
+	TransactionDeposit:          ledger.AccountCash,
+	TransactionWithdrawal:       ledger.AccountCash,
+	TransactionOpeningBalance:   ledger.AccountCash,
+	TransactionLoanDisbursement: ledger.AccountTransferClearing,
+	TransactionLoanOverpayment:  ledger.AccountTransferClearing,
+	TransactionMaturityTransfer: ledger.AccountTransferClearing,
+	TransactionInterest:         ledger.AccountSavingsInterestCost,
+	TransactionWithholdingTax:   ledger.AccountWithholdingTaxPayable,
+	TransactionEarlyWithdrawal:  ledger.AccountSavingsPenaltyIncome,
+}
+
+// postToLedger records a savings transaction in the general ledger
+func postToLedger(tx *gorm.DB, transaction *SavingsTransaction) error {
+	counter, ok := ledgerCounterAccounts[transaction.TransactionType]
+	if !ok {
+		counter = ledger.AccountSuspense
+	}
+	if counter == ledger.AccountCash && strings.EqualFold(transaction.Channel, "MPESA") {
+		counter = ledger.AccountMpesa
+	}
+
+	return ledger.Post(tx, ledger.NewEntry(
+		"SAVINGS_"+transaction.TransactionType,
+		fmt.Sprint(transaction.ID),
+		transaction.Reference,
+		transaction.Narration,
+		ledger.Debit(counter, transaction.Amount),
+		ledger.Credit(ledger.AccountSavingsDeposits, transaction.Amount).For(ledger.SubledgerSavings, transaction.SavingsAccountID),
+	))
+}
+
+// transactionStatus returns the HTTP status for a transaction error
+func transactionStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		return http.StatusNotFound, true
+	case errors.Is(err, ErrInvalidAmount),
+		errors.Is(err, ErrAccountNotActive),
+		errors.Is(err, ErrCustomerMismatch),
+		errors.Is(err, ErrInsufficientFunds),
+		errors.Is(err, ErrWithdrawalLimitExceeded),
+		errors.Is(err, errNotFixedDeposit),
+		errors.Is(err, errDepositNotLocked),
+		errors.Is(err, errDepositMatured),
+		errors.Is(err, errAccountApproved),
+		errors.Is(err, errAccountActivated),
+		errors.Is(err, errAccountClosed):
+		return http.StatusBadRequest, true
+	}
+	return http.StatusInternalServerError, false
+}
+
+// DepositSavings deposits funds into a savings account
+func (ctrl *SavingsAccountController) DepositSavings(c *gin.Context) {
+	ctrl.postTransaction(c, TransactionDeposit, Credit)
+}
+
+// WithdrawSavings withdraws funds from a savings account
+func (ctrl *SavingsAccountController) WithdrawSavings(c *gin.Context) {
+	ctrl.postTransaction(c, TransactionWithdrawal, Debit)
+}
+
+func (ctrl *SavingsAccountController) postTransaction(
+	c *gin.Context, transactionType string, apply func(*gorm.DB, *TransactionRequest) (*SavingsTransaction, *SavingsAccount, error),
+) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid savings account id"})
+		return
+	}
+
+	var dto SavingsTransactionDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var createdBy uint64
+	if metadata, err := ctrl.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		createdBy = metadata.UserId
+	}
+
+	var transaction *SavingsTransaction
+
+	err = ctrl.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		transaction, _, err = apply(tx, &TransactionRequest{
+			SavingsAccountID: uint(id),
+			TransactionType:  transactionType,
+			Amount:           dto.Amount,
+			Channel:          dto.Channel,
+			Reference:        dto.Reference,
+			Narration:        dto.Narration,
+			CreatedBy:        createdBy,
+		})
+		return err
+	})
+	if err != nil {
+		if status, ok := transactionStatus(err); ok {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		ctrl.Logger.Errorf("Failed to post savings %s: %v", transactionType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to post savings transaction"})
+		return
+	}
+
+	ctrl.Logger.Infof("Posted savings %s of %.2f to account %d", transactionType, dto.Amount, id)
+	c.JSON(http.StatusOK, ToSavingsTransactionResponse(transaction))
+}
+
+// ListSavingsTransactions lists the transactions of a savings account, newest first
+func (ctrl *SavingsAccountController) ListSavingsTransactions(c *gin.Context) {
+	var (
+		id          = c.Param("id")
+		queryParams = c.Request.URL.Query()
+		pageToken   = queryParams.Get("pageToken")
+	)
+
+	pageSize, _ := strconv.Atoi(queryParams.Get("pageSize"))
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	var lastID int
+	if pageToken != "" {
+		bs, err := base64.StdEncoding.DecodeString(pageToken)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return
+		}
+		lastID, err = strconv.Atoi(string(bs))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "page token is incorrect"})
+			return
+		}
+	}
+
+	db := ctrl.DB.WithContext(c.Request.Context()).
+		Where("savings_account_id = ?", id).
+		Order("id DESC").
+		Limit(pageSize + 1)
+	if lastID > 0 {
+		db = db.Where("id < ?", lastID)
+	}
+
+	transactions := make([]*SavingsTransaction, 0, pageSize+1)
+	if err := db.Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve savings transactions"})
+		return
+	}
+
+	response := make([]*SavingsTransactionResponse, 0, len(transactions))
+	for index, transaction := range transactions {
+		if index == pageSize {
+			break
+		}
+		response = append(response, ToSavingsTransactionResponse(transaction))
+	}
+
+	var nextPageToken string
+	if len(transactions) > pageSize {
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(transactions[pageSize-1].ID)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"next_page_token": nextPageToken,
+		"transactions":    response,
+	})
+}
@@ -1,8 +1,11 @@
 package savings_product
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/gidyon/pesapalm/pkg/utils/periodutil"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -20,14 +23,26 @@ func (ctrl *SavingsProductController) CreateSavingsProduct(c *gin.Context) {
 		return
 	}
 
+	if err := validateTerm(dto.IsFixedDeposit, dto.TermLength, dto.TermUnit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	product := SavingsProduct{
-		Name:                      dto.Name,
-		ProductCode:               dto.ProductCode,
-		CurrencyID:                dto.CurrencyID,
-		Description:               dto.Description,
-		InterestRate:              dto.InterestRate,
-		InterestCalculationPeriod: dto.InterestCalculationPeriod,
-		InterestCalculationUnit:   dto.InterestCalculationUnit,
+		Name:                       dto.Name,
+		ProductCode:                dto.ProductCode,
+		CurrencyID:                 dto.CurrencyID,
+		Description:                dto.Description,
+		AnnualInterestRate:         dto.AnnualInterestRate,
+		InterestPostingPeriod:      dto.InterestPostingPeriod,
+		InterestPostingUnit:        dto.InterestPostingUnit,
+		InterestBalanceMethod:      balanceMethod(dto.InterestBalanceMethod),
+		WithholdingTaxRate:         dto.WithholdingTaxRate,
+		IsFixedDeposit:             dto.IsFixedDeposit,
+		TermLength:                 dto.TermLength,
+		TermUnit:                   termUnit(dto.TermUnit),
+		EarlyWithdrawalPenaltyRate: dto.EarlyWithdrawalPenaltyRate,
+		RolloverPolicy:             rolloverPolicy(dto.RolloverPolicy),
 	}
 
 	if result := ctrl.DB.Create(&product); result.Error != nil {
@@ -64,6 +79,11 @@ func (ctrl *SavingsProductController) UpdateSavingsProduct(c *gin.Context) {
 		return
 	}
 
+	if err := validateTerm(dto.IsFixedDeposit, dto.TermLength, dto.TermUnit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var product SavingsProduct
 	if result := ctrl.DB.First(&product, id); result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Savings product not found"})
@@ -72,9 +92,16 @@ func (ctrl *SavingsProductController) UpdateSavingsProduct(c *gin.Context) {
 
 	product.Name = dto.Name
 	product.Description = dto.Description
-	product.InterestRate = dto.InterestRate
-	product.InterestCalculationPeriod = dto.InterestCalculationPeriod
-	product.InterestCalculationUnit = dto.InterestCalculationUnit
+	product.AnnualInterestRate = dto.AnnualInterestRate
+	product.InterestPostingPeriod = dto.InterestPostingPeriod
+	product.InterestPostingUnit = dto.InterestPostingUnit
+	product.InterestBalanceMethod = balanceMethod(dto.InterestBalanceMethod)
+	product.WithholdingTaxRate = dto.WithholdingTaxRate
+	product.IsFixedDeposit = dto.IsFixedDeposit
+	product.TermLength = dto.TermLength
+	product.TermUnit = termUnit(dto.TermUnit)
+	product.EarlyWithdrawalPenaltyRate = dto.EarlyWithdrawalPenaltyRate
+	product.RolloverPolicy = rolloverPolicy(dto.RolloverPolicy)
 
 	if result := ctrl.DB.Save(&product); result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
@@ -106,3 +133,37 @@ func (ctrl *SavingsProductController) ListSavingsProducts(c *gin.Context) {
 
 	c.JSON(http.StatusOK, products)
 }
+
+func balanceMethod(method string) string {
+	if method == "" {
+		return BalanceMethodDaily
+	}
+	return method
+}
+
+func rolloverPolicy(policy string) string {
+	if policy == "" {
+		return RolloverNone
+	}
+	return policy
+}
+
+func termUnit(unit string) string {
+	if unit == "" {
+		return periodutil.Month
+	}
+	return periodutil.Normalize(unit)
+}
+
+// validateTerm checks that fixed deposit products have a valid term
+func validateTerm(isFixedDeposit bool, length int, unit string) error {
+	switch {
+	case !isFixedDeposit:
+		return nil
+	case length <= 0:
+		return errors.New("fixed deposit products require a term length greater than zero")
+	case unit != "" && !periodutil.Valid(unit):
+		return fmt.Errorf("unsupported term unit %s", unit)
+	}
+	return nil
+}
@@ -4,50 +4,78 @@ import "time"
 
 // CreateSavingsProductDTO defines the JSON structure for creating a new savings product
 type CreateSavingsProductDTO struct {
-	Name                      string  `json:"name"`
-	ProductCode               string  `json:"product_code"`
-	CurrencyID                int     `json:"currency_id"`
-	Description               string  `json:"description"`
-	InterestRate              float64 `json:"interest_rate"`
-	InterestCalculationPeriod int     `json:"interest_calculation_period"`
-	InterestCalculationUnit   string  `json:"interest_calculation_unit"`
+	Name                       string  `json:"name"`
+	ProductCode                string  `json:"product_code"`
+	CurrencyID                 int     `json:"currency_id"`
+	Description                string  `json:"description"`
+	AnnualInterestRate         float64 `json:"interest_rate"`
+	InterestPostingPeriod      int     `json:"interest_calculation_period"`
+	InterestPostingUnit        string  `json:"interest_calculation_unit"`
+	InterestBalanceMethod      string  `json:"interest_balance_method" binding:"omitempty,oneof=DAILY_BALANCE MINIMUM_BALANCE"`
+	WithholdingTaxRate         float64 `json:"withholding_tax_rate" binding:"gte=0,lte=100"`
+	IsFixedDeposit             bool    `json:"is_fixed_deposit"`
+	TermLength                 int     `json:"term_length"`
+	TermUnit                   string  `json:"term_unit"`
+	EarlyWithdrawalPenaltyRate float64 `json:"early_withdrawal_penalty_rate" binding:"gte=0,lte=100"`
+	RolloverPolicy             string  `json:"rollover_policy" binding:"omitempty,oneof=NONE PRINCIPAL ALL TRANSFER"`
 }
 
 // UpdateSavingsProductDTO defines the JSON structure for updating a savings product
 type UpdateSavingsProductDTO struct {
-	Name                      string  `json:"name"`
-	Description               string  `json:"description"`
-	InterestRate              float64 `json:"interest_rate"`
-	InterestCalculationPeriod int     `json:"interest_calculation_period"`
-	InterestCalculationUnit   string  `json:"interest_calculation_unit"`
+	Name                       string  `json:"name"`
+	Description                string  `json:"description"`
+	AnnualInterestRate         float64 `json:"interest_rate"`
+	InterestPostingPeriod      int     `json:"interest_calculation_period"`
+	InterestPostingUnit        string  `json:"interest_calculation_unit"`
+	InterestBalanceMethod      string  `json:"interest_balance_method" binding:"omitempty,oneof=DAILY_BALANCE MINIMUM_BALANCE"`
+	WithholdingTaxRate         float64 `json:"withholding_tax_rate" binding:"gte=0,lte=100"`
+	IsFixedDeposit             bool    `json:"is_fixed_deposit"`
+	TermLength                 int     `json:"term_length"`
+	TermUnit                   string  `json:"term_unit"`
+	EarlyWithdrawalPenaltyRate float64 `json:"early_withdrawal_penalty_rate" binding:"gte=0,lte=100"`
+	RolloverPolicy             string  `json:"rollover_policy" binding:"omitempty,oneof=NONE PRINCIPAL ALL TRANSFER"`
 }
 
 // SavingsProductResponse defines the structure of the savings product data returned in the response
 type SavingsProductResponse struct {
-	ID                        uint    `json:"id"`
-	Name                      string  `json:"name"`
-	ProductCode               string  `json:"product_code"`
-	CurrencyID                int     `json:"currency_id"`
-	Description               string  `json:"description"`
-	InterestRate              float64 `json:"interest_rate"`
-	InterestCalculationPeriod int     `json:"interest_calculation_period"`
-	InterestCalculationUnit   string  `json:"interest_calculation_unit"`
-	CreatedAt                 string  `json:"created_at"`
-	UpdatedAt                 string  `json:"updated_at"`
+	ID                         uint    `json:"id"`
+	Name                       string  `json:"name"`
+	ProductCode                string  `json:"product_code"`
+	CurrencyID                 int     `json:"currency_id"`
+	Description                string  `json:"description"`
+	AnnualInterestRate         float64 `json:"interest_rate"`
+	InterestPostingPeriod      int     `json:"interest_calculation_period"`
+	InterestPostingUnit        string  `json:"interest_calculation_unit"`
+	InterestBalanceMethod      string  `json:"interest_balance_method"`
+	WithholdingTaxRate         float64 `json:"withholding_tax_rate"`
+	IsFixedDeposit             bool    `json:"is_fixed_deposit"`
+	TermLength                 int     `json:"term_length"`
+	TermUnit                   string  `json:"term_unit"`
+	EarlyWithdrawalPenaltyRate float64 `json:"early_withdrawal_penalty_rate"`
+	RolloverPolicy             string  `json:"rollover_policy"`
+	CreatedAt                  string  `json:"created_at"`
+	UpdatedAt                  string  `json:"updated_at"`
 }
 
 // ToSavingsProductResponse converts a SavingsProduct to a SavingsProductResponse
 func ToSavingsProductResponse(product *SavingsProduct) *SavingsProductResponse {
 	return &SavingsProductResponse{
-		ID:                        product.ID,
-		Name:                      product.Name,
-		ProductCode:               product.ProductCode,
-		CurrencyID:                product.CurrencyID,
-		Description:               product.Description,
-		InterestRate:              product.InterestRate,
-		InterestCalculationPeriod: product.InterestCalculationPeriod,
-		InterestCalculationUnit:   product.InterestCalculationUnit,
-		CreatedAt:                 product.CreatedAt.UTC().Format(time.RFC3339),
-		UpdatedAt:                 product.UpdatedAt.UTC().Format(time.RFC3339),
+		ID:                         product.ID,
+		Name:                       product.Name,
+		ProductCode:                product.ProductCode,
+		CurrencyID:                 product.CurrencyID,
+		Description:                product.Description,
+		AnnualInterestRate:         product.AnnualInterestRate,
+		InterestPostingPeriod:      product.InterestPostingPeriod,
+		InterestPostingUnit:        product.InterestPostingUnit,
+		InterestBalanceMethod:      product.InterestBalanceMethod,
+		WithholdingTaxRate:         product.WithholdingTaxRate,
+		IsFixedDeposit:             product.IsFixedDeposit,
+		TermLength:                 product.TermLength,
+		TermUnit:                   product.TermUnit,
+		EarlyWithdrawalPenaltyRate: product.EarlyWithdrawalPenaltyRate,
+		RolloverPolicy:             product.RolloverPolicy,
+		CreatedAt:                  product.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:                  product.UpdatedAt.UTC().Format(time.RFC3339),
 	}
 }
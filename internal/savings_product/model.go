@@ -1,23 +1,75 @@
 package savings_product
 
 import (
+	"context"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// Fixed deposit rollover policies applied at maturity
+const (
+	RolloverNone      = "NONE"      // Release the deposit into the account
+	RolloverPrincipal = "PRINCIPAL" // Renew the principal for another term, interest is released
+	RolloverAll       = "ALL"       // Renew the principal and interest for another term
+	RolloverTransfer  = "TRANSFER"  // Transfer the principal and interest to the maturity transfer account
+)
+
+// Interest balance methods
+const (
+	BalanceMethodDaily   = "DAILY_BALANCE"
+	BalanceMethodMinimum = "MINIMUM_BALANCE"
 )
 
 // SavingsProduct defines the GORM model for the savings_product table
 type SavingsProduct struct {
-	ID                        uint      `gorm:"primaryKey"`
-	Name                      string    `gorm:"size:32"`
-	ProductCode               string    `gorm:"size:32;unique"`
-	CurrencyID                int       `gorm:"type:TINYINT(1);default:1"`
-	Description               string    `gorm:"type:mediumtext"`
-	InterestRate              float64   `gorm:"type:double(10,5);default:0.00000"`
-	InterestCalculationPeriod int       `gorm:"type:int"`
-	InterestCalculationUnit   string    `gorm:"size:10;default:DAY"`
-	CreatedAt                 time.Time `gorm:"autoCreateTime"`
-	UpdatedAt                 time.Time `gorm:"autoUpdateTime"`
+	ID                         uint      `gorm:"primaryKey"`
+	Name                       string    `gorm:"size:32"`
+	ProductCode                string    `gorm:"size:32;unique"`
+	CurrencyID                 int       `gorm:"type:TINYINT(1);default:1"`
+	Description                string    `gorm:"type:mediumtext"`
+	AnnualInterestRate         float64   `gorm:"column:interest_rate;type:double(10,5);default:0.00000"` // Annual rate percentage, unlike loan products whose rate applies per calculation period
+	InterestPostingPeriod      int       `gorm:"column:interest_calculation_period;type:int"`            // Number of units between interest postings, interest is accrued daily
+	InterestPostingUnit        string    `gorm:"column:interest_calculation_unit;size:10;default:DAY"`
+	InterestBalanceMethod      string    `gorm:"size:20;default:DAILY_BALANCE"`     // Balance interest is earned on
+	WithholdingTaxRate         float64   `gorm:"type:double(10,5);default:0.00000"` // Percentage of posted interest withheld as tax
+	IsFixedDeposit             bool      `gorm:"default:false"`
+	TermLength                 int       `gorm:"type:int;default:0"`
+	TermUnit                   string    `gorm:"size:10;default:MONTH"`
+	EarlyWithdrawalPenaltyRate float64   `gorm:"type:double(10,5);default:0.00000"` // Percentage of the deposit charged when broken before maturity
+	RolloverPolicy             string    `gorm:"size:20;default:NONE"`
+	CreatedAt                  time.Time `gorm:"autoCreateTime"`
+	UpdatedAt                  time.Time `gorm:"autoUpdateTime"`
 }
 
 func (*SavingsProduct) TableName() string {
 	return "savings_product"
 }
+
+// columns added to the savings_product table after the initial schema
+var migratedColumns = []string{
+	"InterestBalanceMethod",
+	"WithholdingTaxRate",
+	"IsFixedDeposit",
+	"TermLength",
+	"TermUnit",
+	"EarlyWithdrawalPenaltyRate",
+	"RolloverPolicy",
+}
+
+// Migrate creates the savings_product table or adds columns that are missing in the database
+func Migrate(ctx context.Context, db *gorm.DB) error {
+	migrator := db.WithContext(ctx).Migrator()
+	if !migrator.HasTable(&SavingsProduct{}) {
+		return migrator.AutoMigrate(&SavingsProduct{})
+	}
+	for _, column := range migratedColumns {
+		if migrator.HasColumn(&SavingsProduct{}, column) {
+			continue
+		}
+		if err := migrator.AddColumn(&SavingsProduct{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package savings_product
 
 import (
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/grpclog"
@@ -12,19 +13,21 @@ type Options struct {
 	Logger       grpclog.LoggerV2
 	TokenManager auth.TokenInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
 }
 
 // RegisterRoutes registers all application routes
 func RegisterRoutes(opt *Options) {
 	productController := SavingsProductController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
 
 	v1 := opt.GinEngine.Group("/api/v1", auth.TokenAuthMiddleware(opt.TokenManager))
 	{
 		// Routes for savings products
-		v1.POST("/savings-products", productController.CreateSavingsProduct)
-		v1.GET("/savings-products/:id", productController.GetSavingsProduct)
-		v1.PUT("/savings-products/:id", productController.UpdateSavingsProduct)
-		v1.DELETE("/savings-products/:id", productController.DeleteSavingsProduct)
-		v1.GET("/savings-products", productController.ListSavingsProducts)
+		v1.POST("/savings-products", authorize("savings_products", auth.ActionCreate), productController.CreateSavingsProduct)
+		v1.GET("/savings-products/:id", authorize("savings_products", auth.ActionRead), productController.GetSavingsProduct)
+		v1.PUT("/savings-products/:id", authorize("savings_products", auth.ActionUpdate), productController.UpdateSavingsProduct)
+		v1.DELETE("/savings-products/:id", authorize("savings_products", auth.ActionDelete), productController.DeleteSavingsProduct)
+		v1.GET("/savings-products", authorize("savings_products", auth.ActionRead), productController.ListSavingsProducts)
 	}
 }
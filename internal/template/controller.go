@@ -3,6 +3,7 @@ package template
 import (
 	"net/http"
 
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc/grpclog"
@@ -14,6 +15,7 @@ type Options struct {
 	Logger       grpclog.LoggerV2
 	TokenManager auth.TokenInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
 }
 
 type TemplateController struct {
@@ -1,24 +1,29 @@
 package template
 
+import (
+	"github.com/gidyon/pesapalm/internal/auth"
+)
+
 func RegisterRoutes(opt *Options) {
 	templateController := TemplateController{Options: opt}
+	authorize := auth.Authorizer(opt.Enforcer, opt.TokenManager)
 
-	v1 := opt.GinEngine.Group("/api/v1/templates")
+	v1 := opt.GinEngine.Group("/api/v1/templates", auth.TokenAuthMiddleware(opt.TokenManager))
 	{
 		// Admin Groups Routes
-		v1.GET("/admin_groups", templateController.GetAdminGroups)
-		v1.POST("/admin_groups", templateController.CreateAdminGroup)
+		v1.GET("/admin_groups", authorize("templates", auth.ActionRead), templateController.GetAdminGroups)
+		v1.POST("/admin_groups", authorize("templates", auth.ActionCreate), templateController.CreateAdminGroup)
 
 		// Branch Routes
-		v1.GET("/branches", templateController.GetBranches)
-		v1.POST("/branches", templateController.CreateBranch)
+		v1.GET("/branches", authorize("templates", auth.ActionRead), templateController.GetBranches)
+		v1.POST("/branches", authorize("templates", auth.ActionCreate), templateController.CreateBranch)
 
 		// Currency Routes
-		v1.GET("/currencies", templateController.GetCurrencies)
-		v1.POST("/currencies", templateController.CreateCurrency)
+		v1.GET("/currencies", authorize("templates", auth.ActionRead), templateController.GetCurrencies)
+		v1.POST("/currencies", authorize("templates", auth.ActionCreate), templateController.CreateCurrency)
 
 		// Language Routes
-		v1.GET("/languages", templateController.GetLanguages)
-		v1.POST("/languages", templateController.CreateLanguage)
+		v1.GET("/languages", authorize("templates", auth.ActionRead), templateController.GetLanguages)
+		v1.POST("/languages", authorize("templates", auth.ActionCreate), templateController.CreateLanguage)
 	}
 }
@@ -13,8 +13,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/casbin/casbin/v2"
 	"github.com/gidyon/pesapalm/internal/auth"
-	sms_app "github.com/gidyon/pesapalm/internal/sms"
 	"github.com/gidyon/pesapalm/pkg/api/sms"
 	"github.com/gidyon/pesapalm/pkg/utils/formatutil"
 	"github.com/gin-gonic/gin"
@@ -33,6 +33,10 @@ type Options struct {
 	TokenManager auth.TokenInterface
 	Auth         auth.AuthInterface
 	GinEngine    *gin.Engine
+	Enforcer     *casbin.Enforcer
+	OTP          *OTPOptions
+	Lockout      *LockoutOptions
+	TOTP         *TOTPOptions
 }
 
 type APIServer struct {
@@ -56,6 +60,8 @@ func StartService(ctx context.Context, opt *Options) (_ *APIServer, err error) {
 		err = errors.New("missing options")
 	case opt.SqlDB == nil:
 		err = errors.New("missing sql db")
+	case opt.Enforcer == nil:
+		err = errors.New("missing enforcer")
 	case opt.RedisDB == nil:
 		err = errors.New("missing redis db")
 	case opt.Auth == nil:
@@ -69,6 +75,28 @@ func StartService(ctx context.Context, opt *Options) (_ *APIServer, err error) {
 		return nil, err
 	}
 
+	if opt.OTP == nil {
+		opt.OTP = &OTPOptions{}
+	}
+	if err = opt.OTP.validate(); err != nil {
+		return nil, err
+	}
+	if opt.Lockout == nil {
+		opt.Lockout = &LockoutOptions{}
+	}
+	if err = opt.Lockout.validate(); err != nil {
+		return nil, err
+	}
+	if opt.TOTP == nil {
+		opt.TOTP = &TOTPOptions{}
+	}
+	if err = opt.TOTP.validate(); err != nil {
+		return nil, err
+	}
+	if opt.OTP.DevMode && opt.Logger != nil {
+		opt.Logger.Warningln("OTP dev mode is on, OTPs are logged instead of being sent")
+	}
+
 	// Account API
 	api := &APIServer{
 		Options: opt,
@@ -84,6 +112,17 @@ func StartService(ctx context.Context, opt *Options) (_ *APIServer, err error) {
 		}
 	}
 
+	// Add two-factor columns to existing tables
+	for _, field := range []string{"TotpSecret", "TotpEnabled", "TotpRecoveryCodes", "TotpEnabledAt"} {
+		if api.SqlDB.WithContext(ctx).Migrator().HasColumn(&User{}, field) {
+			continue
+		}
+		err = api.SqlDB.WithContext(ctx).Migrator().AddColumn(&User{}, field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add column %s to %s table: %v", field, (&User{}).TableName(), err)
+		}
+	}
+
 	// Register routes
 	api.registerRoutes()
 
@@ -107,6 +146,10 @@ func (api *APIServer) Login(c *gin.Context) {
 		return
 	}
 
+	if !api.allowAttempt(c, req.Username) {
+		return
+	}
+
 	db := &User{}
 
 	// Get account
@@ -120,6 +163,7 @@ func (api *APIServer) Login(c *gin.Context) {
 	switch {
 	case err == nil:
 	case errors.Is(err, gorm.ErrRecordNotFound):
+		api.failedAttempt(ctx, c, req.Username, nil)
 		emailOrPhone := func() string {
 			if strings.Contains(req.Username, "@") {
 				return "email " + req.Username
@@ -136,6 +180,10 @@ func (api *APIServer) Login(c *gin.Context) {
 		return
 	}
 
+	if api.checkLockout(ctx, c, db) {
+		return
+	}
+
 	// If no password set in account
 	if db.Password == "" || db.AccountStatus == "RESET_PASSWORD" {
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "reset password"})
@@ -158,61 +206,14 @@ func (api *APIServer) Login(c *gin.Context) {
 	// Check if password match if they logged in with Phone or Email
 	err = compareHash(db.Password, req.Password)
 	if err != nil {
+		api.failedAttempt(ctx, c, req.Username, db)
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "wrong password"})
 		return
 	}
 
-	api.updateSession(ctx, c, db)
-}
-
-func (api *APIServer) Refresh(c *gin.Context) {
-	var (
-		ctx = c.Request.Context()
-		err error
-	)
-
-	// If metadata is passed and the tokens valid, delete them from the redis store
-	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
-	}
+	api.clearAttempts(ctx, req.Username)
 
-	if metadata == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
-		return
-	}
-
-	// Fetch user id
-	userId, err := api.Auth.FetchAuth(ctx, metadata.TokenUuid)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
-		return
-	}
-
-	db := &User{}
-
-	// Get account
-	err = api.SqlDB.WithContext(ctx).First(db, "id=?", userId).Error
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "please login"})
-		return
-	}
-
-	// Check account statuses
-	switch strings.ToLower(db.AccountStatus) {
-	case "blocked":
-		c.JSON(http.StatusNotAcceptable, gin.H{"message": "account is blocked"})
-		return
-	case "inactive":
-		c.JSON(http.StatusNotAcceptable, gin.H{"message": "account is inactive"})
-		return
-	case "invited":
-		c.JSON(http.StatusNotAcceptable, gin.H{"message": "account is inactive"})
-		return
-	}
-
-	api.updateSession(ctx, c, db)
+	api.completeLogin(ctx, c, db)
 }
 
 func (api *APIServer) Logout(c *gin.Context) {
@@ -450,6 +451,16 @@ func (api *APIServer) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// Users who can no longer sign in are logged out of all sessions
+	switch strings.ToUpper(user.AccountStatus) {
+	case BlockedState, InactiveState, "DELETED":
+		if _, err := api.Auth.RevokeSessions(ctx, db.ID); err != nil {
+			api.Logger.Errorln(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to revoke user sessions"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "successfully updated"})
 }
 
@@ -673,37 +684,16 @@ func (api *APIServer) RequestOtp(c *gin.Context) {
 		return
 	}
 
-	// otp := randomdata.Number(100000, 999999)
-	otp := 123456
-
-	// Send sms
-	data := fmt.Sprintf("Login OTP for Therapy Assessment. \n\nOTP is %d \nExpires in %s", otp, OTPExpireDuration)
-
-	err = sms_app.SendSMS(ctx, &sms.SendSMSRequest{
-		Sms: &sms.SMS{
-			DestinationPhones: []string{db.Phone.String},
-			Keyword:           "LoginOTP",
-			Message:           data,
-		},
-		Auth:     api.SMSAuth,
-		Provider: sms.SmsProvider_ONFON,
-	}, viper.GetString("ENV"))
+	// Generate and send otp
+	err = api.issueOTP(ctx, OTPPurposeLogin, getOTPKey(db.ID), db.Phone.String)
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to send otp"})
 		return
 	}
 
-	// Set token with expiration of 5 minutes
-	err = api.RedisDB.Set(ctx, getOTPKey(db.ID), otp, OTPExpireDuration).Err()
-	if err != nil {
-		api.Logger.Errorln(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set otp to cache"})
-		return
-	}
-
 	// Set trials initial value to zero
-	err = api.RedisDB.Set(ctx, getTrialsKey(db.ID), 0, OTPExpireDuration).Err()
+	err = api.RedisDB.Set(ctx, getTrialsKey(db.ID), 0, api.OTP.TTL).Err()
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set otp counter"})
@@ -734,13 +724,20 @@ func (api *APIServer) ValidateOtp(c *gin.Context) {
 		return
 	}
 
+	phone := formatutil.FormatPhoneKE(req.Phone)
+
+	if !api.allowAttempt(c, phone) {
+		return
+	}
+
 	db := &User{}
 
 	// Get account
-	err = api.SqlDB.WithContext(ctx).First(db, "phone=?", formatutil.FormatPhoneKE(req.Phone)).Error
+	err = api.SqlDB.WithContext(ctx).First(db, "phone=?", phone).Error
 	switch {
 	case err == nil:
 	case errors.Is(err, gorm.ErrRecordNotFound):
+		api.failedAttempt(ctx, c, phone, nil)
 		c.JSON(http.StatusBadRequest, gin.H{"message": "account not found"})
 		return
 	default:
@@ -749,6 +746,10 @@ func (api *APIServer) ValidateOtp(c *gin.Context) {
 		return
 	}
 
+	if api.checkLockout(ctx, c, db) {
+		return
+	}
+
 	trialsKey := getTrialsKey(db.ID)
 
 	// Increment trials by 1
@@ -764,27 +765,30 @@ func (api *APIServer) ValidateOtp(c *gin.Context) {
 
 	// Check if exceed trials
 	if trials > maxTrials {
-		// Block the account
-		err = api.SqlDB.WithContext(ctx).Model(db).Update("account_status", BlockedState).Error
+		// Block the account for the lockout duration
+		err = api.lockAccount(ctx, db)
 		if err != nil {
 			api.Logger.Errorln(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to block account"})
+			return
 		}
 
-		// Delete key
-		err = api.RedisDB.Del(ctx, trialsKey).Err()
+		// Delete keys, the otp can no longer be used
+		err = api.RedisDB.Del(ctx, trialsKey, getOTPKey(db.ID)).Err()
 		if err != nil {
 			api.Logger.Errorln(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to clear otp counter"})
+			return
 		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "account is blocked due to too many attempts"})
+		c.JSON(http.StatusLocked, gin.H{"message": "account is blocked due to too many attempts"})
 
 		return
 	}
 
 	// Get otp
-	otp, err := api.RedisDB.Get(ctx, getOTPKey(db.ID)).Result()
+	otpKey := getOTPKey(db.ID)
+	otp, err := api.RedisDB.Get(ctx, otpKey).Result()
 	switch {
 	case err == nil:
 	case errors.Is(err, redis.Nil):
@@ -797,25 +801,30 @@ func (api *APIServer) ValidateOtp(c *gin.Context) {
 	}
 
 	// Compare otp
-	if otp != req.Otp {
+	if !api.OTP.matchOTP(otpKey, otp, req.Otp) {
+		api.failedAttempt(ctx, c, phone, db)
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Login OTP do not match"})
 		return
 	}
 
-	// Delete key
-	err = api.RedisDB.Del(ctx, trialsKey).Err()
+	// Delete keys, an otp can only be used once
+	err = api.RedisDB.Del(ctx, trialsKey, otpKey).Err()
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to remove otp key"})
 		return
 	}
 
-	api.updateSession(ctx, c, db)
+	api.clearAttempts(ctx, phone)
+
+	api.completeLogin(ctx, c, db)
 }
 
-func (api *APIServer) updateSession(ctx context.Context, c *gin.Context, db *User) {
+// updateSession issues tokens to the user, for a new session when session is nil or else for an existing one being refreshed.
+// Fields in extra are added to the response.
+func (api *APIServer) updateSession(ctx context.Context, c *gin.Context, db *User, session *auth.Session, extra gin.H) {
 	// Check account statuses
-	switch strings.ToLower(db.AccountStatus) {
+	switch strings.ToUpper(db.AccountStatus) {
 	case BlockedState:
 		c.JSON(http.StatusNotAcceptable, gin.H{"message": "account is blocked"})
 		return
@@ -845,8 +854,17 @@ func (api *APIServer) updateSession(ctx context.Context, c *gin.Context, db *Use
 		CreatedAt:     db.CreatedAt.Format(time.RFC3339),
 	}
 
+	if session == nil {
+		session = &auth.Session{
+			UserId: db.ID,
+			Device: c.GetHeader(DeviceHeader),
+		}
+	}
+	session.IP = c.ClientIP()
+	session.UserAgent = c.Request.UserAgent()
+
 	// Generate token
-	token, err := api.TokenManager.CreateToken(db.ID, db.Names)
+	token, err := api.TokenManager.CreateToken(db.ID, db.Names, session.ID)
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
@@ -865,18 +883,24 @@ func (api *APIServer) updateSession(ctx context.Context, c *gin.Context, db *Use
 	}
 
 	// Set the token in cache
-	err = api.Auth.CreateAuth(ctx, db.ID, token)
+	session.ID = token.SessionId
+	err = api.Auth.SaveSession(ctx, session, token)
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set token to cache"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	res := gin.H{
 		"token":         token,
 		"user":          user,
 		"hasCredential": ok,
-	})
+	}
+	for k, v := range extra {
+		res[k] = v
+	}
+
+	c.JSON(http.StatusOK, res)
 }
 
 func (api *APIServer) RefreshSession(c *gin.Context) {
@@ -891,9 +915,30 @@ func (api *APIServer) RefreshSession(c *gin.Context) {
 		return
 	}
 
-	// Get refresh token in cache
-	ID, err := api.Auth.FetchAuth(ctx, req.RefreshUuid)
+	metadata, err := api.TokenManager.ExtractRefreshMetadata(c.Request)
 	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	// The refresh uuid in the body is optional but must belong to the refresh token
+	if req.RefreshUuid != "" && req.RefreshUuid != metadata.TokenUuid {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "refresh uuid does not match token"})
+		return
+	}
+
+	// Refresh tokens can only be exchanged once
+	err = api.Auth.ConsumeRefresh(ctx, metadata)
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		api.Logger.Warningf("Refresh token of user %d was reused, revoked session %s", metadata.UserId, metadata.SessionId)
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "refresh token was already used, please login"})
+		return
+	case errors.Is(err, auth.ErrTokenRevoked):
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	default:
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get refresh uuid"})
 		return
@@ -902,7 +947,7 @@ func (api *APIServer) RefreshSession(c *gin.Context) {
 	db := &User{}
 
 	// Get account
-	err = api.SqlDB.WithContext(ctx).First(db, "id=?", ID).Error
+	err = api.SqlDB.WithContext(ctx).First(db, "id=?", metadata.UserId).Error
 	switch {
 	case err == nil:
 	case errors.Is(err, gorm.ErrRecordNotFound):
@@ -914,7 +959,13 @@ func (api *APIServer) RefreshSession(c *gin.Context) {
 		return
 	}
 
-	api.updateSession(ctx, c, db)
+	session, err := api.refreshedSession(ctx, metadata)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	api.updateSession(ctx, c, db, session, nil)
 }
 
 // Constants
@@ -955,36 +1006,16 @@ func (api *APIServer) RequestResetPasswordOtp(c *gin.Context) {
 		return
 	}
 
-	// Generate OTP (for production, use random generation)
-	otp := 123456
-
-	// Send OTP via SMS
-	message := fmt.Sprintf("Reset password OTP is %d. It expires in %s.", otp, OTPExpireDuration)
-	err = sms_app.SendSMS(ctx, &sms.SendSMSRequest{
-		Sms: &sms.SMS{
-			DestinationPhones: []string{db.Phone.String},
-			Keyword:           "PasswordReset",
-			Message:           message,
-		},
-		Auth:     api.SMSAuth,
-		Provider: sms.SmsProvider_ONFON,
-	}, viper.GetString("ENV"))
+	// Generate OTP and send it via SMS
+	err = api.issueOTP(ctx, OTPPurposeResetPassword, getResetPassOTPKey(db.ID), db.Phone.String)
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to send otp"})
 		return
 	}
 
-	// Store OTP in Redis with expiration
-	err = api.RedisDB.Set(ctx, getResetPassOTPKey(db.ID), otp, OTPExpireDuration).Err()
-	if err != nil {
-		api.Logger.Errorln(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set otp in cache"})
-		return
-	}
-
 	// Store trials count in Redis
-	err = api.RedisDB.Set(ctx, getResetPassTrialsKey(db.ID), 0, OTPExpireDuration).Err()
+	err = api.RedisDB.Set(ctx, getResetPassTrialsKey(db.ID), 0, api.OTP.TTL).Err()
 	if err != nil {
 		api.Logger.Errorln(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to initialize trials count"})
@@ -1007,13 +1038,18 @@ func (api *APIServer) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	if !api.allowAttempt(c, req.Username) {
+		return
+	}
+
 	db := &User{}
 
 	// Check if the account exists
-	err = api.SqlDB.WithContext(ctx).Select("id, phone").First(db, "phone = ?", req.Username).Error
+	err = api.SqlDB.WithContext(ctx).Select("id, phone, email, account_status").First(db, "phone = ?", req.Username).Error
 	switch {
 	case err == nil:
 	case errors.Is(err, gorm.ErrRecordNotFound):
+		api.failedAttempt(ctx, c, req.Username, nil)
 		c.JSON(http.StatusBadRequest, gin.H{"message": "account not found"})
 		return
 	default:
@@ -1022,9 +1058,28 @@ func (api *APIServer) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	if api.checkLockout(ctx, c, db) {
+		return
+	}
+
+	// Trials are checked before the OTP so that a guessed OTP is not accepted after the limit
+	trials, err := api.RedisDB.Get(ctx, getResetPassTrialsKey(db.ID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to verify otp"})
+		return
+	}
+	if trials >= MaxTrials {
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "maximum OTP attempts reached"})
+		return
+	}
+
 	// Check if OTP matches the stored OTP
-	storedOtp, err := api.RedisDB.Get(ctx, getResetPassOTPKey(db.ID)).Result()
-	if err == redis.Nil || storedOtp != req.OTP {
+	otpKey := getResetPassOTPKey(db.ID)
+	storedOtp, err := api.RedisDB.Get(ctx, otpKey).Result()
+	if err == redis.Nil || (err == nil && !api.OTP.matchOTP(otpKey, storedOtp, req.OTP)) {
+		api.failedAttempt(ctx, c, req.Username, db)
+
 		// Increment trials count
 		trials, _ := api.RedisDB.Incr(ctx, getResetPassTrialsKey(db.ID)).Result()
 		if trials > MaxTrials {
@@ -1057,6 +1112,7 @@ func (api *APIServer) ResetPassword(c *gin.Context) {
 
 	// Clear OTP and trials count from Redis
 	api.RedisDB.Del(ctx, getResetPassOTPKey(db.ID), getResetPassTrialsKey(db.ID))
+	api.clearAttempts(ctx, req.Username)
 
 	c.JSON(http.StatusOK, gin.H{"message": "password reset successful"})
 }
@@ -37,3 +37,14 @@ type ValidateOtpRequest struct {
 type RefreshRequest struct {
 	RefreshUuid string `json:"refresh_uuid,omitempty"`
 }
+
+type LoginChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token,omitempty"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
@@ -0,0 +1,293 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// LockoutOptions configures brute-force protection of login, OTP validation and password reset
+type LockoutOptions struct {
+	FreeAttempts int           // Failures per username allowed before delays start, defaults to 3
+	MaxDelay     time.Duration // Longest delay between attempts, defaults to 1 minute
+	Threshold    int           // Failures per username after which the account is blocked, defaults to 10
+	Duration     time.Duration // How long an account stays blocked, defaults to 30 minutes
+	IPThreshold  int           // Failures per client IP before it is rejected, defaults to 50
+	Window       time.Duration // How long failures are counted, defaults to 1 hour
+}
+
+func (opt *LockoutOptions) validate() error {
+	if opt.FreeAttempts <= 0 {
+		opt.FreeAttempts = 3
+	}
+	if opt.MaxDelay <= 0 {
+		opt.MaxDelay = time.Minute
+	}
+	if opt.Threshold <= 0 {
+		opt.Threshold = 10
+	}
+	if opt.Duration <= 0 {
+		opt.Duration = 30 * time.Minute
+	}
+	if opt.IPThreshold <= 0 {
+		opt.IPThreshold = 50
+	}
+	if opt.Window <= 0 {
+		opt.Window = time.Hour
+	}
+	if opt.Threshold <= opt.FreeAttempts {
+		return errors.New("lockout threshold must be greater than free attempts")
+	}
+	return nil
+}
+
+func getFailuresKey(scope, subject string) string {
+	return fmt.Sprintf("bruteforce:%s:%s", scope, subject)
+}
+
+func getWaitKey(username string) string {
+	return fmt.Sprintf("bruteforce:wait:%s", username)
+}
+
+// getLockoutKey holds the unix time until which an account is blocked. Accounts that are blocked
+// without it were blocked by an administrator and are not unlocked automatically.
+func getLockoutKey(userID uint64) string {
+	return fmt.Sprintf("lockout:%d", userID)
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// attemptDelay is the delay imposed after the given number of failures, doubling for each failure past the free attempts
+func (opt *LockoutOptions) attemptDelay(failures int64) time.Duration {
+	extra := failures - int64(opt.FreeAttempts)
+	if extra <= 0 {
+		return 0
+	}
+	if extra > 30 {
+		return opt.MaxDelay
+	}
+	delay := time.Second * time.Duration(math.Pow(2, float64(extra-1)))
+	return min(delay, opt.MaxDelay)
+}
+
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"message": fmt.Sprintf("too many attempts, try again in %d seconds", seconds)})
+}
+
+// allowAttempt rejects the request with 429 when the client IP or username must wait before trying again
+func (api *APIServer) allowAttempt(c *gin.Context, username string) bool {
+	var (
+		ctx    = c.Request.Context()
+		ipKey  = getFailuresKey("ip", c.ClientIP())
+		waitOn time.Duration
+	)
+
+	ipFailures, err := api.RedisDB.Get(ctx, ipKey).Int64()
+	switch {
+	case err == nil:
+		if ipFailures >= int64(api.Lockout.IPThreshold) {
+			waitOn, err = api.RedisDB.PTTL(ctx, ipKey).Result()
+		}
+	case errors.Is(err, redis.Nil):
+		err = nil
+	}
+
+	if err == nil && waitOn <= 0 {
+		waitOn, err = api.RedisDB.PTTL(ctx, getWaitKey(normalizeUsername(username))).Result()
+	}
+
+	if err != nil {
+		// Failing open keeps users able to sign in when redis is degraded
+		api.Logger.Errorf("failed to check login attempts: %v", err)
+		return true
+	}
+
+	if waitOn > 0 {
+		tooManyAttempts(c, waitOn)
+		return false
+	}
+
+	return true
+}
+
+// failedAttempt records a failed attempt for the client IP and username, delaying the next attempt
+// progressively and blocking the account, when known, once the lockout threshold is reached
+func (api *APIServer) failedAttempt(ctx context.Context, c *gin.Context, username string, db *User) {
+	username = normalizeUsername(username)
+
+	var (
+		ipKey   = getFailuresKey("ip", c.ClientIP())
+		userKey = getFailuresKey("user", username)
+	)
+
+	// Failures are counted from the first one in the window. The expiry is set in the same
+	// transaction, and only when missing, so that a counter can never be left without one.
+	pipe := api.RedisDB.TxPipeline()
+	pipe.Incr(ctx, ipKey)
+	pipe.ExpireNX(ctx, ipKey, api.Lockout.Window)
+	userIncr := pipe.Incr(ctx, userKey)
+	pipe.ExpireNX(ctx, userKey, api.Lockout.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		api.Logger.Errorf("failed to record failed attempt: %v", err)
+		return
+	}
+
+	failures := userIncr.Val()
+
+	if delay := api.Lockout.attemptDelay(failures); delay > 0 {
+		if err := api.RedisDB.Set(ctx, getWaitKey(username), 1, delay).Err(); err != nil {
+			api.Logger.Errorf("failed to set attempt delay: %v", err)
+		}
+	}
+
+	if db != nil && failures >= int64(api.Lockout.Threshold) {
+		if err := api.lockAccount(ctx, db); err != nil {
+			api.Logger.Errorf("failed to lock account %d: %v", db.ID, err)
+		}
+	}
+}
+
+// clearAttempts forgets failed attempts for the usernames after a successful attempt or an unlock
+func (api *APIServer) clearAttempts(ctx context.Context, usernames ...string) {
+	keys := make([]string, 0, len(usernames)*2)
+	for _, username := range usernames {
+		username = normalizeUsername(username)
+		if username == "" {
+			continue
+		}
+		keys = append(keys, getFailuresKey("user", username), getWaitKey(username))
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := api.RedisDB.Del(ctx, keys...).Err(); err != nil {
+		api.Logger.Errorf("failed to clear failed attempts: %v", err)
+	}
+}
+
+// lockAccount blocks the account for the lockout duration
+func (api *APIServer) lockAccount(ctx context.Context, db *User) error {
+	until := time.Now().Add(api.Lockout.Duration)
+
+	err := api.RedisDB.Set(ctx, getLockoutKey(db.ID), until.Unix(), 0).Err()
+	if err != nil {
+		return err
+	}
+
+	err = api.SqlDB.WithContext(ctx).Model(&User{}).Where("id = ?", db.ID).Update("account_status", BlockedState).Error
+	if err != nil {
+		return err
+	}
+	db.AccountStatus = BlockedState
+
+	if _, err := api.Auth.RevokeSessions(ctx, db.ID); err != nil {
+		return err
+	}
+
+	api.Logger.Warningf("Account %d locked until %s after too many failed attempts", db.ID, until.Format(time.RFC3339))
+
+	return nil
+}
+
+// lockedFor returns how long a temporarily blocked account remains locked. Accounts whose lockout
+// has expired are made active again.
+func (api *APIServer) lockedFor(ctx context.Context, db *User) (time.Duration, error) {
+	if !strings.EqualFold(db.AccountStatus, BlockedState) {
+		return 0, nil
+	}
+
+	until, err := api.RedisDB.Get(ctx, getLockoutKey(db.ID)).Int64()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return 0, nil
+	default:
+		return 0, err
+	}
+
+	if remaining := time.Until(time.Unix(until, 0)); remaining > 0 {
+		return remaining, nil
+	}
+
+	return 0, api.unlockAccount(ctx, db)
+}
+
+// unlockAccount makes a blocked account active and forgets its failed attempts
+func (api *APIServer) unlockAccount(ctx context.Context, db *User) error {
+	err := api.SqlDB.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND account_status = ?", db.ID, BlockedState).
+		Update("account_status", ActiveState).Error
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(db.AccountStatus, BlockedState) {
+		db.AccountStatus = ActiveState
+	}
+
+	if err := api.RedisDB.Del(ctx, getLockoutKey(db.ID), getTrialsKey(db.ID), getResetPassTrialsKey(db.ID)).Err(); err != nil {
+		return err
+	}
+
+	api.clearAttempts(ctx, db.Phone.String, db.Email.String)
+
+	return nil
+}
+
+// checkLockout responds with 423 when the account is temporarily locked and reports whether it did
+func (api *APIServer) checkLockout(ctx context.Context, c *gin.Context, db *User) bool {
+	remaining, err := api.lockedFor(ctx, db)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "try again later"})
+		return true
+	}
+	if remaining > 0 {
+		minutes := int(math.Ceil(remaining.Minutes()))
+		c.JSON(http.StatusLocked, gin.H{"message": fmt.Sprintf("account is locked due to too many attempts, try again in %s", plural(minutes, "minute"))})
+		return true
+	}
+	return false
+}
+
+// UnlockUser unblocks an account blocked by brute-force protection or by an administrator
+func (api *APIServer) UnlockUser(c *gin.Context) {
+	var (
+		ctx    = c.Request.Context()
+		userId = c.Param("userId")
+	)
+
+	db := &User{}
+
+	err := api.SqlDB.WithContext(ctx).Select("id,phone,email,account_status").First(db, "id=?", userId).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	default:
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get user"})
+		return
+	}
+
+	if err := api.unlockAccount(ctx, db); err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked", "account_status": db.AccountStatus})
+}
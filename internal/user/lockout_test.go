@@ -0,0 +1,59 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptDelay(t *testing.T) {
+	defaults := &LockoutOptions{}
+	if err := defaults.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opt      *LockoutOptions
+		failures int64
+		want     time.Duration
+	}{
+		{"no failures", defaults, 0, 0},
+		{"within free attempts", defaults, 3, 0},
+		{"first failure past free attempts", defaults, 4, time.Second},
+		{"delay doubles", defaults, 5, 2 * time.Second},
+		{"delay keeps doubling", defaults, 9, 32 * time.Second},
+		{"capped at the max delay", defaults, 10, time.Minute},
+		{"far past the cap", defaults, 1000, time.Minute},
+		{"custom free attempts", &LockoutOptions{FreeAttempts: 1, MaxDelay: time.Hour}, 3, 2 * time.Second},
+		{"custom max delay", &LockoutOptions{FreeAttempts: 1, MaxDelay: 5 * time.Second}, 5, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opt.attemptDelay(tt.failures); got != tt.want {
+				t.Errorf("attemptDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockoutOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     LockoutOptions
+		wantErr bool
+	}{
+		{"defaults", LockoutOptions{}, false},
+		{"threshold above free attempts", LockoutOptions{FreeAttempts: 5, Threshold: 6}, false},
+		{"threshold equal to free attempts", LockoutOptions{FreeAttempts: 5, Threshold: 5}, true},
+		{"threshold below default free attempts", LockoutOptions{Threshold: 2}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opt.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -28,8 +28,13 @@ type User struct {
 	AccountStatus string         `gorm:"type:enum('INVITED','BLOCKED','ACTIVE', 'INACTIVE','CREATED','DELETED');index;not null;default:'ACTIVE'"`
 	LastLoginIp   sql.NullString `gorm:"type:varchar(20)"`
 	LastLogin     sql.NullTime   `gorm:"type:datetime(6)"`
-	UpdatedAt     time.Time      `gorm:"type:datetime(6);autoUpdateTime;index"`
-	CreatedAt     time.Time      `gorm:"type:datetime(6);autoCreateTime;->;<-:create;index;not null"`
+	TotpSecret    string         `gorm:"type:varchar(64)"`
+	TotpEnabled   bool           `gorm:"not null;default:false"`
+	// Hashes of unused recovery codes
+	TotpRecoveryCodes []byte       `gorm:"type:json"`
+	TotpEnabledAt     sql.NullTime `gorm:"type:datetime(6)"`
+	UpdatedAt         time.Time    `gorm:"type:datetime(6);autoUpdateTime;index"`
+	CreatedAt         time.Time    `gorm:"type:datetime(6);autoCreateTime;->;<-:create;index;not null"`
 }
 
 // TableName is the name of the tables
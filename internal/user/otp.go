@@ -0,0 +1,176 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	sms_app "github.com/gidyon/pesapalm/internal/sms"
+	"github.com/gidyon/pesapalm/pkg/api/sms"
+	"github.com/spf13/viper"
+)
+
+// OTP purposes, each with its own message template
+const (
+	OTPPurposeLogin         = "login"
+	OTPPurposeResetPassword = "reset_password"
+)
+
+const (
+	defaultOTPLength = 6
+	minOTPLength     = 4
+	maxOTPLength     = 10
+)
+
+// Message templates support the {otp} and {ttl} placeholders
+var defaultOTPTemplates = map[string]string{
+	OTPPurposeLogin:         "Your Pesapalm login OTP is {otp}. It expires in {ttl}.",
+	OTPPurposeResetPassword: "Your Pesapalm password reset OTP is {otp}. It expires in {ttl}.",
+}
+
+var otpKeywords = map[string]string{
+	OTPPurposeLogin:         "LoginOTP",
+	OTPPurposeResetPassword: "PasswordReset",
+}
+
+// OTPOptions configures one time passwords sent to users
+type OTPOptions struct {
+	Length    int               // Number of digits, defaults to 6
+	TTL       time.Duration     // Defaults to OTPExpireDuration
+	Templates map[string]string // Message template per purpose, missing purposes use the defaults
+	DevMode   bool              // Log OTPs instead of sending them, for local testing only
+	Secret    string            // Key of the HMAC under which OTPs are stored
+	// Production refuses DevMode so that codes are never written to production logs
+	Production bool
+}
+
+func (opt *OTPOptions) validate() error {
+	if opt.Length == 0 {
+		opt.Length = defaultOTPLength
+	}
+	if opt.Length < minOTPLength || opt.Length > maxOTPLength {
+		return fmt.Errorf("otp length must be between %d and %d", minOTPLength, maxOTPLength)
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = OTPExpireDuration
+	}
+	if opt.Secret == "" {
+		return errors.New("missing otp secret")
+	}
+	if opt.DevMode && opt.Production {
+		return errors.New("otp dev mode cannot be used in production")
+	}
+
+	templates := make(map[string]string, len(defaultOTPTemplates))
+	for purpose, tmpl := range defaultOTPTemplates {
+		templates[purpose] = tmpl
+	}
+	for purpose, tmpl := range opt.Templates {
+		if _, ok := defaultOTPTemplates[purpose]; !ok {
+			return fmt.Errorf("unknown otp purpose %q", purpose)
+		}
+		if tmpl == "" {
+			continue
+		}
+		if !strings.Contains(tmpl, "{otp}") {
+			return fmt.Errorf("otp template for %s is missing the {otp} placeholder", purpose)
+		}
+		templates[purpose] = tmpl
+	}
+	opt.Templates = templates
+
+	return nil
+}
+
+// generateOTP returns a uniformly random numeric code of the given length
+func generateOTP(length int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", length, n), nil
+}
+
+// hashOTP binds the code to its redis key so that a hash is only valid for the purpose and user it was
+// issued to. The hash is keyed with the server secret since the few possible codes are otherwise
+// recovered from a plain hash by trying them all.
+func (opt *OTPOptions) hashOTP(key, otp string) string {
+	mac := hmac.New(sha256.New, []byte(opt.Secret))
+	mac.Write([]byte(key + ":" + otp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchOTP compares an OTP supplied by the user with the hash stored under key
+func (opt *OTPOptions) matchOTP(key, stored, otp string) bool {
+	if stored == "" || otp == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(opt.hashOTP(key, otp))) == 1
+}
+
+func formatTTL(ttl time.Duration) string {
+	switch {
+	case ttl%time.Hour == 0:
+		return plural(int(ttl/time.Hour), "hour")
+	case ttl%time.Minute == 0:
+		return plural(int(ttl/time.Minute), "minute")
+	default:
+		return ttl.String()
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// issueOTP generates an OTP, stores its hash under key and delivers it to phone
+func (api *APIServer) issueOTP(ctx context.Context, purpose, key, phone string) error {
+	tmpl, ok := api.OTP.Templates[purpose]
+	if !ok {
+		return fmt.Errorf("unknown otp purpose %q", purpose)
+	}
+
+	otp, err := generateOTP(api.OTP.Length)
+	if err != nil {
+		return fmt.Errorf("failed to generate otp: %v", err)
+	}
+
+	// Stored before sending so that a delivered code can always be validated
+	err = api.RedisDB.Set(ctx, key, api.OTP.hashOTP(key, otp), api.OTP.TTL).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set otp to cache: %v", err)
+	}
+
+	message := strings.NewReplacer("{otp}", otp, "{ttl}", formatTTL(api.OTP.TTL)).Replace(tmpl)
+
+	if api.OTP.DevMode {
+		api.Logger.Infof("OTP dev mode, %s OTP for %s: %s", purpose, phone, message)
+		return nil
+	}
+
+	if phone == "" {
+		return errors.New("account has no phone number")
+	}
+
+	return sms_app.SendSMS(ctx, &sms.SendSMSRequest{
+		Sms: &sms.SMS{
+			DestinationPhones: []string{phone},
+			Keyword:           otpKeywords[purpose],
+			Message:           message,
+		},
+		Auth:     api.SMSAuth,
+		Provider: sms.SmsProvider_ONFON,
+	}, viper.GetString("ENV"))
+}
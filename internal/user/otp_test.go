@@ -0,0 +1,26 @@
+package user
+
+import "testing"
+
+func TestOTPOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     OTPOptions
+		wantErr bool
+	}{
+		{"defaults", OTPOptions{Secret: "secret"}, false},
+		{"dev mode outside production", OTPOptions{Secret: "secret", DevMode: true}, false},
+		{"production", OTPOptions{Secret: "secret", Production: true}, false},
+		{"dev mode in production", OTPOptions{Secret: "secret", DevMode: true, Production: true}, true},
+		{"missing secret", OTPOptions{}, true},
+		{"template without placeholder", OTPOptions{Secret: "secret", Templates: map[string]string{OTPPurposeLogin: "Your code"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opt.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -10,16 +10,33 @@ func (api *APIServer) registerRoutes() {
 	api.GinEngine.POST("/api/validateOtp", api.ValidateOtp)
 	api.GinEngine.POST("/api/request-password-reset-otp", api.RequestResetPasswordOtp)
 	api.GinEngine.POST("/api/reset-password", api.ResetPassword)
-	api.GinEngine.POST("/api/refresh", auth.TokenAuthMiddleware(api.TokenManager), api.RefreshSession)
+	api.GinEngine.POST("/api/refresh", api.RefreshSession)
+	api.GinEngine.POST("/api/login/2fa", api.LoginTOTP)
+	api.GinEngine.POST("/api/login/2fa/enroll", api.EnrollLoginTOTP)
 
-	api.GinEngine.POST("/api/users_", api.CreateUser)
+	api.GinEngine.POST("/api/users_", auth.TokenAuthMiddleware(api.TokenManager), auth.Authorize("users", auth.ActionCreate, api.Enforcer, api.TokenManager), api.CreateUser)
+
+	authorize := auth.Authorizer(api.Enforcer, api.TokenManager)
+	requireUser := auth.RequireUser()
 
 	userGroup := api.GinEngine.Group("/api/v1/users", auth.TokenAuthMiddleware(api.TokenManager))
 	{
-		userGroup.POST("", api.CreateUser)
-		userGroup.GET("", api.ListUsers)
-		userGroup.GET("/:userId", api.GetUser)
-		userGroup.PATCH("/:userId", api.UpdateUser)
-		userGroup.POST("/logout", api.Logout)
+		userGroup.POST("", authorize("users", auth.ActionCreate), api.CreateUser)
+		userGroup.GET("", authorize("users", auth.ActionRead), api.ListUsers)
+		userGroup.GET("/:userId", authorize("users", auth.ActionRead), api.GetUser)
+		userGroup.PATCH("/:userId", authorize("users", auth.ActionUpdate), api.UpdateUser)
+		userGroup.POST("/:userId/unlock", authorize("users", "unlock"), api.UnlockUser)
+		userGroup.POST("/logout", requireUser, api.Logout)
+		userGroup.GET("/sessions", requireUser, api.ListSessions)
+		userGroup.DELETE("/sessions", requireUser, api.RevokeSessions)
+		userGroup.DELETE("/sessions/:sessionId", requireUser, api.RevokeSession)
+		userGroup.GET("/:userId/sessions", authorize("users", auth.ActionRead), api.ListUserSessions)
+		userGroup.POST("/:userId/logout", authorize("users", "logout"), api.ForceLogout)
+		userGroup.GET("/2fa", requireUser, api.GetTwoFactor)
+		userGroup.POST("/2fa/totp", requireUser, api.EnrollTOTP)
+		userGroup.POST("/2fa/totp/verify", requireUser, api.VerifyTOTP)
+		userGroup.DELETE("/2fa/totp", requireUser, api.DisableTOTP)
+		userGroup.POST("/2fa/recovery-codes", requireUser, api.RegenerateRecoveryCodes)
+		userGroup.POST("/:userId/2fa/reset", authorize("users", "reset_2fa"), api.ResetUserTwoFactor)
 	}
 }
@@ -0,0 +1,145 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gidyon/pesapalm/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceHeader optionally names the device a user logs in from, e.g. "Pixel 7"
+const DeviceHeader = "X-Device-Name"
+
+// refreshedSession retrieves the session a token belongs to. Tokens issued before sessions were
+// tracked have no session and get a new one.
+func (api *APIServer) refreshedSession(ctx context.Context, metadata *auth.AccessDetails) (*auth.Session, error) {
+	if metadata.SessionId == "" {
+		return nil, nil
+	}
+	session, err := api.Auth.FetchSession(ctx, metadata.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserId != metadata.UserId {
+		return nil, auth.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+type sessionResponse struct {
+	*auth.Session
+	Current bool `json:"current"`
+}
+
+func toSessionResponses(sessions []*auth.Session, currentId string) []*sessionResponse {
+	res := make([]*sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		// Token ids are not exposed
+		public := *session
+		public.AccessUuid, public.RefreshUuid = "", ""
+		res = append(res, &sessionResponse{Session: &public, Current: session.ID == currentId})
+	}
+	return res
+}
+
+// ListSessions lists the active sessions of the logged in user
+func (api *APIServer) ListSessions(c *gin.Context) {
+	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	sessions, err := api.Auth.ListSessions(c.Request.Context(), metadata.UserId)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": toSessionResponses(sessions, metadata.SessionId)})
+}
+
+// RevokeSession logs the logged in user out of one of their sessions
+func (api *APIServer) RevokeSession(c *gin.Context) {
+	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	err = api.Auth.RevokeSession(c.Request.Context(), metadata.UserId, c.Param("sessionId"))
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "session not found"})
+		return
+	default:
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeSessions logs the logged in user out of all their sessions, including the current one
+func (api *APIServer) RevokeSessions(c *gin.Context) {
+	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return
+	}
+
+	revoked, err := api.Auth.RevokeSessions(c.Request.Context(), metadata.UserId)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "sessions revoked", "revoked": revoked})
+}
+
+// ListUserSessions lists the active sessions of any user
+func (api *APIServer) ListUserSessions(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+
+	sessions, err := api.Auth.ListSessions(c.Request.Context(), userId)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": toSessionResponses(sessions, "")})
+}
+
+// ForceLogout revokes all sessions of a user, who has to log in again on every device
+func (api *APIServer) ForceLogout(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+
+	revoked, err := api.Auth.RevokeSessions(c.Request.Context(), userId)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to logout user"})
+		return
+	}
+
+	if metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request); err == nil {
+		api.Logger.Infof("User %d force logged out user %d from %d sessions", metadata.UserId, userId, revoked)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user logged out", "revoked": revoked})
+}
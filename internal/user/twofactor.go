@@ -0,0 +1,690 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	totpDigits        = 6
+	totpPeriod        = 30 // seconds
+	totpSecretSize    = 20
+	recoveryCodeCount = 10
+
+	// Failed codes allowed per login challenge before it is discarded
+	maxChallengeAttempts = 5
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPOptions configures two-factor authentication with time-based one time passwords
+type TOTPOptions struct {
+	Issuer         string        // Shown in authenticator apps, defaults to Pesapalm
+	RequiredGroups []string      // Primary groups that must use two-factor authentication
+	ChallengeTTL   time.Duration // How long the second login step may take, defaults to 5 minutes
+}
+
+func (opt *TOTPOptions) validate() error {
+	if opt.Issuer == "" {
+		opt.Issuer = "Pesapalm"
+	}
+	if opt.ChallengeTTL <= 0 {
+		opt.ChallengeTTL = 5 * time.Minute
+	}
+	groups := make([]string, 0, len(opt.RequiredGroups))
+	for _, group := range opt.RequiredGroups {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	opt.RequiredGroups = groups
+	return nil
+}
+
+func getChallengeKey(challengeToken string) string {
+	return fmt.Sprintf("login_challenge:%s", challengeToken)
+}
+
+func getChallengeAttemptsKey(challengeToken string) string {
+	return fmt.Sprintf("login_challenge_attempts:%s", challengeToken)
+}
+
+func getTOTPStepKey(userID uint64) string {
+	return fmt.Sprintf("totp_step:%d", userID)
+}
+
+// totpCode computes the RFC 6238 code of a secret for a time step
+func totpCode(secret []byte, step uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], step)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// provisioningURI is encoded in the QR code scanned by authenticator apps
+func (api *APIServer) provisioningURI(db *User, secret string) string {
+	account := db.Email.String
+	if account == "" {
+		account = db.Phone.String
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", api.TOTP.Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + api.TOTP.Issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// validateTOTP checks a code against the secret of the user, allowing one step of clock drift. A code
+// is accepted once, so codes at or before the last accepted step are rejected.
+func (api *APIServer) validateTOTP(ctx context.Context, db *User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits || db.TotpSecret == "" {
+		return false, nil
+	}
+
+	secret, err := base32NoPadding.DecodeString(db.TotpSecret)
+	if err != nil {
+		return false, err
+	}
+
+	lastStep, err := api.RedisDB.Get(ctx, getTOTPStepKey(db.ID)).Uint64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+
+	now := uint64(time.Now().Unix()) / totpPeriod
+	for _, step := range []uint64{now - 1, now, now + 1} {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			err = api.RedisDB.Set(ctx, getTOTPStepKey(db.ID), step, 3*totpPeriod*time.Second).Err()
+			return err == nil, err
+		}
+	}
+
+	return false, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+// generateRecoveryCodes returns recovery codes for the user and their hashes for storage
+func generateRecoveryCodes() ([]string, []byte, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		bs := make([]byte, 7)
+		if _, err := rand.Read(bs); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(bs))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	bs, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return codes, bs, nil
+}
+
+// useRecoveryCode consumes a recovery code of the user
+func (api *APIServer) useRecoveryCode(ctx context.Context, db *User, code string) (bool, error) {
+	if strings.TrimSpace(code) == "" || len(db.TotpRecoveryCodes) == 0 {
+		return false, nil
+	}
+
+	var hashes []string
+	if err := json.Unmarshal(db.TotpRecoveryCodes, &hashes); err != nil {
+		return false, err
+	}
+
+	hash := hashRecoveryCode(code)
+	for i := range hashes {
+		if subtle.ConstantTimeCompare([]byte(hashes[i]), []byte(hash)) != 1 {
+			continue
+		}
+
+		remaining := append(hashes[:i:i], hashes[i+1:]...)
+		bs, err := json.Marshal(remaining)
+		if err != nil {
+			return false, err
+		}
+
+		// The stored codes must not have changed, otherwise the code may have been used concurrently
+		res := api.SqlDB.WithContext(ctx).Model(&User{}).
+			Where("id = ? AND totp_recovery_codes = CAST(? AS JSON)", db.ID, string(db.TotpRecoveryCodes)).
+			Update("totp_recovery_codes", bs)
+		if res.Error != nil {
+			return false, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return false, nil
+		}
+
+		db.TotpRecoveryCodes = bs
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func recoveryCodesRemaining(db *User) int {
+	var hashes []string
+	_ = json.Unmarshal(db.TotpRecoveryCodes, &hashes)
+	return len(hashes)
+}
+
+// validateSecondFactor accepts a TOTP code or a recovery code
+func (api *APIServer) validateSecondFactor(ctx context.Context, db *User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		return api.validateTOTP(ctx, db, code)
+	}
+	return api.useRecoveryCode(ctx, db, recoveryCode)
+}
+
+// twoFactorRequired reports whether the primary group of the user must use two-factor authentication
+func (api *APIServer) twoFactorRequired(db *User) bool {
+	for _, group := range api.TOTP.RequiredGroups {
+		if strings.EqualFold(group, db.PrimaryGroup) {
+			return true
+		}
+	}
+	return false
+}
+
+// completeLogin starts a session for a user whose first factor has been verified, or issues a
+// challenge for the second login step when the user has or must set up two-factor authentication
+func (api *APIServer) completeLogin(ctx context.Context, c *gin.Context, db *User) {
+	if !db.TotpEnabled && !api.twoFactorRequired(db) {
+		api.updateSession(ctx, c, db, nil, nil)
+		return
+	}
+
+	// Blocked accounts are rejected before the second step
+	switch strings.ToUpper(db.AccountStatus) {
+	case BlockedState:
+		c.JSON(http.StatusNotAcceptable, gin.H{"message": "account is blocked"})
+		return
+	case InactiveState:
+		c.JSON(http.StatusNotAcceptable, gin.H{"message": "account is inactive"})
+		return
+	}
+
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create login challenge"})
+		return
+	}
+	challengeToken := hex.EncodeToString(bs)
+
+	err := api.RedisDB.Set(ctx, getChallengeKey(challengeToken), db.ID, api.TOTP.ChallengeTTL).Err()
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create login challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"two_factor_required": true,
+		"enrollment_required": !db.TotpEnabled,
+		"challenge_token":     challengeToken,
+		"expires_in":          int(api.TOTP.ChallengeTTL.Seconds()),
+	})
+}
+
+// challengedUser retrieves the user of a login challenge
+func (api *APIServer) challengedUser(c *gin.Context, challengeToken string) (*User, bool) {
+	ctx := c.Request.Context()
+
+	userID, err := api.RedisDB.Get(ctx, getChallengeKey(challengeToken)).Uint64()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "login challenge expired, please login"})
+		return nil, false
+	default:
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to get login challenge"})
+		return nil, false
+	}
+
+	db := &User{}
+	err = api.SqlDB.WithContext(ctx).First(db, "id=?", userID).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "account not found"})
+		return nil, false
+	default:
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to retrieve account"})
+		return nil, false
+	}
+
+	return db, true
+}
+
+// enableTOTP turns on two-factor authentication with the pending secret and returns new recovery codes
+func (api *APIServer) enableTOTP(ctx context.Context, db *User) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = api.SqlDB.WithContext(ctx).Model(&User{}).Where("id = ?", db.ID).Updates(map[string]interface{}{
+		"totp_enabled":        true,
+		"totp_recovery_codes": hashes,
+		"totp_enabled_at":     now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	db.TotpEnabled = true
+	db.TotpRecoveryCodes = hashes
+	db.TotpEnabledAt = sql.NullTime{Time: now, Valid: true}
+
+	return codes, nil
+}
+
+// setTOTPSecret stores a new secret that is pending until a code generated from it is verified
+func (api *APIServer) setTOTPSecret(ctx context.Context, db *User) (string, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = api.SqlDB.WithContext(ctx).Model(&User{}).Where("id = ? AND totp_enabled = ?", db.ID, false).
+		Update("totp_secret", secret).Error
+	if err != nil {
+		return "", err
+	}
+	db.TotpSecret = secret
+
+	return secret, nil
+}
+
+// EnrollLoginTOTP sets up two-factor authentication during the login of a user who must use it
+func (api *APIServer) EnrollLoginTOTP(c *gin.Context) {
+	var req LoginChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ChallengeToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing challenge token"})
+		return
+	}
+
+	db, ok := api.challengedUser(c, req.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	if db.TotpEnabled {
+		c.JSON(http.StatusConflict, gin.H{"message": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := api.setTOTPSecret(c.Request.Context(), db)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": api.provisioningURI(db, secret),
+	})
+}
+
+// LoginTOTP completes a login challenge with a TOTP or recovery code. Users enrolling during login
+// verify a code from their new secret and receive their recovery codes with the session.
+func (api *APIServer) LoginTOTP(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req LoginChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ChallengeToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing challenge token"})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing code"})
+		return
+	}
+
+	db, ok := api.challengedUser(c, req.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	if !api.allowAttempt(c, db.Phone.String) {
+		return
+	}
+
+	enrolling := !db.TotpEnabled
+	if enrolling && req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing code"})
+		return
+	}
+
+	valid, err := api.validateSecondFactor(ctx, db, req.Code, req.RecoveryCode)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to verify code"})
+		return
+	}
+
+	if !valid {
+		api.failedAttempt(ctx, c, db.Phone.String, db)
+
+		attempts, _ := api.RedisDB.Incr(ctx, getChallengeAttemptsKey(req.ChallengeToken)).Result()
+		if attempts == 1 {
+			api.RedisDB.Expire(ctx, getChallengeAttemptsKey(req.ChallengeToken), api.TOTP.ChallengeTTL)
+		}
+		if attempts >= maxChallengeAttempts {
+			api.RedisDB.Del(ctx, getChallengeKey(req.ChallengeToken), getChallengeAttemptsKey(req.ChallengeToken))
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "too many invalid codes, please login"})
+			return
+		}
+
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid code"})
+		return
+	}
+
+	// A challenge can only be completed once
+	deleted, err := api.RedisDB.Del(ctx, getChallengeKey(req.ChallengeToken), getChallengeAttemptsKey(req.ChallengeToken)).Result()
+	if err != nil || deleted == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "login challenge expired, please login"})
+		return
+	}
+
+	api.clearAttempts(ctx, db.Phone.String)
+
+	var extra gin.H
+	if enrolling {
+		codes, err := api.enableTOTP(ctx, db)
+		if err != nil {
+			api.Logger.Errorln(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to enable two-factor authentication"})
+			return
+		}
+		extra = gin.H{"recovery_codes": codes}
+	}
+
+	api.updateSession(ctx, c, db, nil, extra)
+}
+
+// currentUser retrieves the logged in user
+func (api *APIServer) currentUser(c *gin.Context) (*User, bool) {
+	metadata, err := api.TokenManager.ExtractTokenMetadata(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "please login"})
+		return nil, false
+	}
+
+	db := &User{}
+	err = api.SqlDB.WithContext(c.Request.Context()).First(db, "id=?", metadata.UserId).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "account not found"})
+		return nil, false
+	default:
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to retrieve account"})
+		return nil, false
+	}
+
+	return db, true
+}
+
+// GetTwoFactor retrieves the two-factor authentication status of the logged in user
+func (api *APIServer) GetTwoFactor(c *gin.Context) {
+	db, ok := api.currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  db.TotpEnabled,
+		"required":                 api.twoFactorRequired(db),
+		"recovery_codes_remaining": recoveryCodesRemaining(db),
+	})
+}
+
+// EnrollTOTP creates a pending TOTP secret for the logged in user
+func (api *APIServer) EnrollTOTP(c *gin.Context) {
+	db, ok := api.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if db.TotpEnabled {
+		c.JSON(http.StatusConflict, gin.H{"message": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := api.setTOTPSecret(c.Request.Context(), db)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": api.provisioningURI(db, secret),
+	})
+}
+
+// VerifyTOTP enables two-factor authentication once a code from the pending secret is verified
+func (api *APIServer) VerifyTOTP(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing code"})
+		return
+	}
+
+	db, ok := api.currentUser(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case db.TotpEnabled:
+		c.JSON(http.StatusConflict, gin.H{"message": "two-factor authentication is already enabled"})
+		return
+	case db.TotpSecret == "":
+		c.JSON(http.StatusBadRequest, gin.H{"message": "two-factor authentication has not been set up"})
+		return
+	}
+
+	valid, err := api.validateTOTP(ctx, db, req.Code)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid code"})
+		return
+	}
+
+	codes, err := api.enableTOTP(ctx, db)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP turns off two-factor authentication for the logged in user, unless it is required for their group
+func (api *APIServer) DisableTOTP(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing code"})
+		return
+	}
+
+	db, ok := api.currentUser(c)
+	if !ok {
+		return
+	}
+
+	switch {
+	case !db.TotpEnabled:
+		c.JSON(http.StatusBadRequest, gin.H{"message": "two-factor authentication is not enabled"})
+		return
+	case api.twoFactorRequired(db):
+		c.JSON(http.StatusForbidden, gin.H{"message": "two-factor authentication is required for your group"})
+		return
+	}
+
+	valid, err := api.validateSecondFactor(ctx, db, req.Code, req.RecoveryCode)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid code"})
+		return
+	}
+
+	if err := api.resetTOTP(ctx, db.ID); err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged in user
+func (api *APIServer) RegenerateRecoveryCodes(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "missing code"})
+		return
+	}
+
+	db, ok := api.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !db.TotpEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "two-factor authentication is not enabled"})
+		return
+	}
+
+	valid, err := api.validateTOTP(ctx, db, req.Code)
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = api.SqlDB.WithContext(ctx).Model(&User{}).Where("id = ?", db.ID).Update("totp_recovery_codes", hashes).Error
+	}
+	if err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (api *APIServer) resetTOTP(ctx context.Context, userID uint64) error {
+	return api.SqlDB.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_enabled":        false,
+		"totp_secret":         "",
+		"totp_recovery_codes": nil,
+		"totp_enabled_at":     nil,
+	}).Error
+}
+
+// ResetUserTwoFactor removes two-factor authentication of a user who lost their device. Users in
+// groups that require it set it up again on their next login.
+func (api *APIServer) ResetUserTwoFactor(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+
+	if err := api.resetTOTP(c.Request.Context(), userID); err != nil {
+		api.Logger.Errorln(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to reset two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication reset"})
+}
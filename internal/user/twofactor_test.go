@@ -0,0 +1,26 @@
+package user
+
+import "testing"
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors of RFC 6238 appendix B for SHA1, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unixTime uint64
+		want     string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.unixTime/30); got != tt.want {
+			t.Errorf("totpCode() at %d = %s, want %s", tt.unixTime, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package payload
+
+import "encoding/json"
+
+// B2CResult is the payload of B2C result and queue timeout callbacks
+type B2CResult struct {
+	Result B2CResultBody `json:"Result"`
+}
+
+// B2CResultBody ...
+type B2CResultBody struct {
+	ResultType               int              `json:"ResultType"`
+	ResultCode               int              `json:"ResultCode"`
+	ResultDesc               string           `json:"ResultDesc"`
+	OriginatorConversationID string           `json:"OriginatorConversationID"`
+	ConversationID           string           `json:"ConversationID"`
+	TransactionID            string           `json:"TransactionID"`
+	ResultParameters         ResultParameters `json:"ResultParameters,omitempty"`
+	ReferenceData            ReferenceData    `json:"ReferenceData,omitempty"`
+}
+
+// ResultParameters ...
+type ResultParameters struct {
+	ResultParameter []ResultParameter `json:"ResultParameter,omitempty"`
+}
+
+// ResultParameter ...
+type ResultParameter struct {
+	Key   string      `json:"Key"`
+	Value interface{} `json:"Value"`
+}
+
+// Get returns the value of the result parameter key as a string, or an empty string if it is missing
+func (r *ResultParameters) Get(key string) string {
+	for _, parameter := range r.ResultParameter {
+		if parameter.Key == key && parameter.Value != nil {
+			return formatValue(parameter.Value)
+		}
+	}
+	return ""
+}
+
+// GetAmount returns the transaction amount
+func (r *ResultParameters) GetAmount() float64 {
+	for _, parameter := range r.ResultParameter {
+		if parameter.Key == "TransactionAmount" {
+			v, _ := parameter.Value.(float64)
+			return v
+		}
+	}
+	return 0
+}
+
+// ReceiverName returns the name of the receiving party, which Daraja formats as "<phone> - <name>"
+func (r *ResultParameters) ReceiverName() string {
+	return r.Get("ReceiverPartyPublicName")
+}
+
+// ReferenceData carries the occasion of a request back in its result
+type ReferenceData struct {
+	ReferenceItem json.RawMessage `json:"ReferenceItem,omitempty"` // A single item or a list of items
+}
+
+// Get returns the value of the reference item key as a string, or an empty string if it is missing
+func (r *ReferenceData) Get(key string) string {
+	var items []ResultParameter
+	if err := json.Unmarshal(r.ReferenceItem, &items); err != nil {
+		var item ResultParameter
+		if err := json.Unmarshal(r.ReferenceItem, &item); err != nil {
+			return ""
+		}
+		items = []ResultParameter{item}
+	}
+	for _, item := range items {
+		if item.Key == key && item.Value != nil {
+			return formatValue(item.Value)
+		}
+	}
+	return ""
+}
+
+// {
+// 	"Result": {
+// 		"ResultType": 0,
+// 		"ResultCode": 0,
+// 		"ResultDesc": "The service request is processed successfully.",
+// 		"OriginatorConversationID": "10571-7910404-1",
+// 		"ConversationID": "AG_20191219_00004e48cf7e3533f581",
+// 		"TransactionID": "NLJ41HAY6Q",
+// 		"ResultParameters": {
+// 			"ResultParameter": [
+// 				{"Key": "TransactionAmount", "Value": 10},
+// 				{"Key": "TransactionReceipt", "Value": "NLJ41HAY6Q"},
+// 				{"Key": "B2CRecipientIsRegisteredCustomer", "Value": "Y"},
+// 				{"Key": "B2CChargesPaidAccountAvailableFunds", "Value": -4510.00},
+// 				{"Key": "ReceiverPartyPublicName", "Value": "254708374149 - John Doe"},
+// 				{"Key": "TransactionCompletedDateTime", "Value": "19.12.2019 11:45:50"},
+// 				{"Key": "B2CUtilityAccountAvailableFunds", "Value": 10116.00},
+// 				{"Key": "B2CWorkingAccountAvailableFunds", "Value": 900000.00}
+// 			]
+// 		}
+// 	}
+// }
@@ -0,0 +1,64 @@
+package payload
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// C2BPayload is the payload of paybill validation and confirmation requests
+type C2BPayload struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID"`
+	TransTime         string `json:"TransTime"`
+	TransAmount       string `json:"TransAmount"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"`
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+// GetAmount returns the transaction amount
+func (p *C2BPayload) GetAmount() float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(p.TransAmount), 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// GetTransTime returns the transaction time
+func (p *C2BPayload) GetTransTime() time.Time {
+	t, err := getTransactionTime(p.TransTime)
+	if err != nil {
+		return time.Now().UTC()
+	}
+	return t
+}
+
+// C2BResponse is the response to paybill validation and confirmation requests
+type C2BResponse struct {
+	ResultCode string `json:"ResultCode"`
+	ResultDesc string `json:"ResultDesc"`
+}
+
+// {
+// 	"TransactionType": "Pay Bill",
+// 	"TransID": "RKTQDM7W6S",
+// 	"TransTime": "20191122063845",
+// 	"TransAmount": "10",
+// 	"BusinessShortCode": "600638",
+// 	"BillRefNumber": "invoice008",
+// 	"InvoiceNumber": "",
+// 	"OrgAccountBalance": "",
+// 	"ThirdPartyTransID": "",
+// 	"MSISDN": "25470****149",
+// 	"FirstName": "John",
+// 	"MiddleName": "",
+// 	"LastName": "Doe"
+// }
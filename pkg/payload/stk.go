@@ -84,12 +84,12 @@ func (c *CallbackMeta) GetTransTime() time.Time {
 
 	switch itemsLen {
 	case 4:
-		t, err = getTransactionTime(fmt.Sprint(c.Item[2].Value))
+		t, err = getTransactionTime(formatValue(c.Item[2].Value))
 		if err != nil {
 			t = time.Now().UTC()
 		}
 	case 5:
-		t, err = getTransactionTime(fmt.Sprint(c.Item[3].Value))
+		t, err = getTransactionTime(formatValue(c.Item[3].Value))
 		if err != nil {
 			t = time.Now().UTC()
 		}
@@ -126,6 +126,14 @@ func (c *CallbackMeta) PhoneNumber() string {
 	return fmt.Sprintf("%.0f", v)
 }
 
+// formatValue formats an item value; numbers decoded as float64 are formatted without an exponent
+func formatValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return fmt.Sprint(v)
+}
+
 func getTransactionTime(transactionTimeStr string) (time.Time, error) {
 	// 20200816204116
 	if len(transactionTimeStr) != 14 {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 func FormatPhoneKE(phone string) string {
@@ -79,3 +80,14 @@ func FormatPhonePrefix(phone, prefix string) string {
 
 	return phone
 }
+
+// Truncate shortens s to at most n bytes without splitting a multi-byte character
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,13 @@
+package moneyutil
+
+import "math"
+
+// Round rounds an amount to 2 decimal places
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Percent returns rate percent of amount rounded to 2 decimal places
+func Percent(amount, rate float64) float64 {
+	return Round(amount * rate / 100)
+}
@@ -0,0 +1,46 @@
+package periodutil
+
+import (
+	"strings"
+	"time"
+)
+
+// Period units used by products and accounts
+const (
+	Day   = "DAY"
+	Week  = "WEEK"
+	Month = "MONTH"
+	Year  = "YEAR"
+)
+
+// Normalize converts units such as "days" or "Month" to their canonical form
+func Normalize(unit string) string {
+	unit = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(unit)), "S")
+	if unit == "" {
+		return Day
+	}
+	return unit
+}
+
+// Add adds n period units to t
+func Add(t time.Time, n int, unit string) time.Time {
+	switch Normalize(unit) {
+	case Week:
+		return t.AddDate(0, 0, 7*n)
+	case Month:
+		return t.AddDate(0, n, 0)
+	case Year:
+		return t.AddDate(n, 0, 0)
+	default:
+		return t.AddDate(0, 0, n)
+	}
+}
+
+// Valid reports whether unit is a supported period unit
+func Valid(unit string) bool {
+	switch Normalize(unit) {
+	case Day, Week, Month, Year:
+		return true
+	}
+	return false
+}